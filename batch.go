@@ -1,25 +1,291 @@
 package leveldb
 
-import "github.com/kezhuw/leveldb/internal/batch"
+import (
+	"time"
+
+	"github.com/kezhuw/leveldb/internal/batch"
+	"github.com/kezhuw/leveldb/internal/errors"
+	"github.com/kezhuw/leveldb/internal/iterator"
+	"github.com/kezhuw/leveldb/internal/keys"
+	"github.com/kezhuw/leveldb/internal/leveldb"
+	ttlp "github.com/kezhuw/leveldb/internal/ttl"
+)
 
 // Batch holds a collection of updates to apply atomatically to a DB.
 type Batch struct {
 	batch batch.Batch
+	// Nil if batch is not indexed.
+	index *leveldb.BatchIndex
+}
+
+// NewBatchFrom creates a batch from data, which is encoded in format of
+// batches in log files, as Batch.Repr returns. Empty data results in an
+// empty batch. It returns a corruption error if data is not a valid batch.
+func NewBatchFrom(data []byte) (*Batch, error) {
+	b := &Batch{}
+	if len(data) == 0 {
+		return b, nil
+	}
+	b.batch.Reset(append([]byte(nil), data...))
+	if err := b.batch.Iterate(nopHandler{}); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+type nopHandler struct{}
+
+func (nopHandler) Add(seq keys.Sequence, kind keys.Kind, key, value []byte) {
+}
+
+func (nopHandler) AddColumnFamily(family uint32, seq keys.Sequence, kind keys.Kind, key, value []byte) {
+}
+
+// NewIndexedBatch creates a batch which indexes its updates in sorted order,
+// so they could be read through GetFromBatchAndDB and NewIteratorWithBase
+// before the batch is written. Indexed batch uses comparator and merge
+// operator of db, and should be read with db only.
+func (db *DB) NewIndexedBatch() *Batch {
+	return &Batch{index: db.db.NewBatchIndex()}
+}
+
+func (b *Batch) addIndex(kind keys.Kind, key, value []byte) {
+	if b.index != nil && b.batch.Err() == nil {
+		b.index.Add(keys.Sequence(b.batch.Count()), kind, key, value)
+	}
 }
 
 // Put adds a key/value update to batch.
 func (b *Batch) Put(key, value []byte) {
 	b.batch.Put(key, value)
+	b.addIndex(keys.Value, key, value)
+}
+
+// PutWithTTL adds a key/value update, which expires after ttl, to batch.
+// Expiration time is computed from Options.Clock of db when batch is written,
+// or when this update is added for batch created by NewIndexedBatch, so it
+// could be read before written.
+func (b *Batch) PutWithTTL(key, value []byte, ttl time.Duration) {
+	if b.index == nil {
+		b.batch.PutWithTTL(key, value, ttl)
+		return
+	}
+	expiration := b.index.Expiration(ttl)
+	b.batch.PutWithExpiration(key, value, expiration)
+	b.addIndex(keys.ExpiringValue, key, ttlp.Append(nil, expiration, value))
 }
 
 // Delete adds a key deletion to batch.
 func (b *Batch) Delete(key []byte) {
 	b.batch.Delete(key)
+	b.addIndex(keys.Delete, key, nil)
 }
 
-// Clear clears all updates written before.
+// Merge adds a merge of operand into value of key to batch. DB applying
+// this batch must be opened with a MergeOperator.
+func (b *Batch) Merge(key, operand []byte) {
+	b.batch.Merge(key, operand)
+	b.addIndex(keys.Merge, key, operand)
+}
+
+// DeleteRange adds a deletion of all keys in range [start, limit) to batch.
+func (b *Batch) DeleteRange(start, limit []byte) {
+	b.batch.DeleteRange(start, limit)
+	b.addIndex(keys.RangeDelete, start, limit)
+}
+
+// PutCF adds a key/value update of column family cf to batch. Updates to
+// column families other than default one are not indexed.
+func (b *Batch) PutCF(cf *ColumnFamily, key, value []byte) {
+	if cf.cf.ID() == 0 {
+		b.Put(key, value)
+		return
+	}
+	b.batch.PutCF(cf.cf.ID(), key, value)
+}
+
+// DeleteCF adds a key deletion of column family cf to batch.
+func (b *Batch) DeleteCF(cf *ColumnFamily, key []byte) {
+	if cf.cf.ID() == 0 {
+		b.Delete(key)
+		return
+	}
+	b.batch.DeleteCF(cf.cf.ID(), key)
+}
+
+// MergeCF adds a merge of operand into value of key in column family cf to
+// batch. Column family cf must be created with a MergeOperator.
+func (b *Batch) MergeCF(cf *ColumnFamily, key, operand []byte) {
+	if cf.cf.ID() == 0 {
+		b.Merge(key, operand)
+		return
+	}
+	b.batch.MergeCF(cf.cf.ID(), key, operand)
+}
+
+// DeleteRangeCF adds a deletion of all keys in range [start, limit) of column
+// family cf to batch.
+func (b *Batch) DeleteRangeCF(cf *ColumnFamily, start, limit []byte) {
+	if cf.cf.ID() == 0 {
+		b.DeleteRange(start, limit)
+		return
+	}
+	b.batch.DeleteRangeCF(cf.cf.ID(), start, limit)
+}
+
+// Clear clears all updates written before, and all save points.
 func (b *Batch) Clear() {
 	b.batch.Clear()
+	if b.index != nil {
+		b.index.Reset()
+	}
+}
+
+// SetSavePoint records current state of batch, so updates added after could
+// be dropped by RollbackToSavePoint. Save points are stacked.
+func (b *Batch) SetSavePoint() {
+	b.batch.SetSavePoint()
+}
+
+// RollbackToSavePoint drops updates added after most recent save point, and
+// removes that save point. It returns ErrNoSavePoint if there is no save
+// point.
+func (b *Batch) RollbackToSavePoint() error {
+	if err := b.batch.RollbackToSavePoint(); err != nil {
+		return err
+	}
+	if b.index != nil {
+		return b.index.Rebuild(&b.batch)
+	}
+	return nil
+}
+
+// PopSavePoint removes most recent save point without dropping any updates.
+// It returns ErrNoSavePoint if there is no save point.
+func (b *Batch) PopSavePoint() error {
+	return b.batch.PopSavePoint()
+}
+
+// Len returns number of updates in batch.
+func (b *Batch) Len() int {
+	return int(b.batch.Count())
+}
+
+// Size returns size of batch in bytes, which is also length of Repr.
+func (b *Batch) Size() int {
+	return b.batch.Size()
+}
+
+// Repr returns encoded content of batch in format of batches in log files.
+// The returned slice is valid until next modification of batch. Repr of an
+// empty batch is empty.
+func (b *Batch) Repr() []byte {
+	return b.batch.Bytes()
+}
+
+// BatchHandler receives updates of a batch in Batch.Replay.
+type BatchHandler interface {
+	Put(key, value []byte)
+	Delete(key []byte)
+}
+
+// BatchMergeHandler is a BatchHandler receiving merges.
+type BatchMergeHandler interface {
+	BatchHandler
+	Merge(key, operand []byte)
+}
+
+// BatchRangeDeleteHandler is a BatchHandler receiving range deletions.
+type BatchRangeDeleteHandler interface {
+	BatchHandler
+	DeleteRange(start, limit []byte)
+}
+
+// BatchTTLHandler is a BatchHandler receiving key/value updates written with
+// time to live.
+type BatchTTLHandler interface {
+	BatchHandler
+	PutWithExpiration(key, value []byte, expiration time.Time)
+}
+
+type replayer struct {
+	handler BatchHandler
+	err     error
+}
+
+func (r *replayer) Add(seq keys.Sequence, kind keys.Kind, key, value []byte) {
+	if r.err != nil {
+		return
+	}
+	switch kind {
+	case keys.Value:
+		r.handler.Put(key, value)
+		return
+	case keys.Delete:
+		r.handler.Delete(key)
+		return
+	case keys.Merge:
+		if h, ok := r.handler.(BatchMergeHandler); ok {
+			h.Merge(key, value)
+			return
+		}
+	case keys.RangeDelete:
+		if h, ok := r.handler.(BatchRangeDeleteHandler); ok {
+			h.DeleteRange(key, value)
+			return
+		}
+	case keys.ExpiringValue:
+		if h, ok := r.handler.(BatchTTLHandler); ok {
+			expiration, value, err := ttlp.Split(value)
+			if err != nil {
+				r.err = err
+				return
+			}
+			h.PutWithExpiration(key, value, time.Unix(0, expiration))
+			return
+		}
+	}
+	r.err = errors.ErrUnhandledUpdate
+}
+
+// Replay replays updates of batch to handler in order they were added. If
+// batch contains merges, range deletions or updates with time to live,
+// handler must implement BatchMergeHandler, BatchRangeDeleteHandler or
+// BatchTTLHandler respectively, otherwise Replay stops at that update and
+// returns ErrUnhandledUpdate. Updates to column families other than default
+// one are not replayed, Replay returns ErrUnhandledUpdate for them. Keys and
+// values passed to handler are valid only during the call.
+func (b *Batch) Replay(handler BatchHandler) error {
+	if b.batch.Empty() {
+		return nil
+	}
+	r := replayer{handler: handler}
+	if err := b.batch.Iterate(&r); err != nil {
+		return err
+	}
+	return r.err
+}
+
+// GetFromBatchAndDB gets value for given key from updates in this batch over
+// latest data of db. It returns ErrNotFound if neither contains that key, or
+// ErrBatchNotIndexed if this batch is not created by NewIndexedBatch.
+func (b *Batch) GetFromBatchAndDB(db *DB, key []byte, opts *ReadOptions) ([]byte, error) {
+	if b.index == nil {
+		return nil, errors.ErrBatchNotIndexed
+	}
+	return b.index.Get(db.db, key, convertReadOptions(opts))
+}
+
+// NewIteratorWithBase returns an iterator which merges updates in this batch
+// over base, which is usually an iterator of db or Snapshot. Updates in batch
+// shadow entries of base with same keys. Closing returned iterator closes
+// base. Batch should not be updated during iteration.
+func (b *Batch) NewIteratorWithBase(base Iterator) Iterator {
+	if b.index == nil {
+		base.Close()
+		return iterator.Error(errors.ErrBatchNotIndexed)
+	}
+	return b.index.NewIterator(base)
 }
 
 func (b *Batch) empty() bool {
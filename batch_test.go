@@ -0,0 +1,409 @@
+package leveldb
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+type manualClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *manualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *manualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+func TestBatchPutWithTTLClock(t *testing.T) {
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+
+	clock := &manualClock{now: time.Unix(1000, 0)}
+	db := openTestDB(t, filepath.Join(dir, "db"), &Options{Clock: clock})
+	defer db.Close()
+
+	var batch Batch
+	batch.PutWithTTL([]byte("batch"), []byte("value"), time.Hour)
+	// Expiration is resolved when batch is written.
+	clock.Advance(time.Hour)
+	if err := db.Write(batch, nil); err != nil {
+		t.Fatalf("fail to write batch: %s", err)
+	}
+	if err := db.PutWithTTL([]byte("db"), []byte("value"), time.Hour, nil); err != nil {
+		t.Fatalf("fail to put with ttl: %s", err)
+	}
+
+	clock.Advance(time.Hour - time.Second)
+	expectValue(t, db, []byte("batch"), []byte("value"))
+	expectValue(t, db, []byte("db"), []byte("value"))
+
+	clock.Advance(time.Second)
+	expectNotFound(t, db, []byte("batch"))
+	expectNotFound(t, db, []byte("db"))
+}
+
+func collectKeys(t *testing.T, it Iterator) []string {
+	defer it.Close()
+	var keys []string
+	for it.Next() {
+		keys = append(keys, string(it.Key()))
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("fail to iterate: %s", err)
+	}
+	return keys
+}
+
+func TestIndexedBatchReadYourWrites(t *testing.T) {
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+
+	db := openTestDB(t, filepath.Join(dir, "db"), nil)
+	defer db.Close()
+	putTestKeys(t, db, 0, 8, nil)
+
+	batch := db.NewIndexedBatch()
+	batch.Put(testKey(1), []byte("batch"))
+	batch.Delete(testKey(2))
+	batch.DeleteRange(testKey(4), testKey(6))
+	batch.Put(testKey(5), []byte("batch"))
+	batch.Put(testKey(9), []byte("batch"))
+
+	values := map[int][]byte{
+		0: testValue(0),
+		1: []byte("batch"),
+		3: testValue(3),
+		5: []byte("batch"),
+		6: testValue(6),
+		7: testValue(7),
+		9: []byte("batch"),
+	}
+	for i := 0; i < 10; i++ {
+		value, err := batch.GetFromBatchAndDB(db, testKey(i), nil)
+		want, ok := values[i]
+		switch {
+		case !ok && err != ErrNotFound:
+			t.Fatalf("key %s: expect ErrNotFound, got value %q, error %v", testKey(i), value, err)
+		case ok && err != nil:
+			t.Fatalf("fail to get key %s from batch and db: %s", testKey(i), err)
+		case ok && !bytes.Equal(value, want):
+			t.Fatalf("key %s: got value %q, want %q", testKey(i), value, want)
+		}
+	}
+
+	want := []string{"key000000", "key000001", "key000003", "key000005", "key000006", "key000007", "key000009"}
+	if got := collectKeys(t, batch.NewIteratorWithBase(db.All(nil))); !reflect.DeepEqual(got, want) {
+		t.Fatalf("iterate batch with base: got keys %v, want %v", got, want)
+	}
+	expectValue(t, db, testKey(1), testValue(1))
+	expectNotFound(t, db, testKey(9))
+
+	if err := db.Write(*batch, nil); err != nil {
+		t.Fatalf("fail to write batch: %s", err)
+	}
+	if got := collectKeys(t, db.All(nil)); !reflect.DeepEqual(got, want) {
+		t.Fatalf("iterate db after batch written: got keys %v, want %v", got, want)
+	}
+	for i, value := range values {
+		expectValue(t, db, testKey(i), value)
+	}
+}
+
+func TestBatchNotIndexed(t *testing.T) {
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+
+	db := openTestDB(t, filepath.Join(dir, "db"), nil)
+	defer db.Close()
+	putTestKeys(t, db, 0, 8, nil)
+
+	var batch Batch
+	batch.Put(testKey(1), []byte("batch"))
+	if _, err := batch.GetFromBatchAndDB(db, testKey(1), nil); err != ErrBatchNotIndexed {
+		t.Fatalf("get from plain batch: expect error %v, got %v", ErrBatchNotIndexed, err)
+	}
+	it := batch.NewIteratorWithBase(db.All(nil))
+	if it.First() {
+		t.Fatalf("iterate plain batch: expect no entries")
+	}
+	if err := it.Close(); err != ErrBatchNotIndexed {
+		t.Fatalf("iterate plain batch: expect error %v, got %v", ErrBatchNotIndexed, err)
+	}
+}
+
+// batchRecorder records puts and deletions replayed from batch.
+type batchRecorder struct {
+	updates []string
+}
+
+func (r *batchRecorder) Put(key, value []byte) {
+	r.updates = append(r.updates, "put "+string(key)+"="+string(value))
+}
+
+func (r *batchRecorder) Delete(key []byte) {
+	r.updates = append(r.updates, "delete "+string(key))
+}
+
+// fullBatchRecorder records all kinds of updates replayed from batch.
+type fullBatchRecorder struct {
+	batchRecorder
+	expirations []time.Time
+}
+
+func (r *fullBatchRecorder) Merge(key, operand []byte) {
+	r.updates = append(r.updates, "merge "+string(key)+"="+string(operand))
+}
+
+func (r *fullBatchRecorder) DeleteRange(start, limit []byte) {
+	r.updates = append(r.updates, "delete range "+string(start)+"-"+string(limit))
+}
+
+func (r *fullBatchRecorder) PutWithExpiration(key, value []byte, expiration time.Time) {
+	r.updates = append(r.updates, "put "+string(key)+"="+string(value)+" with expiration")
+	r.expirations = append(r.expirations, expiration)
+}
+
+func buildFullBatch() *Batch {
+	var batch Batch
+	batch.Put([]byte("a"), []byte("1"))
+	batch.Delete([]byte("b"))
+	batch.Merge([]byte("c"), []byte("2"))
+	batch.DeleteRange([]byte("d"), []byte("f"))
+	batch.PutWithTTL([]byte("g"), []byte("3"), time.Hour)
+	return &batch
+}
+
+var fullBatchUpdates = []string{
+	"put a=1",
+	"delete b",
+	"merge c=2",
+	"delete range d-f",
+	"put g=3 with expiration",
+}
+
+func TestBatchRepr(t *testing.T) {
+	empty, err := NewBatchFrom(nil)
+	if err != nil {
+		t.Fatalf("fail to create batch from empty data: %s", err)
+	}
+	if empty.Len() != 0 || len(empty.Repr()) != 0 {
+		t.Fatalf("batch from empty data: got %d updates, repr %q", empty.Len(), empty.Repr())
+	}
+
+	before := time.Now()
+	batch := buildFullBatch()
+	after := time.Now()
+	repr := batch.Repr()
+	if len(repr) != batch.Size() {
+		t.Fatalf("got repr of %d bytes, want size %d", len(repr), batch.Size())
+	}
+	decoded, err := NewBatchFrom(repr)
+	if err != nil {
+		t.Fatalf("fail to create batch from repr: %s", err)
+	}
+	if decoded.Len() != batch.Len() {
+		t.Fatalf("got %d updates from repr, want %d", decoded.Len(), batch.Len())
+	}
+	if !bytes.Equal(decoded.Repr(), repr) {
+		t.Fatalf("got repr %q, want %q", decoded.Repr(), repr)
+	}
+	// Batch created from data owns a copy of it.
+	batch.Put([]byte("h"), []byte("4"))
+	var r fullBatchRecorder
+	if err := decoded.Replay(&r); err != nil {
+		t.Fatalf("fail to replay batch from repr: %s", err)
+	}
+	if !reflect.DeepEqual(r.updates, fullBatchUpdates) {
+		t.Fatalf("replay batch from repr: got updates %q, want %q", r.updates, fullBatchUpdates)
+	}
+	// Expiration of unwritten batch is computed from time of addition.
+	if expiration := r.expirations[0]; expiration.Before(before.Add(time.Hour)) || expiration.After(after.Add(time.Hour)) {
+		t.Fatalf("replay batch from repr: got expiration %s, want between %s and %s", expiration, before.Add(time.Hour), after.Add(time.Hour))
+	}
+}
+
+func TestBatchCorruptRepr(t *testing.T) {
+	repr := buildFullBatch().Repr()
+	for n := 1; n < len(repr); n++ {
+		if _, err := NewBatchFrom(repr[:n]); err == nil || !IsCorrupt(err) {
+			t.Fatalf("truncated to %d bytes: expect corruption error, got %v", n, err)
+		}
+	}
+	corrupt := append([]byte(nil), repr...)
+	// Kind of first update follows header of sequence and count.
+	corrupt[12] = 0xff
+	if _, err := NewBatchFrom(corrupt); err == nil || !IsCorrupt(err) {
+		t.Fatalf("unknown update kind: expect corruption error, got %v", err)
+	}
+}
+
+func TestBatchReplayUnhandledUpdate(t *testing.T) {
+	var r batchRecorder
+	if err := buildFullBatch().Replay(&r); err != ErrUnhandledUpdate {
+		t.Fatalf("replay merge to plain handler: expect error %v, got %v", ErrUnhandledUpdate, err)
+	}
+	// Replay stops at first unhandled update.
+	if want := fullBatchUpdates[:2]; !reflect.DeepEqual(r.updates, want) {
+		t.Fatalf("replay to plain handler: got updates %q, want %q", r.updates, want)
+	}
+
+	tests := []struct {
+		name  string
+		build func(batch *Batch)
+	}{
+		{"range deletion", func(batch *Batch) { batch.DeleteRange([]byte("a"), []byte("b")) }},
+		{"put with ttl", func(batch *Batch) { batch.PutWithTTL([]byte("a"), []byte("1"), time.Hour) }},
+	}
+	for _, test := range tests {
+		var batch Batch
+		test.build(&batch)
+		if err := batch.Replay(&batchRecorder{}); err != ErrUnhandledUpdate {
+			t.Fatalf("replay %s to plain handler: expect error %v, got %v", test.name, ErrUnhandledUpdate, err)
+		}
+	}
+}
+
+func TestBatchSavePoint(t *testing.T) {
+	var batch Batch
+	if err := batch.RollbackToSavePoint(); err != ErrNoSavePoint {
+		t.Fatalf("rollback without save point: expect error %v, got %v", ErrNoSavePoint, err)
+	}
+	if err := batch.PopSavePoint(); err != ErrNoSavePoint {
+		t.Fatalf("pop without save point: expect error %v, got %v", ErrNoSavePoint, err)
+	}
+
+	batch.Put([]byte("a"), []byte("1"))
+	batch.SetSavePoint()
+	repr := append([]byte(nil), batch.Repr()...)
+	batch.Delete([]byte("b"))
+	batch.SetSavePoint()
+	batch.Merge([]byte("c"), []byte("2"))
+	if err := batch.PopSavePoint(); err != nil {
+		t.Fatalf("fail to pop save point: %s", err)
+	}
+	if err := batch.RollbackToSavePoint(); err != nil {
+		t.Fatalf("fail to rollback to save point: %s", err)
+	}
+	if batch.Len() != 1 || !bytes.Equal(batch.Repr(), repr) {
+		t.Fatalf("rollback to save point: got %d updates, repr %q, want repr %q", batch.Len(), batch.Repr(), repr)
+	}
+	if err := batch.RollbackToSavePoint(); err != ErrNoSavePoint {
+		t.Fatalf("rollback after save points consumed: expect error %v, got %v", ErrNoSavePoint, err)
+	}
+
+	batch.SetSavePoint()
+	batch.Clear()
+	if err := batch.RollbackToSavePoint(); err != ErrNoSavePoint {
+		t.Fatalf("rollback after clear: expect error %v, got %v", ErrNoSavePoint, err)
+	}
+}
+
+func TestIndexedBatchRollbackToSavePoint(t *testing.T) {
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+
+	db := openTestDB(t, filepath.Join(dir, "db"), nil)
+	defer db.Close()
+	putTestKeys(t, db, 0, 4, nil)
+
+	batch := db.NewIndexedBatch()
+	if err := batch.RollbackToSavePoint(); err != ErrNoSavePoint {
+		t.Fatalf("rollback without save point: expect error %v, got %v", ErrNoSavePoint, err)
+	}
+	batch.Put(testKey(0), []byte("batch"))
+	batch.SetSavePoint()
+	batch.Put(testKey(1), []byte("dropped"))
+	batch.Delete(testKey(2))
+	batch.DeleteRange(testKey(3), testKey(4))
+	batch.Put(testKey(5), []byte("dropped"))
+	if err := batch.RollbackToSavePoint(); err != nil {
+		t.Fatalf("fail to rollback to save point: %s", err)
+	}
+	batch.Put(testKey(6), []byte("batch"))
+
+	values := map[int][]byte{
+		0: []byte("batch"),
+		1: testValue(1),
+		2: testValue(2),
+		3: testValue(3),
+		6: []byte("batch"),
+	}
+	for i := 0; i < 8; i++ {
+		value, err := batch.GetFromBatchAndDB(db, testKey(i), nil)
+		want, ok := values[i]
+		switch {
+		case !ok && err != ErrNotFound:
+			t.Fatalf("key %s: expect ErrNotFound, got value %q, error %v", testKey(i), value, err)
+		case ok && err != nil:
+			t.Fatalf("fail to get key %s from batch and db: %s", testKey(i), err)
+		case ok && !bytes.Equal(value, want):
+			t.Fatalf("key %s: got value %q, want %q", testKey(i), value, want)
+		}
+	}
+	want := []string{"key000000", "key000001", "key000002", "key000003", "key000006"}
+	if got := collectKeys(t, batch.NewIteratorWithBase(db.All(nil))); !reflect.DeepEqual(got, want) {
+		t.Fatalf("iterate batch with base: got keys %v, want %v", got, want)
+	}
+
+	if err := db.Write(*batch, nil); err != nil {
+		t.Fatalf("fail to write batch: %s", err)
+	}
+	for i := 0; i < 8; i++ {
+		if value, ok := values[i]; ok {
+			expectValue(t, db, testKey(i), value)
+		} else {
+			expectNotFound(t, db, testKey(i))
+		}
+	}
+}
+
+func TestBatchRollbackPutWithTTL(t *testing.T) {
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+
+	clock := &manualClock{now: time.Unix(1000, 0)}
+	db := openTestDB(t, filepath.Join(dir, "db"), &Options{Clock: clock})
+	defer db.Close()
+
+	var batch Batch
+	batch.PutWithTTL([]byte("kept"), []byte("value"), time.Hour)
+	batch.SetSavePoint()
+	batch.PutWithTTL([]byte("dropped"), []byte("value"), time.Hour)
+	batch.Put([]byte("dropped-put"), []byte("value"))
+	if err := batch.RollbackToSavePoint(); err != nil {
+		t.Fatalf("fail to rollback to save point: %s", err)
+	}
+	batch.PutWithTTL([]byte("later"), []byte("value"), 2*time.Hour)
+
+	// Expirations of remaining puts are still resolved when batch is written.
+	clock.Advance(time.Hour)
+	if err := db.Write(batch, nil); err != nil {
+		t.Fatalf("fail to write batch: %s", err)
+	}
+	expectNotFound(t, db, []byte("dropped"))
+	expectNotFound(t, db, []byte("dropped-put"))
+
+	clock.Advance(time.Hour - time.Second)
+	expectValue(t, db, []byte("kept"), []byte("value"))
+	expectValue(t, db, []byte("later"), []byte("value"))
+
+	clock.Advance(time.Second)
+	expectNotFound(t, db, []byte("kept"))
+	expectValue(t, db, []byte("later"), []byte("value"))
+
+	clock.Advance(time.Hour)
+	expectNotFound(t, db, []byte("later"))
+}
@@ -0,0 +1,17 @@
+package leveldb
+
+import (
+	"time"
+
+	"github.com/kezhuw/leveldb/internal/options"
+)
+
+// Clock tells current time, which decides expiration of values written with
+// time to live. Methods of a clock may be called by concurrent goroutines.
+type Clock interface {
+	// Now returns current time.
+	Now() time.Time
+}
+
+var _ Clock = (options.Clock)(nil)
+var _ options.Clock = (Clock)(nil)
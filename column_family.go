@@ -0,0 +1,125 @@
+package leveldb
+
+import (
+	"time"
+
+	"github.com/kezhuw/leveldb/internal/leveldb"
+)
+
+// ColumnFamily is a handle to a separated keyspace of db. Each column family
+// has its own memtables, table files, comparator and compression options.
+// All column families share log files of db, so a Batch updating several
+// column families is applied atomically.
+type ColumnFamily struct {
+	cf *leveldb.ColumnFamily
+}
+
+// CreateColumnFamily creates a column family with given name and options. It
+// returns ErrColumnFamilyExists if name is in use. Clock, Logger, FileSystem,
+// CreateIfMissing, ErrorIfExists, RetainLogs and ColumnFamilies in opts are
+// ignored. The same options should be specified in Options.ColumnFamilies
+// when opening db later.
+func (db *DB) CreateColumnFamily(name string, opts *Options) (*ColumnFamily, error) {
+	cf, err := db.db.CreateColumnFamily(name, convertOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+	return &ColumnFamily{cf: cf}, nil
+}
+
+// DropColumnFamily drops cf and all its data. Operations on cf after this
+// call get error ErrColumnFamilyDropped. It returns ErrDropDefaultFamily for
+// default column family.
+func (db *DB) DropColumnFamily(cf *ColumnFamily) error {
+	return db.db.DropColumnFamily(cf.cf)
+}
+
+// ColumnFamily returns handle to column family with given name, or nil if
+// there is no such column family.
+func (db *DB) ColumnFamily(name string) *ColumnFamily {
+	cf := db.db.ColumnFamily(name)
+	if cf == nil {
+		return nil
+	}
+	return &ColumnFamily{cf: cf}
+}
+
+// DefaultColumnFamily returns handle to default column family, which is
+// operated by methods of DB.
+func (db *DB) DefaultColumnFamily() *ColumnFamily {
+	return &ColumnFamily{cf: db.db.DefaultColumnFamily()}
+}
+
+// Name returns name of column family.
+func (cf *ColumnFamily) Name() string {
+	return cf.cf.Name()
+}
+
+// Get gets value for given key. It returns ErrNotFound if column family does
+// not contain that key.
+func (cf *ColumnFamily) Get(key []byte, opts *ReadOptions) ([]byte, error) {
+	return cf.cf.Get(key, convertReadOptions(opts))
+}
+
+// Put stores a key/value pair in column family.
+func (cf *ColumnFamily) Put(key, value []byte, opts *WriteOptions) error {
+	return cf.cf.Put(key, value, convertWriteOptions(opts))
+}
+
+// PutWithTTL stores a key/value pair in column family, which expires after
+// ttl as DB.PutWithTTL does.
+func (cf *ColumnFamily) PutWithTTL(key, value []byte, ttl time.Duration, opts *WriteOptions) error {
+	return cf.cf.PutWithTTL(key, value, ttl, convertWriteOptions(opts))
+}
+
+// Delete deletes the entry for given key in column family.
+func (cf *ColumnFamily) Delete(key []byte, opts *WriteOptions) error {
+	return cf.cf.Delete(key, convertWriteOptions(opts))
+}
+
+// Merge merges operand into value of key using Options.MergeOperator of
+// column family.
+func (cf *ColumnFamily) Merge(key, operand []byte, opts *WriteOptions) error {
+	return cf.cf.Merge(key, operand, convertWriteOptions(opts))
+}
+
+// DeleteRange deletes all entries with keys in range [start, limit) in column
+// family.
+func (cf *ColumnFamily) DeleteRange(start, limit []byte, opts *WriteOptions) error {
+	return cf.cf.DeleteRange(start, limit, convertWriteOptions(opts))
+}
+
+// All returns an iterator catching all keys in column family.
+func (cf *ColumnFamily) All(opts *ReadOptions) Iterator {
+	return cf.cf.All(convertReadOptions(opts))
+}
+
+// Find returns an iterator catching all keys greater than or equal to start
+// in column family. Zero length start acts as infinite small.
+func (cf *ColumnFamily) Find(start []byte, opts *ReadOptions) Iterator {
+	return cf.cf.Find(start, convertReadOptions(opts))
+}
+
+// Range returns an iterator catching all keys in range [start, limit) in
+// column family.
+func (cf *ColumnFamily) Range(start, limit []byte, opts *ReadOptions) Iterator {
+	return cf.cf.Range(start, limit, convertReadOptions(opts))
+}
+
+// Prefix returns an iterator catching all keys having prefix as prefix in
+// column family.
+func (cf *ColumnFamily) Prefix(prefix []byte, opts *ReadOptions) Iterator {
+	return cf.cf.Prefix(prefix, convertReadOptions(opts))
+}
+
+// CompactRange compacts keys in range [start, limit] of column family as
+// DB.CompactRange does. Memtables of all column families are flushed first.
+func (cf *ColumnFamily) CompactRange(start, limit []byte) error {
+	return cf.cf.CompactRange(start, limit)
+}
+
+// GetProperty returns value of a property about column family state. Valid
+// properties are same as DB.GetProperty.
+func (cf *ColumnFamily) GetProperty(name string) (string, bool) {
+	return cf.cf.GetProperty(name)
+}
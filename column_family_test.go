@@ -0,0 +1,97 @@
+package leveldb
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func expectFamilyValue(t *testing.T, cf *ColumnFamily, key, value []byte) {
+	got, err := cf.Get(key, nil)
+	if err != nil {
+		t.Fatalf("fail to get key %s from column family %s: %s", key, cf.Name(), err)
+	}
+	if !bytes.Equal(got, value) {
+		t.Fatalf("key %s of column family %s: got value %q, want %q", key, cf.Name(), got, value)
+	}
+}
+
+func TestColumnFamily(t *testing.T) {
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+
+	dbname := filepath.Join(dir, "db")
+	db := openTestDB(t, dbname, nil)
+	a, err := db.CreateColumnFamily("a", nil)
+	if err != nil {
+		t.Fatalf("fail to create column family: %s", err)
+	}
+	b, err := db.CreateColumnFamily("b", nil)
+	if err != nil {
+		t.Fatalf("fail to create column family: %s", err)
+	}
+	if _, err := db.CreateColumnFamily("a", nil); err != ErrColumnFamilyExists {
+		t.Fatalf("create column family with name in use: expect error %v, got %v", ErrColumnFamilyExists, err)
+	}
+	if err := db.DropColumnFamily(db.DefaultColumnFamily()); err != ErrDropDefaultFamily {
+		t.Fatalf("drop default column family: expect error %v, got %v", ErrDropDefaultFamily, err)
+	}
+
+	key := []byte("key")
+	for _, cf := range []*ColumnFamily{db.DefaultColumnFamily(), a, b} {
+		if err := cf.Put(key, []byte(cf.Name()), nil); err != nil {
+			t.Fatalf("fail to put to column family %s: %s", cf.Name(), err)
+		}
+	}
+	if err := db.Flush(true); err != nil {
+		t.Fatalf("fail to flush: %s", err)
+	}
+	if err := a.Put([]byte("logged"), []byte("a"), nil); err != nil {
+		t.Fatalf("fail to put to column family: %s", err)
+	}
+	expectValue(t, db, key, []byte("default"))
+	expectFamilyValue(t, a, key, []byte("a"))
+	expectFamilyValue(t, b, key, []byte("b"))
+	if got := db.ColumnFamily("a"); got == nil || got.Name() != "a" {
+		t.Fatalf("column family a: got %v", got)
+	}
+
+	if err := db.DropColumnFamily(b); err != nil {
+		t.Fatalf("fail to drop column family: %s", err)
+	}
+	if _, err := b.Get(key, nil); err != ErrColumnFamilyDropped {
+		t.Fatalf("get from dropped column family: expect error %v, got %v", ErrColumnFamilyDropped, err)
+	}
+	if err := b.Put(key, []byte("b"), nil); err != ErrColumnFamilyDropped {
+		t.Fatalf("put to dropped column family: expect error %v, got %v", ErrColumnFamilyDropped, err)
+	}
+	if cf := db.ColumnFamily("b"); cf != nil {
+		t.Fatalf("dropped column family b: got %v", cf)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("fail to close db: %s", err)
+	}
+
+	db = openTestDB(t, dbname, &Options{ColumnFamilies: map[string]*Options{"a": {}}})
+	defer db.Close()
+	a = db.ColumnFamily("a")
+	if a == nil {
+		t.Fatalf("column family a not found after reopen")
+	}
+	if cf := db.ColumnFamily("b"); cf != nil {
+		t.Fatalf("dropped column family b found after reopen")
+	}
+	expectValue(t, db, key, []byte("default"))
+	expectFamilyValue(t, a, key, []byte("a"))
+	expectFamilyValue(t, a, []byte("logged"), []byte("a"))
+	expectNotFound(t, db, []byte("logged"))
+
+	b, err = db.CreateColumnFamily("b", nil)
+	if err != nil {
+		t.Fatalf("fail to recreate column family: %s", err)
+	}
+	if value, err := b.Get(key, nil); err != ErrNotFound {
+		t.Fatalf("get from recreated column family: expect ErrNotFound, got value %q, error %v", value, err)
+	}
+}
@@ -0,0 +1,55 @@
+package leveldb
+
+import "github.com/kezhuw/leveldb/internal/compaction"
+
+// CompactionDecision tells compaction what to do with an entry passed to
+// CompactionFilter.
+type CompactionDecision int
+
+const (
+	// CompactionKeep keeps entry as it is.
+	CompactionKeep CompactionDecision = iota
+
+	// CompactionRemove removes entry, as if its key had been deleted.
+	CompactionRemove
+
+	// CompactionChangeValue replaces value of entry with the one returned
+	// from CompactionFilter.
+	CompactionChangeValue
+)
+
+// CompactionFilter filters entries during compaction. It enables expiring,
+// rewriting or garbage collecting entries without scanning whole db. Methods
+// of a compaction filter may be called by concurrent goroutines.
+//
+// Compaction filter is not applied by compactions started while snapshots
+// are live, so no reads from snapshots could observe changes made by it.
+// Deletions and merge operands which have not been merged into values are
+// not passed. Entries not compacted yet are not filtered, readers must not
+// rely on the filter for correctness.
+type CompactionFilter interface {
+	// Name returns the name of this compaction filter.
+	Name() string
+
+	// Filter decides fate of value of key, which is being compacted to
+	// level. Level is zero if entry is being flushed from memtable. The
+	// returned value is used only if decision is CompactionChangeValue.
+	Filter(level int, key, value []byte) (decision CompactionDecision, newValue []byte)
+}
+
+type wrappedCompactionFilter struct {
+	CompactionFilter
+}
+
+func (f wrappedCompactionFilter) Filter(level int, key, value []byte) (compaction.Decision, []byte) {
+	decision, newValue := f.CompactionFilter.Filter(level, key, value)
+	switch decision {
+	case CompactionRemove:
+		return compaction.Remove, nil
+	case CompactionChangeValue:
+		return compaction.ChangeValue, newValue
+	}
+	return compaction.Keep, nil
+}
+
+var _ compaction.Filter = wrappedCompactionFilter{}
@@ -0,0 +1,46 @@
+package leveldb
+
+import "github.com/kezhuw/leveldb/internal/compress"
+
+// Compression ids stored in trailer of table blocks for builtin compressions.
+const (
+	NoCompressionID     = uint8(compress.NoCompression)
+	SnappyCompressionID = uint8(compress.SnappyCompression)
+	ZstdCompressionID   = uint8(compress.ZstdCompression)
+	LZ4CompressionID    = uint8(compress.LZ4Compression)
+)
+
+// customCompression is the base of CompressionType for registered
+// compressors.
+const customCompression CompressionType = 1 << 8
+
+// Compressor defines methods to compress and decompress table blocks. Many
+// goroutines may call methods of this compressor concurrently.
+type Compressor interface {
+	// Encode returns the compressed form of src. The returned slice may be
+	// a sub-slice of dst if dst was large enough to hold the compressed data.
+	Encode(dst, src []byte) ([]byte, error)
+
+	// Decode returns the decompressed form of src. The returned slice may be
+	// a sub-slice of dst if dst was large enough to hold the decompressed
+	// data.
+	Decode(dst, src []byte) ([]byte, error)
+}
+
+// RegisterCompressor registers a compressor for compression id, which is
+// stored in trailer of table blocks to identify the compression used. It
+// returns a CompressionType which can be used as Options.Compression.
+// Compressors for NoCompressionID, SnappyCompressionID and LZ4CompressionID
+// are builtin and can't be registered. Compressor registered for
+// ZstdCompressionID replaces the builtin one, and must read and write zstd
+// frames. All compressors used to compress existing tables must be
+// registered before opening the db.
+func RegisterCompressor(id uint8, c Compressor) (CompressionType, error) {
+	if err := compress.Register(compress.Type(id), c); err != nil {
+		return DefaultCompression, err
+	}
+	if id == ZstdCompressionID {
+		return ZstdCompression, nil
+	}
+	return customCompression + CompressionType(id), nil
+}
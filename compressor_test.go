@@ -0,0 +1,131 @@
+package leveldb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestZstdCompression(t *testing.T) {
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+
+	for i, opts := range []*Options{
+		{Compression: ZstdCompression},
+		{LevelCompression: []CompressionType{ZstdCompression, SnappyCompression}},
+	} {
+		dbname := filepath.Join(dir, fmt.Sprintf("db%d", i))
+		db := openTestDB(t, dbname, opts)
+		putTestKeys(t, db, 0, 1000, nil)
+		if err := db.Flush(true); err != nil {
+			t.Fatalf("fail to flush: %s", err)
+		}
+		if err := db.CompactRange(nil, nil); err != nil {
+			t.Fatalf("fail to compact: %s", err)
+		}
+		if err := db.Close(); err != nil {
+			t.Fatalf("fail to close db: %s", err)
+		}
+		db = openTestDB(t, dbname, opts)
+		expectTestKeys(t, db, 0, 1000)
+		db.Close()
+	}
+}
+
+func TestUnregisteredCompression(t *testing.T) {
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+
+	unregistered := customCompression + 202
+	dbname := filepath.Join(dir, "db")
+	for _, opts := range []*Options{
+		{Compression: unregistered},
+		{LevelCompression: []CompressionType{NoCompression, unregistered}},
+		{ColumnFamilies: map[string]*Options{"custom": {Compression: unregistered}}},
+	} {
+		opts.CreateIfMissing = true
+		if db, err := Open(dbname, opts); err != ErrUnsupportedCompression {
+			if err == nil {
+				db.Close()
+			}
+			t.Fatalf("open with unregistered compression: expect error %v, got %v", ErrUnsupportedCompression, err)
+		}
+	}
+
+	db := openTestDB(t, dbname, nil)
+	defer db.Close()
+	if _, err := db.CreateColumnFamily("custom", &Options{Compression: unregistered}); err != ErrUnsupportedCompression {
+		t.Fatalf("create column family with unregistered compression: expect error %v, got %v", ErrUnsupportedCompression, err)
+	}
+}
+
+func TestCustomCompressor(t *testing.T) {
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+
+	compression, err := RegisterCompressor(201, nopCompressor{})
+	if err != nil {
+		t.Fatalf("fail to register compressor: %s", err)
+	}
+	dbname := filepath.Join(dir, "db")
+	db := openTestDB(t, dbname, &Options{Compression: compression})
+	defer db.Close()
+	putTestKeys(t, db, 0, 1000, nil)
+	if err := db.Flush(true); err != nil {
+		t.Fatalf("fail to flush: %s", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("fail to close db: %s", err)
+	}
+	db = openTestDB(t, dbname, &Options{Compression: compression})
+	defer db.Close()
+	expectTestKeys(t, db, 0, 1000)
+}
+
+func TestLevelCompressionTables(t *testing.T) {
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+
+	for n, levelCompression := range [][]CompressionType{
+		{NoCompression, SnappyCompression},
+		{SnappyCompression, NoCompression},
+	} {
+		dbname := filepath.Join(dir, fmt.Sprintf("db%d", n))
+		db := openTestDB(t, dbname, &Options{LevelCompression: levelCompression})
+		for i := 0; i < 1000; i++ {
+			if err := db.Put(testKey(i), blobValue(i), nil); err != nil {
+				t.Fatalf("fail to put key %s: %s", testKey(i), err)
+			}
+		}
+		// Memtable output stays in level 0 for compression differing
+		// from level 1.
+		if err := db.Flush(true); err != nil {
+			t.Fatalf("fail to flush: %s", err)
+		}
+		levels := levelFileSizes(t, db)
+		if len(levels[0]) != 1 {
+			t.Fatalf("level compression %v: expect one file in level 0, got %v", levelCompression, levels)
+		}
+		level0 := totalFileSize(levels[0])
+		if err := db.CompactRange(nil, nil); err != nil {
+			t.Fatalf("fail to compact range: %s", err)
+		}
+		levels = levelFileSizes(t, db)
+		if len(levels[0]) != 0 || len(levels[1]) == 0 {
+			t.Fatalf("level compression %v: expect files compacted to level 1, got %v", levelCompression, levels)
+		}
+		level1 := totalFileSize(levels[1])
+		compressed, uncompressed := level1, level0
+		if levelCompression[0] != NoCompression {
+			compressed, uncompressed = level0, level1
+		}
+		if compressed*2 > uncompressed {
+			t.Fatalf("level compression %v: got %d bytes in level 0, %d bytes in level 1", levelCompression, level0, level1)
+		}
+		for i := 0; i < 1000; i++ {
+			expectValue(t, db, testKey(i), blobValue(i))
+		}
+		db.Close()
+	}
+}
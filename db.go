@@ -2,6 +2,7 @@ package leveldb
 
 import (
 	"runtime"
+	"time"
 
 	"github.com/kezhuw/leveldb/internal/errors"
 	"github.com/kezhuw/leveldb/internal/leveldb"
@@ -23,6 +24,41 @@ func Open(dbname string, opts *Options) (*DB, error) {
 	return db, nil
 }
 
+// OpenReadOnly opens an existing LevelDB database stored in directory 'dbname'
+// for reads only. It doesn't lock the database, so other processes could open
+// it meanwhile, and it writes no files: updates in log files are replayed to
+// memory, and no compactions are run. Updates through returned DB fail with
+// ErrReadOnly. Data written by others after opening is not visible.
+func OpenReadOnly(dbname string, opts *Options) (*DB, error) {
+	ldb, err := leveldb.OpenReadOnly(dbname, convertOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+	db := &DB{db: ldb}
+	runtime.SetFinalizer(db, (*DB).finalize)
+	return db, nil
+}
+
+// Repair recovers as much data as possible from a corrupted database which
+// can't be opened, for example, due to corrupted or missing MANIFEST files.
+// Log files are converted to tables, unreadable files are moved to directory
+// "lost" under 'dbname', and a new MANIFEST is written for all recovered
+// tables. Column families are recovered from old MANIFEST files, options of
+// them should be specified in Options.ColumnFamilies. Repair fails if updates
+// of column families can't be told apart, for example, MANIFEST files are
+// missing. Some data may be lost, so be careful when calling this function on
+// a database that contains important information.
+func Repair(dbname string, opts *Options) error {
+	return leveldb.Repair(dbname, convertOptions(opts))
+}
+
+// Destroy removes database files in directory 'dbname' and the directory if
+// it is empty after that. It fails if the database is opened by others. Files
+// not created by LevelDB are left untouched.
+func Destroy(dbname string, opts *Options) error {
+	return leveldb.Destroy(dbname, convertOptions(opts))
+}
+
 func (db *DB) finalize() {
 	go db.db.Close()
 }
@@ -49,12 +85,32 @@ func (db *DB) Put(key, value []byte, opts *WriteOptions) error {
 	return db.db.Put(key, value, convertWriteOptions(opts))
 }
 
+// PutWithTTL stores a key/value pair in DB, which expires after ttl as told by
+// Options.Clock. Expired entries are hidden from reads, and dropped from files
+// by compactions. Merges into an expiring value apply to it until it expires,
+// and apply as if key did not exist after that.
+func (db *DB) PutWithTTL(key, value []byte, ttl time.Duration, opts *WriteOptions) error {
+	return db.db.PutWithTTL(key, value, ttl, convertWriteOptions(opts))
+}
+
 // Delete deletes the database entry for given key. It is not an error
 // if db does not contain that key.
 func (db *DB) Delete(key []byte, opts *WriteOptions) error {
 	return db.db.Delete(key, convertWriteOptions(opts))
 }
 
+// Merge merges operand into value of key using Options.MergeOperator. It
+// returns ErrNoMergeOperator if db is opened without a merge operator.
+func (db *DB) Merge(key, operand []byte, opts *WriteOptions) error {
+	return db.db.Merge(key, operand, convertWriteOptions(opts))
+}
+
+// DeleteRange deletes all database entries with keys in range [start, limit).
+// Keys are not required to exist in db.
+func (db *DB) DeleteRange(start, limit []byte, opts *WriteOptions) error {
+	return db.db.DeleteRange(start, limit, convertWriteOptions(opts))
+}
+
 // Write applies batch to db.
 func (db *DB) Write(batch Batch, opts *WriteOptions) error {
 	switch {
@@ -89,6 +145,68 @@ func (db *DB) Prefix(prefix []byte, opts *ReadOptions) Iterator {
 	return db.db.Prefix(prefix, convertReadOptions(opts))
 }
 
+// CompactRange compacts underlying storage for keys in range [start, limit].
+// Data in memtable is flushed to table first. Deleted and overwritten versions
+// of keys are discarded, and data is pushed down to the bottommost level
+// containing keys in that range. Zero length start acts as infinite small,
+// zero length limit acts as infinite large. This call blocks until the
+// compaction is done.
+func (db *DB) CompactRange(start, limit []byte) error {
+	return db.db.CompactRange(start, limit)
+}
+
+// Flush compacts data in memtable to table file. If wait is true, it blocks
+// until the compaction is done.
+func (db *DB) Flush(wait bool) error {
+	return db.db.Flush(wait)
+}
+
+// GetProperty returns value of a property about db state. It returns false
+// if name is not a valid property.
+//
+// Valid properties:
+//
+//	"leveldb.num-files-at-level<N>" - number of files at level <N>, where <N>
+//	   is an ASCII representation of a level number (e.g. "0").
+//	"leveldb.stats" - multi-line string that describes statistics about the
+//	   files and compactions in each level.
+//	"leveldb.sstables" - multi-line string that describes all of the table
+//	   files that make up the db contents.
+//	"leveldb.approximate-memory-usage" - approximate number of bytes of
+//	   memory in use by memtables.
+func (db *DB) GetProperty(name string) (string, bool) {
+	return db.db.GetProperty(name)
+}
+
+// Range represents key range [Start, Limit).
+type Range struct {
+	// Start is the first key in range. Zero length Start acts as infinite small.
+	Start []byte
+
+	// Limit is the key after the last key in range. Zero length Limit acts as
+	// infinite large.
+	Limit []byte
+}
+
+// GetApproximateSizes returns approximate file system space used by keys in
+// each range. Data in memtables is not counted, so sizes may not reflect
+// recent written data.
+func (db *DB) GetApproximateSizes(ranges []Range) []uint64 {
+	sizes := make([]uint64, len(ranges))
+	for i, r := range ranges {
+		sizes[i] = db.db.ApproximateSize(r.Start, r.Limit)
+	}
+	return sizes
+}
+
+// Checkpoint creates an openable point-in-time copy of db in directory 'dir',
+// which must not contain a database. Table and blob files are hard linked if
+// FileSystem implements Linker, copied otherwise. Log file is copied up to the
+// point captured, after writes not logged are flushed to tables.
+func (db *DB) Checkpoint(dir string) error {
+	return db.db.Checkpoint(dir)
+}
+
 // GetSnapshot captures current state of db as a Snapshot. Following updates in
 // db will not affect the state of Snapshot.
 func (db *DB) GetSnapshot() *Snapshot {
@@ -0,0 +1,1471 @@
+package leveldb
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "leveldb-test-")
+	if err != nil {
+		t.Fatalf("fail to create temp dir: %s", err)
+	}
+	return dir
+}
+
+func openTestDB(t *testing.T, dbname string, opts *Options) *DB {
+	if opts == nil {
+		opts = &Options{}
+	}
+	opts.CreateIfMissing = true
+	db, err := Open(dbname, opts)
+	if err != nil {
+		t.Fatalf("fail to open db %s: %s", dbname, err)
+	}
+	return db
+}
+
+func testKey(i int) []byte {
+	return []byte(fmt.Sprintf("key%06d", i))
+}
+
+func testValue(i int) []byte {
+	return []byte(fmt.Sprintf("value%06d", i))
+}
+
+func putTestKeys(t *testing.T, db *DB, start, end int, opts *WriteOptions) {
+	for i := start; i < end; i++ {
+		if err := db.Put(testKey(i), testValue(i), opts); err != nil {
+			t.Fatalf("fail to put key %s: %s", testKey(i), err)
+		}
+	}
+}
+
+func expectValue(t *testing.T, db *DB, key, value []byte) {
+	got, err := db.Get(key, nil)
+	if err != nil {
+		t.Fatalf("fail to get key %s: %s", key, err)
+	}
+	if !bytes.Equal(got, value) {
+		t.Fatalf("key %s: got value %q, want %q", key, got, value)
+	}
+}
+
+func expectNotFound(t *testing.T, db *DB, key []byte) {
+	value, err := db.Get(key, nil)
+	if err != ErrNotFound {
+		t.Fatalf("key %s: expect ErrNotFound, got value %q, error %v", key, value, err)
+	}
+}
+
+func expectTestKeys(t *testing.T, db *DB, start, end int) {
+	for i := start; i < end; i++ {
+		expectValue(t, db, testKey(i), testValue(i))
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+
+	dbname := filepath.Join(dir, "db")
+	db := openTestDB(t, dbname, nil)
+	defer db.Close()
+
+	putTestKeys(t, db, 0, 100, nil)
+	if err := db.Flush(true); err != nil {
+		t.Fatalf("fail to flush: %s", err)
+	}
+	putTestKeys(t, db, 100, 200, nil)
+	putTestKeys(t, db, 200, 300, &WriteOptions{DisableWAL: true})
+	putTestKeys(t, db, 300, 400, nil)
+
+	checkpoint := filepath.Join(dir, "checkpoint")
+	if err := db.Checkpoint(checkpoint); err != nil {
+		t.Fatalf("fail to create checkpoint: %s", err)
+	}
+	if err := db.Checkpoint(checkpoint); err != ErrDBExists {
+		t.Fatalf("checkpoint to existing db: expect error %v, got %v", ErrDBExists, err)
+	}
+
+	putTestKeys(t, db, 400, 500, nil)
+	if err := db.Delete(testKey(0), nil); err != nil {
+		t.Fatalf("fail to delete: %s", err)
+	}
+
+	cp := openTestDB(t, checkpoint, nil)
+	defer cp.Close()
+	expectTestKeys(t, cp, 0, 400)
+	expectNotFound(t, cp, testKey(400))
+
+	// Checkpoint is independent of its origin.
+	putTestKeys(t, cp, 500, 600, nil)
+	expectNotFound(t, db, testKey(500))
+	if err := cp.Close(); err != nil {
+		t.Fatalf("fail to close checkpoint: %s", err)
+	}
+	cp = openTestDB(t, checkpoint, nil)
+	defer cp.Close()
+	expectTestKeys(t, cp, 0, 400)
+	expectTestKeys(t, cp, 500, 600)
+}
+
+// logContains reports whether any log file of db contains data.
+func logContains(t *testing.T, dbname string, data []byte) bool {
+	matches, err := filepath.Glob(filepath.Join(dbname, "*.log"))
+	if err != nil {
+		t.Fatalf("fail to list log files: %s", err)
+	}
+	for _, name := range matches {
+		content, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatalf("fail to read log file %s: %s", name, err)
+		}
+		if bytes.Contains(content, data) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestDisableWAL(t *testing.T) {
+	for name, opts := range map[string]Options{
+		"default": {},
+		"blob":    {MinBlobSize: 8},
+	} {
+		opts := opts
+		t.Run(name, func(t *testing.T) {
+			testDisableWAL(t, &opts)
+		})
+	}
+}
+
+func testDisableWAL(t *testing.T, opts *Options) {
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+
+	dbname := filepath.Join(dir, "db")
+	db := openTestDB(t, dbname, opts)
+	defer db.Close()
+
+	putTestKeys(t, db, 0, 100, nil)
+	putTestKeys(t, db, 100, 200, &WriteOptions{DisableWAL: true})
+	if err := db.Delete(testKey(0), &WriteOptions{DisableWAL: true}); err != nil {
+		t.Fatalf("fail to delete: %s", err)
+	}
+	expectTestKeys(t, db, 1, 200)
+	expectNotFound(t, db, testKey(0))
+
+	if !logContains(t, dbname, testValue(99)) {
+		t.Fatalf("logged value %s not found in log files", testValue(99))
+	}
+	for i := 100; i < 200; i++ {
+		if logContains(t, dbname, testValue(i)) {
+			t.Fatalf("unlogged value %s found in log files", testValue(i))
+		}
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("fail to close db: %s", err)
+	}
+	db = openTestDB(t, dbname, opts)
+	defer db.Close()
+	expectTestKeys(t, db, 1, 200)
+	expectNotFound(t, db, testKey(0))
+}
+
+// levelFileSizes returns sizes of table files in each level of db, parsed
+// from property "leveldb.sstables".
+func levelFileSizes(t *testing.T, db *DB) [][]uint64 {
+	sstables, ok := db.GetProperty("leveldb.sstables")
+	if !ok {
+		t.Fatalf("no property leveldb.sstables")
+	}
+	var levels [][]uint64
+	for _, line := range strings.Split(sstables, "\n") {
+		var level, number int
+		var size uint64
+		if n, _ := fmt.Sscanf(line, "level %d contains", &level); n == 1 {
+			levels = append(levels, nil)
+		} else if n, _ := fmt.Sscanf(line, "file %d: size %d,", &number, &size); n == 2 {
+			levels[len(levels)-1] = append(levels[len(levels)-1], size)
+		}
+	}
+	return levels
+}
+
+func totalFileSize(sizes []uint64) (total uint64) {
+	for _, size := range sizes {
+		total += size
+	}
+	return total
+}
+
+// waitCompactionsSettled waits until no level of db needs compaction.
+func waitCompactionsSettled(t *testing.T, db *DB) {
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		sstables, _ := db.GetProperty("leveldb.sstables")
+		if strings.Contains(sstables, "compaction scores: []") {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("compactions not settled:\n%s", sstables)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func numFiles(t *testing.T, db *DB) int {
+	var n int
+	for level := 0; level < 7; level++ {
+		value, ok := db.GetProperty(fmt.Sprintf("leveldb.num-files-at-level%d", level))
+		if !ok {
+			t.Fatalf("no number of files at level %d", level)
+		}
+		var files int
+		fmt.Sscan(value, &files)
+		n += files
+	}
+	return n
+}
+
+func TestCompactRange(t *testing.T) {
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+
+	db := openTestDB(t, filepath.Join(dir, "db"), nil)
+	defer db.Close()
+
+	putTestKeys(t, db, 0, 1000, nil)
+	if err := db.CompactRange(nil, nil); err != nil {
+		t.Fatalf("fail to compact range: %s", err)
+	}
+	if n := numFiles(t, db); n == 0 {
+		t.Fatalf("no files after compacting range")
+	}
+	expectTestKeys(t, db, 0, 1000)
+
+	// Deletions in memtable are flushed and compacted with data in tables.
+	for i := 0; i < 1000; i++ {
+		if err := db.Delete(testKey(i), nil); err != nil {
+			t.Fatalf("fail to delete key %s: %s", testKey(i), err)
+		}
+	}
+	if err := db.CompactRange(nil, nil); err != nil {
+		t.Fatalf("fail to compact range: %s", err)
+	}
+	if n := numFiles(t, db); n != 0 {
+		t.Fatalf("got %d files after compacting deleted range, want none", n)
+	}
+	expectNotFound(t, db, testKey(0))
+}
+
+func removeManifests(t *testing.T, dbname string) {
+	matches, err := filepath.Glob(filepath.Join(dbname, "MANIFEST-*"))
+	if err != nil || len(matches) == 0 {
+		t.Fatalf("no manifest files found in %s: %v", dbname, err)
+	}
+	for _, name := range matches {
+		if err := os.Remove(name); err != nil {
+			t.Fatalf("fail to remove manifest file: %s", err)
+		}
+	}
+}
+
+func TestRepairMissingManifest(t *testing.T) {
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+
+	dbname := filepath.Join(dir, "db")
+	db := openTestDB(t, dbname, nil)
+	putTestKeys(t, db, 0, 100, nil)
+	if err := db.Flush(true); err != nil {
+		t.Fatalf("fail to flush: %s", err)
+	}
+	putTestKeys(t, db, 100, 200, nil)
+	if err := db.Close(); err != nil {
+		t.Fatalf("fail to close db: %s", err)
+	}
+
+	removeManifests(t, dbname)
+	if db, err := Open(dbname, &Options{}); err == nil {
+		db.Close()
+		t.Fatalf("open db without manifest: expect error")
+	}
+	if err := Repair(dbname, nil); err != nil {
+		t.Fatalf("fail to repair db: %s", err)
+	}
+	db = openTestDB(t, dbname, nil)
+	defer db.Close()
+	expectTestKeys(t, db, 0, 200)
+	putTestKeys(t, db, 200, 300, nil)
+	expectTestKeys(t, db, 0, 300)
+}
+
+// renameFailFileSystem fails renaming files.
+type renameFailFileSystem struct {
+	FileSystem
+}
+
+func (renameFailFileSystem) Rename(oldpath, newpath string) error {
+	return fmt.Errorf("rename %s to %s: permission denied", oldpath, newpath)
+}
+
+func TestRepairArchiveError(t *testing.T) {
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+
+	dbname := filepath.Join(dir, "db")
+	db := openTestDB(t, dbname, nil)
+	putTestKeys(t, db, 0, 100, nil)
+	if err := db.Close(); err != nil {
+		t.Fatalf("fail to close db: %s", err)
+	}
+
+	removeManifests(t, dbname)
+	opts := &Options{FileSystem: renameFailFileSystem{DefaultFileSystem}}
+	if err := Repair(dbname, opts); err == nil {
+		t.Fatalf("repair with failed archiving: expect error")
+	}
+}
+
+func TestRepairColumnFamilies(t *testing.T) {
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+
+	dbname := filepath.Join(dir, "db")
+	db := openTestDB(t, dbname, nil)
+	cf1, err := db.CreateColumnFamily("cf1", nil)
+	if err != nil {
+		t.Fatalf("fail to create column family: %s", err)
+	}
+	cf2, err := db.CreateColumnFamily("cf2", nil)
+	if err != nil {
+		t.Fatalf("fail to create column family: %s", err)
+	}
+	families := []*ColumnFamily{db.DefaultColumnFamily(), cf1, cf2}
+	for _, key := range []string{"flushed", "logged"} {
+		for _, cf := range families {
+			if err := cf.Put([]byte(key), []byte(cf.Name()), nil); err != nil {
+				t.Fatalf("fail to put to column family %s: %s", cf.Name(), err)
+			}
+		}
+		if key == "flushed" {
+			if err := db.Flush(true); err != nil {
+				t.Fatalf("fail to flush: %s", err)
+			}
+		}
+	}
+	if err := db.DropColumnFamily(cf2); err != nil {
+		t.Fatalf("fail to drop column family: %s", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("fail to close db: %s", err)
+	}
+
+	if err := Repair(dbname, nil); err != nil {
+		t.Fatalf("fail to repair db: %s", err)
+	}
+	db = openTestDB(t, dbname, nil)
+	cf1 = db.ColumnFamily("cf1")
+	if cf1 == nil {
+		t.Fatalf("column family cf1 not found after repair")
+	}
+	if cf := db.ColumnFamily("cf2"); cf != nil {
+		t.Fatalf("dropped column family cf2 found after repair")
+	}
+	for _, key := range []string{"flushed", "logged"} {
+		expectValue(t, db, []byte(key), []byte("default"))
+		expectFamilyValue(t, cf1, []byte(key), []byte("cf1"))
+	}
+	if err := cf1.Put([]byte("unknown"), []byte("cf1"), nil); err != nil {
+		t.Fatalf("fail to put to column family: %s", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("fail to close db: %s", err)
+	}
+
+	// Updates of column families can't be recovered without manifest.
+	removeManifests(t, dbname)
+	if err := Repair(dbname, nil); err == nil {
+		t.Fatalf("repair column family updates without manifest: expect error")
+	}
+}
+
+// createTableFailFileSystem fails creating table files.
+type createTableFailFileSystem struct {
+	FileSystem
+}
+
+func (fs createTableFailFileSystem) Open(name string, flag int) (File, error) {
+	if flag&os.O_CREATE != 0 && filepath.Ext(name) == ".ldb" {
+		return nil, fmt.Errorf("open %s: no space left on device", name)
+	}
+	return fs.FileSystem.Open(name, flag)
+}
+
+func TestRepairConvertLogError(t *testing.T) {
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+
+	dbname := filepath.Join(dir, "db")
+	db := openTestDB(t, dbname, nil)
+	putTestKeys(t, db, 0, 100, nil)
+	if err := db.Close(); err != nil {
+		t.Fatalf("fail to close db: %s", err)
+	}
+	logs, err := filepath.Glob(filepath.Join(dbname, "*.log"))
+	if err != nil || len(logs) == 0 {
+		t.Fatalf("no log files found in %s: %v", dbname, err)
+	}
+
+	removeManifests(t, dbname)
+	var buf bytes.Buffer
+	opts := &Options{FileSystem: createTableFailFileSystem{DefaultFileSystem}, Logger: newBufferLogger(&buf)}
+	if err := Repair(dbname, opts); err == nil {
+		t.Fatalf("repair with failed table writing: expect error")
+	}
+	if !strings.Contains(buf.String(), "fail to convert log") {
+		t.Fatalf("repair with failed table writing: got log %q", buf.String())
+	}
+	for _, name := range logs {
+		if _, err := os.Stat(name); err != nil {
+			t.Fatalf("log not converted is archived: %s", err)
+		}
+	}
+
+	if err := Repair(dbname, nil); err != nil {
+		t.Fatalf("fail to repair db: %s", err)
+	}
+	db = openTestDB(t, dbname, nil)
+	defer db.Close()
+	expectTestKeys(t, db, 0, 100)
+}
+
+func TestFlush(t *testing.T) {
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+
+	dbname := filepath.Join(dir, "db")
+	db := openTestDB(t, dbname, nil)
+	defer db.Close()
+
+	if err := db.Flush(true); err != nil {
+		t.Fatalf("fail to flush empty memtable: %s", err)
+	}
+	if n := numFiles(t, db); n != 0 {
+		t.Fatalf("got %d files after flushing empty memtable, want none", n)
+	}
+
+	putTestKeys(t, db, 0, 100, nil)
+	if err := db.Flush(true); err != nil {
+		t.Fatalf("fail to flush: %s", err)
+	}
+	if n := numFiles(t, db); n != 1 {
+		t.Fatalf("got %d files after flushing, want 1", n)
+	}
+
+	putTestKeys(t, db, 100, 200, nil)
+	if err := db.Flush(false); err != nil {
+		t.Fatalf("fail to flush without waiting: %s", err)
+	}
+	if err := db.Flush(true); err != nil {
+		t.Fatalf("fail to wait flush: %s", err)
+	}
+	if n := numFiles(t, db); n != 2 {
+		t.Fatalf("got %d files after flushing twice, want 2", n)
+	}
+	expectTestKeys(t, db, 0, 200)
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("fail to close db: %s", err)
+	}
+	if err := db.Flush(true); err != ErrDBClosed {
+		t.Fatalf("flush closed db: expect error %v, got %v", ErrDBClosed, err)
+	}
+	db = openTestDB(t, dbname, nil)
+	defer db.Close()
+	if n := numFiles(t, db); n != 2 {
+		t.Fatalf("got %d files after reopening, want 2", n)
+	}
+	expectTestKeys(t, db, 0, 200)
+}
+
+func TestGetProperty(t *testing.T) {
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+
+	db := openTestDB(t, filepath.Join(dir, "db"), nil)
+	defer db.Close()
+
+	for _, name := range []string{"", "leveldb.", "leveldb.unknown", "rocksdb.stats", "leveldb.num-files-at-level", "leveldb.num-files-at-level7", "leveldb.num-files-at-levelx"} {
+		if value, ok := db.GetProperty(name); ok {
+			t.Errorf("property %q: expect invalid, got value %q", name, value)
+		}
+	}
+
+	empty, ok := db.GetProperty("leveldb.approximate-memory-usage")
+	if !ok {
+		t.Fatalf("no approximate memory usage")
+	}
+	putTestKeys(t, db, 0, 100, nil)
+	usage, _ := db.GetProperty("leveldb.approximate-memory-usage")
+	var emptyUsage, putUsage int
+	fmt.Sscan(empty, &emptyUsage)
+	fmt.Sscan(usage, &putUsage)
+	if putUsage <= emptyUsage {
+		t.Fatalf("memory usage: got %d after puts, %d before", putUsage, emptyUsage)
+	}
+
+	if err := db.Flush(true); err != nil {
+		t.Fatalf("fail to flush: %s", err)
+	}
+	var level int
+	for ; level < 7; level++ {
+		if n, _ := db.GetProperty(fmt.Sprintf("leveldb.num-files-at-level%d", level)); n == "1" {
+			break
+		}
+	}
+	if level == 7 {
+		t.Fatalf("no level contains flushed file")
+	}
+	stats, ok := db.GetProperty("leveldb.stats")
+	if !ok || !strings.Contains(stats, "Compactions") {
+		t.Fatalf("stats: got %q", stats)
+	}
+	found := false
+	for _, line := range strings.Split(stats, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 6 && fields[0] == strconv.Itoa(level) && fields[1] == "1" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("stats: expect one file in level %d, got:\n%s", level, stats)
+	}
+	sstables, ok := db.GetProperty("leveldb.sstables")
+	if !ok || !strings.Contains(sstables, string(testKey(0))) || !strings.Contains(sstables, string(testKey(99))) {
+		t.Fatalf("sstables: expect range of flushed file, got:\n%s", sstables)
+	}
+}
+
+func TestGetApproximateSizes(t *testing.T) {
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+
+	db := openTestDB(t, filepath.Join(dir, "db"), &Options{Compression: NoCompression})
+	defer db.Close()
+
+	value := bytes.Repeat([]byte("v"), 1000)
+	for i := 0; i < 1000; i++ {
+		if err := db.Put(testKey(i), value, nil); err != nil {
+			t.Fatalf("fail to put: %s", err)
+		}
+	}
+	all := []Range{{}}
+	if sizes := db.GetApproximateSizes(all); sizes[0] != 0 {
+		t.Fatalf("data in memtable: got size %d, want 0", sizes[0])
+	}
+	if err := db.CompactRange(nil, nil); err != nil {
+		t.Fatalf("fail to compact range: %s", err)
+	}
+
+	sizes := db.GetApproximateSizes([]Range{
+		{},
+		{Start: testKey(0), Limit: testKey(500)},
+		{Start: testKey(500)},
+		{Start: testKey(500), Limit: testKey(100)},
+		{Start: testKey(2000)},
+	})
+	const total = 1000 * 1000
+	expectBetween := func(i int, min, max uint64) {
+		if sizes[i] < min || sizes[i] > max {
+			t.Errorf("range %d: got size %d, want in [%d, %d]", i, sizes[i], min, max)
+		}
+	}
+	expectBetween(0, total, total*11/10)
+	expectBetween(1, total/2*9/10, total/2*11/10)
+	expectBetween(2, total/2*9/10, total/2*11/10)
+	expectBetween(3, 0, 0)
+	expectBetween(4, 0, total/100)
+}
+
+func TestDestroy(t *testing.T) {
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+
+	dbname := filepath.Join(dir, "db")
+	db := openTestDB(t, dbname, nil)
+	putTestKeys(t, db, 0, 100, nil)
+	if err := db.Flush(true); err != nil {
+		t.Fatalf("fail to flush: %s", err)
+	}
+	putTestKeys(t, db, 100, 200, nil)
+	if err := Destroy(dbname, &Options{}); err == nil {
+		t.Fatalf("destroy opened db: expect error")
+	}
+	expectTestKeys(t, db, 0, 200)
+	if err := db.Close(); err != nil {
+		t.Fatalf("fail to close db: %s", err)
+	}
+
+	if err := Destroy(dbname, &Options{}); err != nil {
+		t.Fatalf("fail to destroy db: %s", err)
+	}
+	if _, err := os.Stat(dbname); !os.IsNotExist(err) {
+		t.Fatalf("db directory exists after destroy: %v", err)
+	}
+	if err := Destroy(dbname, &Options{}); err != nil {
+		t.Fatalf("fail to destroy nonexistent db: %s", err)
+	}
+
+	db = openTestDB(t, dbname, nil)
+	putTestKeys(t, db, 0, 100, nil)
+	if err := db.Close(); err != nil {
+		t.Fatalf("fail to close db: %s", err)
+	}
+	other := filepath.Join(dbname, "other.txt")
+	if err := ioutil.WriteFile(other, []byte("other"), 0644); err != nil {
+		t.Fatalf("fail to write file: %s", err)
+	}
+	if err := Destroy(dbname, &Options{}); err != nil {
+		t.Fatalf("fail to destroy db: %s", err)
+	}
+	names, err := ioutil.ReadDir(dbname)
+	if err != nil {
+		t.Fatalf("fail to read db directory: %s", err)
+	}
+	if len(names) != 1 || names[0].Name() != "other.txt" {
+		var got []string
+		for _, fi := range names {
+			got = append(got, fi.Name())
+		}
+		t.Fatalf("files after destroy: got %v, want [other.txt]", got)
+	}
+}
+
+func listFileNames(t *testing.T, dir string) []string {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("fail to read directory %s: %s", dir, err)
+	}
+	names := make([]string, len(infos))
+	for i, fi := range infos {
+		names[i] = fi.Name()
+	}
+	return names
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+
+	dbname := filepath.Join(dir, "db")
+	if _, err := OpenReadOnly(dbname, nil); err != ErrDBMissing {
+		t.Fatalf("open missing db read only: expect error %v, got %v", ErrDBMissing, err)
+	}
+
+	db := openTestDB(t, dbname, nil)
+	putTestKeys(t, db, 0, 100, nil)
+	if err := db.Flush(true); err != nil {
+		t.Fatalf("fail to flush: %s", err)
+	}
+	putTestKeys(t, db, 100, 200, nil)
+	if err := db.Close(); err != nil {
+		t.Fatalf("fail to close db: %s", err)
+	}
+	names := listFileNames(t, dbname)
+
+	unregistered := customCompression + 203
+	if _, err := OpenReadOnly(dbname, &Options{Compression: unregistered}); err != ErrUnsupportedCompression {
+		t.Fatalf("open db read only with unregistered compression: expect error %v, got %v", ErrUnsupportedCompression, err)
+	}
+
+	rdb, err := OpenReadOnly(dbname, nil)
+	if err != nil {
+		t.Fatalf("fail to open db read only: %s", err)
+	}
+	defer rdb.Close()
+	expectTestKeys(t, rdb, 0, 200)
+
+	key := testKey(0)
+	writes := map[string]func() error{
+		"Put":        func() error { return rdb.Put(key, []byte("value"), nil) },
+		"Delete":     func() error { return rdb.Delete(key, nil) },
+		"Flush":      func() error { return rdb.Flush(true) },
+		"Compact":    func() error { return rdb.CompactRange(nil, nil) },
+		"Checkpoint": func() error { return rdb.Checkpoint(filepath.Join(dir, "checkpoint")) },
+		"CreateColumnFamily": func() error {
+			_, err := rdb.CreateColumnFamily("cf", nil)
+			return err
+		},
+	}
+	for name, write := range writes {
+		if err := write(); err != ErrReadOnly {
+			t.Fatalf("%s on read only db: expect error %v, got %v", name, ErrReadOnly, err)
+		}
+	}
+	expectTestKeys(t, rdb, 0, 200)
+	if got := listFileNames(t, dbname); !reflect.DeepEqual(got, names) {
+		t.Fatalf("files after opening read only: got %v, want %v", got, names)
+	}
+
+	// Read only db doesn't lock db.
+	db = openTestDB(t, dbname, nil)
+	defer db.Close()
+	if err := db.Put(key, []byte("value"), nil); err != nil {
+		t.Fatalf("fail to put: %s", err)
+	}
+	expectValue(t, db, key, []byte("value"))
+	expectValue(t, rdb, key, testValue(0))
+}
+
+// appendOperator appends operands to existing value, separated by commas.
+// Missing value is merged as "-".
+type appendOperator struct{}
+
+func (appendOperator) Name() string {
+	return "leveldb.test.append"
+}
+
+func (appendOperator) Merge(key, existing []byte, operands [][]byte) ([]byte, error) {
+	if existing == nil {
+		existing = []byte("-")
+	}
+	value := append([]byte(nil), existing...)
+	for _, operand := range operands {
+		value = append(value, ',')
+		value = append(value, operand...)
+	}
+	return value, nil
+}
+
+// collectEntries returns entries of iterator in "key=value" form.
+func collectEntries(t *testing.T, it Iterator) []string {
+	defer it.Close()
+	var entries []string
+	for it.Next() {
+		entries = append(entries, string(it.Key())+"="+string(it.Value()))
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("fail to iterate: %s", err)
+	}
+	return entries
+}
+
+func expectEntries(t *testing.T, it Iterator, want ...string) {
+	if got := collectEntries(t, it); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got entries %v, want %v", got, want)
+	}
+}
+
+func TestMergeExpiringValue(t *testing.T) {
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+
+	clock := &manualClock{now: time.Unix(1000, 0)}
+	db := openTestDB(t, filepath.Join(dir, "db"), &Options{Clock: clock, MergeOperator: appendOperator{}})
+	defer db.Close()
+
+	if err := db.PutWithTTL([]byte("a"), []byte("av"), time.Minute, nil); err != nil {
+		t.Fatalf("fail to put with ttl: %s", err)
+	}
+	if err := db.PutWithTTL([]byte("b"), []byte("bv"), time.Minute, nil); err != nil {
+		t.Fatalf("fail to put with ttl: %s", err)
+	}
+	for _, key := range []string{"a", "b"} {
+		if err := db.Merge([]byte(key), []byte("+"), nil); err != nil {
+			t.Fatalf("fail to merge: %s", err)
+		}
+	}
+	if err := db.Flush(true); err != nil {
+		t.Fatalf("fail to flush: %s", err)
+	}
+	if err := db.Merge([]byte("b"), []byte("*"), nil); err != nil {
+		t.Fatalf("fail to merge: %s", err)
+	}
+
+	// Merges apply to live expiring values, and survive compaction without
+	// being folded, as their results change after values expire.
+	for i := 0; i < 2; i++ {
+		expectValue(t, db, []byte("a"), []byte("av,+"))
+		expectValue(t, db, []byte("b"), []byte("bv,+,*"))
+		expectEntries(t, db.All(nil), "a=av,+", "b=bv,+,*")
+		if err := db.CompactRange(nil, nil); err != nil {
+			t.Fatalf("fail to compact range: %s", err)
+		}
+	}
+
+	// Merges apply as if key did not exist after value expired.
+	clock.Advance(2 * time.Minute)
+	for i := 0; i < 2; i++ {
+		expectValue(t, db, []byte("a"), []byte("-,+"))
+		expectValue(t, db, []byte("b"), []byte("-,+,*"))
+		expectEntries(t, db.All(nil), "a=-,+", "b=-,+,*")
+		if err := db.CompactRange(nil, nil); err != nil {
+			t.Fatalf("fail to compact range: %s", err)
+		}
+	}
+
+	// Flush folds merges into expired value as if key did not exist.
+	if err := db.PutWithTTL([]byte("c"), []byte("cv"), time.Minute, nil); err != nil {
+		t.Fatalf("fail to put with ttl: %s", err)
+	}
+	if err := db.PutWithTTL([]byte("d"), []byte("dv"), time.Minute, nil); err != nil {
+		t.Fatalf("fail to put with ttl: %s", err)
+	}
+	if err := db.Merge([]byte("d"), []byte("+"), nil); err != nil {
+		t.Fatalf("fail to merge: %s", err)
+	}
+	clock.Advance(2 * time.Minute)
+	for i := 0; i < 2; i++ {
+		expectNotFound(t, db, []byte("c"))
+		expectValue(t, db, []byte("d"), []byte("-,+"))
+		expectEntries(t, db.All(nil), "a=-,+", "b=-,+,*", "d=-,+")
+		if err := db.Flush(true); err != nil {
+			t.Fatalf("fail to flush: %s", err)
+		}
+	}
+}
+
+func collectReverseEntries(t *testing.T, it Iterator) []string {
+	defer it.Close()
+	var entries []string
+	for it.Prev() {
+		entries = append(entries, string(it.Key())+"="+string(it.Value()))
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("fail to iterate: %s", err)
+	}
+	return entries
+}
+
+func testEntries(indices ...int) []string {
+	entries := make([]string, len(indices))
+	for i, index := range indices {
+		entries[i] = string(testKey(index)) + "=" + string(testValue(index))
+	}
+	return entries
+}
+
+func TestDeleteRange(t *testing.T) {
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+
+	dbname := filepath.Join(dir, "db")
+	db := openTestDB(t, dbname, nil)
+	defer func() {
+		db.Close()
+	}()
+	putTestKeys(t, db, 0, 20, nil)
+	if err := db.Flush(true); err != nil {
+		t.Fatalf("fail to flush: %s", err)
+	}
+	ss := db.GetSnapshot()
+	defer ss.Close()
+
+	if err := db.DeleteRange(testKey(5), testKey(10), nil); err != nil {
+		t.Fatalf("fail to delete range: %s", err)
+	}
+	var batch Batch
+	batch.DeleteRange(testKey(12), testKey(15))
+	if err := db.Write(batch, nil); err != nil {
+		t.Fatalf("fail to write batch: %s", err)
+	}
+
+	deleted := map[int]bool{}
+	check := func(snapshot bool) {
+		var remaining []int
+		for i := 0; i < 20; i++ {
+			if deleted[i] {
+				expectNotFound(t, db, testKey(i))
+			} else {
+				expectValue(t, db, testKey(i), testValue(i))
+				remaining = append(remaining, i)
+			}
+		}
+		if got, want := collectEntries(t, db.All(nil)), testEntries(remaining...); !reflect.DeepEqual(got, want) {
+			t.Fatalf("got entries %v, want %v", got, want)
+		}
+		want := testEntries(11, 10, 4, 3)
+		if got := collectReverseEntries(t, db.Range(testKey(3), testKey(13), nil)); !reflect.DeepEqual(got, want) {
+			t.Fatalf("got reverse entries %v, want %v", got, want)
+		}
+		expectEntries(t, db.Range(testKey(4), testKey(12), nil), testEntries(4, 10, 11)...)
+
+		it := db.All(nil)
+		if !it.Seek(testKey(5)) || !bytes.Equal(it.Key(), testKey(10)) {
+			t.Fatalf("seek into deleted range: expect key %s", testKey(10))
+		}
+		if !it.Prev() || !bytes.Equal(it.Key(), testKey(4)) {
+			t.Fatalf("prev from deleted range: expect key %s", testKey(4))
+		}
+		it.Close()
+
+		if !snapshot {
+			return
+		}
+		for i := 0; i < 20; i++ {
+			value, err := ss.Get(testKey(i), nil)
+			if err != nil || !bytes.Equal(value, testValue(i)) {
+				t.Fatalf("snapshot key %s: got value %q, error %v, want %q", testKey(i), value, err, testValue(i))
+			}
+		}
+		all := make([]int, 20)
+		for i := range all {
+			all[i] = i
+		}
+		expectEntries(t, ss.All(nil), testEntries(all...)...)
+	}
+	for i := 5; i < 10; i++ {
+		deleted[i] = true
+	}
+	for i := 12; i < 15; i++ {
+		deleted[i] = true
+	}
+
+	check(true)
+	if err := db.Flush(true); err != nil {
+		t.Fatalf("fail to flush: %s", err)
+	}
+	check(true)
+	if err := db.CompactRange(nil, nil); err != nil {
+		t.Fatalf("fail to compact range: %s", err)
+	}
+	check(true)
+
+	ss.Close()
+	if err := db.CompactRange(nil, nil); err != nil {
+		t.Fatalf("fail to compact range: %s", err)
+	}
+	check(false)
+
+	// Tombstones in both tables and log survive reopen.
+	if err := db.DeleteRange(testKey(17), testKey(19), nil); err != nil {
+		t.Fatalf("fail to delete range: %s", err)
+	}
+	deleted[17], deleted[18] = true, true
+	if err := db.Close(); err != nil {
+		t.Fatalf("fail to close db: %s", err)
+	}
+	db = openTestDB(t, dbname, nil)
+	check(false)
+}
+
+// prefixFilter removes keys prefixed with "remove", and changes values of
+// keys prefixed with "change" to "changed".
+type prefixFilter struct {
+	mu     sync.Mutex
+	levels []int
+}
+
+func (*prefixFilter) Name() string {
+	return "leveldb.test.prefix"
+}
+
+func (f *prefixFilter) Filter(level int, key, value []byte) (CompactionDecision, []byte) {
+	f.mu.Lock()
+	f.levels = append(f.levels, level)
+	f.mu.Unlock()
+	switch {
+	case bytes.HasPrefix(key, []byte("remove")):
+		return CompactionRemove, nil
+	case bytes.HasPrefix(key, []byte("change")):
+		return CompactionChangeValue, []byte("changed")
+	}
+	return CompactionKeep, nil
+}
+
+// calledLevels returns levels passed to Filter since last call.
+func (f *prefixFilter) calledLevels() []int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	levels := f.levels
+	f.levels = nil
+	return levels
+}
+
+// putFilterKeys writes keys twice with a flush in between, so compaction of
+// them could not be a trivial move.
+func putFilterKeys(t *testing.T, db *DB, keys ...string) {
+	for i := 0; i < 2; i++ {
+		for _, key := range keys {
+			if err := db.Put([]byte(key), []byte(key+"v"), nil); err != nil {
+				t.Fatalf("fail to put key %s: %s", key, err)
+			}
+		}
+		if err := db.Flush(true); err != nil {
+			t.Fatalf("fail to flush: %s", err)
+		}
+	}
+}
+
+func TestCompactionFilter(t *testing.T) {
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+
+	filter := &prefixFilter{}
+	db := openTestDB(t, filepath.Join(dir, "db"), &Options{CompactionFilter: filter})
+	defer db.Close()
+
+	putFilterKeys(t, db, "change", "keep", "remove")
+	if levels := filter.calledLevels(); len(levels) != 0 {
+		t.Fatalf("filter called in flush without CompactionFilterOnFlush: levels %v", levels)
+	}
+	expectEntries(t, db.All(nil), "change=changev", "keep=keepv", "remove=removev")
+
+	if err := db.CompactRange(nil, nil); err != nil {
+		t.Fatalf("fail to compact range: %s", err)
+	}
+	levels := filter.calledLevels()
+	if len(levels) == 0 {
+		t.Fatalf("filter not called in level compaction")
+	}
+	for _, level := range levels {
+		if level <= 0 {
+			t.Fatalf("filter called with level %d in level compaction", level)
+		}
+	}
+	expectValue(t, db, []byte("change"), []byte("changed"))
+	expectValue(t, db, []byte("keep"), []byte("keepv"))
+	expectNotFound(t, db, []byte("remove"))
+	expectEntries(t, db.All(nil), "change=changed", "keep=keepv")
+}
+
+func TestCompactionFilterOnFlush(t *testing.T) {
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+
+	filter := &prefixFilter{}
+	db := openTestDB(t, filepath.Join(dir, "db"), &Options{CompactionFilter: filter, CompactionFilterOnFlush: true})
+	defer db.Close()
+
+	for _, key := range []string{"change", "keep", "remove"} {
+		if err := db.Put([]byte(key), []byte(key+"v"), nil); err != nil {
+			t.Fatalf("fail to put key %s: %s", key, err)
+		}
+	}
+	expectEntries(t, db.All(nil), "change=changev", "keep=keepv", "remove=removev")
+	if err := db.Flush(true); err != nil {
+		t.Fatalf("fail to flush: %s", err)
+	}
+	if levels := filter.calledLevels(); !reflect.DeepEqual(levels, []int{0, 0, 0}) {
+		t.Fatalf("filter called with levels %v in flush, want [0 0 0]", levels)
+	}
+	expectValue(t, db, []byte("change"), []byte("changed"))
+	expectNotFound(t, db, []byte("remove"))
+	expectEntries(t, db.All(nil), "change=changed", "keep=keepv")
+}
+
+func TestCompactionFilterSnapshot(t *testing.T) {
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+
+	filter := &prefixFilter{}
+	db := openTestDB(t, filepath.Join(dir, "db"), &Options{CompactionFilter: filter, CompactionFilterOnFlush: true})
+	defer db.Close()
+
+	ss := db.GetSnapshot()
+	defer ss.Close()
+	putFilterKeys(t, db, "change", "keep", "remove")
+	ss.Close()
+
+	// Entries visible to snapshot are not filtered while it is live.
+	ss = db.GetSnapshot()
+	if err := db.CompactRange(nil, nil); err != nil {
+		t.Fatalf("fail to compact range: %s", err)
+	}
+	if levels := filter.calledLevels(); len(levels) != 0 {
+		t.Fatalf("filter called with live snapshot: levels %v", levels)
+	}
+	expectEntries(t, ss.All(nil), "change=changev", "keep=keepv", "remove=removev")
+	expectEntries(t, db.All(nil), "change=changev", "keep=keepv", "remove=removev")
+
+	// Filter applies to later compactions after snapshot released.
+	ss.Close()
+	putFilterKeys(t, db, "change", "remove")
+	if err := db.CompactRange(nil, nil); err != nil {
+		t.Fatalf("fail to compact range: %s", err)
+	}
+	if levels := filter.calledLevels(); len(levels) == 0 {
+		t.Fatalf("filter not called after snapshot released")
+	}
+	expectEntries(t, db.All(nil), "change=changed", "keep=keepv")
+}
+
+// countingOperator is an appendOperator counting its merges.
+type countingOperator struct {
+	appendOperator
+	merges int32
+}
+
+func (op *countingOperator) Merge(key, existing []byte, operands [][]byte) ([]byte, error) {
+	atomic.AddInt32(&op.merges, 1)
+	return op.appendOperator.Merge(key, existing, operands)
+}
+
+func TestMerge(t *testing.T) {
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+
+	dbname := filepath.Join(dir, "db")
+	operator := &countingOperator{}
+	opts := &Options{MergeOperator: operator}
+	db := openTestDB(t, dbname, opts)
+	defer func() {
+		db.Close()
+	}()
+
+	merge := func(key, operand string) {
+		if err := db.Merge([]byte(key), []byte(operand), nil); err != nil {
+			t.Fatalf("fail to merge %s into key %s: %s", operand, key, err)
+		}
+	}
+	if err := db.Put([]byte("a"), []byte("x"), nil); err != nil {
+		t.Fatalf("fail to put: %s", err)
+	}
+	if err := db.Put([]byte("c"), []byte("x"), nil); err != nil {
+		t.Fatalf("fail to put: %s", err)
+	}
+	merge("a", "1")
+	ss := db.GetSnapshot()
+	defer ss.Close()
+	merge("a", "2")
+	var batch Batch
+	batch.Merge([]byte("b"), []byte("1"))
+	batch.Delete([]byte("c"))
+	batch.Merge([]byte("c"), []byte("1"))
+	if err := db.Write(batch, nil); err != nil {
+		t.Fatalf("fail to write batch: %s", err)
+	}
+
+	want := []string{"a=x,1,2", "b=-,1", "c=-,1"}
+	check := func(snapshot bool) {
+		for _, entry := range want {
+			i := strings.IndexByte(entry, '=')
+			expectValue(t, db, []byte(entry[:i]), []byte(entry[i+1:]))
+		}
+		expectEntries(t, db.All(nil), want...)
+		reversed := make([]string, len(want))
+		for i, entry := range want {
+			reversed[len(want)-1-i] = entry
+		}
+		if got := collectReverseEntries(t, db.All(nil)); !reflect.DeepEqual(got, reversed) {
+			t.Fatalf("got reverse entries %v, want %v", got, reversed)
+		}
+		if !snapshot {
+			return
+		}
+		// Snapshot taken between operands sees only older ones.
+		if value, err := ss.Get([]byte("a"), nil); err != nil || string(value) != "x,1" {
+			t.Fatalf("snapshot key a: got value %q, error %v, want %q", value, err, "x,1")
+		}
+		if value, err := ss.Get([]byte("b"), nil); err != ErrNotFound {
+			t.Fatalf("snapshot key b: expect ErrNotFound, got value %q, error %v", value, err)
+		}
+		expectEntries(t, ss.All(nil), "a=x,1", "c=x")
+	}
+
+	check(true)
+	if err := db.Flush(true); err != nil {
+		t.Fatalf("fail to flush: %s", err)
+	}
+	check(true)
+
+	// Operands in memtable merge with those in tables.
+	merge("a", "3")
+	merge("b", "2")
+	want = []string{"a=x,1,2,3", "b=-,1,2", "c=-,1"}
+	check(true)
+	if err := db.CompactRange(nil, nil); err != nil {
+		t.Fatalf("fail to compact range: %s", err)
+	}
+	check(true)
+
+	// Operands are folded into values by compaction after snapshot
+	// released, so reads need no merges. New operand overlaps with table
+	// in bottom level to get it compacted.
+	ss.Close()
+	merge("b", "3")
+	want = []string{"a=x,1,2,3", "b=-,1,2,3", "c=-,1"}
+	if err := db.CompactRange(nil, nil); err != nil {
+		t.Fatalf("fail to compact range: %s", err)
+	}
+	atomic.StoreInt32(&operator.merges, 0)
+	check(false)
+	if n := atomic.LoadInt32(&operator.merges); n != 0 {
+		t.Fatalf("got %d merges in reads after compaction, want 0", n)
+	}
+
+	merge("c", "2")
+	want = []string{"a=x,1,2,3", "b=-,1,2,3", "c=-,1,2"}
+	if err := db.Close(); err != nil {
+		t.Fatalf("fail to close db: %s", err)
+	}
+	db = openTestDB(t, dbname, opts)
+	check(false)
+}
+
+func TestMergeWithoutOperator(t *testing.T) {
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+
+	db := openTestDB(t, filepath.Join(dir, "db"), nil)
+	defer db.Close()
+
+	if err := db.Merge([]byte("a"), []byte("1"), nil); err != ErrNoMergeOperator {
+		t.Fatalf("merge without merge operator: expect error %v, got %v", ErrNoMergeOperator, err)
+	}
+	expectNotFound(t, db, []byte("a"))
+
+	// Operands written in batch fail reads.
+	var batch Batch
+	batch.Merge([]byte("a"), []byte("1"))
+	if err := db.Write(batch, nil); err != nil {
+		t.Fatalf("fail to write batch: %s", err)
+	}
+	if value, err := db.Get([]byte("a"), nil); err != ErrNoMergeOperator {
+		t.Fatalf("get operand without merge operator: expect error %v, got value %q, error %v", ErrNoMergeOperator, value, err)
+	}
+}
+
+func blobValue(i int) []byte {
+	return bytes.Repeat(testValue(i), 20)
+}
+
+// listBlobFiles returns names of blob files in db directory.
+func listBlobFiles(t *testing.T, dbname string) []string {
+	var blobs []string
+	for _, name := range listFileNames(t, dbname) {
+		if strings.HasSuffix(name, ".blob") {
+			blobs = append(blobs, name)
+		}
+	}
+	return blobs
+}
+
+func TestBlobFiles(t *testing.T) {
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+
+	dbname := filepath.Join(dir, "db")
+	opts := &Options{MinBlobSize: 100, BlobGarbageRatio: 0.5}
+	db := openTestDB(t, dbname, opts)
+	defer func() {
+		db.Close()
+	}()
+
+	// Values of even keys are stored in blob files, odd ones in tables.
+	value := func(i int) []byte {
+		if i%2 == 0 {
+			return blobValue(i)
+		}
+		return testValue(i)
+	}
+	deleted := map[int]bool{}
+	check := func(db *DB) {
+		var want []string
+		for i := 0; i < 100; i++ {
+			if deleted[i] {
+				expectNotFound(t, db, testKey(i))
+				continue
+			}
+			expectValue(t, db, testKey(i), value(i))
+			want = append(want, string(testKey(i))+"="+string(value(i)))
+		}
+		expectEntries(t, db.All(nil), want...)
+		reversed := make([]string, len(want))
+		for i, entry := range want {
+			reversed[len(want)-1-i] = entry
+		}
+		if got := collectReverseEntries(t, db.All(nil)); !reflect.DeepEqual(got, reversed) {
+			t.Fatalf("got reverse entries %v, want %v", got, reversed)
+		}
+	}
+
+	for i := 0; i < 100; i++ {
+		if err := db.Put(testKey(i), value(i), nil); err != nil {
+			t.Fatalf("fail to put key %s: %s", testKey(i), err)
+		}
+	}
+	if err := db.Flush(true); err != nil {
+		t.Fatalf("fail to flush: %s", err)
+	}
+	blobs := listBlobFiles(t, dbname)
+	if len(blobs) != 1 {
+		t.Fatalf("got blob files %v after flush, want one", blobs)
+	}
+	check(db)
+	if err := db.CompactRange(nil, nil); err != nil {
+		t.Fatalf("fail to compact range: %s", err)
+	}
+	check(db)
+
+	checkpoint := filepath.Join(dir, "checkpoint")
+	if err := db.Checkpoint(checkpoint); err != nil {
+		t.Fatalf("fail to create checkpoint: %s", err)
+	}
+	cp := openTestDB(t, checkpoint, opts)
+	check(cp)
+	if err := cp.Close(); err != nil {
+		t.Fatalf("fail to close checkpoint: %s", err)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("fail to close db: %s", err)
+	}
+	db = openTestDB(t, dbname, opts)
+	check(db)
+
+	// Compaction of deletions makes most values in blob file garbage.
+	for i := 0; i < 80; i++ {
+		if err := db.Delete(testKey(i), nil); err != nil {
+			t.Fatalf("fail to delete key %s: %s", testKey(i), err)
+		}
+		deleted[i] = true
+	}
+	if err := db.CompactRange(nil, nil); err != nil {
+		t.Fatalf("fail to compact range: %s", err)
+	}
+	check(db)
+
+	// Next compaction of table pointing to collected blob file relocates
+	// its live values, and deletes it. Obsolete files are removed in
+	// background, they are gone after reopened db is closed.
+	if err := db.Put(testKey(85), value(85), nil); err != nil {
+		t.Fatalf("fail to put key %s: %s", testKey(85), err)
+	}
+	if err := db.CompactRange(nil, nil); err != nil {
+		t.Fatalf("fail to compact range: %s", err)
+	}
+	check(db)
+	if err := db.Close(); err != nil {
+		t.Fatalf("fail to close db: %s", err)
+	}
+	db = openTestDB(t, dbname, opts)
+	check(db)
+	if err := db.Close(); err != nil {
+		t.Fatalf("fail to close db: %s", err)
+	}
+	for _, name := range listBlobFiles(t, dbname) {
+		if name == blobs[0] {
+			t.Fatalf("collected blob file %s not deleted", name)
+		}
+	}
+}
+
+func TestTargetFileSizeMultiplier(t *testing.T) {
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+
+	const targetFileSize = 8 * 1024
+	for _, multiplier := range []int{1, 4} {
+		dbname := filepath.Join(dir, fmt.Sprintf("db%d", multiplier))
+		opts := &Options{Compression: NoCompression, TargetFileSize: targetFileSize, TargetFileSizeMultiplier: multiplier}
+		db := openTestDB(t, dbname, opts)
+
+		// Memtable output not overlapping with any file is placed in
+		// level 2, following one overlapping it is placed in level 1.
+		putTestKeys(t, db, 0, 1, nil)
+		putTestKeys(t, db, 4999, 5000, nil)
+		if err := db.Flush(true); err != nil {
+			t.Fatalf("fail to flush: %s", err)
+		}
+		if levels := levelFileSizes(t, db); len(levels[2]) != 1 {
+			t.Fatalf("multiplier %d: expect one file in level 2, got %v", multiplier, levels)
+		}
+		for i := 0; i < 5000; i++ {
+			if err := db.Put(testKey(i), blobValue(i), nil); err != nil {
+				t.Fatalf("fail to put key %s: %s", testKey(i), err)
+			}
+		}
+		if err := db.Flush(true); err != nil {
+			t.Fatalf("fail to flush: %s", err)
+		}
+		if levels := levelFileSizes(t, db); len(levels[1]) != 1 {
+			t.Fatalf("multiplier %d: expect one file in level 1, got %v", multiplier, levels)
+		}
+		if err := db.CompactRange(nil, nil); err != nil {
+			t.Fatalf("fail to compact range: %s", err)
+		}
+
+		levels := levelFileSizes(t, db)
+		if len(levels[1]) != 0 || len(levels[2]) < 2 {
+			t.Fatalf("multiplier %d: expect files compacted to level 2, got %v", multiplier, levels)
+		}
+		// Output stops at first block reaching target size of level 2,
+		// except the last one.
+		target := uint64(targetFileSize * multiplier)
+		files := levels[2]
+		for _, size := range files[:len(files)-1] {
+			if size < target || size > target+4*1024 {
+				t.Fatalf("multiplier %d: got file sizes %v in level 2, want about %d", multiplier, files, target)
+			}
+		}
+		if size := files[len(files)-1]; size > target+4*1024 {
+			t.Fatalf("multiplier %d: got file sizes %v in level 2, want at most about %d", multiplier, files, target)
+		}
+		for i := 0; i < 5000; i++ {
+			expectValue(t, db, testKey(i), blobValue(i))
+		}
+		db.Close()
+	}
+}
+
+func TestDynamicLevelBytes(t *testing.T) {
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+
+	const levelBase = 16 * 1024
+	for _, dynamic := range []bool{false, true} {
+		dbname := filepath.Join(dir, fmt.Sprintf("db-%t", dynamic))
+		// Memtable outputs stay in level 0 for its different compression.
+		opts := &Options{
+			LevelCompression:     []CompressionType{SnappyCompression, NoCompression},
+			TargetFileSize:       4 * 1024,
+			MaxBytesForLevelBase: levelBase,
+			DynamicLevelBytes:    dynamic,
+		}
+		db := openTestDB(t, dbname, opts)
+		for i := 0; i < 4000; i++ {
+			if err := db.Put(testKey(i), blobValue(i), nil); err != nil {
+				t.Fatalf("fail to put key %s: %s", testKey(i), err)
+			}
+			if i%250 == 249 {
+				if err := db.Flush(true); err != nil {
+					t.Fatalf("fail to flush: %s", err)
+				}
+			}
+		}
+		waitCompactionsSettled(t, db)
+
+		levels := levelFileSizes(t, db)
+		level2, level3 := totalFileSize(levels[2]), totalFileSize(levels[3])
+		if level3 == 0 || totalFileSize(levels[4]) != 0 {
+			t.Fatalf("dynamic %t: expect level 3 as bottommost level, got %v", dynamic, levels)
+		}
+		switch {
+		case !dynamic && level2 <= level3/10:
+			// Level 2 is filled up to its static limit, which is 10
+			// times of MaxBytesForLevelBase.
+			t.Fatalf("static level bytes: expect level 2 of %d bytes over tenth of level 3 of %d bytes", level2, level3)
+		case dynamic && level2 > level3/10 && level2 > levelBase:
+			t.Fatalf("dynamic level bytes: expect level 2 of %d bytes no more than tenth of level 3 of %d bytes", level2, level3)
+		}
+		for i := 0; i < 4000; i++ {
+			expectValue(t, db, testKey(i), blobValue(i))
+		}
+		db.Close()
+	}
+}
@@ -1,12 +1,38 @@
 package leveldb
 
-import "github.com/kezhuw/leveldb/internal/errors"
+import (
+	"github.com/kezhuw/leveldb/internal/compress"
+	"github.com/kezhuw/leveldb/internal/errors"
+)
 
 var (
 	ErrNotFound  = errors.ErrNotFound // key not found
 	ErrDBExists  = errors.ErrDBExists
 	ErrDBMissing = errors.ErrDBMissing
 	ErrDBClosed  = errors.ErrDBClosed
+
+	ErrNoMergeOperator = errors.ErrNoMergeOperator // merge without merge operator
+
+	ErrUnsupportedCompression = compress.ErrUnsupportedCompression // compression without registered compressor
+
+	ErrConflict = errors.ErrConflict // transaction conflicts with others
+	ErrTryAgain = errors.ErrTryAgain // transaction too old to check conflicts
+	ErrTxnDone  = errors.ErrTxnDone  // transaction committed or rolled back
+
+	ErrLockTimeout = errors.ErrLockTimeout // timeout on waiting for key lock
+	ErrDeadlock    = errors.ErrDeadlock    // waiting for key lock causes deadlock
+
+	ErrBatchNotIndexed = errors.ErrBatchNotIndexed // read from batch not created by NewIndexedBatch
+	ErrUnhandledUpdate = errors.ErrUnhandledUpdate // replay batch update not supported by handler
+	ErrNoSavePoint     = errors.ErrNoSavePoint     // rollback or pop without save point
+
+	ErrUpdatesUnavailable = errors.ErrUpdatesUnavailable // updates since sequence deleted from log files
+
+	ErrColumnFamilyExists  = errors.ErrColumnFamilyExists  // create column family with name in use
+	ErrColumnFamilyDropped = errors.ErrColumnFamilyDropped // operate on dropped column family
+	ErrDropDefaultFamily   = errors.ErrDropDefaultFamily   // drop default column family
+
+	ErrReadOnly = errors.ErrReadOnly // update db opened by OpenReadOnly
 )
 
 // IsCorrupt returns a boolean indicating whether the error is a corruption error.
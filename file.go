@@ -44,6 +44,14 @@ type FileSystem interface {
 	Rename(oldpath, newpath string) error
 }
 
+// Linker is an optional interface which a FileSystem can implement to support
+// hard links. DB.Checkpoint links immutable files instead of copying them if
+// FileSystem implements Linker.
+type Linker interface {
+	// Link creates newname as a hard link to oldname.
+	Link(oldname, newname string) error
+}
+
 type internalFileSystem struct {
 	file.FileSystem
 }
@@ -52,6 +60,10 @@ func (fs internalFileSystem) Open(name string, flag int) (File, error) {
 	return fs.FileSystem.Open(name, flag)
 }
 
+func (fs internalFileSystem) Link(oldname, newname string) error {
+	return fs.FileSystem.(file.Linker).Link(oldname, newname)
+}
+
 type wrappedFileSystem struct {
 	FileSystem
 }
@@ -60,8 +72,15 @@ func (fs wrappedFileSystem) Open(name string, flag int) (file.File, error) {
 	return fs.FileSystem.Open(name, flag)
 }
 
+type linkedFileSystem struct {
+	wrappedFileSystem
+	Linker
+}
+
 // DefaultFileSystem is the file system provided by os package.
 var DefaultFileSystem FileSystem = internalFileSystem{file.DefaultFileSystem}
 
 var _ FileSystem = internalFileSystem{}
+var _ Linker = internalFileSystem{}
 var _ file.FileSystem = wrappedFileSystem{}
+var _ file.Linker = linkedFileSystem{}
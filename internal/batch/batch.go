@@ -3,10 +3,12 @@ package batch
 import (
 	"encoding/binary"
 	"math"
+	"time"
 
 	"github.com/kezhuw/leveldb/internal/endian"
 	"github.com/kezhuw/leveldb/internal/errors"
 	"github.com/kezhuw/leveldb/internal/keys"
+	"github.com/kezhuw/leveldb/internal/ttl"
 )
 
 const batchHeaderSize = 12
@@ -15,29 +17,183 @@ var firstBatchHeaderBytes = [batchHeaderSize]byte{0, 0, 0, 0, 0, 0, 0, 0, 1, 0,
 
 type Batch struct {
 	data []byte
+
+	savePoints []savePoint
+
+	// Expirations to resolve relative to time of writing.
+	ttls []pendingTTL
+}
+
+// pendingTTL is an expiration at offset of data, which expires after ttl
+// since batch is written.
+type pendingTTL struct {
+	offset int
+	ttl    time.Duration
+}
+
+type savePoint struct {
+	size  int
+	count uint32
 }
 
+// columnFamilyFlag is set in kind of updates to column families other than
+// the default one, whose ids follow kind in varint.
+const columnFamilyFlag = 0x80
+
 func (b *Batch) Put(key, value []byte) {
-	scratch, ok := b.grow(1 + 2*binary.MaxVarintLen64 + len(key) + len(value))
+	b.PutCF(0, key, value)
+}
+
+// PutCF adds a key/value update to column family.
+func (b *Batch) PutCF(family uint32, key, value []byte) {
+	scratch, ok := b.grow(1 + binary.MaxVarintLen32 + 2*binary.MaxVarintLen64 + len(key) + len(value))
 	if !ok {
 		return
 	}
-	b.data = append(b.data, byte(keys.Value))
+	b.appendKind(scratch, family, keys.Value)
 	b.appendBytes(scratch, key)
 	b.appendBytes(scratch, value)
 }
 
+// PutWithExpiration adds a key/value update which expires at expiration, in
+// nanoseconds since Unix epoch.
+func (b *Batch) PutWithExpiration(key, value []byte, expiration int64) {
+	b.PutWithExpirationCF(0, key, value, expiration)
+}
+
+// PutWithExpirationCF adds a key/value update which expires at expiration
+// to column family.
+func (b *Batch) PutWithExpirationCF(family uint32, key, value []byte, expiration int64) {
+	scratch, ok := b.grow(1 + binary.MaxVarintLen32 + 2*binary.MaxVarintLen64 + len(key) + ttl.HeaderSize + len(value))
+	if !ok {
+		return
+	}
+	b.appendKind(scratch, family, keys.ExpiringValue)
+	b.appendBytes(scratch, key)
+	n := binary.PutUvarint(scratch, uint64(ttl.HeaderSize+len(value)))
+	b.data = append(b.data, scratch[:n]...)
+	b.data = ttl.Append(b.data, expiration, value)
+}
+
+// PutWithTTL adds a key/value update which expires after d since this batch
+// is written. Expiration is computed from now until ResolveTTL is called.
+func (b *Batch) PutWithTTL(key, value []byte, d time.Duration) {
+	b.PutWithTTLCF(0, key, value, d)
+}
+
+// PutWithTTLCF adds a key/value update which expires after d since this batch
+// is written to column family.
+func (b *Batch) PutWithTTLCF(family uint32, key, value []byte, d time.Duration) {
+	n := len(b.data)
+	b.PutWithExpirationCF(family, key, value, ttl.Expiration(time.Now().UnixNano(), d))
+	if len(b.data) != n {
+		offset := len(b.data) - len(value) - ttl.HeaderSize
+		b.ttls = append(b.ttls, pendingTTL{offset: offset, ttl: d})
+	}
+}
+
+// ResolveTTL sets expirations of updates added by PutWithTTL relative to now,
+// which is in nanoseconds since Unix epoch.
+func (b *Batch) ResolveTTL(now int64) {
+	for _, t := range b.ttls {
+		endian.PutUint64(b.data[t.offset:], uint64(ttl.Expiration(now, t.ttl)))
+	}
+}
+
+func (b *Batch) Merge(key, operand []byte) {
+	b.MergeCF(0, key, operand)
+}
+
+// MergeCF adds a merge of operand into value of key to column family.
+func (b *Batch) MergeCF(family uint32, key, operand []byte) {
+	scratch, ok := b.grow(1 + binary.MaxVarintLen32 + 2*binary.MaxVarintLen64 + len(key) + len(operand))
+	if !ok {
+		return
+	}
+	b.appendKind(scratch, family, keys.Merge)
+	b.appendBytes(scratch, key)
+	b.appendBytes(scratch, operand)
+}
+
 func (b *Batch) Delete(key []byte) {
-	scratch, ok := b.grow(1 + binary.MaxVarintLen64 + len(key))
+	b.DeleteCF(0, key)
+}
+
+// DeleteCF adds a key deletion to column family.
+func (b *Batch) DeleteCF(family uint32, key []byte) {
+	scratch, ok := b.grow(1 + binary.MaxVarintLen32 + binary.MaxVarintLen64 + len(key))
 	if !ok {
 		return
 	}
-	b.data = append(b.data, byte(keys.Delete))
+	b.appendKind(scratch, family, keys.Delete)
 	b.appendBytes(scratch, key)
 }
 
+func (b *Batch) DeleteRange(start, limit []byte) {
+	b.DeleteRangeCF(0, start, limit)
+}
+
+// DeleteRangeCF adds a deletion of keys in range [start, limit) to column
+// family.
+func (b *Batch) DeleteRangeCF(family uint32, start, limit []byte) {
+	scratch, ok := b.grow(1 + binary.MaxVarintLen32 + 2*binary.MaxVarintLen64 + len(start) + len(limit))
+	if !ok {
+		return
+	}
+	b.appendKind(scratch, family, keys.RangeDelete)
+	b.appendBytes(scratch, start)
+	b.appendBytes(scratch, limit)
+}
+
 func (b *Batch) Clear() {
 	b.data = b.data[:0]
+	b.savePoints = b.savePoints[:0]
+	b.ttls = b.ttls[:0]
+}
+
+// SetSavePoint records current state of batch, so that later updates could
+// be rolled back.
+func (b *Batch) SetSavePoint() {
+	b.savePoints = append(b.savePoints, savePoint{size: len(b.data), count: b.Count()})
+}
+
+// RollbackToSavePoint drops updates after most recent save point, and
+// removes that save point.
+func (b *Batch) RollbackToSavePoint() error {
+	n := len(b.savePoints)
+	if n == 0 {
+		return errors.ErrNoSavePoint
+	}
+	sp := b.savePoints[n-1]
+	b.savePoints = b.savePoints[:n-1]
+	b.data = b.data[:sp.size]
+	for i := len(b.ttls) - 1; i >= 0 && b.ttls[i].offset >= sp.size; i-- {
+		b.ttls = b.ttls[:i]
+	}
+	if sp.size != 0 {
+		endian.PutUint32(b.countData(), sp.count)
+	}
+	return nil
+}
+
+// PopSavePoint removes most recent save point without rolling back.
+func (b *Batch) PopSavePoint() error {
+	n := len(b.savePoints)
+	if n == 0 {
+		return errors.ErrNoSavePoint
+	}
+	b.savePoints = b.savePoints[:n-1]
+	return nil
+}
+
+func (b *Batch) appendKind(scratch []byte, family uint32, kind keys.Kind) {
+	if family == 0 {
+		b.data = append(b.data, byte(kind))
+		return
+	}
+	b.data = append(b.data, byte(kind)|columnFamilyFlag)
+	n := binary.PutUvarint(scratch, uint64(family))
+	b.data = append(b.data, scratch[:n]...)
 }
 
 func (b *Batch) appendBytes(scratch []byte, bytes []byte) {
@@ -49,6 +205,9 @@ func (b *Batch) appendBytes(scratch []byte, bytes []byte) {
 func (b *Batch) grow(n int) (scratch []byte, ok bool) {
 	n += binary.MaxVarintLen64
 	l, z := len(b.data), cap(b.data)
+	if l == 0 {
+		n += batchHeaderSize
+	}
 	if l+n > z {
 		z += z/2 + n
 		buf := make([]byte, l, z)
@@ -77,6 +236,8 @@ func (b *Batch) grow(n int) (scratch []byte, ok bool) {
 
 func (b *Batch) Reset(data []byte) {
 	b.data = data
+	b.savePoints = b.savePoints[:0]
+	b.ttls = b.ttls[:0]
 }
 
 func (b *Batch) Append(buf []byte) bool {
@@ -97,6 +258,22 @@ func (b *Batch) Append(buf []byte) bool {
 	}
 }
 
+// AppendBatch appends updates of other to this batch, along with their
+// expirations to resolve.
+func (b *Batch) AppendBatch(other *Batch) bool {
+	n := len(b.data)
+	if !b.Append(other.data) {
+		return false
+	}
+	if n != 0 {
+		n -= batchHeaderSize
+	}
+	for _, t := range other.ttls {
+		b.ttls = append(b.ttls, pendingTTL{offset: n + t.offset, ttl: t.ttl})
+	}
+	return true
+}
+
 func (b *Batch) Empty() bool {
 	return len(b.data) <= batchHeaderSize
 }
@@ -138,6 +315,8 @@ func (b *Batch) Bytes() []byte {
 func (b *Batch) Pin() {
 	n := len(b.data)
 	b.data = b.data[:n:n]
+	m := len(b.ttls)
+	b.ttls = b.ttls[:m:m]
 }
 
 func (b *Batch) Size() int {
@@ -148,20 +327,58 @@ type Iterator interface {
 	Add(seq keys.Sequence, kind keys.Kind, key, value []byte)
 }
 
+// ColumnFamilyIterator is an Iterator which also receives updates to column
+// families other than the default one.
+type ColumnFamilyIterator interface {
+	Iterator
+	AddColumnFamily(family uint32, seq keys.Sequence, kind keys.Kind, key, value []byte)
+}
+
+// Iterate iterates updates of batch in order. Updates to default column
+// family are passed to it.Add, others are passed to it.AddColumnFamily if
+// it is a ColumnFamilyIterator, otherwise Iterate returns
+// errors.ErrUnhandledUpdate.
 func (b *Batch) Iterate(it Iterator) (err error) {
 	if b.Empty() {
 		return errors.ErrCorruptWriteBatch
 	}
+	cfIt, _ := it.(ColumnFamilyIterator)
 	seq := b.Sequence()
 	found := uint32(0)
 	for buf := b.Body(); len(buf) != 0; seq, found = seq+1, found+1 {
 		var key, value []byte
+		var family uint64
+		var ok bool
 		kind := keys.Kind(buf[0])
-		key, buf = getLengthPrefixedBytes(buf[1:])
-		if kind == keys.Value {
-			value, buf = getLengthPrefixedBytes(buf)
+		buf = buf[1:]
+		if kind&columnFamilyFlag != 0 {
+			kind &^= columnFamilyFlag
+			var n int
+			family, n = binary.Uvarint(buf)
+			if n <= 0 || family == 0 || family > math.MaxUint32 {
+				return errors.ErrCorruptWriteBatch
+			}
+			buf = buf[n:]
+		}
+		switch kind {
+		case keys.Delete:
+			key, buf, ok = getLengthPrefixedBytes(buf)
+		case keys.Value, keys.RangeDelete, keys.Merge, keys.ExpiringValue:
+			key, buf, ok = getLengthPrefixedBytes(buf)
+			if ok {
+				value, buf, ok = getLengthPrefixedBytes(buf)
+			}
+		}
+		switch {
+		case !ok:
+			return errors.ErrCorruptWriteBatch
+		case family == 0:
+			it.Add(seq, kind, key, value)
+		case cfIt == nil:
+			return errors.ErrUnhandledUpdate
+		default:
+			cfIt.AddColumnFamily(uint32(family), seq, kind, key, value)
 		}
-		it.Add(seq, kind, key, value)
 	}
 	if found != b.Count() {
 		return errors.ErrCorruptWriteBatch
@@ -169,11 +386,11 @@ func (b *Batch) Iterate(it Iterator) (err error) {
 	return
 }
 
-func getLengthPrefixedBytes(buf []byte) (bytes, remains []byte) {
+func getLengthPrefixedBytes(buf []byte) (bytes, remains []byte, ok bool) {
 	l, n := binary.Uvarint(buf)
-	if n <= 0 || n > binary.MaxVarintLen32 {
-		panic(errors.ErrCorruptWriteBatch)
+	if n <= 0 || n > binary.MaxVarintLen32 || l > uint64(len(buf)-n) {
+		return nil, nil, false
 	}
 	buf = buf[n:]
-	return buf[:l:l], buf[l:]
+	return buf[:l:l], buf[l:], true
 }
@@ -2,9 +2,12 @@ package batch_test
 
 import (
 	"testing"
+	"time"
 
 	"github.com/kezhuw/leveldb/internal/batch"
+	"github.com/kezhuw/leveldb/internal/errors"
 	"github.com/kezhuw/leveldb/internal/keys"
+	"github.com/kezhuw/leveldb/internal/ttl"
 )
 
 type writeCase struct {
@@ -36,6 +39,10 @@ var bunchCases = map[string]batchCase{
 			{keys.Delete, "nkj89fdans", "xnonlkjojn"},
 			{keys.Value, "nlkfsdjiolk", "fnsdalkjil"},
 			{keys.Value, "\x93\x0a\xc3", "mbiojfasf"},
+			{keys.RangeDelete, "abc", "xyz"},
+			{keys.Merge, "nkj89fdans", "+1"},
+			{keys.Value, "nlkfsdjiolk", "fnsdalkjil"},
+			{keys.ExpiringValue, "mvkjoea", string(ttl.Append(nil, 1234567, []byte("lkjafdoi")))},
 		},
 	},
 }
@@ -48,6 +55,16 @@ func addBatchWrites(t *testing.T, b *batch.Batch, name string, cases []writeCase
 			b.Put([]byte(c.Key), []byte(c.Value))
 		case keys.Delete:
 			b.Delete([]byte(c.Key))
+		case keys.RangeDelete:
+			b.DeleteRange([]byte(c.Key), []byte(c.Value))
+		case keys.Merge:
+			b.Merge([]byte(c.Key), []byte(c.Value))
+		case keys.ExpiringValue:
+			expiration, value, err := ttl.Split([]byte(c.Value))
+			if err != nil {
+				t.Fatalf("%s(%d): invalid expiring value: %s\n", name, i, err)
+			}
+			b.PutWithExpiration([]byte(c.Key), value, expiration)
 		default:
 			t.Fatalf("%s(%d): unknown keys.Kind: %s\n", name, i, c.Kind)
 		}
@@ -90,7 +107,7 @@ func (a *batchApplier) Add(seq keys.Sequence, kind keys.Kind, key, value []byte)
 		t.Errorf("%s(%d): expect key: %s, got: %s", a.name, i, a.writes[i].Key, key)
 	}
 	switch kind {
-	case keys.Value:
+	case keys.Value, keys.RangeDelete, keys.Merge, keys.ExpiringValue:
 		if a.writes[i].Value != string(value) {
 			t.Errorf("%s(%d): expect value: %s, got %s", a.name, i, a.writes[i].Value, value)
 		}
@@ -130,3 +147,159 @@ func TestBatchAppend(t *testing.T) {
 		b.Iterate(&a)
 	}
 }
+
+type nopIterator struct{}
+
+func (nopIterator) Add(seq keys.Sequence, kind keys.Kind, key, value []byte) {}
+
+func TestBatchIterateCorrupt(t *testing.T) {
+	for name, cases := range bunchCases {
+		b := buildBatch(t, name, cases.Seq, cases.Writes)
+		data := b.Bytes()
+		for n := 13; n < len(data); n++ {
+			var truncated batch.Batch
+			truncated.Reset(append([]byte(nil), data[:n]...))
+			if err := truncated.Iterate(nopIterator{}); err != errors.ErrCorruptWriteBatch {
+				t.Errorf("%s: truncated to %d bytes, got error: %v", name, n, err)
+			}
+		}
+		var unknown batch.Batch
+		unknown.Reset(append([]byte(nil), data...))
+		unknown.Body()[0] = 0xff
+		if err := unknown.Iterate(nopIterator{}); err != errors.ErrCorruptWriteBatch {
+			t.Errorf("%s: unknown kind, got error: %v", name, err)
+		}
+	}
+}
+
+func TestBatchSavePoint(t *testing.T) {
+	var b batch.Batch
+	if err := b.RollbackToSavePoint(); err != errors.ErrNoSavePoint {
+		t.Fatalf("rollback without save point, got error: %v", err)
+	}
+	if err := b.PopSavePoint(); err != errors.ErrNoSavePoint {
+		t.Fatalf("pop without save point, got error: %v", err)
+	}
+	cases := bunchCases["test1"].Writes
+	b.SetSavePoint()
+	addBatchWrites(t, &b, "test1", cases[:3])
+	b.SetSavePoint()
+	data := append([]byte(nil), b.Bytes()...)
+	addBatchWrites(t, &b, "test1", cases[3:])
+	b.SetSavePoint()
+	b.Delete([]byte("dropped"))
+	if err := b.PopSavePoint(); err != nil {
+		t.Fatalf("pop save point, got error: %v", err)
+	}
+	if err := b.RollbackToSavePoint(); err != nil {
+		t.Fatalf("rollback to save point, got error: %v", err)
+	}
+	if got := b.Bytes(); string(got) != string(data) {
+		t.Fatalf("rollback to save point, got data %q, want %q", got, data)
+	}
+	applier := batchApplier{t: t, name: "test1", writes: cases[:3]}
+	if err := b.Iterate(&applier); err != nil || applier.nextIndex != 3 {
+		t.Fatalf("iterate after rollback, got %d writes, error: %v", applier.nextIndex, err)
+	}
+	if err := b.RollbackToSavePoint(); err != nil {
+		t.Fatalf("rollback to save point, got error: %v", err)
+	}
+	if !b.Empty() || b.Count() != 0 {
+		t.Fatalf("rollback to first save point, got count %d", b.Count())
+	}
+	b.Put([]byte("key"), []byte("value"))
+	if b.Count() != 1 {
+		t.Fatalf("put after rollback, got count %d", b.Count())
+	}
+}
+
+type familyWrite struct {
+	Family uint32
+	Seq    keys.Sequence
+	Kind   keys.Kind
+	Key    string
+	Value  string
+}
+
+type familyApplier struct {
+	writes []familyWrite
+}
+
+func (a *familyApplier) Add(seq keys.Sequence, kind keys.Kind, key, value []byte) {
+	a.AddColumnFamily(0, seq, kind, key, value)
+}
+
+func (a *familyApplier) AddColumnFamily(family uint32, seq keys.Sequence, kind keys.Kind, key, value []byte) {
+	a.writes = append(a.writes, familyWrite{family, seq, kind, string(key), string(value)})
+}
+
+func TestBatchColumnFamily(t *testing.T) {
+	var b batch.Batch
+	b.DeleteCF(1, []byte("k"))
+	b.Put([]byte("key0"), []byte("value0"))
+	b.PutCF(1<<20, []byte("key1"), []byte("value1"))
+	b.MergeCF(2, []byte("key2"), []byte("+1"))
+	b.DeleteRangeCF(3, []byte("a"), []byte("z"))
+	b.PutWithExpirationCF(4, []byte("key4"), []byte("value4"), 1234)
+	b.SetSequence(100)
+	want := []familyWrite{
+		{1, 100, keys.Delete, "k", ""},
+		{0, 101, keys.Value, "key0", "value0"},
+		{1 << 20, 102, keys.Value, "key1", "value1"},
+		{2, 103, keys.Merge, "key2", "+1"},
+		{3, 104, keys.RangeDelete, "a", "z"},
+		{4, 105, keys.ExpiringValue, "key4", string(ttl.Append(nil, 1234, []byte("value4")))},
+	}
+	var a familyApplier
+	if err := b.Iterate(&a); err != nil {
+		t.Fatalf("iterate column families, got error: %v", err)
+	}
+	if len(a.writes) != len(want) {
+		t.Fatalf("iterate column families, got %d writes, want %d", len(a.writes), len(want))
+	}
+	for i, w := range want {
+		if a.writes[i] != w {
+			t.Errorf("iterate column families(%d), got %+v, want %+v", i, a.writes[i], w)
+		}
+	}
+	if err := b.Iterate(nopIterator{}); err != errors.ErrUnhandledUpdate {
+		t.Errorf("iterate column families without handler, got error: %v", err)
+	}
+}
+
+func TestBatchResolveTTL(t *testing.T) {
+	var b batch.Batch
+	b.PutWithTTL([]byte("key0"), []byte("value0"), time.Second)
+	b.SetSavePoint()
+	b.PutWithTTLCF(2, []byte("key1"), []byte("value1"), 2*time.Second)
+	if err := b.RollbackToSavePoint(); err != nil {
+		t.Fatalf("rollback to save point, got error: %v", err)
+	}
+	b.Put([]byte("key2"), []byte("value2"))
+
+	var other batch.Batch
+	other.PutWithTTLCF(3, []byte("key3"), []byte("value3"), 3*time.Second)
+	b.Pin()
+	if !b.AppendBatch(&other) {
+		t.Fatalf("append batch failed")
+	}
+	b.ResolveTTL(1000)
+	b.SetSequence(100)
+	want := []familyWrite{
+		{0, 100, keys.ExpiringValue, "key0", string(ttl.Append(nil, 1000+int64(time.Second), []byte("value0")))},
+		{0, 101, keys.Value, "key2", "value2"},
+		{3, 102, keys.ExpiringValue, "key3", string(ttl.Append(nil, 1000+int64(3*time.Second), []byte("value3")))},
+	}
+	var a familyApplier
+	if err := b.Iterate(&a); err != nil {
+		t.Fatalf("iterate resolved batch, got error: %v", err)
+	}
+	if len(a.writes) != len(want) {
+		t.Fatalf("iterate resolved batch, got %d writes, want %d", len(a.writes), len(want))
+	}
+	for i, w := range want {
+		if a.writes[i] != w {
+			t.Errorf("iterate resolved batch(%d), got %+v, want %+v", i, a.writes[i], w)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+// Package blob stores large values out of tables in append-only blob files.
+// Tables keep keys.BlobIndex entries with pointers to these values.
+//
+// A blob file is a sequence of values, each prefixed with masked crc32c
+// checksum of that value.
+package blob
+
+import (
+	"encoding/binary"
+
+	"github.com/kezhuw/leveldb/internal/errors"
+)
+
+// HeaderSize is size of checksum in front of value in blob file.
+const HeaderSize = 4
+
+// Pointer locates a value in blob file.
+type Pointer struct {
+	FileNumber uint64
+	// Offset of checksum of value in blob file.
+	Offset uint64
+	// Size of value.
+	Size uint64
+}
+
+// Append appends encoded pointer to dst.
+func (p Pointer) Append(dst []byte) []byte {
+	var buf [3 * binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], p.FileNumber)
+	n += binary.PutUvarint(buf[n:], p.Offset)
+	n += binary.PutUvarint(buf[n:], p.Size)
+	return append(dst, buf[:n]...)
+}
+
+// EntrySize returns number of bytes value occupies in blob file.
+func (p Pointer) EntrySize() uint64 {
+	return HeaderSize + p.Size
+}
+
+// DecodePointer decodes pointer encoded by Pointer.Append.
+func DecodePointer(b []byte) (Pointer, error) {
+	var p Pointer
+	var fields = [3]*uint64{&p.FileNumber, &p.Offset, &p.Size}
+	for _, field := range fields {
+		x, n := binary.Uvarint(b)
+		if n <= 0 {
+			return Pointer{}, errors.ErrCorruptBlobPointer
+		}
+		*field = x
+		b = b[n:]
+	}
+	if len(b) != 0 {
+		return Pointer{}, errors.ErrCorruptBlobPointer
+	}
+	return p, nil
+}
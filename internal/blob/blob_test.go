@@ -0,0 +1,91 @@
+package blob_test
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/kezhuw/leveldb/internal/blob"
+	"github.com/kezhuw/leveldb/internal/errors"
+	"github.com/kezhuw/leveldb/internal/file"
+	"github.com/kezhuw/leveldb/internal/files"
+)
+
+func TestPointer(t *testing.T) {
+	pointers := []blob.Pointer{
+		{},
+		{FileNumber: 5, Offset: 0, Size: 100},
+		{FileNumber: 1 << 40, Offset: 1 << 33, Size: 1 << 20},
+	}
+	for _, p := range pointers {
+		encoded := p.Append(nil)
+		got, err := blob.DecodePointer(encoded)
+		if err != nil || got != p {
+			t.Errorf("test=decode-pointer pointer=%+v got=%+v err=%v", p, got, err)
+		}
+		if _, err := blob.DecodePointer(encoded[:len(encoded)-1]); err != errors.ErrCorruptBlobPointer {
+			t.Errorf("test=decode-truncated-pointer pointer=%+v got-err=%v", p, err)
+		}
+		if _, err := blob.DecodePointer(append(encoded, 0)); err != errors.ErrCorruptBlobPointer {
+			t.Errorf("test=decode-trailing-pointer pointer=%+v got-err=%v", p, err)
+		}
+	}
+}
+
+func TestReadWrite(t *testing.T) {
+	dir, err := os.MkdirTemp("", "blob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fs := file.DefaultFileSystem
+	const number = 7
+	f, err := fs.Open(files.BlobFileName(dir, number), os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := [][]byte{[]byte("value0"), {}, bytes.Repeat([]byte("value2"), 1000)}
+	var w blob.Writer
+	w.Reset(f, number)
+	var pointers []blob.Pointer
+	var size uint64
+	for _, value := range values {
+		p, err := w.Add(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.FileNumber != number || p.Offset != size || p.Size != uint64(len(value)) {
+			t.Errorf("test=add value=%q got-pointer=%+v want-offset=%d", value, p, size)
+		}
+		size += p.EntrySize()
+		pointers = append(pointers, p)
+	}
+	if w.Size() != size {
+		t.Errorf("test=size got=%d want=%d", w.Size(), size)
+	}
+	if err := w.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	c := blob.NewCache(dir, fs)
+	defer c.Evict(number)
+	for i, p := range pointers {
+		value, err := c.ReadBlob(p.Append(nil))
+		if err != nil || !bytes.Equal(value, values[i]) {
+			t.Errorf("test=read pointer=%+v got-value-size=%d got-err=%v want-value-size=%d", p, len(value), err, len(values[i]))
+		}
+	}
+
+	p := pointers[len(pointers)-1]
+	p.Size++
+	if _, err := c.Read(p); err == nil {
+		t.Errorf("test=read-truncated pointer=%+v got-err=nil", p)
+	}
+	p = pointers[0]
+	p.Offset++
+	if _, err := c.Read(p); err == nil {
+		t.Errorf("test=read-corrupt pointer=%+v got-err=nil", p)
+	}
+}
@@ -0,0 +1,87 @@
+package blob
+
+import (
+	"io"
+	"os"
+	"sync"
+
+	"github.com/kezhuw/leveldb/internal/crc"
+	"github.com/kezhuw/leveldb/internal/endian"
+	"github.com/kezhuw/leveldb/internal/errors"
+	"github.com/kezhuw/leveldb/internal/file"
+	"github.com/kezhuw/leveldb/internal/files"
+)
+
+// Cache reads values from blob files, which are kept open until evicted.
+type Cache struct {
+	dbname string
+	fs     file.FileSystem
+
+	mu    sync.Mutex
+	files map[uint64]file.File
+}
+
+// NewCache creates a cache for blob files in database dbname.
+func NewCache(dbname string, fs file.FileSystem) *Cache {
+	return &Cache{
+		dbname: dbname,
+		fs:     fs,
+		files:  make(map[uint64]file.File),
+	}
+}
+
+func (c *Cache) open(number uint64) (file.File, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if f := c.files[number]; f != nil {
+		return f, nil
+	}
+	f, err := c.fs.Open(files.BlobFileName(c.dbname, number), os.O_RDONLY)
+	if err != nil {
+		return nil, err
+	}
+	c.files[number] = f
+	return f, nil
+}
+
+// Read reads value located by p.
+func (c *Cache) Read(p Pointer) ([]byte, error) {
+	f, err := c.open(p.FileNumber)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, p.EntrySize())
+	n, err := f.ReadAt(buf, int64(p.Offset))
+	switch {
+	case n == len(buf):
+	case err == nil || err == io.EOF:
+		return nil, errors.NewCorruption(p.FileNumber, "blob", int64(p.Offset), "truncated value")
+	default:
+		return nil, err
+	}
+	value := buf[HeaderSize:]
+	if crc.New(value).Value() != endian.Uint32(buf) {
+		return nil, errors.NewCorruption(p.FileNumber, "blob", int64(p.Offset), "checksum mismatch")
+	}
+	return value, nil
+}
+
+// ReadBlob reads value located by encoded pointer.
+func (c *Cache) ReadBlob(pointer []byte) ([]byte, error) {
+	p, err := DecodePointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	return c.Read(p)
+}
+
+// Evict closes blob file numbered number if it is open.
+func (c *Cache) Evict(number uint64) {
+	c.mu.Lock()
+	f := c.files[number]
+	delete(c.files, number)
+	c.mu.Unlock()
+	if f != nil {
+		f.Close()
+	}
+}
@@ -0,0 +1,51 @@
+package blob
+
+import (
+	"github.com/kezhuw/leveldb/internal/crc"
+	"github.com/kezhuw/leveldb/internal/endian"
+	"github.com/kezhuw/leveldb/internal/file"
+)
+
+// Writer appends values to a blob file.
+type Writer struct {
+	w      file.Writer
+	number uint64
+	offset uint64
+	header [HeaderSize]byte
+}
+
+// Reset resets writer to append values to blob file numbered number.
+func (w *Writer) Reset(f file.Writer, number uint64) {
+	w.w = f
+	w.number = number
+	w.offset = 0
+}
+
+// Number returns number of blob file.
+func (w *Writer) Number() uint64 {
+	return w.number
+}
+
+// Size returns number of bytes written to blob file.
+func (w *Writer) Size() uint64 {
+	return w.offset
+}
+
+// Add appends value to blob file and returns its location.
+func (w *Writer) Add(value []byte) (Pointer, error) {
+	endian.PutUint32(w.header[:], crc.New(value).Value())
+	if _, err := w.w.Write(w.header[:]); err != nil {
+		return Pointer{}, err
+	}
+	if _, err := w.w.Write(value); err != nil {
+		return Pointer{}, err
+	}
+	p := Pointer{FileNumber: w.number, Offset: w.offset, Size: uint64(len(value))}
+	w.offset += p.EntrySize()
+	return p, nil
+}
+
+// Finish flushes written values to stable storage.
+func (w *Writer) Finish() error {
+	return w.w.Sync()
+}
@@ -0,0 +1,20 @@
+package compaction
+
+// Decision tells compaction what to do with an entry.
+type Decision int
+
+const (
+	// Keep keeps entry as it is.
+	Keep Decision = iota
+	// Remove removes entry.
+	Remove
+	// ChangeValue replaces value of entry.
+	ChangeValue
+)
+
+// Filter decides fate of values in compaction started with no live
+// snapshots.
+type Filter interface {
+	Name() string
+	Filter(level int, key, value []byte) (Decision, []byte)
+}
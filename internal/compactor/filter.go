@@ -0,0 +1,40 @@
+package compactor
+
+import (
+	"github.com/kezhuw/leveldb/internal/compaction"
+	"github.com/kezhuw/leveldb/internal/keys"
+	"github.com/kezhuw/leveldb/internal/ttl"
+)
+
+// filterValue applies filter to value entry ikey visible to all snapshots,
+// which is either a value or a live expiring value. It returns entry to
+// output, which is a deletion if filter removes it, as older entries of this
+// user key may exist outside compaction.
+func filterValue(filter compaction.Filter, level int, ikey keys.InternalKey, value []byte) (keys.InternalKey, []byte, bool) {
+	if filter == nil {
+		return ikey, value, false
+	}
+	ukey, seq, kind := ikey.Split()
+	data := value
+	if kind == keys.ExpiringValue {
+		data = value[ttl.HeaderSize:]
+	}
+	switch decision, newValue := filter.Filter(level, ukey, data); decision {
+	case compaction.Remove:
+		return keys.NewInternalKey(ukey, seq, keys.Delete), nil, true
+	case compaction.ChangeValue:
+		if kind == keys.ExpiringValue {
+			expiration, _, _ := ttl.Split(value)
+			return ikey, ttl.Append(nil, expiration, newValue), false
+		}
+		return ikey, newValue, false
+	}
+	return ikey, value, false
+}
+
+// expireValue returns whether expiring value has expired at now. Expired
+// value is removed as if its user key had been deleted.
+func expireValue(now int64, value []byte) (bool, error) {
+	_, live, err := ttl.Live(value, now)
+	return !live, err
+}
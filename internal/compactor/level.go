@@ -4,6 +4,8 @@ import (
 	"os"
 	"sort"
 
+	"github.com/kezhuw/leveldb/internal/blob"
+	"github.com/kezhuw/leveldb/internal/compaction"
 	"github.com/kezhuw/leveldb/internal/configs"
 	"github.com/kezhuw/leveldb/internal/errors"
 	"github.com/kezhuw/leveldb/internal/file"
@@ -11,20 +13,26 @@ import (
 	"github.com/kezhuw/leveldb/internal/keys"
 	"github.com/kezhuw/leveldb/internal/manifest"
 	"github.com/kezhuw/leveldb/internal/options"
+	"github.com/kezhuw/leveldb/internal/rangedel"
 	"github.com/kezhuw/leveldb/internal/table"
 )
 
-func NewLevelCompactor(dbname string, seq keys.Sequence, compaction *manifest.Compaction, m *manifest.Manifest, opts *options.Options) Compactor {
+// NewLevelCompactor creates a compactor for compaction. Entries visible to
+// snapshot seq are kept. Compaction filter is not applied if snapshots are
+// live, which could observe values changed by it.
+func NewLevelCompactor(dbname string, seq keys.Sequence, snapshots bool, compaction *manifest.Compaction, m *manifest.Manifest, opts *options.Options) Compactor {
 	if compaction.IsTrivialMove() {
 		return &moveCompactor{c: compaction}
 	}
 	c := &levelCompactor{
 		dbname:           dbname,
 		manifest:         m,
+		blobs:            m.Blobs(),
 		options:          opts,
 		fs:               opts.FileSystem,
 		Compaction:       compaction,
 		smallestSequence: seq,
+		snapshots:        snapshots,
 	}
 	return c
 }
@@ -37,19 +45,41 @@ type levelCompactor struct {
 	*manifest.Compaction
 
 	manifest *manifest.Manifest
+	blobs    *blob.Cache
 	dbname   string
 	options  *options.Options
 	fs       file.FileSystem
 
 	smallestSequence keys.Sequence
+	snapshots        bool
+	// Time in nanoseconds since Unix epoch to expire values.
+	now int64
 
 	outputs manifest.FileList
 
+	// Range tombstones from inputs. They are written to output tables after
+	// clipped to key range of each table.
+	tombstones      rangedel.List
+	tableLowerBound []byte
+
 	tableName   string
 	tableMeta   manifest.FileMeta
 	tableFile   file.WriteCloser
 	tableWriter table.Writer
 
+	// Bytes of blob values referenced by input and output entries. Their
+	// difference is garbage of blob files generated by this compaction.
+	blobInputBytes  map[uint64]uint64
+	blobOutputBytes map[uint64]uint64
+
+	// Live values in blob files collecting garbage are relocated to new
+	// blob file.
+	collectingBlobs map[uint64]bool
+	blobOutputs     []manifest.BlobFileMeta
+	blobFile        file.WriteCloser
+	blobWriter      blob.Writer
+	blobPointer     []byte
+
 	fileNumbers    []uint64
 	fileNumbersOff int
 	nextFileNumber uint64
@@ -93,6 +123,11 @@ func (c *levelCompactor) Rewind() {
 		c.tableFile = nil
 	}
 	c.outputs = c.outputs[:0]
+	c.closeBlobFile()
+	c.blobInputBytes = nil
+	c.blobOutputBytes = nil
+	c.blobOutputs = c.blobOutputs[:0]
+	c.tableLowerBound = nil
 	c.fileNumbersOff = 0
 	c.grandparentsIndex = 0
 	c.grandparentsSeenKey = false
@@ -100,7 +135,9 @@ func (c *levelCompactor) Rewind() {
 	copy(c.levelFilePointers[:], zeroLevelFilePointers[:])
 }
 
-func (c *levelCompactor) closeCurrentTable() error {
+// closeCurrentTable finishes current table, limit is the first user key of
+// next table, nil if there is no next table.
+func (c *levelCompactor) closeCurrentTable(limit []byte) error {
 	f := c.tableFile
 	if f == nil {
 		return nil
@@ -109,6 +146,8 @@ func (c *levelCompactor) closeCurrentTable() error {
 	defer f.Close()
 
 	c.tableMeta.Largest = append(c.tableMeta.Largest[:0], c.tableWriter.LastKey()...)
+	c.addRangeTombstones(c.tableLowerBound, limit)
+	c.tableLowerBound = append([]byte(nil), limit...)
 	err := c.tableWriter.Finish()
 	if err != nil {
 		return err
@@ -139,8 +178,33 @@ func (c *levelCompactor) newFileNumber() uint64 {
 	return fileNumber
 }
 
-func (c *levelCompactor) openTableFile() error {
-	err := c.closeCurrentTable()
+// addRangeTombstones adds part of tombstones in range [start, limit) to
+// current table.
+func (c *levelCompactor) addRangeTombstones(start, limit []byte) {
+	icmp := c.options.Comparator
+	for _, t := range c.tombstones {
+		if t, ok := t.Clip(icmp.UserKeyComparator, start, limit); ok {
+			c.tableWriter.AddRangeTombstone(t)
+			c.tableMeta.ExpandRange(icmp, &t)
+		}
+	}
+}
+
+// survivedRangeTombstones returns tombstones which are not visible to some
+// snapshots or may cover keys in deeper levels.
+func (c *levelCompactor) survivedRangeTombstones(tombstones rangedel.List) rangedel.List {
+	var survived rangedel.List
+	for _, t := range tombstones {
+		if t.Sequence <= c.smallestSequence && c.IsBaseLevelForRange(t.Start, t.Limit) {
+			continue
+		}
+		survived = append(survived, t)
+	}
+	return survived
+}
+
+func (c *levelCompactor) openTableFile(ukey []byte) error {
+	err := c.closeCurrentTable(ukey)
 	if err != nil {
 		return err
 	}
@@ -153,7 +217,8 @@ func (c *levelCompactor) openTableFile() error {
 	c.tableName = tableName
 	c.tableFile = f
 	c.tableMeta.Number = tableNumber
-	c.tableWriter.Reset(f, c.options)
+	c.tableMeta.Smallest = c.tableMeta.Smallest[:0]
+	c.tableWriter.Reset(f, c.Level()+1, c.options)
 	c.grandparentsOverlappedBytes = 0
 	return nil
 }
@@ -167,7 +232,7 @@ func (c *levelCompactor) shouldStopBefore(ikey []byte) bool {
 		c.grandparentsIndex++
 	}
 	c.grandparentsSeenKey = true
-	return c.grandparentsOverlappedBytes > configs.MaxGrandparentOverlappingBytes
+	return c.grandparentsOverlappedBytes > c.MaxGrandparentOverlappingBytes
 }
 
 func (c *levelCompactor) isBaseLevelForKey(ukey []byte) bool {
@@ -182,7 +247,7 @@ func (c *levelCompactor) isBaseLevelForKey(ukey []byte) bool {
 		}
 		i := sort.Search(n, func(i int) bool { return ucmp.Compare(ukey, files[i].Largest.UserKey()) <= 0 })
 		c.levelFilePointers[level] = k + i
-		if i != n && ucmp.Compare(ukey, files[i].Smallest) >= 0 {
+		if i != n && ucmp.Compare(ukey, files[i].Smallest.UserKey()) >= 0 {
 			return false
 		}
 	}
@@ -190,13 +255,19 @@ func (c *levelCompactor) isBaseLevelForKey(ukey []byte) bool {
 }
 
 func (c *levelCompactor) add(key, value []byte, firstTime bool) error {
+	if _, _, kind := keys.InternalKey(key).Split(); kind == keys.BlobIndex {
+		var err error
+		if value, err = c.addBlob(value); err != nil {
+			return err
+		}
+	}
 	// We save keys with same user key in same table, so tables in level+1
 	// will not overlap with each other in user key space.
 	switch {
 	case c.tableFile == nil:
 		fallthrough
 	case firstTime && (c.tableWriter.FileSize() >= c.MaxOutputFileSize || c.shouldStopBefore(key)):
-		err := c.openTableFile()
+		err := c.openTableFile(keys.InternalKey(key).UserKey())
 		if err != nil {
 			return err
 		}
@@ -207,11 +278,178 @@ func (c *levelCompactor) add(key, value []byte, firstTime bool) error {
 	return c.tableWriter.Add(key, value)
 }
 
+// countBlob adds bytes of blob value located by pointer to counts.
+func countBlob(counts map[uint64]uint64, pointer []byte) error {
+	p, err := blob.DecodePointer(pointer)
+	if err != nil {
+		return err
+	}
+	counts[p.FileNumber] += p.EntrySize()
+	return nil
+}
+
+// addBlob counts blob value referenced by output entry, and relocates it if
+// its blob file is collecting garbage. It returns pointer to output.
+func (c *levelCompactor) addBlob(pointer []byte) ([]byte, error) {
+	p, err := blob.DecodePointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	if c.collectingBlobs[p.FileNumber] {
+		value, err := c.blobs.Read(p)
+		if err != nil {
+			return nil, err
+		}
+		if c.blobFile == nil {
+			if err := c.openBlobFile(); err != nil {
+				return nil, err
+			}
+		}
+		if p, err = c.blobWriter.Add(value); err != nil {
+			return nil, err
+		}
+		c.blobPointer = p.Append(c.blobPointer[:0])
+		pointer = c.blobPointer
+	}
+	c.blobOutputBytes[p.FileNumber] += p.EntrySize()
+	return pointer, nil
+}
+
+func (c *levelCompactor) openBlobFile() error {
+	blobNumber := c.newFileNumber()
+	f, err := c.fs.Open(files.BlobFileName(c.dbname, blobNumber), os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	c.blobFile = f
+	c.blobWriter.Reset(f, blobNumber)
+	return nil
+}
+
+func (c *levelCompactor) finishBlobFile() error {
+	if c.blobFile == nil {
+		return nil
+	}
+	defer c.closeBlobFile()
+	if err := c.blobWriter.Finish(); err != nil {
+		return err
+	}
+	c.blobOutputs = append(c.blobOutputs, manifest.BlobFileMeta{Number: c.blobWriter.Number(), Size: c.blobWriter.Size()})
+	return nil
+}
+
+func (c *levelCompactor) closeBlobFile() {
+	if c.blobFile != nil {
+		c.blobFile.Close()
+		c.blobFile = nil
+	}
+}
+
+// pickCollectingBlobs picks blob files whose garbage ratio reaches
+// Options.BlobGarbageRatio to relocate their live values.
+func (c *levelCompactor) pickCollectingBlobs() {
+	c.collectingBlobs = make(map[uint64]bool)
+	for number, f := range c.Base.BlobFiles {
+		if f.GarbageRatio() >= c.options.BlobGarbageRatio {
+			c.collectingBlobs[number] = true
+		}
+	}
+}
+
+// filter returns compaction filter to apply, which is nil if snapshots are
+// live.
+func (c *levelCompactor) filter() compaction.Filter {
+	if c.snapshots {
+		return nil
+	}
+	return c.options.CompactionFilter
+}
+
+// addValue adds value entry visible to all snapshots after applying
+// compaction filter to it.
+func (c *levelCompactor) addValue(ikey keys.InternalKey, value []byte, firstTime bool) error {
+	ikey, value, removed := filterValue(c.filter(), c.Level()+1, ikey, value)
+	if removed && c.isBaseLevelForKey(ikey.UserKey()) {
+		return nil
+	}
+	return c.add(ikey, value, firstTime)
+}
+
+// addExpiringValue adds expiring value visible to all snapshots. Expired
+// value is dropped, or replaced by deletion if deeper levels may contain
+// older entries of its user key.
+func (c *levelCompactor) addExpiringValue(ikey keys.InternalKey, value []byte, firstTime bool) error {
+	expired, err := expireValue(c.now, value)
+	switch {
+	case err != nil:
+		return err
+	case !expired:
+		return c.addValue(ikey, value, firstTime)
+	}
+	ukey, seq, _ := ikey.Split()
+	if c.isBaseLevelForKey(ukey) {
+		return nil
+	}
+	return c.add(keys.NewInternalKey(ukey, seq, keys.Delete), nil, firstTime)
+}
+
+// addBlobIndex adds blob index entry visible to all snapshots after applying
+// compaction filter to its value. Changed value is stored in table.
+func (c *levelCompactor) addBlobIndex(ikey keys.InternalKey, pointer []byte, firstTime bool) error {
+	filter := c.filter()
+	if filter == nil {
+		return c.add(ikey, pointer, firstTime)
+	}
+	value, err := c.blobs.ReadBlob(pointer)
+	if err != nil {
+		return err
+	}
+	ukey, seq, _ := ikey.Split()
+	switch decision, newValue := filter.Filter(c.Level()+1, ukey, value); decision {
+	case compaction.Remove:
+		if c.isBaseLevelForKey(ukey) {
+			return nil
+		}
+		return c.add(keys.NewInternalKey(ukey, seq, keys.Delete), nil, firstTime)
+	case compaction.ChangeValue:
+		return c.add(keys.NewInternalKey(ukey, seq, keys.Value), newValue, firstTime)
+	}
+	return c.add(ikey, pointer, firstTime)
+}
+
+// flushMerges outputs merge operands remaining in folder after all entries
+// of their user key in compaction consumed. Operands are merged as if key
+// did not exist if no deeper levels contain this key.
+func (c *levelCompactor) flushMerges(f *mergeFolder) error {
+	if !f.active() {
+		return nil
+	}
+	if !c.isBaseLevelForKey(f.ukey) {
+		return f.unfold(c.add)
+	}
+	merged, value, err := f.fold(nil)
+	if err != nil {
+		return err
+	}
+	return c.addValue(merged, value, f.firstTime)
+}
+
 func (c *levelCompactor) compact() error {
+	tombstones, err := c.RangeTombstones()
+	if err != nil {
+		return err
+	}
+	c.tombstones = c.survivedRangeTombstones(tombstones)
+
 	it := c.NewIterator()
 	defer it.Close()
 
+	c.now = c.options.Now()
+	c.blobInputBytes = make(map[uint64]uint64)
+	c.blobOutputBytes = make(map[uint64]uint64)
+	c.pickCollectingBlobs()
 	ucmp := c.options.Comparator.UserKeyComparator
+	folder := mergeFolder{operator: c.options.MergeOperator, blobs: c.blobs, ucmp: ucmp, now: c.now}
 	var lastSequence keys.Sequence
 	var lastKey []byte
 	for it.Next() {
@@ -221,12 +459,53 @@ func (c *levelCompactor) compact() error {
 		}
 		currentUserKey, currentSequence, kind := ikey.Split()
 		if len(lastKey) == 0 || ucmp.Compare(lastKey, currentUserKey) != 0 {
+			if err := c.flushMerges(&folder); err != nil {
+				return err
+			}
 			lastKey = append(lastKey[:0], currentUserKey...)
 			lastSequence = keys.MaxSequence
 		}
+		if kind == keys.BlobIndex {
+			if err := countBlob(c.blobInputBytes, it.Value()); err != nil {
+				return err
+			}
+		}
 		switch {
+		case folder.active() && folder.stoppedBy(currentSequence, kind, it.Value(), tombstones):
+			if err := folder.unfold(c.add); err != nil {
+				return err
+			}
+			if err := c.addExpiringValue(ikey, it.Value(), false); err != nil {
+				return err
+			}
+		case folder.active():
+			merged, value, ok, err := folder.add(currentSequence, kind, it.Value(), tombstones)
+			if err != nil {
+				return err
+			}
+			if ok {
+				if err := c.addValue(merged, value, folder.firstTime); err != nil {
+					return err
+				}
+			}
 		case lastSequence <= c.smallestSequence:
 		case kind == keys.Delete && currentSequence <= c.smallestSequence && c.isBaseLevelForKey(currentUserKey):
+		case tombstones.MaxSequence(ucmp, currentUserKey, c.smallestSequence) > currentSequence:
+			// Deleted by range tombstone which is visible to all snapshots.
+		case kind == keys.Merge && currentSequence <= c.smallestSequence:
+			folder.start(currentUserKey, currentSequence, it.Value(), lastSequence == keys.MaxSequence)
+		case kind == keys.Value && currentSequence <= c.smallestSequence:
+			if err := c.addValue(ikey, it.Value(), lastSequence == keys.MaxSequence); err != nil {
+				return err
+			}
+		case kind == keys.ExpiringValue && currentSequence <= c.smallestSequence:
+			if err := c.addExpiringValue(ikey, it.Value(), lastSequence == keys.MaxSequence); err != nil {
+				return err
+			}
+		case kind == keys.BlobIndex && currentSequence <= c.smallestSequence:
+			if err := c.addBlobIndex(ikey, it.Value(), lastSequence == keys.MaxSequence); err != nil {
+				return err
+			}
 		default:
 			err := c.add(ikey, it.Value(), lastSequence == keys.MaxSequence)
 			if err != nil {
@@ -235,8 +514,22 @@ func (c *levelCompactor) compact() error {
 		}
 		lastSequence = currentSequence
 	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+	if err := c.flushMerges(&folder); err != nil {
+		return err
+	}
 
-	if err := c.closeCurrentTable(); err != nil {
+	if c.tableFile == nil && len(c.tombstones) != 0 {
+		if err := c.openTableFile(nil); err != nil {
+			return err
+		}
+	}
+	if err := c.closeCurrentTable(nil); err != nil {
+		return err
+	}
+	if err := c.finishBlobFile(); err != nil {
 		return err
 	}
 
@@ -257,6 +550,14 @@ func (c *levelCompactor) record(edit *manifest.Edit) {
 	for _, f := range c.outputs {
 		edit.AddedFiles = append(edit.AddedFiles, manifest.LevelFileMeta{Level: level + 1, FileMeta: f})
 	}
+	edit.AddedBlobFiles = append(edit.AddedBlobFiles[:0], c.blobOutputs...)
+	edit.BlobGarbages = edit.BlobGarbages[:0]
+	for number, input := range c.blobInputBytes {
+		if output := c.blobOutputBytes[number]; input > output {
+			edit.BlobGarbages = append(edit.BlobGarbages, manifest.BlobGarbage{Number: number, Bytes: input - output})
+		}
+	}
+	sort.Slice(edit.BlobGarbages, func(i, j int) bool { return edit.BlobGarbages[i].Number < edit.BlobGarbages[j].Number })
 	edit.CompactPointers = append(edit.CompactPointers, manifest.LevelCompactPointer{Level: level, Largest: c.NextCompactPointer})
 	if c.nextFileNumber > edit.NextFileNumber {
 		edit.NextFileNumber = c.nextFileNumber
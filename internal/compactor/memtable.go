@@ -3,6 +3,7 @@ package compactor
 import (
 	"os"
 
+	"github.com/kezhuw/leveldb/internal/blob"
 	"github.com/kezhuw/leveldb/internal/errors"
 	"github.com/kezhuw/leveldb/internal/file"
 	"github.com/kezhuw/leveldb/internal/keys"
@@ -26,22 +27,32 @@ func CompactMemTable(fileNumber uint64, fileName string, smallestSequence keys.S
 	return compactor.compact()
 }
 
-func NewMemTableCompactor(fileNumber uint64, fileName string, smallestSequence keys.Sequence, mem *memtable.MemTable, opts *options.Options) Compactor {
+// NewMemTableCompactor creates a compactor to compact memtable to file. If
+// blobName is not empty, values not smaller than Options.MinBlobSize are
+// stored in blob file blobName numbered blobNumber. Compaction filter is not
+// applied if snapshots are live.
+func NewMemTableCompactor(fileNumber uint64, fileName string, blobNumber uint64, blobName string, smallestSequence keys.Sequence, snapshots bool, mem *memtable.MemTable, opts *options.Options) Compactor {
 	c := &memtableCompactor{
 		mem:              mem,
 		smallestSequence: smallestSequence,
+		snapshots:        snapshots,
 		fs:               opts.FileSystem,
 		options:          opts,
 		tableName:        fileName,
+		blobName:         blobName,
 	}
 	c.tableMeta.Number = fileNumber
 	c.tableMeta.Size = 0
+	c.blobMeta.Number = blobNumber
 	return c
 }
 
 type memtableCompactor struct {
 	mem              *memtable.MemTable
 	smallestSequence keys.Sequence
+	snapshots        bool
+	// Time in nanoseconds since Unix epoch to expire values.
+	now int64
 
 	fs      file.FileSystem
 	options *options.Options
@@ -49,6 +60,13 @@ type memtableCompactor struct {
 	tableMeta   manifest.FileMeta
 	tableName   string
 	tableWriter table.Writer
+
+	// Large values are separated to blob file if blobName is not empty.
+	blobMeta    manifest.BlobFileMeta
+	blobName    string
+	blobFile    file.WriteCloser
+	blobWriter  blob.Writer
+	blobPointer []byte
 }
 
 func (c *memtableCompactor) Level() int {
@@ -57,9 +75,10 @@ func (c *memtableCompactor) Level() int {
 
 func (c *memtableCompactor) Rewind() {
 	c.tableMeta.Size = 0
+	c.blobMeta.Size = 0
 }
 
-func (c *memtableCompactor) compact() (*manifest.FileMeta, error) {
+func (c *memtableCompactor) compact() (_ *manifest.FileMeta, err error) {
 	f, err := c.fs.Open(c.tableName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
 	if err != nil {
 		return nil, err
@@ -70,39 +89,94 @@ func (c *memtableCompactor) compact() (*manifest.FileMeta, error) {
 			c.fs.Remove(c.tableName)
 		}
 	}()
+	defer func() {
+		if c.closeBlobFile() && err != nil {
+			c.fs.Remove(c.blobName)
+		}
+	}()
 
 	it := c.mem.NewIterator()
 	defer it.Close()
 
-	if !it.First() {
+	tombstones := c.mem.RangeTombstones()
+	if !it.First() && len(tombstones) == 0 {
 		return nil, it.Err()
 	}
 
 	w := &c.tableWriter
-	w.Reset(f, c.options)
+	w.Reset(f, 0, c.options)
 
-	w.Add(it.Key(), it.Value())
-	c.tableMeta.Smallest = append(c.tableMeta.Smallest[:0], it.Key()...)
-	c.tableMeta.Largest = append(c.tableMeta.Largest[:0], c.tableMeta.Smallest...)
-	lastUserKey, lastSequence, _ := c.tableMeta.Smallest.Split()
+	c.tableMeta.Smallest = c.tableMeta.Smallest[:0]
+	c.tableMeta.Largest = c.tableMeta.Largest[:0]
+	c.now = c.options.Now()
 	ucmp := c.options.Comparator.UserKeyComparator
-	for it.Next() {
+	folder := mergeFolder{operator: c.options.MergeOperator, ucmp: ucmp, now: c.now}
+	var lastUserKey []byte
+	var lastSequence keys.Sequence
+	for ok := it.Valid(); ok; ok = it.Next() {
 		key := it.Key()
-		currentUserKey, currentSequence, _ := keys.InternalKey(key).Split()
-		if lastSequence <= c.smallestSequence && ucmp.Compare(lastUserKey, currentUserKey) == 0 {
-			continue
+		currentUserKey, currentSequence, kind := keys.InternalKey(key).Split()
+		if lastUserKey == nil || ucmp.Compare(lastUserKey, currentUserKey) != 0 {
+			// Older entries of this key may exist in tables.
+			if err = folder.unfold(c.add); err != nil {
+				return nil, err
+			}
+			lastUserKey, lastSequence = currentUserKey, keys.MaxSequence
+		}
+		switch {
+		case folder.active() && folder.stoppedBy(currentSequence, kind, it.Value(), tombstones):
+			if err = folder.unfold(c.add); err != nil {
+				return nil, err
+			}
+			if err = c.addExpiringValue(key, it.Value()); err != nil {
+				return nil, err
+			}
+		case folder.active():
+			var merged, value []byte
+			var ok bool
+			if merged, value, ok, err = folder.add(currentSequence, kind, it.Value(), tombstones); err != nil {
+				return nil, err
+			}
+			if ok {
+				if err = c.addValue(merged, value); err != nil {
+					return nil, err
+				}
+			}
+		case lastSequence != keys.MaxSequence && lastSequence <= c.smallestSequence:
+		case kind == keys.Merge && currentSequence <= c.smallestSequence:
+			folder.start(currentUserKey, currentSequence, it.Value(), false)
+		case kind == keys.Value && currentSequence <= c.smallestSequence:
+			if err = c.addValue(key, it.Value()); err != nil {
+				return nil, err
+			}
+		case kind == keys.ExpiringValue && currentSequence <= c.smallestSequence:
+			if err = c.addExpiringValue(key, it.Value()); err != nil {
+				return nil, err
+			}
+		default:
+			if err = c.add(key, it.Value(), false); err != nil {
+				return nil, err
+			}
 		}
-		w.Add(key, it.Value())
-		c.tableMeta.Largest = append(c.tableMeta.Largest[:0], key...)
-		lastUserKey, lastSequence = c.tableMeta.Largest.UserKey(), currentSequence
+		lastSequence = currentSequence
+	}
+	if err = it.Err(); err != nil {
+		return nil, err
+	}
+	if err = folder.unfold(c.add); err != nil {
+		return nil, err
+	}
+	for i := range tombstones {
+		w.AddRangeTombstone(tombstones[i])
+		c.tableMeta.ExpandRange(c.options.Comparator, &tombstones[i])
 	}
-	if err := it.Err(); err != nil {
+	if err = w.Finish(); err != nil {
 		return nil, err
 	}
-	if err := w.Finish(); err != nil {
+	if err = f.Sync(); err != nil {
 		return nil, err
 	}
-	if err := f.Sync(); err != nil {
+	if err = c.finishBlobFile(); err != nil {
 		return nil, err
 	}
 	c.tableMeta.Size = uint64(w.FileSize())
@@ -114,6 +188,89 @@ func (c *memtableCompactor) compact() (*manifest.FileMeta, error) {
 	}, nil
 }
 
+func (c *memtableCompactor) add(key, value []byte, firstTime bool) error {
+	if c.blobName != "" && len(value) >= c.options.MinBlobSize {
+		if _, _, kind := keys.InternalKey(key).Split(); kind == keys.Value {
+			var err error
+			if key, value, err = c.addBlob(key, value); err != nil {
+				return err
+			}
+		}
+	}
+	if c.tableWriter.Empty() {
+		c.tableMeta.Smallest = append(c.tableMeta.Smallest[:0], key...)
+	}
+	c.tableMeta.Largest = append(c.tableMeta.Largest[:0], key...)
+	return c.tableWriter.Add(key, value)
+}
+
+// addBlob writes value of entry key to blob file, and returns blob index
+// entry pointing to it.
+func (c *memtableCompactor) addBlob(key, value []byte) ([]byte, []byte, error) {
+	if c.blobFile == nil {
+		f, err := c.fs.Open(c.blobName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+		if err != nil {
+			return nil, nil, err
+		}
+		c.blobFile = f
+		c.blobWriter.Reset(f, c.blobMeta.Number)
+	}
+	p, err := c.blobWriter.Add(value)
+	if err != nil {
+		return nil, nil, err
+	}
+	c.blobPointer = p.Append(c.blobPointer[:0])
+	ukey, seq, _ := keys.InternalKey(key).Split()
+	return keys.NewInternalKey(ukey, seq, keys.BlobIndex), c.blobPointer, nil
+}
+
+func (c *memtableCompactor) finishBlobFile() error {
+	if c.blobFile == nil {
+		return nil
+	}
+	if err := c.blobWriter.Finish(); err != nil {
+		return err
+	}
+	c.blobMeta.Size = c.blobWriter.Size()
+	return nil
+}
+
+// closeBlobFile closes blob file if it is opened, and reports whether it was
+// opened.
+func (c *memtableCompactor) closeBlobFile() bool {
+	if c.blobFile == nil {
+		return false
+	}
+	c.blobFile.Close()
+	c.blobFile = nil
+	return true
+}
+
+// addValue adds value entry visible to all snapshots after applying
+// compaction filter to it if Options.CompactionFilterOnFlush is set and no
+// snapshots are live.
+func (c *memtableCompactor) addValue(key, value []byte) error {
+	if c.options.CompactionFilterOnFlush && !c.snapshots {
+		key, value, _ = filterValue(c.options.CompactionFilter, 0, key, value)
+	}
+	return c.add(key, value, false)
+}
+
+// addExpiringValue adds expiring value visible to all snapshots. Expired
+// value is replaced by deletion, as older entries of its user key may exist
+// in tables.
+func (c *memtableCompactor) addExpiringValue(key, value []byte) error {
+	expired, err := expireValue(c.now, value)
+	switch {
+	case err != nil:
+		return err
+	case !expired:
+		return c.addValue(key, value)
+	}
+	ukey, seq, _ := keys.InternalKey(key).Split()
+	return c.add(keys.NewInternalKey(ukey, seq, keys.Delete), nil, false)
+}
+
 func (c *memtableCompactor) Compact(edit *manifest.Edit) error {
 	file, err := c.compact()
 	if err != nil {
@@ -123,5 +280,9 @@ func (c *memtableCompactor) Compact(edit *manifest.Edit) error {
 		return errors.ErrEmptyMemTable
 	}
 	edit.AddedFiles = append(edit.AddedFiles[:0], manifest.LevelFileMeta{Level: 0, FileMeta: file})
+	edit.AddedBlobFiles = edit.AddedBlobFiles[:0]
+	if c.blobMeta.Size != 0 {
+		edit.AddedBlobFiles = append(edit.AddedBlobFiles, c.blobMeta)
+	}
 	return nil
 }
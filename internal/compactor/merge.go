@@ -0,0 +1,107 @@
+package compactor
+
+import (
+	"github.com/kezhuw/leveldb/internal/keys"
+	"github.com/kezhuw/leveldb/internal/merge"
+	"github.com/kezhuw/leveldb/internal/rangedel"
+	"github.com/kezhuw/leveldb/internal/ttl"
+)
+
+// mergeFolder folds merge operands of a user key, which are visible to all
+// snapshots, with older entry of that key.
+type mergeFolder struct {
+	operator merge.Operator
+	blobs    merge.BlobReader
+	ucmp     keys.Comparer
+	// Time in nanoseconds since Unix epoch to expire values.
+	now int64
+
+	ukey      []byte
+	firstTime bool
+
+	// Sequences and operands from newest to oldest.
+	sequences []keys.Sequence
+	operands  [][]byte
+}
+
+func (f *mergeFolder) active() bool {
+	return len(f.operands) != 0
+}
+
+// start starts folding from the newest merge operand visible to all
+// snapshots. firstTime is true if no newer entries of this user key exist.
+func (f *mergeFolder) start(ukey []byte, seq keys.Sequence, operand []byte, firstTime bool) {
+	f.ukey = append(f.ukey[:0], ukey...)
+	f.firstTime = firstTime
+	f.push(seq, operand)
+}
+
+func (f *mergeFolder) push(seq keys.Sequence, operand []byte) {
+	f.sequences = append(f.sequences, seq)
+	f.operands = append(f.operands, append([]byte(nil), operand...))
+}
+
+func (f *mergeFolder) reset() {
+	f.sequences = f.sequences[:0]
+	f.operands = f.operands[:0]
+}
+
+// stoppedBy returns true if older entry of current user key is a live
+// expiring value. Operands could not be folded into it, as result of merging
+// changes after it expires. Expired value is folded by add as if key did not
+// exist, same as reads do.
+func (f *mergeFolder) stoppedBy(seq keys.Sequence, kind keys.Kind, value []byte, tombstones rangedel.List) bool {
+	if kind != keys.ExpiringValue || tombstones.MaxSequence(f.ucmp, f.ukey, f.sequences[0]) > seq {
+		return false
+	}
+	// Corrupt value is reported when it is added to output.
+	_, live, err := ttl.Live(value, f.now)
+	return live || err != nil
+}
+
+// add adds older entry of current user key to folder. It returns true if
+// operands are folded with this entry into merged.
+func (f *mergeFolder) add(seq keys.Sequence, kind keys.Kind, value []byte, tombstones rangedel.List) (merged keys.InternalKey, mergedValue []byte, ok bool, err error) {
+	deleted := tombstones.MaxSequence(f.ucmp, f.ukey, f.sequences[0]) > seq
+	switch {
+	case kind == keys.Merge && !deleted:
+		f.push(seq, value)
+		return nil, nil, false, nil
+	case kind == keys.Value && !deleted:
+		if value == nil {
+			value = []byte{}
+		}
+	case kind == keys.BlobIndex && !deleted:
+		if value, err = f.blobs.ReadBlob(value); err != nil {
+			return nil, nil, false, err
+		}
+	default:
+		value = nil
+	}
+	merged, mergedValue, err = f.fold(value)
+	return merged, mergedValue, true, err
+}
+
+// fold merges operands into existing value, which is nil if key does not
+// exist. Merged entry takes sequence of the newest operand.
+func (f *mergeFolder) fold(existing []byte) (keys.InternalKey, []byte, error) {
+	defer f.reset()
+	value, err := merge.Merge(f.operator, f.ukey, existing, f.operands)
+	if err != nil {
+		return nil, nil, err
+	}
+	return keys.NewInternalKey(f.ukey, f.sequences[0], keys.Value), value, nil
+}
+
+// unfold outputs operands as they are, since older entries of user key may
+// exist outside compaction.
+func (f *mergeFolder) unfold(add func(key, value []byte, firstTime bool) error) error {
+	defer f.reset()
+	for i, operand := range f.operands {
+		ikey := keys.NewInternalKey(f.ukey, f.sequences[i], keys.Merge)
+		if err := add(ikey, operand, f.firstTime && i == 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
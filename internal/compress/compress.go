@@ -2,6 +2,7 @@ package compress
 
 import (
 	"errors"
+	"sync"
 
 	"github.com/golang/snappy"
 )
@@ -11,22 +12,80 @@ type Type int
 const (
 	NoCompression     Type = 0
 	SnappyCompression Type = 1
+	ZstdCompression   Type = 2
+	LZ4Compression    Type = 4
 )
 
+// MaxType is the max compression type, as it is stored as a byte in block
+// trailer.
+const MaxType Type = 255
+
 var (
 	ErrNoCompression          = errors.New("leveldb: no compression")
 	ErrUnsupportedCompression = errors.New("leveldb: unsupported compression")
+	ErrInvalidCompression     = errors.New("leveldb: invalid compression type")
+	ErrBuiltinCompression     = errors.New("leveldb: can't override builtin compression")
+)
+
+// Codec compresses and decompresses blocks.
+type Codec interface {
+	Encode(dst, src []byte) ([]byte, error)
+	Decode(dst, src []byte) ([]byte, error)
+}
+
+var (
+	codecs   = make(map[Type]Codec)
+	codecsMu sync.RWMutex
 )
 
+// Register registers codec for compression type typ. Builtin compression
+// types except ZstdCompression can't be overrode. Registered zstd codec,
+// e.g. a faster one, takes place of the builtin one.
+func Register(typ Type, codec Codec) error {
+	switch {
+	case typ <= NoCompression || typ > MaxType:
+		return ErrInvalidCompression
+	case typ == SnappyCompression || typ == LZ4Compression:
+		return ErrBuiltinCompression
+	}
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[typ] = codec
+	return nil
+}
+
+func lookup(typ Type) Codec {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	return codecs[typ]
+}
+
+// Supported returns whether blocks could be compressed using typ, which is
+// either builtin or registered.
+func Supported(typ Type) bool {
+	switch typ {
+	case NoCompression, SnappyCompression, ZstdCompression, LZ4Compression:
+		return true
+	}
+	return lookup(typ) != nil
+}
+
 func Decode(typ Type, dst, src []byte) ([]byte, error) {
 	switch typ {
 	case NoCompression:
 		return nil, ErrNoCompression
 	case SnappyCompression:
 		return snappy.Decode(dst, src)
-	default:
-		return nil, ErrUnsupportedCompression
+	case LZ4Compression:
+		return decodeLZ4(dst, src)
 	}
+	if codec := lookup(typ); codec != nil {
+		return codec.Decode(dst, src)
+	}
+	if typ == ZstdCompression {
+		return decodeZstd(dst, src)
+	}
+	return nil, ErrUnsupportedCompression
 }
 
 func Encode(typ Type, dst, src []byte) ([]byte, error) {
@@ -35,7 +94,14 @@ func Encode(typ Type, dst, src []byte) ([]byte, error) {
 		return nil, ErrNoCompression
 	case SnappyCompression:
 		return snappy.Encode(dst, src), nil
-	default:
-		return nil, ErrUnsupportedCompression
+	case LZ4Compression:
+		return encodeLZ4(dst, src), nil
+	}
+	if codec := lookup(typ); codec != nil {
+		return codec.Encode(dst, src)
+	}
+	if typ == ZstdCompression {
+		return encodeZstd(dst, src), nil
 	}
+	return nil, ErrUnsupportedCompression
 }
@@ -2,6 +2,8 @@ package compress_test
 
 import (
 	"bytes"
+	"encoding/hex"
+	"fmt"
 	"math"
 	"math/rand"
 	"testing"
@@ -24,6 +26,24 @@ var compressionTests = []compressTest{
 	{typ: compress.SnappyCompression, size: 1024 * 1024},
 	{typ: compress.SnappyCompression, size: 1024 * 1024 * 4},
 	{typ: compress.SnappyCompression, size: 1024 * 1024 * 16},
+	{typ: compress.LZ4Compression, size: 0},
+	{typ: compress.LZ4Compression, size: 1},
+	{typ: compress.LZ4Compression, size: 16},
+	{typ: compress.LZ4Compression, size: 128},
+	{typ: compress.LZ4Compression, size: 1024},
+	{typ: compress.LZ4Compression, size: 1024 * 32},
+	{typ: compress.LZ4Compression, size: 1024 * 128},
+	{typ: compress.LZ4Compression, size: 1024 * 1024},
+	{typ: compress.LZ4Compression, size: 1024 * 1024 * 4},
+	{typ: compress.ZstdCompression, size: 0},
+	{typ: compress.ZstdCompression, size: 1},
+	{typ: compress.ZstdCompression, size: 16},
+	{typ: compress.ZstdCompression, size: 128},
+	{typ: compress.ZstdCompression, size: 1024},
+	{typ: compress.ZstdCompression, size: 1024 * 32},
+	{typ: compress.ZstdCompression, size: 1024 * 128},
+	{typ: compress.ZstdCompression, size: 1024 * 1024},
+	{typ: compress.ZstdCompression, size: 1024 * 1024 * 4},
 }
 
 func randomBuffer(size int) []byte {
@@ -49,6 +69,168 @@ func TestCompression(t *testing.T) {
 	}
 }
 
+func compressibleBuffer(size int) []byte {
+	words := [][]byte{[]byte("leveldb"), []byte("table"), []byte("block"), []byte("0123456789"), []byte("\x00\x00\x00\x00")}
+	buf := make([]byte, 0, size)
+	for len(buf) < size {
+		buf = append(buf, words[rand.Intn(len(words))]...)
+		if rand.Intn(8) == 0 {
+			buf = append(buf, byte(rand.Intn(256)))
+		}
+	}
+	return buf[:size]
+}
+
+func TestCompressibleCompression(t *testing.T) {
+	types := []compress.Type{compress.SnappyCompression, compress.LZ4Compression, compress.ZstdCompression}
+	sizes := []int{16, 100, 1024, 4096, 1024 * 64, 1024 * 1024}
+	for _, typ := range types {
+		for _, size := range sizes {
+			buf := compressibleBuffer(size)
+			compressed, err := compress.Encode(typ, nil, buf)
+			if err != nil {
+				t.Fatalf("compression type=%d size=%d encode error=%q", typ, size, err)
+			}
+			if size >= 1024 && len(compressed) >= size/2 {
+				t.Errorf("compression type=%d size=%d got compressed size %d", typ, size, len(compressed))
+			}
+			decompressed, err := compress.Decode(typ, nil, compressed)
+			if err != nil {
+				t.Fatalf("compression type=%d size=%d decode error=%q", typ, size, err)
+			}
+			if !bytes.Equal(buf, decompressed) {
+				t.Fatalf("compression type=%d size=%d decompressed content don't equal to original", typ, size)
+			}
+		}
+	}
+}
+
+func TestCorruptLZ4(t *testing.T) {
+	buf := compressibleBuffer(4096)
+	compressed, _ := compress.Encode(compress.LZ4Compression, nil, buf)
+	for i := 0; i < 1000; i++ {
+		corrupted := append([]byte(nil), compressed...)
+		corrupted[rand.Intn(len(corrupted))] = byte(rand.Intn(256))
+		corrupted = corrupted[:rand.Intn(len(corrupted)+1)]
+		// Must not panic.
+		compress.Decode(compress.LZ4Compression, nil, corrupted)
+	}
+}
+
+func TestCorruptZstd(t *testing.T) {
+	buf := compressibleBuffer(4096)
+	compressed, _ := compress.Encode(compress.ZstdCompression, nil, buf)
+	for i := 0; i < 1000; i++ {
+		corrupted := append([]byte(nil), compressed...)
+		corrupted[rand.Intn(len(corrupted))] = byte(rand.Intn(256))
+		corrupted = corrupted[:rand.Intn(len(corrupted)+1)]
+		// Must not panic.
+		compress.Decode(compress.ZstdCompression, nil, corrupted)
+	}
+}
+
+// zstdReferenceFrame is compressed by zstd command line tool with level 19
+// from zstdReferenceContent. It contains FSE compressed Huffman weights,
+// four literal streams, FSE coded sequence tables and checksum.
+const zstdReferenceFrame = "" +
+	"28b52ffd64100db50e0036e84e14c025c30100e01ca0e87fc9be524a99524a5c" +
+	"75664b0047004700541c52be5378d1ba38b4a070a744a83ebcdca77aadc9d962" +
+	"d1064b8a8a86249f9378529fe385836d09a9369c3c762aaf6ae2cc6262959253" +
+	"7968794ff4588f131362181000201844c11010254435c391cbe9de6b73ba6854" +
+	"5932aa1886c8eb441ed5c3918504f525a6eab0729ee6ad8e736a5131a592a192" +
+	"a1c87fb2e7ba1c2e18d29584a96a58723a8d37b570be78bc2e692e29158794ef" +
+	"145e445d1c5a50b85322541f5eee53bdd6e46cb168a34a8a8a86249f9378529f" +
+	"e385836d09a9369c3ce8545ed5c499c5c42a25a7f2d0f29ee8b11e271631116b" +
+	"0542ab84a866387239dd7b6d4e178d2a4b4615c3a8bc4ee4513d1c5948505f62" +
+	"aa0e2be769deea20a71615532a192a198afc277baecbe182215d498caa8625a7" +
+	"d378530be78bc71b8190a811d0edb306e1cf01210408c4f7016092d93176fccd" +
+	"048032695c3e9b6476dc42cdb74966c7d8f1c78525cd1ab3b267d22cfb3f2bbf" +
+	"261a3b66cdf35524b363ecf89b4949a1a459f93569ec98657fbcd70e53c7d8f1" +
+	"371300caa471f936c96c710b35df26991d63c71f174b9a65ccca9e49b3ecffac" +
+	"fc9a34764c35cf5791cc8eb1e36f26a5424953e5d7a4b163261515c84005646a" +
+	"4580f56b"
+
+func zstdReferenceContent() []byte {
+	var buf bytes.Buffer
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&buf, "key%04d=value%04d;", i, i*7%1000)
+	}
+	return buf.Bytes()
+}
+
+func TestZstdReferenceFrame(t *testing.T) {
+	frame, err := hex.DecodeString(zstdReferenceFrame)
+	if err != nil {
+		t.Fatalf("fail to decode hex: %s", err)
+	}
+	want := zstdReferenceContent()
+	got, err := compress.Decode(compress.ZstdCompression, nil, frame)
+	if err != nil {
+		t.Fatalf("Decode got error %q", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("decompressed content don't equal to original")
+	}
+	// Concatenated frames decode to concatenated contents.
+	got, err = compress.Decode(compress.ZstdCompression, nil, append(frame, frame...))
+	if err != nil {
+		t.Fatalf("Decode concatenated frames got error %q", err)
+	}
+	if !bytes.Equal(got, append(want, want...)) {
+		t.Fatalf("decompressed content of concatenated frames don't equal to original")
+	}
+	frame[len(frame)-1] ^= 0xff
+	if _, err := compress.Decode(compress.ZstdCompression, nil, frame); err == nil {
+		t.Fatalf("Decode frame with mismatched checksum got no error")
+	}
+}
+
+type xorCodec byte
+
+func (c xorCodec) Encode(dst, src []byte) ([]byte, error) {
+	dst = dst[:0]
+	for _, b := range src {
+		dst = append(dst, b^byte(c))
+	}
+	return dst, nil
+}
+
+func (c xorCodec) Decode(dst, src []byte) ([]byte, error) {
+	return c.Encode(dst, src)
+}
+
+func TestRegister(t *testing.T) {
+	const customCompression compress.Type = 100
+	buf := randomBuffer(1024)
+	if _, err := compress.Encode(customCompression, nil, buf); err != compress.ErrUnsupportedCompression {
+		t.Fatalf("Encode expect ErrUnsupportedCompression, got %v", err)
+	}
+	if err := compress.Register(customCompression, xorCodec(0x5a)); err != nil {
+		t.Fatalf("Register got error %q", err)
+	}
+	compressed, err := compress.Encode(customCompression, nil, buf)
+	if err != nil {
+		t.Fatalf("Encode got error %q", err)
+	}
+	decompressed, err := compress.Decode(customCompression, nil, compressed)
+	if err != nil {
+		t.Fatalf("Decode got error %q", err)
+	}
+	if !bytes.Equal(buf, decompressed) {
+		t.Fatalf("decompressed content don't equal to original")
+	}
+	for _, typ := range []compress.Type{compress.NoCompression, compress.SnappyCompression, compress.LZ4Compression} {
+		if err := compress.Register(typ, xorCodec(0)); err == nil {
+			t.Errorf("Register builtin compression %d got no error", typ)
+		}
+	}
+	for _, typ := range []compress.Type{-1, compress.MaxType + 1} {
+		if err := compress.Register(typ, xorCodec(0)); err != compress.ErrInvalidCompression {
+			t.Errorf("Register compression %d expect ErrInvalidCompression, got %v", typ, err)
+		}
+	}
+}
+
 func TestNoCompression(t *testing.T) {
 	var err error
 	_, err = compress.Encode(compress.NoCompression, nil, nil)
@@ -0,0 +1,165 @@
+package compress
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// LZ4 compressed block is prefixed with varint encoded length of
+// uncompressed data, followed by data in LZ4 block format.
+
+const (
+	lz4MinMatch     = 4
+	lz4HashLog      = 12
+	lz4MaxOffset    = 65535
+	lz4LastLiterals = 5
+	// Last match must start at least 12 bytes before end of block.
+	lz4MFLimit = 12
+	// Max compression ratio in LZ4 block format is about 255.
+	lz4MaxRatio = 255
+)
+
+var errCorruptLZ4 = errors.New("leveldb: corrupt lz4 block")
+
+func lz4Load32(b []byte, i int) uint32 {
+	return binary.LittleEndian.Uint32(b[i : i+4])
+}
+
+func lz4Hash(u uint32) uint32 {
+	return (u * 2654435761) >> (32 - lz4HashLog)
+}
+
+func lz4AppendLength(dst []byte, n int) []byte {
+	for n >= 255 {
+		dst = append(dst, 255)
+		n -= 255
+	}
+	return append(dst, byte(n))
+}
+
+func lz4AppendLiterals(dst []byte, token byte, literals []byte) []byte {
+	n := len(literals)
+	if n >= 15 {
+		dst = append(dst, token|0xF0)
+		dst = lz4AppendLength(dst, n-15)
+	} else {
+		dst = append(dst, token|byte(n<<4))
+	}
+	return append(dst, literals...)
+}
+
+func lz4AppendSequence(dst []byte, literals []byte, offset, matchLen int) []byte {
+	matchLen -= lz4MinMatch
+	var token byte
+	if matchLen >= 15 {
+		token = 0x0F
+	} else {
+		token = byte(matchLen)
+	}
+	dst = lz4AppendLiterals(dst, token, literals)
+	dst = append(dst, byte(offset), byte(offset>>8))
+	if matchLen >= 15 {
+		dst = lz4AppendLength(dst, matchLen-15)
+	}
+	return dst
+}
+
+func encodeLZ4(dst, src []byte) []byte {
+	n := len(src)
+	var buf [binary.MaxVarintLen64]byte
+	dst = append(dst[:0], buf[:binary.PutUvarint(buf[:], uint64(n))]...)
+	// Positions are stored plus one, so zero means empty slot.
+	var table [1 << lz4HashLog]int32
+	anchor := 0
+	matchLimit := n - lz4LastLiterals
+	for i := 0; i < n-lz4MFLimit; {
+		seq := lz4Load32(src, i)
+		h := lz4Hash(seq)
+		ref := int(table[h]) - 1
+		table[h] = int32(i + 1)
+		if ref < 0 || i-ref > lz4MaxOffset || lz4Load32(src, ref) != seq {
+			i++
+			continue
+		}
+		matchLen := lz4MinMatch
+		for i+matchLen < matchLimit && src[ref+matchLen] == src[i+matchLen] {
+			matchLen++
+		}
+		for i > anchor && ref > 0 && src[i-1] == src[ref-1] {
+			i, ref, matchLen = i-1, ref-1, matchLen+1
+		}
+		dst = lz4AppendSequence(dst, src[anchor:i], i-ref, matchLen)
+		i += matchLen
+		anchor = i
+	}
+	return lz4AppendLiterals(dst, 0, src[anchor:])
+}
+
+func lz4ReadLength(src []byte, n int) (int, []byte, error) {
+	if n != 15 {
+		return n, src, nil
+	}
+	for {
+		if len(src) == 0 {
+			return 0, nil, errCorruptLZ4
+		}
+		b := src[0]
+		src = src[1:]
+		n += int(b)
+		if b != 255 {
+			return n, src, nil
+		}
+	}
+}
+
+func decodeLZ4(dst, src []byte) ([]byte, error) {
+	size, k := binary.Uvarint(src)
+	if k <= 0 || size > uint64(len(src))*lz4MaxRatio {
+		return nil, errCorruptLZ4
+	}
+	src = src[k:]
+	n := int(size)
+	if cap(dst) < n {
+		dst = make([]byte, 0, n)
+	}
+	dst = dst[:0]
+	for len(src) != 0 {
+		token := src[0]
+		literalLen, src1, err := lz4ReadLength(src[1:], int(token>>4))
+		if err != nil {
+			return nil, err
+		}
+		src = src1
+		if literalLen > len(src) || len(dst)+literalLen > n {
+			return nil, errCorruptLZ4
+		}
+		dst = append(dst, src[:literalLen]...)
+		src = src[literalLen:]
+		if len(src) == 0 {
+			// Last sequence contains only literals.
+			break
+		}
+		if len(src) < 2 {
+			return nil, errCorruptLZ4
+		}
+		offset := int(src[0]) | int(src[1])<<8
+		matchLen, src1, err := lz4ReadLength(src[2:], int(token&0x0F))
+		if err != nil {
+			return nil, err
+		}
+		src = src1
+		matchLen += lz4MinMatch
+		if offset == 0 || offset > len(dst) || len(dst)+matchLen > n {
+			return nil, errCorruptLZ4
+		}
+		// Match may overlap with bytes it produces, copy byte by byte.
+		pos := len(dst) - offset
+		for i := 0; i < matchLen; i++ {
+			dst = append(dst, dst[pos+i])
+		}
+	}
+	if len(dst) != n {
+		return nil, errCorruptLZ4
+	}
+	return dst, nil
+}
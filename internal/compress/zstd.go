@@ -0,0 +1,368 @@
+package compress
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/bits"
+)
+
+// Zstd compressed block is a zstd frame as specified in RFC 8878. Encoder
+// writes one frame with content size and without checksum. It finds matches
+// using a hash table as LZ4 encoder does, codes literals using Huffman
+// coding, and codes sequences using predefined FSE distributions. Decoder
+// accepts frames from other encoders except those requiring dictionaries.
+
+const (
+	zstdMagic          = 0xFD2FB528
+	zstdSkippableMagic = 0x184D2A50
+	zstdSkippableMask  = 0xFFFFFFF0
+	zstdMaxBlockSize   = 128 << 10
+	zstdMinMatch       = 4
+	zstdHashLog        = 14
+	// Greater offsets have codes not covered by predefined distribution.
+	zstdMaxOffset = 1<<(zstdMaxPredefinedOffsetCode+1) - 4
+	// Decoder preallocates no more than this for frame content size.
+	zstdMaxPrealloc = 1 << 22
+)
+
+// Block types.
+const (
+	zstdRawBlock        = 0
+	zstdRLEBlock        = 1
+	zstdCompressedBlock = 2
+)
+
+var (
+	errCorruptZstd    = errors.New("leveldb: corrupt zstd block")
+	errZstdDictionary = errors.New("leveldb: zstd dictionary unsupported")
+)
+
+func zstdAppendUint32(dst []byte, v uint32) []byte {
+	return append(dst, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func zstdAppendBlockHeader(dst []byte, last bool, typ int, size int) []byte {
+	h := uint32(typ<<1 | size<<3)
+	if last {
+		h |= 1
+	}
+	return append(dst, byte(h), byte(h>>8), byte(h>>16))
+}
+
+func zstdHash(u uint32) uint32 {
+	return (u * 2654435761) >> (32 - zstdHashLog)
+}
+
+type zstdEncoder struct {
+	src []byte
+	// Positions are stored plus one, so zero means empty slot.
+	table     [1 << zstdHashLog]int32
+	literals  []byte
+	sequences []zstdSequence
+}
+
+func encodeZstd(dst, src []byte) []byte {
+	n := len(src)
+	dst = zstdAppendUint32(dst[:0], zstdMagic)
+	// Single segment frame with content size.
+	switch {
+	case n < 256:
+		dst = append(dst, 0<<6|1<<5, byte(n))
+	case n < 1<<16+256:
+		dst = append(dst, 1<<6|1<<5, byte(n-256), byte((n-256)>>8))
+	case uint64(n) < 1<<32:
+		dst = append(dst, 2<<6|1<<5)
+		dst = zstdAppendUint32(dst, uint32(n))
+	default:
+		dst = append(dst, 3<<6|1<<5)
+		dst = zstdAppendUint32(dst, uint32(n))
+		dst = zstdAppendUint32(dst, uint32(uint64(n)>>32))
+	}
+	if n == 0 {
+		return zstdAppendBlockHeader(dst, true, zstdRawBlock, 0)
+	}
+	e := &zstdEncoder{src: src}
+	for start := 0; start < n; start += zstdMaxBlockSize {
+		end := start + zstdMaxBlockSize
+		if end > n {
+			end = n
+		}
+		dst = e.appendBlock(dst, start, end)
+	}
+	return dst
+}
+
+func (e *zstdEncoder) appendBlock(dst []byte, start, end int) []byte {
+	block := e.src[start:end]
+	last := end == len(e.src)
+	if len(block) > 1 && zstdRepeated(block) {
+		dst = zstdAppendBlockHeader(dst, last, zstdRLEBlock, len(block))
+		return append(dst, block[0])
+	}
+	e.findSequences(start, end)
+	headerAt := len(dst)
+	dst = zstdAppendBlockHeader(dst, last, zstdCompressedBlock, 0)
+	dst = zstdAppendLiterals(dst, e.literals)
+	dst = zstdAppendSequences(dst, e.sequences)
+	size := len(dst) - headerAt - 3
+	if size >= len(block) {
+		dst = zstdAppendBlockHeader(dst[:headerAt], last, zstdRawBlock, len(block))
+		return append(dst, block...)
+	}
+	// Overwrite placeholder header with compressed size.
+	zstdAppendBlockHeader(dst[headerAt:headerAt], last, zstdCompressedBlock, size)
+	return dst
+}
+
+// findSequences finds matches for bytes in [start, end) of src, matches
+// may refer to bytes in previous blocks.
+func (e *zstdEncoder) findSequences(start, end int) {
+	src := e.src
+	e.literals = e.literals[:0]
+	e.sequences = e.sequences[:0]
+	anchor := start
+	for i := start; i+zstdMinMatch <= end; {
+		seq := binary.LittleEndian.Uint32(src[i:])
+		h := zstdHash(seq)
+		ref := int(e.table[h]) - 1
+		e.table[h] = int32(i + 1)
+		if ref < 0 || i-ref > zstdMaxOffset || binary.LittleEndian.Uint32(src[ref:]) != seq {
+			i++
+			continue
+		}
+		matchLen := zstdMinMatch
+		for i+matchLen < end && src[ref+matchLen] == src[i+matchLen] {
+			matchLen++
+		}
+		for i > anchor && ref > 0 && src[i-1] == src[ref-1] {
+			i, ref, matchLen = i-1, ref-1, matchLen+1
+		}
+		e.literals = append(e.literals, src[anchor:i]...)
+		e.sequences = append(e.sequences, zstdSequence{
+			literalLen: uint32(i - anchor),
+			matchLen:   uint32(matchLen),
+			offset:     uint32(i - ref),
+		})
+		i += matchLen
+		anchor = i
+	}
+	e.literals = append(e.literals, src[anchor:end]...)
+}
+
+// zstdDecoder decodes frames. Tables and buffers are kept across blocks in
+// a frame.
+type zstdDecoder struct {
+	huffman   zstdHuffmanDecoder
+	sequences zstdSequenceDecoder
+	literals  []byte
+}
+
+func decodeZstd(dst, src []byte) ([]byte, error) {
+	dst = dst[:0]
+	var d zstdDecoder
+	for len(src) != 0 {
+		if len(src) < 4 {
+			return nil, errCorruptZstd
+		}
+		magic := binary.LittleEndian.Uint32(src)
+		if magic&zstdSkippableMask == zstdSkippableMagic {
+			if len(src) < 8 {
+				return nil, errCorruptZstd
+			}
+			size := binary.LittleEndian.Uint32(src[4:])
+			if uint64(len(src)-8) < uint64(size) {
+				return nil, errCorruptZstd
+			}
+			src = src[8+int(size):]
+			continue
+		}
+		if magic != zstdMagic {
+			return nil, errCorruptZstd
+		}
+		var err error
+		if dst, src, err = d.decodeFrame(dst, src[4:]); err != nil {
+			return nil, err
+		}
+	}
+	return dst, nil
+}
+
+// decodeFrame decodes frame after magic number, and returns remaining bytes.
+func (d *zstdDecoder) decodeFrame(dst, src []byte) ([]byte, []byte, error) {
+	if len(src) == 0 {
+		return nil, nil, errCorruptZstd
+	}
+	descriptor := src[0]
+	src = src[1:]
+	if descriptor&(1<<3) != 0 {
+		return nil, nil, errCorruptZstd
+	}
+	singleSegment := descriptor&(1<<5) != 0
+	checksum := descriptor&(1<<2) != 0
+	dictSize := [4]int{0, 1, 2, 4}[descriptor&3]
+	contentSizeSize := [4]int{0, 2, 4, 8}[descriptor>>6]
+	if singleSegment && contentSizeSize == 0 {
+		contentSizeSize = 1
+	}
+	headerSize := dictSize + contentSizeSize
+	if !singleSegment {
+		// Window size is not needed as whole content is kept in memory.
+		headerSize++
+	}
+	if len(src) < headerSize {
+		return nil, nil, errCorruptZstd
+	}
+	if !singleSegment {
+		src = src[1:]
+	}
+	var dictID, contentSize uint64
+	for i := dictSize - 1; i >= 0; i-- {
+		dictID = dictID<<8 | uint64(src[i])
+	}
+	src = src[dictSize:]
+	if dictID != 0 {
+		return nil, nil, errZstdDictionary
+	}
+	for i := contentSizeSize - 1; i >= 0; i-- {
+		contentSize = contentSize<<8 | uint64(src[i])
+	}
+	if contentSizeSize == 2 {
+		contentSize += 256
+	}
+	src = src[contentSizeSize:]
+
+	frameStart := len(dst)
+	if contentSizeSize != 0 && contentSize <= zstdMaxPrealloc && uint64(cap(dst)-len(dst)) < contentSize {
+		buf := make([]byte, len(dst), len(dst)+int(contentSize))
+		copy(buf, dst)
+		dst = buf
+	}
+	d.huffman.reset()
+	d.sequences.reset()
+	for last := false; !last; {
+		if len(src) < 3 {
+			return nil, nil, errCorruptZstd
+		}
+		h := int(src[0]) | int(src[1])<<8 | int(src[2])<<16
+		src = src[3:]
+		last = h&1 != 0
+		size := h >> 3
+		if size > zstdMaxBlockSize {
+			return nil, nil, errCorruptZstd
+		}
+		switch h >> 1 & 3 {
+		case zstdRawBlock:
+			if len(src) < size {
+				return nil, nil, errCorruptZstd
+			}
+			dst = append(dst, src[:size]...)
+			src = src[size:]
+		case zstdRLEBlock:
+			if len(src) == 0 {
+				return nil, nil, errCorruptZstd
+			}
+			for i := 0; i < size; i++ {
+				dst = append(dst, src[0])
+			}
+			src = src[1:]
+		case zstdCompressedBlock:
+			if len(src) < size {
+				return nil, nil, errCorruptZstd
+			}
+			var err error
+			if dst, err = d.decodeBlock(dst, src[:size], frameStart); err != nil {
+				return nil, nil, err
+			}
+			src = src[size:]
+		default:
+			return nil, nil, errCorruptZstd
+		}
+	}
+	if contentSizeSize != 0 && uint64(len(dst)-frameStart) != contentSize {
+		return nil, nil, errCorruptZstd
+	}
+	if checksum {
+		if len(src) < 4 {
+			return nil, nil, errCorruptZstd
+		}
+		if uint32(zstdXXHash64(dst[frameStart:])) != binary.LittleEndian.Uint32(src) {
+			return nil, nil, errCorruptZstd
+		}
+		src = src[4:]
+	}
+	return dst, src, nil
+}
+
+func (d *zstdDecoder) decodeBlock(dst, block []byte, frameStart int) ([]byte, error) {
+	literals, rest, err := d.decodeLiterals(block)
+	if err != nil {
+		return nil, err
+	}
+	return d.sequences.decode(dst, rest, literals, frameStart)
+}
+
+const (
+	zstdPrime64x1 = 11400714785074694791
+	zstdPrime64x2 = 14029467366897019727
+	zstdPrime64x3 = 1609587929392839161
+	zstdPrime64x4 = 9650029242287828579
+	zstdPrime64x5 = 2870177450012600261
+)
+
+func zstdXXHashRound(acc, v uint64) uint64 {
+	acc += v * zstdPrime64x2
+	acc = bits.RotateLeft64(acc, 31)
+	return acc * zstdPrime64x1
+}
+
+func zstdXXHashMerge(acc, v uint64) uint64 {
+	acc ^= zstdXXHashRound(0, v)
+	return acc*zstdPrime64x1 + zstdPrime64x4
+}
+
+// zstdXXHash64 returns XXH64 of b with zero seed, lower 32 bits of which is
+// frame checksum.
+func zstdXXHash64(b []byte) uint64 {
+	n := len(b)
+	var h uint64
+	if n >= 32 {
+		prime1 := uint64(zstdPrime64x1)
+		v1 := prime1 + zstdPrime64x2
+		v2 := uint64(zstdPrime64x2)
+		v3 := uint64(0)
+		v4 := -prime1
+		for ; len(b) >= 32; b = b[32:] {
+			v1 = zstdXXHashRound(v1, binary.LittleEndian.Uint64(b))
+			v2 = zstdXXHashRound(v2, binary.LittleEndian.Uint64(b[8:]))
+			v3 = zstdXXHashRound(v3, binary.LittleEndian.Uint64(b[16:]))
+			v4 = zstdXXHashRound(v4, binary.LittleEndian.Uint64(b[24:]))
+		}
+		h = bits.RotateLeft64(v1, 1) + bits.RotateLeft64(v2, 7) + bits.RotateLeft64(v3, 12) + bits.RotateLeft64(v4, 18)
+		h = zstdXXHashMerge(h, v1)
+		h = zstdXXHashMerge(h, v2)
+		h = zstdXXHashMerge(h, v3)
+		h = zstdXXHashMerge(h, v4)
+	} else {
+		h = zstdPrime64x5
+	}
+	h += uint64(n)
+	for ; len(b) >= 8; b = b[8:] {
+		h ^= zstdXXHashRound(0, binary.LittleEndian.Uint64(b))
+		h = bits.RotateLeft64(h, 27)*zstdPrime64x1 + zstdPrime64x4
+	}
+	if len(b) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(b)) * zstdPrime64x1
+		h = bits.RotateLeft64(h, 23)*zstdPrime64x2 + zstdPrime64x3
+		b = b[4:]
+	}
+	for _, c := range b {
+		h ^= uint64(c) * zstdPrime64x5
+		h = bits.RotateLeft64(h, 11) * zstdPrime64x1
+	}
+	h ^= h >> 33
+	h *= zstdPrime64x2
+	h ^= h >> 29
+	h *= zstdPrime64x3
+	h ^= h >> 32
+	return h
+}
@@ -0,0 +1,136 @@
+package compress
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+func zstdBitMask(n uint) uint64 {
+	return 1<<n - 1
+}
+
+// zstdLoad64 returns up to 8 little endian bytes of b starting at byte i,
+// missing bytes are zeros.
+func zstdLoad64(b []byte, i int) uint64 {
+	if i+8 <= len(b) {
+		return binary.LittleEndian.Uint64(b[i:])
+	}
+	var v uint64
+	for j := len(b) - 1; j >= i; j-- {
+		v = v<<8 | uint64(b[j])
+	}
+	return v
+}
+
+// zstdForwardReader reads bits from lowest bit of first byte, as FSE table
+// descriptions are stored.
+type zstdForwardReader struct {
+	b   []byte
+	pos int
+}
+
+// peek returns next n bits, n <= 32. Bits after end of data are zeros.
+func (r *zstdForwardReader) peek(n uint) uint32 {
+	return uint32(zstdLoad64(r.b, r.pos>>3) >> uint(r.pos&7) & zstdBitMask(n))
+}
+
+func (r *zstdForwardReader) skip(n uint) {
+	r.pos += int(n)
+}
+
+func (r *zstdForwardReader) read(n uint) uint32 {
+	v := r.peek(n)
+	r.skip(n)
+	return v
+}
+
+func (r *zstdForwardReader) overflowed() bool {
+	return r.pos > len(r.b)*8
+}
+
+// bytes returns number of bytes consumed.
+func (r *zstdForwardReader) bytes() int {
+	return (r.pos + 7) >> 3
+}
+
+// zstdBackwardReader reads bits from highest bit of last byte, as Huffman
+// and FSE coded streams are stored. Streams end with a 1 bit marking their
+// starts.
+type zstdBackwardReader struct {
+	b []byte
+	// pos is number of bits not read yet. It is negative after reading
+	// past start of stream.
+	pos int
+}
+
+func (r *zstdBackwardReader) init(b []byte) error {
+	if len(b) == 0 || b[len(b)-1] == 0 {
+		return errCorruptZstd
+	}
+	r.b = b
+	r.pos = (len(b)-1)*8 + bits.Len8(b[len(b)-1]) - 1
+	return nil
+}
+
+// peek returns next n bits, n <= 32. Bits before start of stream are zeros.
+func (r *zstdBackwardReader) peek(n uint) uint32 {
+	p := r.pos - int(n)
+	if p >= 0 {
+		return uint32(zstdLoad64(r.b, p>>3) >> uint(p&7) & zstdBitMask(n))
+	}
+	if r.pos <= 0 {
+		return 0
+	}
+	return uint32((zstdLoad64(r.b, 0) & zstdBitMask(uint(r.pos))) << uint(-p))
+}
+
+func (r *zstdBackwardReader) skip(n uint) {
+	r.pos -= int(n)
+}
+
+func (r *zstdBackwardReader) read(n uint) uint32 {
+	if n == 0 {
+		return 0
+	}
+	v := r.peek(n)
+	r.skip(n)
+	return v
+}
+
+// overflowed reports whether bits before start of stream were read.
+func (r *zstdBackwardReader) overflowed() bool {
+	return r.pos < 0
+}
+
+// finished reports whether all bits were read exactly.
+func (r *zstdBackwardReader) finished() bool {
+	return r.pos == 0
+}
+
+// zstdBitWriter writes bits in order zstdBackwardReader reads them
+// backward.
+type zstdBitWriter struct {
+	b     []byte
+	value uint64
+	count uint
+}
+
+// add appends lowest n bits of v, n <= 32.
+func (w *zstdBitWriter) add(v uint64, n uint) {
+	w.value |= (v & zstdBitMask(n)) << w.count
+	w.count += n
+	for w.count >= 8 {
+		w.b = append(w.b, byte(w.value))
+		w.value >>= 8
+		w.count -= 8
+	}
+}
+
+// close appends the start mark and returns written bytes.
+func (w *zstdBitWriter) close() []byte {
+	w.add(1, 1)
+	if w.count != 0 {
+		w.b = append(w.b, byte(w.value))
+	}
+	return w.b
+}
@@ -0,0 +1,229 @@
+package compress
+
+import "math/bits"
+
+// zstdFSEEntry is a decoding state of FSE table. Next state is baseline
+// plus next nbBits bits of stream.
+type zstdFSEEntry struct {
+	symbol   uint8
+	nbBits   uint8
+	baseline uint16
+}
+
+type zstdFSETable struct {
+	log     uint
+	entries []zstdFSEEntry
+}
+
+// zstdSpreadSymbols spreads symbols with normalized counts over table.
+// Symbols with count -1 take one cell each from end of table.
+func zstdSpreadSymbols(table []uint8, norm []int16) error {
+	size := len(table)
+	high := size - 1
+	for s, c := range norm {
+		if c == -1 {
+			if high < 0 {
+				return errCorruptZstd
+			}
+			table[high] = uint8(s)
+			high--
+		}
+	}
+	pos, step, mask := 0, size>>1+size>>3+3, size-1
+	for s, c := range norm {
+		for i := 0; i < int(c); i++ {
+			table[pos] = uint8(s)
+			pos = (pos + step) & mask
+			for pos > high {
+				pos = (pos + step) & mask
+			}
+		}
+	}
+	if pos != 0 {
+		return errCorruptZstd
+	}
+	return nil
+}
+
+func newZstdFSETable(norm []int16, log uint) (*zstdFSETable, error) {
+	size := 1 << log
+	symbols := make([]uint8, size)
+	if err := zstdSpreadSymbols(symbols, norm); err != nil {
+		return nil, err
+	}
+	next := make([]uint16, len(norm))
+	for s, c := range norm {
+		if c == -1 {
+			next[s] = 1
+		} else {
+			next[s] = uint16(c)
+		}
+	}
+	entries := make([]zstdFSEEntry, size)
+	for u, s := range symbols {
+		x := next[s]
+		next[s]++
+		nbBits := log + 1 - uint(bits.Len16(x))
+		entries[u] = zstdFSEEntry{symbol: s, nbBits: uint8(nbBits), baseline: uint16(int(x)<<nbBits - size)}
+	}
+	return &zstdFSETable{log: log, entries: entries}, nil
+}
+
+// newZstdRLETable returns table which decodes only symbol s.
+func newZstdRLETable(s uint8) *zstdFSETable {
+	return &zstdFSETable{entries: []zstdFSEEntry{{symbol: s}}}
+}
+
+// readZstdFSETable reads FSE table description from src, and returns table
+// and number of bytes consumed.
+func readZstdFSETable(src []byte, maxSymbol int, maxLog uint) (*zstdFSETable, int, error) {
+	r := zstdForwardReader{b: src}
+	log := uint(r.read(4)) + 5
+	if log > maxLog {
+		return nil, 0, errCorruptZstd
+	}
+	remaining := int32(1)<<log + 1
+	threshold := int32(1) << log
+	nbBits := log + 1
+	norm := make([]int16, 0, maxSymbol+1)
+	previous0 := false
+	for remaining > 1 && len(norm) <= maxSymbol {
+		if previous0 {
+			n := len(norm)
+			for {
+				repeat := r.read(2)
+				n += int(repeat)
+				if repeat != 3 {
+					break
+				}
+				if r.overflowed() {
+					return nil, 0, errCorruptZstd
+				}
+			}
+			if n > maxSymbol {
+				return nil, 0, errCorruptZstd
+			}
+			for len(norm) < n {
+				norm = append(norm, 0)
+			}
+		}
+		max := 2*threshold - 1 - remaining
+		v := int32(r.peek(nbBits))
+		var count int32
+		if v&(threshold-1) < max {
+			count = v & (threshold - 1)
+			r.skip(nbBits - 1)
+		} else {
+			count = v & (2*threshold - 1)
+			if count >= threshold {
+				count -= max
+			}
+			r.skip(nbBits)
+		}
+		count--
+		if count < 0 {
+			remaining += count
+		} else {
+			remaining -= count
+		}
+		if remaining < 1 {
+			return nil, 0, errCorruptZstd
+		}
+		norm = append(norm, int16(count))
+		previous0 = count == 0
+		for remaining < threshold {
+			nbBits--
+			threshold >>= 1
+		}
+	}
+	if remaining != 1 || r.overflowed() {
+		return nil, 0, errCorruptZstd
+	}
+	table, err := newZstdFSETable(norm, log)
+	if err != nil {
+		return nil, 0, err
+	}
+	return table, r.bytes(), nil
+}
+
+type zstdFSESymbol struct {
+	deltaNbBits    uint32
+	deltaFindState int32
+}
+
+// zstdFSEEncoder encodes symbols to states of FSE table built from same
+// normalized counts.
+type zstdFSEEncoder struct {
+	log        uint
+	stateTable []uint16
+	symbols    []zstdFSESymbol
+}
+
+func newZstdFSEEncoder(norm []int16, log uint) *zstdFSEEncoder {
+	size := 1 << log
+	symbols := make([]uint8, size)
+	if err := zstdSpreadSymbols(symbols, norm); err != nil {
+		panic(err)
+	}
+	cumul := make([]int, len(norm))
+	total := 0
+	for s, c := range norm {
+		cumul[s] = total
+		if c == -1 {
+			total++
+		} else {
+			total += int(c)
+		}
+	}
+	e := &zstdFSEEncoder{
+		log:        log,
+		stateTable: make([]uint16, size),
+		symbols:    make([]zstdFSESymbol, len(norm)),
+	}
+	for u, s := range symbols {
+		e.stateTable[cumul[s]] = uint16(size + u)
+		cumul[s]++
+	}
+	total = 0
+	for s, c := range norm {
+		switch c {
+		case 0:
+		case -1, 1:
+			e.symbols[s] = zstdFSESymbol{deltaNbBits: uint32(log<<16 - uint(size)), deltaFindState: int32(total - 1)}
+			total++
+		default:
+			maxBitsOut := log - uint(bits.Len16(uint16(c-1))-1)
+			minStatePlus := uint32(c) << maxBitsOut
+			e.symbols[s] = zstdFSESymbol{deltaNbBits: uint32(maxBitsOut<<16) - minStatePlus, deltaFindState: int32(total - int(c))}
+			total += int(c)
+		}
+	}
+	return e
+}
+
+type zstdFSEState struct {
+	encoder *zstdFSEEncoder
+	value   uint32
+}
+
+// init initializes state to the one decoding symbol without emitting bits.
+func (st *zstdFSEState) init(encoder *zstdFSEEncoder, symbol uint8) {
+	tt := encoder.symbols[symbol]
+	nbBitsOut := (tt.deltaNbBits + 1<<15) >> 16
+	value := nbBitsOut<<16 - tt.deltaNbBits
+	st.encoder = encoder
+	st.value = uint32(encoder.stateTable[int32(value>>nbBitsOut)+tt.deltaFindState])
+}
+
+// encode emits bits of current state to transit to the one decoding symbol.
+func (st *zstdFSEState) encode(w *zstdBitWriter, symbol uint8) {
+	tt := st.encoder.symbols[symbol]
+	nbBitsOut := (st.value + tt.deltaNbBits) >> 16
+	w.add(uint64(st.value), uint(nbBitsOut))
+	st.value = uint32(st.encoder.stateTable[int32(st.value>>nbBitsOut)+tt.deltaFindState])
+}
+
+// flush emits final state, which is the initial state for decoder.
+func (st *zstdFSEState) flush(w *zstdBitWriter) {
+	w.add(uint64(st.value), st.encoder.log)
+}
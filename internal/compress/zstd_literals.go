@@ -0,0 +1,496 @@
+package compress
+
+import (
+	"encoding/binary"
+	"math/bits"
+	"sort"
+)
+
+// Literals section types.
+const (
+	zstdRawLiterals        = 0
+	zstdRLELiterals        = 1
+	zstdCompressedLiterals = 2
+	zstdTreelessLiterals   = 3
+)
+
+const (
+	zstdMaxHuffmanBits = 11
+	// zstdMaxDirectWeights is the max number of Huffman weights stored
+	// directly in 4 bits each.
+	zstdMaxDirectWeights = 128
+	// zstdMinHuffmanLiterals is the min number of literals worth Huffman
+	// coding.
+	zstdMinHuffmanLiterals = 64
+	// zstdMaxSingleStreamLiterals is the max number of literals stored in
+	// one Huffman coded stream.
+	zstdMaxSingleStreamLiterals = 1023
+)
+
+func zstdAppendLiteralsHeader(dst []byte, typ byte, n int) []byte {
+	switch {
+	case n < 32:
+		return append(dst, typ|byte(n)<<3)
+	case n < 4096:
+		return append(dst, typ|1<<2|byte(n)<<4, byte(n>>4))
+	default:
+		return append(dst, typ|3<<2|byte(n)<<4, byte(n>>4), byte(n>>12))
+	}
+}
+
+func zstdAppendLiterals(dst []byte, literals []byte) []byte {
+	n := len(literals)
+	if n > 1 && zstdRepeated(literals) {
+		dst = zstdAppendLiteralsHeader(dst, zstdRLELiterals, n)
+		return append(dst, literals[0])
+	}
+	if n >= zstdMinHuffmanLiterals {
+		if b, ok := zstdAppendHuffmanLiterals(dst, literals); ok {
+			return b
+		}
+	}
+	dst = zstdAppendLiteralsHeader(dst, zstdRawLiterals, n)
+	return append(dst, literals...)
+}
+
+// zstdRepeated reports whether all bytes in b are same.
+func zstdRepeated(b []byte) bool {
+	for _, c := range b[1:] {
+		if c != b[0] {
+			return false
+		}
+	}
+	return true
+}
+
+// zstdHuffmanItem is either a symbol or a package of two items in previous
+// list of package-merge algorithm.
+type zstdHuffmanItem struct {
+	weight int
+	// symbol is -1 for package.
+	symbol int
+}
+
+// zstdHuffmanLengths returns lengths of Huffman codes for symbols with
+// frequencies, no one exceeds maxBits. It uses package-merge algorithm.
+func zstdHuffmanLengths(freq []int, maxBits int) []uint8 {
+	var leaves []zstdHuffmanItem
+	for s, f := range freq {
+		if f > 0 {
+			leaves = append(leaves, zstdHuffmanItem{weight: f, symbol: s})
+		}
+	}
+	sort.SliceStable(leaves, func(i, j int) bool { return leaves[i].weight < leaves[j].weight })
+	lists := make([][]zstdHuffmanItem, maxBits)
+	lists[0] = leaves
+	for i := 1; i < maxBits; i++ {
+		prev := lists[i-1]
+		list := make([]zstdHuffmanItem, 0, len(leaves)+len(prev)/2)
+		k := 0
+		for _, leaf := range leaves {
+			for ; k+1 < len(prev) && prev[k].weight+prev[k+1].weight < leaf.weight; k += 2 {
+				list = append(list, zstdHuffmanItem{weight: prev[k].weight + prev[k+1].weight, symbol: -1})
+			}
+			list = append(list, leaf)
+		}
+		for ; k+1 < len(prev); k += 2 {
+			list = append(list, zstdHuffmanItem{weight: prev[k].weight + prev[k+1].weight, symbol: -1})
+		}
+		lists[i] = list
+	}
+	// Packages are merged in order, so selected packages in a list are
+	// made from leading items of previous list.
+	lengths := make([]uint8, len(freq))
+	n := 2*len(leaves) - 2
+	for i := maxBits - 1; i >= 0 && n > 0; i-- {
+		packages := 0
+		for _, item := range lists[i][:n] {
+			if item.symbol < 0 {
+				packages++
+			} else {
+				lengths[item.symbol]++
+			}
+		}
+		n = 2 * packages
+	}
+	return lengths
+}
+
+type zstdHuffmanEncoder struct {
+	codes   [256]uint16
+	lengths [256]uint8
+}
+
+func (e *zstdHuffmanEncoder) appendStream(dst []byte, literals []byte) []byte {
+	w := zstdBitWriter{b: dst}
+	// Decoder reads stream backward, so first literal goes last.
+	for i := len(literals) - 1; i >= 0; i-- {
+		c := literals[i]
+		w.add(uint64(e.codes[c]), uint(e.lengths[c]))
+	}
+	return w.close()
+}
+
+// zstdAppendHuffmanLiterals appends Huffman compressed literals section if
+// it is smaller than raw literals.
+func zstdAppendHuffmanLiterals(dst []byte, literals []byte) ([]byte, bool) {
+	var freq [256]int
+	for _, c := range literals {
+		freq[c]++
+	}
+	maxSymbol := 255
+	for freq[maxSymbol] == 0 {
+		maxSymbol--
+	}
+	if maxSymbol > zstdMaxDirectWeights {
+		return dst, false
+	}
+	var e zstdHuffmanEncoder
+	copy(e.lengths[:], zstdHuffmanLengths(freq[:maxSymbol+1], zstdMaxHuffmanBits))
+	maxBits := uint8(0)
+	for _, n := range e.lengths {
+		if n > maxBits {
+			maxBits = n
+		}
+	}
+	// Weight of symbol with code length n is maxBits+1-n. Codes of
+	// symbols with same weight are consecutive in symbol order, and
+	// codes of greater weights come after those of smaller weights.
+	var weights [256]uint8
+	var rankStart [zstdMaxHuffmanBits + 2]uint32
+	for s := 0; s <= maxSymbol; s++ {
+		if n := e.lengths[s]; n != 0 {
+			weights[s] = maxBits + 1 - n
+			rankStart[weights[s]+1] += 1 << (weights[s] - 1)
+		}
+	}
+	for w := 1; w < len(rankStart); w++ {
+		rankStart[w] += rankStart[w-1]
+	}
+	for s := 0; s <= maxSymbol; s++ {
+		if w := weights[s]; w != 0 {
+			e.codes[s] = uint16(rankStart[w] >> (w - 1))
+			rankStart[w] += 1 << (w - 1)
+		}
+	}
+
+	n := len(literals)
+	start := len(dst)
+	var headerSize int
+	switch {
+	case n <= zstdMaxSingleStreamLiterals:
+		headerSize = 3
+	case n < 1<<14:
+		headerSize = 4
+	default:
+		headerSize = 5
+	}
+	dst = append(dst, make([]byte, headerSize)...)
+	// Weight of last symbol is implied.
+	dst = append(dst, byte(127+maxSymbol))
+	for s := 0; s < maxSymbol; s += 2 {
+		b := weights[s] << 4
+		if s+1 < maxSymbol {
+			b |= weights[s+1]
+		}
+		dst = append(dst, b)
+	}
+	sizeFormat := uint64(0)
+	if n <= zstdMaxSingleStreamLiterals {
+		dst = e.appendStream(dst, literals)
+	} else {
+		sizeFormat = uint64(headerSize - 2)
+		segment := (n + 3) / 4
+		jump := len(dst)
+		dst = append(dst, 0, 0, 0, 0, 0, 0)
+		for i := 0; i < 4; i++ {
+			streamStart := len(dst)
+			end := (i + 1) * segment
+			if i == 3 {
+				end = n
+			}
+			dst = e.appendStream(dst, literals[i*segment:end])
+			if i < 3 {
+				binary.LittleEndian.PutUint16(dst[jump+2*i:], uint16(len(dst)-streamStart))
+			}
+		}
+	}
+	compressedSize := len(dst) - start - headerSize
+	sizeBits := 4*uint(headerSize) - 2
+	if compressedSize+headerSize >= n+3 || bits.Len(uint(compressedSize)) > int(sizeBits) {
+		return dst[:start], false
+	}
+	header := zstdCompressedLiterals | sizeFormat<<2 | uint64(n)<<4 | uint64(compressedSize)<<(4+sizeBits)
+	for i := 0; i < headerSize; i++ {
+		dst[start+i] = byte(header >> (8 * uint(i)))
+	}
+	return dst, true
+}
+
+type zstdHuffmanEntry struct {
+	symbol uint8
+	nbBits uint8
+}
+
+// zstdHuffmanDecoder decodes Huffman coded literals. Table is kept for
+// treeless literals in later blocks of frame.
+type zstdHuffmanDecoder struct {
+	maxBits uint
+	table   []zstdHuffmanEntry
+}
+
+func (d *zstdHuffmanDecoder) reset() {
+	d.maxBits = 0
+	d.table = d.table[:0]
+}
+
+// readZstdWeights reads FSE compressed Huffman weights.
+func readZstdWeights(weights []uint8, src []byte) (int, error) {
+	table, size, err := readZstdFSETable(src, zstdMaxHuffmanBits+1, 6)
+	if err != nil {
+		return 0, err
+	}
+	var r zstdBackwardReader
+	if err := r.init(src[size:]); err != nil {
+		return 0, err
+	}
+	state1 := r.read(table.log)
+	state2 := r.read(table.log)
+	n := 0
+	for {
+		if n+2 > len(weights) {
+			return 0, errCorruptZstd
+		}
+		e := table.entries[state1]
+		weights[n] = e.symbol
+		n++
+		state1 = uint32(e.baseline) + r.read(uint(e.nbBits))
+		if r.overflowed() {
+			weights[n] = table.entries[state2].symbol
+			return n + 1, nil
+		}
+		e = table.entries[state2]
+		weights[n] = e.symbol
+		n++
+		state2 = uint32(e.baseline) + r.read(uint(e.nbBits))
+		if r.overflowed() {
+			weights[n] = table.entries[state1].symbol
+			return n + 1, nil
+		}
+	}
+}
+
+// readTable reads Huffman tree description from src and returns remaining
+// bytes.
+func (d *zstdHuffmanDecoder) readTable(src []byte) ([]byte, error) {
+	if len(src) == 0 {
+		return nil, errCorruptZstd
+	}
+	header := int(src[0])
+	src = src[1:]
+	var weights [256]uint8
+	var n int
+	if header >= 128 {
+		n = header - 127
+		size := (n + 1) / 2
+		if len(src) < size {
+			return nil, errCorruptZstd
+		}
+		for i := 0; i < n; i++ {
+			b := src[i/2]
+			if i%2 == 0 {
+				weights[i] = b >> 4
+			} else {
+				weights[i] = b & 15
+			}
+		}
+		src = src[size:]
+	} else {
+		if len(src) < header {
+			return nil, errCorruptZstd
+		}
+		var err error
+		if n, err = readZstdWeights(weights[:len(weights)-1], src[:header]); err != nil {
+			return nil, err
+		}
+		src = src[header:]
+	}
+	var rankCount [zstdMaxHuffmanBits + 2]uint32
+	total := uint32(0)
+	for _, w := range weights[:n] {
+		if w > zstdMaxHuffmanBits {
+			return nil, errCorruptZstd
+		}
+		if w != 0 {
+			total += 1 << (w - 1)
+		}
+	}
+	if total == 0 {
+		return nil, errCorruptZstd
+	}
+	maxBits := uint(bits.Len32(total))
+	if maxBits > zstdMaxHuffmanBits {
+		return nil, errCorruptZstd
+	}
+	rest := uint32(1)<<maxBits - total
+	if rest&(rest-1) != 0 {
+		return nil, errCorruptZstd
+	}
+	// Weight of last symbol completes the tree.
+	weights[n] = uint8(bits.Len32(rest))
+	n++
+	for _, w := range weights[:n] {
+		rankCount[w]++
+	}
+	var rankStart [zstdMaxHuffmanBits + 2]uint32
+	for w := 1; w <= int(maxBits); w++ {
+		rankStart[w+1] = rankStart[w] + rankCount[w]<<uint(w-1)
+	}
+	size := 1 << maxBits
+	if cap(d.table) < size {
+		d.table = make([]zstdHuffmanEntry, size)
+	}
+	d.table = d.table[:size]
+	d.maxBits = maxBits
+	for s, w := range weights[:n] {
+		if w == 0 {
+			continue
+		}
+		entry := zstdHuffmanEntry{symbol: uint8(s), nbBits: uint8(maxBits + 1 - uint(w))}
+		start, end := rankStart[w], rankStart[w]+1<<(w-1)
+		for i := start; i < end; i++ {
+			d.table[i] = entry
+		}
+		rankStart[w] = end
+	}
+	return src, nil
+}
+
+func (d *zstdHuffmanDecoder) decodeStream(dst []byte, src []byte, n int) ([]byte, error) {
+	var r zstdBackwardReader
+	if err := r.init(src); err != nil {
+		return nil, err
+	}
+	for i := 0; i < n; i++ {
+		e := d.table[r.peek(d.maxBits)]
+		dst = append(dst, e.symbol)
+		r.skip(uint(e.nbBits))
+	}
+	if !r.finished() {
+		return nil, errCorruptZstd
+	}
+	return dst, nil
+}
+
+func (d *zstdHuffmanDecoder) decode(dst []byte, src []byte, n int, streams int) ([]byte, error) {
+	if len(d.table) == 0 {
+		return nil, errCorruptZstd
+	}
+	if streams == 1 {
+		return d.decodeStream(dst, src, n)
+	}
+	if len(src) < 6 {
+		return nil, errCorruptZstd
+	}
+	var sizes [4]int
+	total := 6
+	for i := 0; i < 3; i++ {
+		sizes[i] = int(binary.LittleEndian.Uint16(src[2*i:]))
+		total += sizes[i]
+	}
+	if total > len(src) {
+		return nil, errCorruptZstd
+	}
+	sizes[3] = len(src) - total
+	src = src[6:]
+	segment := (n + 3) / 4
+	if 3*segment > n {
+		return nil, errCorruptZstd
+	}
+	for i := 0; i < 4; i++ {
+		count := segment
+		if i == 3 {
+			count = n - 3*segment
+		}
+		var err error
+		if dst, err = d.decodeStream(dst, src[:sizes[i]], count); err != nil {
+			return nil, err
+		}
+		src = src[sizes[i]:]
+	}
+	return dst, nil
+}
+
+// decodeLiterals decodes literals section in front of block, and returns
+// literals and remaining bytes. Raw literals alias block.
+func (d *zstdDecoder) decodeLiterals(block []byte) (literals, rest []byte, err error) {
+	if len(block) == 0 {
+		return nil, nil, errCorruptZstd
+	}
+	typ, sizeFormat := block[0]&3, block[0]>>2&3
+	switch typ {
+	case zstdRawLiterals, zstdRLELiterals:
+		var n, headerSize int
+		switch sizeFormat {
+		case 0, 2:
+			n, headerSize = int(block[0]>>3), 1
+		case 1:
+			if len(block) < 2 {
+				return nil, nil, errCorruptZstd
+			}
+			n, headerSize = int(block[0]>>4)|int(block[1])<<4, 2
+		case 3:
+			if len(block) < 3 {
+				return nil, nil, errCorruptZstd
+			}
+			n, headerSize = int(block[0]>>4)|int(block[1])<<4|int(block[2])<<12, 3
+		}
+		if n > zstdMaxBlockSize {
+			return nil, nil, errCorruptZstd
+		}
+		block = block[headerSize:]
+		if typ == zstdRawLiterals {
+			if len(block) < n {
+				return nil, nil, errCorruptZstd
+			}
+			return block[:n], block[n:], nil
+		}
+		if len(block) == 0 {
+			return nil, nil, errCorruptZstd
+		}
+		d.literals = d.literals[:0]
+		for i := 0; i < n; i++ {
+			d.literals = append(d.literals, block[0])
+		}
+		return d.literals, block[1:], nil
+	default:
+		streams, headerSize := 4, int(sizeFormat)+2
+		if sizeFormat == 0 {
+			streams, headerSize = 1, 3
+		}
+		if len(block) < headerSize {
+			return nil, nil, errCorruptZstd
+		}
+		var header uint64
+		for i := 0; i < headerSize; i++ {
+			header |= uint64(block[i]) << (8 * uint(i))
+		}
+		sizeBits := 4*uint(headerSize) - 2
+		n := int(header >> 4 & zstdBitMask(sizeBits))
+		size := int(header >> (4 + sizeBits) & zstdBitMask(sizeBits))
+		block = block[headerSize:]
+		if n > zstdMaxBlockSize || len(block) < size {
+			return nil, nil, errCorruptZstd
+		}
+		src, rest := block[:size], block[size:]
+		if typ == zstdCompressedLiterals {
+			if src, err = d.huffman.readTable(src); err != nil {
+				return nil, nil, err
+			}
+		}
+		if d.literals, err = d.huffman.decode(d.literals[:0], src, n, streams); err != nil {
+			return nil, nil, err
+		}
+		return d.literals, rest, nil
+	}
+}
@@ -0,0 +1,349 @@
+package compress
+
+import "math/bits"
+
+const (
+	zstdMaxLiteralLengthCode = 35
+	zstdMaxMatchLengthCode   = 52
+	zstdMaxOffsetCode        = 31
+	zstdMaxLiteralLengthLog  = 9
+	zstdMaxMatchLengthLog    = 9
+	zstdMaxOffsetLog         = 8
+)
+
+// Sequence compression modes.
+const (
+	zstdPredefinedMode = 0
+	zstdRLEMode        = 1
+	zstdFSEMode        = 2
+	zstdRepeatMode     = 3
+)
+
+var zstdLiteralLengthBase = [zstdMaxLiteralLengthCode + 1]uint32{
+	0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+	16, 18, 20, 22, 24, 28, 32, 40, 48, 64, 128, 256, 512, 1024, 2048, 4096,
+	8192, 16384, 32768, 65536,
+}
+
+var zstdLiteralLengthBits = [zstdMaxLiteralLengthCode + 1]uint8{
+	0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	1, 1, 1, 1, 2, 2, 3, 3, 4, 6, 7, 8, 9, 10, 11, 12,
+	13, 14, 15, 16,
+}
+
+var zstdMatchLengthBase = [zstdMaxMatchLengthCode + 1]uint32{
+	3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18,
+	19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 34,
+	35, 37, 39, 41, 43, 47, 51, 59, 67, 83, 99, 131, 259, 515, 1027, 2051,
+	4099, 8195, 16387, 32771, 65539,
+}
+
+var zstdMatchLengthBits = [zstdMaxMatchLengthCode + 1]uint8{
+	0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	1, 1, 1, 1, 2, 2, 3, 3, 4, 4, 5, 7, 8, 9, 10, 11,
+	12, 13, 14, 15, 16,
+}
+
+// Predefined distributions for sequences, which need no table description
+// in blocks.
+var (
+	zstdLiteralLengthNorm = []int16{
+		4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1,
+		2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1,
+		-1, -1, -1, -1,
+	}
+	zstdMatchLengthNorm = []int16{
+		1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1,
+		-1, -1, -1, -1, -1,
+	}
+	zstdOffsetNorm = []int16{
+		1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1,
+	}
+)
+
+const (
+	zstdLiteralLengthNormLog = 6
+	zstdMatchLengthNormLog   = 6
+	zstdOffsetNormLog        = 5
+	// zstdMaxPredefinedOffsetCode is the max offset code covered by
+	// predefined offset distribution.
+	zstdMaxPredefinedOffsetCode = 28
+)
+
+func mustZstdFSETable(norm []int16, log uint) *zstdFSETable {
+	table, err := newZstdFSETable(norm, log)
+	if err != nil {
+		panic(err)
+	}
+	return table
+}
+
+var (
+	zstdLiteralLengthTable = mustZstdFSETable(zstdLiteralLengthNorm, zstdLiteralLengthNormLog)
+	zstdMatchLengthTable   = mustZstdFSETable(zstdMatchLengthNorm, zstdMatchLengthNormLog)
+	zstdOffsetTable        = mustZstdFSETable(zstdOffsetNorm, zstdOffsetNormLog)
+
+	zstdLiteralLengthEncoder = newZstdFSEEncoder(zstdLiteralLengthNorm, zstdLiteralLengthNormLog)
+	zstdMatchLengthEncoder   = newZstdFSEEncoder(zstdMatchLengthNorm, zstdMatchLengthNormLog)
+	zstdOffsetEncoder        = newZstdFSEEncoder(zstdOffsetNorm, zstdOffsetNormLog)
+)
+
+type zstdSequence struct {
+	literalLen uint32
+	matchLen   uint32
+	// offset is the real match offset, not repeat offset code.
+	offset uint32
+}
+
+func zstdLiteralLengthCode(n uint32) uint8 {
+	if n < 16 {
+		return uint8(n)
+	}
+	if n >= 64 {
+		return uint8(bits.Len32(n)) + 18
+	}
+	code := uint8(16)
+	for zstdLiteralLengthBase[code+1] <= n {
+		code++
+	}
+	return code
+}
+
+func zstdMatchLengthCode(n uint32) uint8 {
+	n -= 3
+	if n < 32 {
+		return uint8(n)
+	}
+	if n >= 128 {
+		return uint8(bits.Len32(n)) + 35
+	}
+	code := uint8(32)
+	for zstdMatchLengthBase[code+1]-3 <= n {
+		code++
+	}
+	return code
+}
+
+func zstdAppendSequencesCount(dst []byte, n int) []byte {
+	switch {
+	case n < 128:
+		return append(dst, byte(n))
+	case n < 0x7F00:
+		return append(dst, byte(n>>8)+128, byte(n))
+	default:
+		n -= 0x7F00
+		return append(dst, 255, byte(n), byte(n>>8))
+	}
+}
+
+// zstdAppendSequences appends sequences section coded with predefined
+// distributions. Offsets are not coded as repeat offsets, so their codes
+// must not exceed zstdMaxPredefinedOffsetCode.
+func zstdAppendSequences(dst []byte, sequences []zstdSequence) []byte {
+	n := len(sequences)
+	dst = zstdAppendSequencesCount(dst, n)
+	if n == 0 {
+		return dst
+	}
+	dst = append(dst, zstdPredefinedMode<<6|zstdPredefinedMode<<4|zstdPredefinedMode<<2)
+	w := zstdBitWriter{b: dst}
+	var llState, mlState, ofState zstdFSEState
+	for i := n - 1; i >= 0; i-- {
+		seq := sequences[i]
+		llCode := zstdLiteralLengthCode(seq.literalLen)
+		mlCode := zstdMatchLengthCode(seq.matchLen)
+		// Offset values 1 to 3 are repeat offsets.
+		offsetValue := seq.offset + 3
+		ofCode := uint8(bits.Len32(offsetValue) - 1)
+		if i == n-1 {
+			llState.init(zstdLiteralLengthEncoder, llCode)
+			mlState.init(zstdMatchLengthEncoder, mlCode)
+			ofState.init(zstdOffsetEncoder, ofCode)
+		} else {
+			ofState.encode(&w, ofCode)
+			mlState.encode(&w, mlCode)
+			llState.encode(&w, llCode)
+		}
+		w.add(uint64(seq.literalLen-zstdLiteralLengthBase[llCode]), uint(zstdLiteralLengthBits[llCode]))
+		w.add(uint64(seq.matchLen-zstdMatchLengthBase[mlCode]), uint(zstdMatchLengthBits[mlCode]))
+		w.add(uint64(offsetValue), uint(ofCode))
+	}
+	mlState.flush(&w)
+	ofState.flush(&w)
+	llState.flush(&w)
+	return w.close()
+}
+
+// zstdSequenceDecoder decodes sequences sections of blocks in a frame.
+// Tables and repeat offsets are kept across blocks.
+type zstdSequenceDecoder struct {
+	literalLengthTable *zstdFSETable
+	matchLengthTable   *zstdFSETable
+	offsetTable        *zstdFSETable
+	repeatOffsets      [3]uint32
+}
+
+func (d *zstdSequenceDecoder) reset() {
+	*d = zstdSequenceDecoder{repeatOffsets: [3]uint32{1, 4, 8}}
+}
+
+func zstdReadSequenceTable(table **zstdFSETable, mode byte, src []byte, predefined *zstdFSETable, maxSymbol int, maxLog uint) ([]byte, error) {
+	switch mode {
+	case zstdPredefinedMode:
+		*table = predefined
+	case zstdRLEMode:
+		if len(src) == 0 || int(src[0]) > maxSymbol {
+			return nil, errCorruptZstd
+		}
+		*table = newZstdRLETable(src[0])
+		src = src[1:]
+	case zstdFSEMode:
+		t, n, err := readZstdFSETable(src, maxSymbol, maxLog)
+		if err != nil {
+			return nil, err
+		}
+		*table = t
+		src = src[n:]
+	case zstdRepeatMode:
+		if *table == nil {
+			return nil, errCorruptZstd
+		}
+	}
+	return src, nil
+}
+
+// offset converts offset value to match offset and updates repeat offsets.
+func (d *zstdSequenceDecoder) offset(offsetValue, literalLen uint32) uint32 {
+	reps := &d.repeatOffsets
+	if offsetValue > 3 {
+		offset := offsetValue - 3
+		reps[0], reps[1], reps[2] = offset, reps[0], reps[1]
+		return offset
+	}
+	index := offsetValue - 1
+	if literalLen == 0 {
+		index++
+	}
+	var offset uint32
+	switch index {
+	case 0:
+		return reps[0]
+	case 1:
+		offset = reps[1]
+		reps[1] = reps[0]
+	case 2:
+		offset = reps[2]
+		reps[2], reps[1] = reps[1], reps[0]
+	default:
+		// Zero offset is invalid, caller must check it.
+		offset = reps[0] - 1
+		reps[2], reps[1] = reps[1], reps[0]
+	}
+	reps[0] = offset
+	return offset
+}
+
+// decode decodes sequences section src and executes sequences with
+// literals. Matches may refer to bytes in dst after frameStart. Bytes
+// decoded must not exceed zstdMaxBlockSize.
+func (d *zstdSequenceDecoder) decode(dst, src, literals []byte, frameStart int) ([]byte, error) {
+	if len(src) == 0 {
+		return nil, errCorruptZstd
+	}
+	n := int(src[0])
+	switch {
+	case n == 255:
+		if len(src) < 3 {
+			return nil, errCorruptZstd
+		}
+		n = int(src[1]) | int(src[2])<<8 + 0x7F00
+		src = src[3:]
+	case n >= 128:
+		if len(src) < 2 {
+			return nil, errCorruptZstd
+		}
+		n = (n-128)<<8 | int(src[1])
+		src = src[2:]
+	default:
+		src = src[1:]
+	}
+	if len(literals) > zstdMaxBlockSize {
+		return nil, errCorruptZstd
+	}
+	if n == 0 {
+		if len(src) != 0 {
+			return nil, errCorruptZstd
+		}
+		return append(dst, literals...), nil
+	}
+	if len(src) == 0 {
+		return nil, errCorruptZstd
+	}
+	modes := src[0]
+	if modes&3 != 0 {
+		return nil, errCorruptZstd
+	}
+	src = src[1:]
+	var err error
+	if src, err = zstdReadSequenceTable(&d.literalLengthTable, modes>>6, src, zstdLiteralLengthTable, zstdMaxLiteralLengthCode, zstdMaxLiteralLengthLog); err != nil {
+		return nil, err
+	}
+	if src, err = zstdReadSequenceTable(&d.offsetTable, modes>>4&3, src, zstdOffsetTable, zstdMaxOffsetCode, zstdMaxOffsetLog); err != nil {
+		return nil, err
+	}
+	if src, err = zstdReadSequenceTable(&d.matchLengthTable, modes>>2&3, src, zstdMatchLengthTable, zstdMaxMatchLengthCode, zstdMaxMatchLengthLog); err != nil {
+		return nil, err
+	}
+	var r zstdBackwardReader
+	if err := r.init(src); err != nil {
+		return nil, err
+	}
+	llTable, ofTable, mlTable := d.literalLengthTable, d.offsetTable, d.matchLengthTable
+	llState := r.read(llTable.log)
+	ofState := r.read(ofTable.log)
+	mlState := r.read(mlTable.log)
+	limit := len(dst) + zstdMaxBlockSize
+	for i := 0; i < n; i++ {
+		if r.overflowed() {
+			return nil, errCorruptZstd
+		}
+		ll, of, ml := llTable.entries[llState], ofTable.entries[ofState], mlTable.entries[mlState]
+		if ll.symbol > zstdMaxLiteralLengthCode || ml.symbol > zstdMaxMatchLengthCode {
+			return nil, errCorruptZstd
+		}
+		offsetValue := uint32(1)<<of.symbol + r.read(uint(of.symbol))
+		matchLen := zstdMatchLengthBase[ml.symbol] + r.read(uint(zstdMatchLengthBits[ml.symbol]))
+		literalLen := zstdLiteralLengthBase[ll.symbol] + r.read(uint(zstdLiteralLengthBits[ll.symbol]))
+		if i != n-1 {
+			llState = uint32(ll.baseline) + r.read(uint(ll.nbBits))
+			mlState = uint32(ml.baseline) + r.read(uint(ml.nbBits))
+			ofState = uint32(of.baseline) + r.read(uint(of.nbBits))
+		}
+		offset := d.offset(offsetValue, literalLen)
+		if uint64(literalLen) > uint64(len(literals)) || len(dst)+int(literalLen)+int(matchLen) > limit {
+			return nil, errCorruptZstd
+		}
+		dst = append(dst, literals[:literalLen]...)
+		literals = literals[literalLen:]
+		if offset == 0 || uint64(offset) > uint64(len(dst)-frameStart) {
+			return nil, errCorruptZstd
+		}
+		// Match may overlap with bytes it produces, copy byte by byte.
+		pos := len(dst) - int(offset)
+		if int(offset) >= int(matchLen) {
+			dst = append(dst, dst[pos:pos+int(matchLen)]...)
+		} else {
+			for j := 0; j < int(matchLen); j++ {
+				dst = append(dst, dst[pos+j])
+			}
+		}
+	}
+	if !r.finished() || len(dst)+len(literals) > limit {
+		return nil, errCorruptZstd
+	}
+	return append(dst, literals...), nil
+}
@@ -6,8 +6,5 @@ const (
 
 const MaxMemTableCompactLevel = 2
 
-const (
-	TargetFileSize                 = 2 * 1024 * 1024
-	ExpandedCompactionLimitBytes   = 25 * TargetFileSize
-	MaxGrandparentOverlappingBytes = 10 * TargetFileSize
-)
+// MaxManifestFileSize is size of manifest file to switch to new one.
+const MaxManifestFileSize = 2 * 1024 * 1024
@@ -7,17 +7,33 @@ import (
 )
 
 var (
-	ErrNotFound           = errors.New("leveldb: key not found")
-	ErrDBExists           = errors.New("leveldb: db exists")
-	ErrDBMissing          = errors.New("leveldb: missing db")
-	ErrDBClosed           = errors.New("leveldb: db closed")
-	ErrCorruptWriteBatch  = errors.New("leveldb: corrupt write batch")
-	ErrCorruptInternalKey = errors.New("leveldb: corrupt internal key")
-	ErrComparatorMismatch = errors.New("leveldb: comparator mismatch")
-	ErrOverlappedTables   = errors.New("leveldb: overlapped tables in level 1+")
-	ErrBatchTooManyWrites = errors.New("leveldb: too many writes in one batch")
-	ErrSnapshotClosed     = errors.New("leveldb: snapshot closed")
-	ErrEmptyMemTable      = errors.New("leveldb: empty memtable")
+	ErrNotFound            = errors.New("leveldb: key not found")
+	ErrDBExists            = errors.New("leveldb: db exists")
+	ErrDBMissing           = errors.New("leveldb: missing db")
+	ErrDBClosed            = errors.New("leveldb: db closed")
+	ErrCorruptWriteBatch   = errors.New("leveldb: corrupt write batch")
+	ErrCorruptInternalKey  = errors.New("leveldb: corrupt internal key")
+	ErrComparatorMismatch  = errors.New("leveldb: comparator mismatch")
+	ErrOverlappedTables    = errors.New("leveldb: overlapped tables in level 1+")
+	ErrBatchTooManyWrites  = errors.New("leveldb: too many writes in one batch")
+	ErrSnapshotClosed      = errors.New("leveldb: snapshot closed")
+	ErrEmptyMemTable       = errors.New("leveldb: empty memtable")
+	ErrNoMergeOperator     = errors.New("leveldb: no merge operator")
+	ErrConflict            = errors.New("leveldb: transaction conflict")
+	ErrTryAgain            = errors.New("leveldb: transaction conflict unknown, try again")
+	ErrTxnDone             = errors.New("leveldb: transaction has been committed or rolled back")
+	ErrLockTimeout         = errors.New("leveldb: lock timeout")
+	ErrDeadlock            = errors.New("leveldb: deadlock")
+	ErrBatchNotIndexed     = errors.New("leveldb: batch not indexed")
+	ErrUnhandledUpdate     = errors.New("leveldb: batch update not supported by handler")
+	ErrNoSavePoint         = errors.New("leveldb: no save point")
+	ErrUpdatesUnavailable  = errors.New("leveldb: updates unavailable in log files")
+	ErrCorruptExpiration   = errors.New("leveldb: corrupt expiration")
+	ErrCorruptBlobPointer  = errors.New("leveldb: corrupt blob pointer")
+	ErrColumnFamilyExists  = errors.New("leveldb: column family exists")
+	ErrColumnFamilyDropped = errors.New("leveldb: column family dropped")
+	ErrDropDefaultFamily   = errors.New("leveldb: drop default column family")
+	ErrReadOnly            = errors.New("leveldb: db opened read only")
 )
 
 // KeyRangeError represents invalid key range, eg. invalid [start, limit) interval.
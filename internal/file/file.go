@@ -64,6 +64,13 @@ type FileSystem interface {
 	Rename(oldpath, newpath string) error
 }
 
+// Linker is an optional interface implemented by FileSystem which supports
+// hard links.
+type Linker interface {
+	// Link creates newname as a hard link to oldname.
+	Link(oldname, newname string) error
+}
+
 type osFileSystem struct{}
 
 func (osFileSystem) Open(name string, flag int) (File, error) {
@@ -95,4 +102,8 @@ func (osFileSystem) Rename(oldpath, newpath string) error {
 	return os.Rename(oldpath, newpath)
 }
 
+func (osFileSystem) Link(oldname, newname string) error {
+	return os.Link(oldname, newname)
+}
+
 var DefaultFileSystem FileSystem = osFileSystem{}
@@ -24,6 +24,7 @@ const (
 	SSTTable
 	Log
 	InfoLog
+	Blob
 )
 
 var pathSeparator = string(os.PathSeparator)
@@ -44,6 +45,8 @@ func (k Kind) String() string {
 		return "LOG"
 	case InfoLog:
 		return "InfoLog"
+	case Blob:
+		return "BLOB"
 	}
 	return "unknown"
 }
@@ -68,6 +71,10 @@ func SSTTableFileName(dbname string, number uint64) string {
 	return MakeFileName(dbname, number, "sst")
 }
 
+func BlobFileName(dbname string, number uint64) string {
+	return MakeFileName(dbname, number, "blob")
+}
+
 func MakeFileName(dbname string, number uint64, ext string) string {
 	return fmt.Sprintf("%s%c%06d.%s", dbname, os.PathSeparator, number, ext)
 }
@@ -112,6 +119,8 @@ func Parse(name string) (kind Kind, number uint64) {
 			kind = Log
 		case "dbtmp":
 			kind = Temp
+		case "blob":
+			kind = Blob
 		default:
 			return Invalid, 0
 		}
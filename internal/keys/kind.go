@@ -12,11 +12,22 @@ const (
 	// Delete represents deletion of this key.
 	Delete Kind = 0
 	// Value represents value setting of this key.
-	Value   Kind = 1
-	maxKind      = Value
+	Value Kind = 1
+	// RangeDelete represents deletion of keys in range [key, value).
+	RangeDelete Kind = 2
+	// Merge represents merge operand of this key.
+	Merge Kind = 3
+	// ExpiringValue represents value setting of this key, which expires at
+	// time encoded in front of value.
+	ExpiringValue Kind = 4
+	// BlobIndex represents value setting of this key, which is stored in a
+	// blob file located by pointer in value.
+	BlobIndex Kind = 5
+	maxKind        = BlobIndex
 
-	// Seek is maximum(Value, Delete), which is a valid Kind and
-	// serves as start point for keys with same sequence.
+	// Seek is maximum(Value, Delete, RangeDelete, Merge, ExpiringValue,
+	// BlobIndex), which is a valid Kind and serves as start point for keys
+	// with same sequence.
 	//
 	// See InternalComparator.Compare for ordering among internal keys.
 	Seek = maxKind
@@ -28,6 +39,14 @@ func (k Kind) String() string {
 		return "value deletion"
 	case Value:
 		return "value setting"
+	case RangeDelete:
+		return "range deletion"
+	case Merge:
+		return "value merging"
+	case ExpiringValue:
+		return "expiring value setting"
+	case BlobIndex:
+		return "blob value setting"
 	}
 	return fmt.Sprintf("unknown kind: %d", k)
 }
@@ -10,15 +10,24 @@ const (
 	persistedDelete = 0
 	persistedValue  = 1
 
-	invalidKindValueA keys.Kind = 5
-	invalidKindValueB keys.Kind = 6
+	persistedRangeDelete = 2
+	persistedMerge       = 3
+
+	persistedExpiringValue = 4
+	persistedBlobIndex     = 5
+
+	invalidKindValueA keys.Kind = 6
+	invalidKindValueB keys.Kind = 7
 )
 
-func maxKind(a, b keys.Kind) keys.Kind {
-	if a < b {
-		return b
+func maxKind(kinds ...keys.Kind) keys.Kind {
+	max := kinds[0]
+	for _, k := range kinds[1:] {
+		if k > max {
+			max = k
+		}
 	}
-	return a
+	return max
 }
 
 func TestKindValues(t *testing.T) {
@@ -28,7 +37,19 @@ func TestKindValues(t *testing.T) {
 	if persistedValue != keys.Value {
 		t.Errorf("test=persisted-kind-value got=%d want=%d", keys.Value, persistedValue)
 	}
-	if maxKindValue := maxKind(keys.Delete, keys.Value); keys.Seek != maxKindValue {
+	if persistedRangeDelete != keys.RangeDelete {
+		t.Errorf("test=persisted-kind-range-delete got=%d want=%d", keys.RangeDelete, persistedRangeDelete)
+	}
+	if persistedMerge != keys.Merge {
+		t.Errorf("test=persisted-kind-merge got=%d want=%d", keys.Merge, persistedMerge)
+	}
+	if persistedExpiringValue != keys.ExpiringValue {
+		t.Errorf("test=persisted-kind-expiring-value got=%d want=%d", keys.ExpiringValue, persistedExpiringValue)
+	}
+	if persistedBlobIndex != keys.BlobIndex {
+		t.Errorf("test=persisted-kind-blob-index got=%d want=%d", keys.BlobIndex, persistedBlobIndex)
+	}
+	if maxKindValue := maxKind(keys.Delete, keys.Value, keys.RangeDelete, keys.Merge, keys.ExpiringValue, keys.BlobIndex); keys.Seek != maxKindValue {
 		t.Errorf("test=seek got=%d want=%d", keys.Seek, maxKindValue)
 	}
 }
@@ -46,4 +67,16 @@ func TestKindString(t *testing.T) {
 	if v, b := keys.Value.String(), invalidKindValueB.String(); v == b {
 		t.Errorf("test=kind-value-invalid-string keys.Value=%q a=%q", v, b)
 	}
+	if r, d := keys.RangeDelete.String(), keys.Delete.String(); r == d {
+		t.Errorf("test=kind-range-delete-string keys.RangeDelete=%q keys.Delete=%q", r, d)
+	}
+	if m, v := keys.Merge.String(), keys.Value.String(); m == v {
+		t.Errorf("test=kind-merge-string keys.Merge=%q keys.Value=%q", m, v)
+	}
+	if e, v := keys.ExpiringValue.String(), keys.Value.String(); e == v {
+		t.Errorf("test=kind-expiring-value-string keys.ExpiringValue=%q keys.Value=%q", e, v)
+	}
+	if b, v := keys.BlobIndex.String(), keys.Value.String(); b == v {
+		t.Errorf("test=kind-blob-index-string keys.BlobIndex=%q keys.Value=%q", b, v)
+	}
 }
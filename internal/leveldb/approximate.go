@@ -0,0 +1,34 @@
+package leveldb
+
+import "github.com/kezhuw/leveldb/internal/keys"
+
+// ApproximateSize returns approximate file system space used by keys in range
+// [start, limit). Zero length start acts as infinite small, zero length limit
+// acts as infinite large.
+func (db *DB) ApproximateSize(start, limit []byte) uint64 {
+	return db.defaultFamily.ApproximateSize(start, limit)
+}
+
+// ApproximateSize returns approximate file system space used by keys in range
+// [start, limit) of this column family.
+func (cf *ColumnFamily) ApproximateSize(start, limit []byte) uint64 {
+	bundle := cf.loadBundle()
+	if bundle == nil {
+		return 0
+	}
+	version := bundle.version
+	var startOffset, limitOffset uint64
+	if len(start) != 0 {
+		startOffset = version.ApproximateOffsetOf(keys.NewInternalKey(start, keys.MaxSequence, keys.Seek))
+	}
+	switch len(limit) {
+	case 0:
+		limitOffset = version.TotalFileSize()
+	default:
+		limitOffset = version.ApproximateOffsetOf(keys.NewInternalKey(limit, keys.MaxSequence, keys.Seek))
+	}
+	if limitOffset <= startOffset {
+		return 0
+	}
+	return limitOffset - startOffset
+}
@@ -0,0 +1,107 @@
+package leveldb
+
+import (
+	"time"
+
+	"github.com/kezhuw/leveldb/internal/batch"
+	"github.com/kezhuw/leveldb/internal/iterator"
+	"github.com/kezhuw/leveldb/internal/keys"
+	"github.com/kezhuw/leveldb/internal/memtable"
+	"github.com/kezhuw/leveldb/internal/merge"
+	"github.com/kezhuw/leveldb/internal/options"
+)
+
+// BatchIndex indexes updates of a batch in sorted order, so they could be
+// read before the batch is written to db. Updates are sequenced by their
+// positions in batch, starting from one.
+type BatchIndex struct {
+	db  *DB
+	mem *memtable.MemTable
+}
+
+func (db *DB) NewBatchIndex() *BatchIndex {
+	return &BatchIndex{db: db, mem: memtable.New(db.options.Comparator)}
+}
+
+// Add indexes update at position seq of batch.
+func (x *BatchIndex) Add(seq keys.Sequence, kind keys.Kind, key, value []byte) {
+	x.mem.Add(seq, kind, key, value)
+}
+
+// Reset drops all indexed updates.
+func (x *BatchIndex) Reset() {
+	x.mem = memtable.New(x.db.options.Comparator)
+}
+
+type batchIndexer struct {
+	index *BatchIndex
+	n     keys.Sequence
+}
+
+func (x *batchIndexer) Add(seq keys.Sequence, kind keys.Kind, key, value []byte) {
+	x.n++
+	x.index.Add(x.n, kind, key, value)
+}
+
+// AddColumnFamily skips updates to non default column families, which are
+// not indexed, but they still take positions in batch.
+func (x *batchIndexer) AddColumnFamily(family uint32, seq keys.Sequence, kind keys.Kind, key, value []byte) {
+	x.n++
+}
+
+// Expiration returns expiration time of values written now with time to
+// live d.
+func (x *BatchIndex) Expiration(d time.Duration) int64 {
+	return x.db.Expiration(d)
+}
+
+// Rebuild drops indexed updates and indexes all updates in b.
+func (x *BatchIndex) Rebuild(b *batch.Batch) error {
+	x.Reset()
+	if b.Empty() {
+		return nil
+	}
+	return b.Iterate(&batchIndexer{index: x})
+}
+
+// Get gets value of key from indexed updates over latest data of db.
+func (x *BatchIndex) Get(db *DB, key []byte, opts *options.ReadOptions) ([]byte, error) {
+	return x.get(db, key, db.manifest.LoadLastSequence(), opts)
+}
+
+// get gets value of key from indexed updates over data of db visible to seq.
+func (x *BatchIndex) get(db *DB, key []byte, seq keys.Sequence, opts *options.ReadOptions) ([]byte, error) {
+	var lookup merge.Lookup
+	lookup.Reset(db.options.MergeOperator, db.manifest.Blobs(), key, db.options.Now())
+	if x.mem.Get(keys.NewInternalKey(key, keys.MaxSequence, keys.Seek), &lookup) {
+		return lookup.Result()
+	}
+	return db.defaultFamily.lookup(key, seq, &lookup, opts)
+}
+
+// NewIterator creates an iterator merging indexed updates over base, which
+// iterates user keys. Closing returned iterator closes base.
+func (x *BatchIndex) NewIterator(base iterator.Iterator) iterator.Iterator {
+	mergeIt := iterator.NewMergeIterator(x.db.options.Comparator, x.mem.NewIterator(), &baseIterator{Iterator: base})
+	return newDBIterator(x.db.defaultFamily, nil, x.mem.RangeTombstones(), keys.MaxSequence, mergeIt)
+}
+
+// baseIterator presents entries of an iterator over user keys as values
+// with zero sequence, so they are older than all indexed updates.
+type baseIterator struct {
+	iterator.Iterator
+	buf []byte
+}
+
+func (it *baseIterator) Seek(ikey []byte) bool {
+	return it.Iterator.Seek(keys.InternalKey(ikey).UserKey())
+}
+
+func (it *baseIterator) Key() []byte {
+	ukey := it.Iterator.Key()
+	n := len(ukey) + keys.TagBytes
+	if cap(it.buf) < n {
+		it.buf = make([]byte, n)
+	}
+	return keys.MakeInternalKey(it.buf[:n], ukey, 0, keys.Value)
+}
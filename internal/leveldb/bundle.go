@@ -14,40 +14,40 @@ type bundle struct {
 	version *manifest.Version
 }
 
-func (db *DB) loadBundle() *bundle {
-	return (*bundle)(atomic.LoadPointer((*unsafe.Pointer)(unsafe.Pointer(&db.bundle))))
+func (cf *ColumnFamily) loadBundle() *bundle {
+	return (*bundle)(atomic.LoadPointer((*unsafe.Pointer)(unsafe.Pointer(&cf.bundle))))
 }
 
-func (db *DB) swapBundle(old, new *bundle) bool {
-	return atomic.CompareAndSwapPointer((*unsafe.Pointer)(unsafe.Pointer(&db.bundle)), unsafe.Pointer(old), unsafe.Pointer(new))
+func (cf *ColumnFamily) swapBundle(old, new *bundle) bool {
+	return atomic.CompareAndSwapPointer((*unsafe.Pointer)(unsafe.Pointer(&cf.bundle)), unsafe.Pointer(old), unsafe.Pointer(new))
 }
 
-func (db *DB) storeBundle(new *bundle) {
-	atomic.StorePointer((*unsafe.Pointer)(unsafe.Pointer(&db.bundle)), unsafe.Pointer(new))
+func (cf *ColumnFamily) storeBundle(new *bundle) {
+	atomic.StorePointer((*unsafe.Pointer)(unsafe.Pointer(&cf.bundle)), unsafe.Pointer(new))
 }
 
-func (db *DB) switchMemTable(mem *memtable.MemTable) *memtable.MemTable {
-	imm := mem
-	mem = memtable.New(db.options.Comparator)
-	old := db.loadBundle()
+func (cf *ColumnFamily) switchMemTable() {
+	imm := cf.mem
+	cf.mem = memtable.New(cf.options.Comparator)
+	cf.immSequence, cf.memSequence = cf.memSequence, cf.db.manifest.LastSequence()
+	old := cf.loadBundle()
 	new := &bundle{
-		mem:     mem,
+		mem:     cf.mem,
 		imm:     imm,
 		version: old.version,
 	}
-	for !db.swapBundle(old, new) {
-		old = db.loadBundle()
+	for !cf.swapBundle(old, new) {
+		old = cf.loadBundle()
 		// Use version from compaction goroutine.
 		new.version = old.version
 	}
-	db.compactionMemtable <- imm
-	return mem
+	cf.db.compactionMemtable <- memtableCompaction{family: cf, mem: imm, logNumber: cf.db.logNumber}
 }
 
-func (db *DB) switchVersion(level int, version *manifest.Version) {
-	defer db.wakeupWrite(level)
-	db.manifest.Append(version)
-	old := db.loadBundle()
+func (cf *ColumnFamily) switchVersion(level int, version *manifest.Version) {
+	defer cf.db.wakeupWrite(level)
+	cf.db.manifest.Append(version)
+	old := cf.loadBundle()
 	new := &bundle{
 		mem:     old.mem,
 		imm:     old.imm,
@@ -56,8 +56,8 @@ func (db *DB) switchVersion(level int, version *manifest.Version) {
 	if level == -1 {
 		new.imm = nil
 	}
-	for !db.swapBundle(old, new) {
-		old = db.loadBundle()
+	for !cf.swapBundle(old, new) {
+		old = cf.loadBundle()
 		// Use memtables from write goroutine.
 		new.mem = old.mem
 		new.imm = old.imm
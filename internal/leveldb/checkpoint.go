@@ -0,0 +1,218 @@
+package leveldb
+
+import (
+	"io"
+	"os"
+	"runtime"
+
+	"github.com/kezhuw/leveldb/internal/errors"
+	"github.com/kezhuw/leveldb/internal/file"
+	"github.com/kezhuw/leveldb/internal/files"
+	"github.com/kezhuw/leveldb/internal/keys"
+	"github.com/kezhuw/leveldb/internal/manifest"
+)
+
+// checkpointRequest requests write goroutine to capture state of db for
+// checkpoint.
+type checkpointRequest struct {
+	state chan checkpointState
+}
+
+// checkpointState is a point-in-time state of db. Entries in memtables of
+// bundles are all written to log logNumber before logOffset, which is pinned
+// until released.
+type checkpointState struct {
+	families     []*ColumnFamily
+	bundles      []*bundle
+	lastSequence keys.Sequence
+	logNumber    uint64
+	logOffset    int64
+	err          error
+}
+
+// captureCheckpoint captures state of db for checkpoint. It is called by
+// write goroutine when there are no immutable memtables and no writes not
+// logged in current memtables.
+func (db *DB) captureCheckpoint() checkpointState {
+	if db.log == nil {
+		return checkpointState{err: db.logErr}
+	}
+	families := db.columnFamilies()
+	bundles := make([]*bundle, len(families))
+	for i, cf := range families {
+		bundles[i] = cf.loadBundle()
+	}
+	db.pinLog(db.logNumber)
+	return checkpointState{
+		families:     families,
+		bundles:      bundles,
+		lastSequence: db.manifest.LastSequence(),
+		logNumber:    db.logNumber,
+		logOffset:    db.log.Offset(),
+	}
+}
+
+func replyCheckpoints(checkpoints []*checkpointRequest, err error) {
+	for _, r := range checkpoints {
+		r.state <- checkpointState{err: err}
+	}
+}
+
+// Checkpoint creates an openable copy of db in directory dir. Table and blob
+// files are hard linked if file system supports, copied otherwise. Current
+// log file is copied up to the point captured.
+func (db *DB) Checkpoint(dir string) (err error) {
+	if db.readOnly {
+		return errors.ErrReadOnly
+	}
+	fs := db.fs
+	if fs.Exists(files.CurrentFileName(dir)) {
+		return errors.ErrDBExists
+	}
+	if err := fs.MkdirAll(dir); err != nil {
+		return err
+	}
+
+	// Write goroutine captures state of db after compacting immutable
+	// memtables and unlogged writes, so all entries in memtables are in
+	// current log file.
+	r := &checkpointRequest{state: make(chan checkpointState, 1)}
+	select {
+	case <-db.bgClosing:
+		return errors.ErrDBClosed
+	case db.checkpointRequests <- r:
+	}
+	state := <-r.state
+	if state.err != nil {
+		return state.err
+	}
+	defer db.unpinLog(state.logNumber)
+	families, bundles := state.families, state.bundles
+	// Pin versions to prevent their files from being deleted.
+	defer runtime.KeepAlive(bundles)
+
+	var created []string
+	defer func() {
+		if err != nil {
+			for _, name := range created {
+				fs.Remove(name)
+			}
+		}
+	}()
+
+	edits := make([]*manifest.Edit, len(families))
+	maxFileNumber := state.logNumber
+	var maxColumnFamily uint32
+	for i, cf := range families {
+		edit := &manifest.Edit{ComparatorName: cf.options.Comparator.UserKeyComparator.Name()}
+		if cf.ID() != 0 {
+			edit.ColumnFamily = cf.ID()
+			edit.AddedColumnFamily = cf.Name()
+			maxColumnFamily = cf.ID()
+		}
+		edits[i] = edit
+		bundles[i].version.Snapshot(edit)
+		for _, f := range edit.AddedFiles {
+			srcName := files.TableFileName(db.name, f.Number)
+			dstName := files.TableFileName(dir, f.Number)
+			if !fs.Exists(srcName) {
+				srcName = files.SSTTableFileName(db.name, f.Number)
+				dstName = files.SSTTableFileName(dir, f.Number)
+			}
+			if err := linkOrCopyFile(fs, srcName, dstName); err != nil {
+				return err
+			}
+			created = append(created, dstName)
+			if f.Number > maxFileNumber {
+				maxFileNumber = f.Number
+			}
+		}
+		for _, f := range edit.AddedBlobFiles {
+			dstName := files.BlobFileName(dir, f.Number)
+			if err := linkOrCopyFile(fs, files.BlobFileName(db.name, f.Number), dstName); err != nil {
+				return err
+			}
+			created = append(created, dstName)
+			if f.Number > maxFileNumber {
+				maxFileNumber = f.Number
+			}
+		}
+	}
+
+	logName := files.LogFileName(dir, state.logNumber)
+	created = append(created, logName)
+	if err := copyFile(fs, files.LogFileName(db.name, state.logNumber), logName, state.logOffset); err != nil {
+		return err
+	}
+
+	manifestNumber := maxFileNumber + 1
+	for _, edit := range edits {
+		edit.LogNumber = state.logNumber
+	}
+	edits[0].NextFileNumber = manifestNumber + 1
+	edits[0].LastSequence = state.lastSequence
+	edits[0].MaxColumnFamily = maxColumnFamily
+	return writeManifest(fs, dir, manifestNumber, edits...)
+}
+
+// pinLog prevents log file number from being deleted until unpinned.
+func (db *DB) pinLog(number uint64) {
+	db.pinnedLogsMu.Lock()
+	if db.pinnedLogs == nil {
+		db.pinnedLogs = make(map[uint64]int)
+	}
+	db.pinnedLogs[number]++
+	db.pinnedLogsMu.Unlock()
+}
+
+func (db *DB) unpinLog(number uint64) {
+	db.pinnedLogsMu.Lock()
+	if db.pinnedLogs[number]--; db.pinnedLogs[number] == 0 {
+		delete(db.pinnedLogs, number)
+	}
+	db.pinnedLogsMu.Unlock()
+}
+
+func (db *DB) isLogPinned(number uint64) bool {
+	db.pinnedLogsMu.Lock()
+	defer db.pinnedLogsMu.Unlock()
+	return db.pinnedLogs[number] != 0
+}
+
+func linkOrCopyFile(fs file.FileSystem, srcName, dstName string) error {
+	if linker, ok := fs.(file.Linker); ok && linker.Link(srcName, dstName) == nil {
+		return nil
+	}
+	return copyFile(fs, srcName, dstName, -1)
+}
+
+// copyFile copies first size bytes of file srcName to dstName, or all its
+// bytes if size is negative.
+func copyFile(fs file.FileSystem, srcName, dstName string, size int64) (err error) {
+	src, err := fs.Open(srcName, os.O_RDONLY)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := fs.Open(dstName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := dst.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			fs.Remove(dstName)
+		}
+	}()
+	if size < 0 {
+		_, err = io.Copy(dst, src)
+	} else {
+		_, err = io.CopyN(dst, src, size)
+	}
+	if err != nil {
+		return err
+	}
+	return dst.Sync()
+}
@@ -0,0 +1,278 @@
+package leveldb
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kezhuw/leveldb/internal/batch"
+	"github.com/kezhuw/leveldb/internal/configs"
+	"github.com/kezhuw/leveldb/internal/errors"
+	"github.com/kezhuw/leveldb/internal/iterator"
+	"github.com/kezhuw/leveldb/internal/keys"
+	"github.com/kezhuw/leveldb/internal/manifest"
+	"github.com/kezhuw/leveldb/internal/memtable"
+	"github.com/kezhuw/leveldb/internal/merge"
+	"github.com/kezhuw/leveldb/internal/options"
+)
+
+// ColumnFamily is a keyspace of db with its own memtables, levels of tables
+// and options. All column families of db share log files, so a batch
+// updating multiple column families is applied atomically.
+type ColumnFamily struct {
+	db      *DB
+	family  *manifest.ColumnFamily
+	options *options.Options
+
+	bundle *bundle
+
+	// mem is current memtable, owned by write goroutine.
+	mem *memtable.MemTable
+
+	// Last sequences before creation of mem and imm, owned by write
+	// goroutine. Updates after them are all in memtables from that on.
+	memSequence keys.Sequence
+	immSequence keys.Sequence
+
+	dropped uint32
+
+	stats   [configs.NumberLevels]compactionStats
+	statsMu sync.Mutex
+}
+
+func newColumnFamily(db *DB, family *manifest.ColumnFamily) *ColumnFamily {
+	cf := &ColumnFamily{db: db, family: family, options: family.Options()}
+	cf.mem = memtable.New(cf.options.Comparator)
+	cf.memSequence = db.manifest.LoadLastSequence()
+	cf.bundle = &bundle{mem: cf.mem, version: family.Version()}
+	return cf
+}
+
+// ID returns id of this column family, which is zero for default column
+// family.
+func (cf *ColumnFamily) ID() uint32 {
+	return cf.family.ID
+}
+
+// Name returns name of this column family.
+func (cf *ColumnFamily) Name() string {
+	return cf.family.Name
+}
+
+func (cf *ColumnFamily) isDropped() bool {
+	return atomic.LoadUint32(&cf.dropped) != 0
+}
+
+func (cf *ColumnFamily) write(b batch.Batch, opts *options.WriteOptions) error {
+	if cf.isDropped() {
+		return errors.ErrColumnFamilyDropped
+	}
+	return cf.db.Write(b, opts)
+}
+
+func (cf *ColumnFamily) Put(key, value []byte, opts *options.WriteOptions) error {
+	var batch batch.Batch
+	batch.PutCF(cf.ID(), key, value)
+	return cf.write(batch, opts)
+}
+
+// PutWithTTL puts key/value which expires after d.
+func (cf *ColumnFamily) PutWithTTL(key, value []byte, d time.Duration, opts *options.WriteOptions) error {
+	var batch batch.Batch
+	batch.PutWithExpirationCF(cf.ID(), key, value, cf.db.Expiration(d))
+	return cf.write(batch, opts)
+}
+
+func (cf *ColumnFamily) Delete(key []byte, opts *options.WriteOptions) error {
+	var batch batch.Batch
+	batch.DeleteCF(cf.ID(), key)
+	return cf.write(batch, opts)
+}
+
+// Merge merges operand into value of key using merge operator.
+func (cf *ColumnFamily) Merge(key, operand []byte, opts *options.WriteOptions) error {
+	if cf.options.MergeOperator == nil {
+		return errors.ErrNoMergeOperator
+	}
+	var batch batch.Batch
+	batch.MergeCF(cf.ID(), key, operand)
+	return cf.write(batch, opts)
+}
+
+// DeleteRange deletes all keys in range [start, limit).
+func (cf *ColumnFamily) DeleteRange(start, limit []byte, opts *options.WriteOptions) error {
+	var batch batch.Batch
+	batch.DeleteRangeCF(cf.ID(), start, limit)
+	return cf.write(batch, opts)
+}
+
+func (cf *ColumnFamily) Get(key []byte, opts *options.ReadOptions) ([]byte, error) {
+	return cf.get(key, cf.db.manifest.LoadLastSequence(), opts)
+}
+
+func (cf *ColumnFamily) get(key []byte, seq keys.Sequence, opts *options.ReadOptions) ([]byte, error) {
+	var lookup merge.Lookup
+	lookup.Reset(cf.options.MergeOperator, cf.db.manifest.Blobs(), key, cf.options.Now())
+	return cf.lookup(key, seq, &lookup, opts)
+}
+
+// lookup feeds entries of key visible to seq to l until it is resolved.
+func (cf *ColumnFamily) lookup(key []byte, seq keys.Sequence, l *merge.Lookup, opts *options.ReadOptions) ([]byte, error) {
+	if cf.isDropped() {
+		return nil, errors.ErrColumnFamilyDropped
+	}
+	bundle := cf.loadBundle()
+	if bundle == nil {
+		return nil, errors.ErrDBClosed
+	}
+	ikey := keys.NewInternalKey(key, seq, keys.Seek)
+	memtables := [2]*memtable.MemTable{bundle.mem, bundle.imm}
+	for _, mem := range memtables {
+		if mem == nil {
+			continue
+		}
+		if mem.Get(ikey, l) {
+			return l.Result()
+		}
+	}
+	seekThroughFile := bundle.version.Get(ikey, l, opts)
+	if seekThroughFile.FileMeta != nil {
+		cf.tryCompactFile(seekThroughFile)
+	}
+	return l.Result()
+}
+
+func (cf *ColumnFamily) All(opts *options.ReadOptions) iterator.Iterator {
+	return cf.between(nil, nil, cf.db.manifest.LoadLastSequence(), opts)
+}
+
+func (cf *ColumnFamily) Find(start []byte, opts *options.ReadOptions) iterator.Iterator {
+	return cf.between(start, nil, cf.db.manifest.LoadLastSequence(), opts)
+}
+
+func (cf *ColumnFamily) Range(start, limit []byte, opts *options.ReadOptions) iterator.Iterator {
+	return cf.between(start, limit, cf.db.manifest.LoadLastSequence(), opts)
+}
+
+func (cf *ColumnFamily) Prefix(prefix []byte, opts *options.ReadOptions) iterator.Iterator {
+	return cf.prefix(prefix, cf.db.manifest.LoadLastSequence(), opts)
+}
+
+func (cf *ColumnFamily) prefix(prefix []byte, seq keys.Sequence, opts *options.ReadOptions) iterator.Iterator {
+	limit := cf.options.Comparator.UserKeyComparator.MakePrefixSuccessor(prefix)
+	return cf.between(prefix, limit, seq, opts)
+}
+
+func (cf *ColumnFamily) between(start, limit []byte, seq keys.Sequence, opts *options.ReadOptions) iterator.Iterator {
+	if cf.isDropped() {
+		return iterator.Error(errors.ErrColumnFamilyDropped)
+	}
+	bundle := cf.loadBundle()
+	if bundle == nil {
+		return iterator.Error(errors.ErrDBClosed)
+	}
+	iters := make([]iterator.Iterator, 1, 16)
+	iters[0] = bundle.mem.NewIterator()
+	tombstones := bundle.mem.RangeTombstones()
+	if bundle.imm != nil {
+		iters = append(iters, bundle.imm.NewIterator())
+		tombstones = append(tombstones, bundle.imm.RangeTombstones()...)
+	}
+	iters = bundle.version.AppendIterators(iters, opts)
+	mergeIt := iterator.NewMergeIterator(cf.options.Comparator, iters...)
+	dbIt := newDBIterator(cf, bundle.version, tombstones, seq, mergeIt)
+	ucmp := cf.options.Comparator.UserKeyComparator
+	return iterator.NewRangeIterator(start, limit, ucmp, dbIt)
+}
+
+// DefaultColumnFamily returns the default column family, which is operated
+// by methods of db.
+func (db *DB) DefaultColumnFamily() *ColumnFamily {
+	return db.defaultFamily
+}
+
+// ColumnFamily returns column family with given name, or nil if there is no
+// such column family.
+func (db *DB) ColumnFamily(name string) *ColumnFamily {
+	for _, cf := range db.columnFamilies() {
+		if cf.Name() == name {
+			return cf
+		}
+	}
+	return nil
+}
+
+// columnFamilies returns all live column families ordered by id. The
+// returned slice must not be modified.
+func (db *DB) columnFamilies() []*ColumnFamily {
+	db.familiesMu.RLock()
+	defer db.familiesMu.RUnlock()
+	return db.families
+}
+
+// columnFamily returns column family with given id, or nil if it has been
+// dropped.
+func (db *DB) columnFamily(id uint32) *ColumnFamily {
+	for _, cf := range db.columnFamilies() {
+		if cf.ID() == id {
+			return cf
+		}
+	}
+	return nil
+}
+
+// CreateColumnFamily creates a column family with given name and options.
+// Fields shared by all column families are taken from options of db.
+func (db *DB) CreateColumnFamily(name string, opts *options.Options) (*ColumnFamily, error) {
+	if db.readOnly {
+		return nil, errors.ErrReadOnly
+	}
+	if err := opts.CheckCompression(); err != nil {
+		return nil, err
+	}
+	db.familiesMu.Lock()
+	defer db.familiesMu.Unlock()
+	if atomic.LoadUintptr(&db.closing) != 0 {
+		return nil, errors.ErrDBClosed
+	}
+	family, err := db.manifest.CreateColumnFamily(name, db.options.ForColumnFamily(opts))
+	if err != nil {
+		return nil, err
+	}
+	cf := newColumnFamily(db, family)
+	// Copy on write, so readers could use families without locking.
+	families := make([]*ColumnFamily, len(db.families), len(db.families)+1)
+	copy(families, db.families)
+	db.families = append(families, cf)
+	return cf, nil
+}
+
+// DropColumnFamily drops cf. Updates to cf are ignored after dropping, and
+// its files are deleted after all its iterators are released.
+func (db *DB) DropColumnFamily(cf *ColumnFamily) error {
+	if cf.ID() == 0 {
+		return errors.ErrDropDefaultFamily
+	}
+	if db.readOnly {
+		return errors.ErrReadOnly
+	}
+	db.familiesMu.Lock()
+	defer db.familiesMu.Unlock()
+	if atomic.LoadUintptr(&db.closing) != 0 {
+		return errors.ErrDBClosed
+	}
+	if err := db.manifest.DropColumnFamily(cf.family); err != nil {
+		return err
+	}
+	atomic.StoreUint32(&cf.dropped, 1)
+	families := make([]*ColumnFamily, 0, len(db.families)-1)
+	for _, f := range db.families {
+		if f != cf {
+			families = append(families, f)
+		}
+	}
+	db.families = families
+	// Write goroutine may wait for compaction of its immutable memtable.
+	db.wakeupWrite(0)
+	return nil
+}
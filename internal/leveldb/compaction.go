@@ -1,15 +1,19 @@
 package leveldb
 
 import (
+	"time"
+
 	"github.com/kezhuw/leveldb/internal/compaction"
 	"github.com/kezhuw/leveldb/internal/compactor"
 	"github.com/kezhuw/leveldb/internal/configs"
+	"github.com/kezhuw/leveldb/internal/errors"
 	"github.com/kezhuw/leveldb/internal/files"
 	"github.com/kezhuw/leveldb/internal/manifest"
 	"github.com/kezhuw/leveldb/internal/memtable"
 )
 
 type compactionResult struct {
+	family  *ColumnFamily
 	err     error
 	level   int
 	edit    *manifest.Edit
@@ -17,13 +21,109 @@ type compactionResult struct {
 }
 
 type compactionEdit struct {
-	level int
-	edit  *manifest.Edit
+	family *ColumnFamily
+	level  int
+	edit   *manifest.Edit
+}
+
+// fileCompaction requests to compact file of family due to seeks.
+type fileCompaction struct {
+	family *ColumnFamily
+	file   manifest.LevelFileMeta
+}
+
+// memtableCompaction requests to compact mem of family, which was switched
+// when log logNumber was opened.
+type memtableCompaction struct {
+	family    *ColumnFamily
+	mem       *memtable.MemTable
+	logNumber uint64
+}
+
+// familyCompaction is compaction state of a column family, owned by
+// compaction goroutine.
+type familyCompaction struct {
+	registry               compaction.Registry
+	pendingMemtable        *memtableCompaction
+	pendingFiles           [configs.NumberLevels - 1]manifest.FileList
+	pendingLevelCompaction bool
+}
+
+type familyCompactions map[*ColumnFamily]*familyCompaction
+
+func (compactions familyCompactions) get(cf *ColumnFamily) *familyCompaction {
+	c := compactions[cf]
+	if c == nil {
+		c = &familyCompaction{}
+		c.registry.Recap(cf.options.CompactionConcurrency)
+		compactions[cf] = c
+	}
+	return c
+}
+
+// idle returns true if there is no ongoing compaction.
+func (compactions familyCompactions) idle() bool {
+	for _, c := range compactions {
+		if c.registry.Concurrency() != 0 {
+			return false
+		}
+	}
+	return true
 }
 
-func (db *DB) tryCompactFile(file manifest.LevelFileMeta) {
+// nextFileNumber returns minimum NextFileNumber among all ongoing compactions
+// or zero if there is no ongoing compaction.
+func (compactions familyCompactions) nextFileNumber() uint64 {
+	var nextFileNumber uint64
+	for _, c := range compactions {
+		n := c.registry.NextFileNumber(0)
+		if n != 0 && (nextFileNumber == 0 || n < nextFileNumber) {
+			nextFileNumber = n
+		}
+	}
+	return nextFileNumber
+}
+
+// rangeCompaction compacts files overlapping with user key range [start, limit]
+// level by level until maxLevel.
+type rangeCompaction struct {
+	family   *ColumnFamily
+	start    []byte
+	limit    []byte
+	level    int
+	maxLevel int
+	// next is the user key where compactions in current level start from.
+	next []byte
+	// resume is non nil if ongoing compaction doesn't cover all overlapping
+	// files in current level.
+	resume  []byte
+	running bool
+	done    chan error
+}
+
+// complete completes ongoing compaction in given level of family. It returns
+// true if this range compaction is done due to error.
+func (r *rangeCompaction) complete(family *ColumnFamily, level int, err error) bool {
+	if !r.running || r.family != family || r.level != level {
+		return false
+	}
+	r.running = false
+	switch {
+	case err != nil:
+		r.done <- err
+		return true
+	case r.resume != nil:
+		r.next, r.resume = r.resume, nil
+	default:
+		r.level++
+		r.next = r.start
+	}
+	return false
+}
+
+func (cf *ColumnFamily) tryCompactFile(file manifest.LevelFileMeta) {
 	select {
-	case db.compactionFile <- file:
+	case cf.db.compactionFile <- fileCompaction{family: cf, file: file}:
 	default:
 	}
 }
@@ -35,66 +135,168 @@ func (db *DB) tryLevelCompaction() {
 	}
 }
 
-func (db *DB) compact(c compactor.Compactor, edit *manifest.Edit) {
+// CompactRange flushes memtable and compacts files overlapping with user key
+// range [start, limit] down to the bottommost level containing files
+// overlapping with this range. Zero length start and limit act as infinite
+// small and infinite large.
+func (db *DB) CompactRange(start, limit []byte) error {
+	return db.defaultFamily.CompactRange(start, limit)
+}
+
+// CompactRange flushes memtables and compacts files of this column family
+// overlapping with user key range [start, limit] like DB.CompactRange.
+func (cf *ColumnFamily) CompactRange(start, limit []byte) error {
+	db := cf.db
+	if db.readOnly {
+		return errors.ErrReadOnly
+	}
+	// Flush memtable, so deletions in it could discard data in tables.
+	if err := db.flush(true); err != nil {
+		return err
+	}
+	r := &rangeCompaction{
+		family: cf,
+		start:  start,
+		limit:  limit,
+		next:   start,
+		done:   make(chan error, 1),
+	}
+	select {
+	case <-db.bgClosing:
+		return errors.ErrDBClosed
+	case db.compactionRange <- r:
+	}
+	return <-r.done
+}
+
+func (cf *ColumnFamily) compact(c compactor.Compactor, edit *manifest.Edit, bytesRead uint64) {
+	db := cf.db
+	start := time.Now()
 	level, err := c.Level(), c.Compact(edit)
 	if err != nil {
-		db.compactionResult <- compactionResult{level: level, err: err}
+		db.compactionResult <- compactionResult{family: cf, level: level, err: err}
 		return
 	}
+	cf.recordCompactionStats(level+1, time.Since(start), bytesRead, edit)
 	if level == -1 {
-		db.memtableEdit <- edit
+		db.memtableEdit <- compactionEdit{family: cf, level: level, edit: edit}
 		return
 	}
-	db.compactionEdit <- compactionEdit{level: level, edit: edit}
+	db.compactionEdit <- compactionEdit{family: cf, level: level, edit: edit}
 }
 
-func (db *DB) startMemTableCompaction(registry *compaction.Registry, mem *memtable.MemTable) bool {
+func (cf *ColumnFamily) startMemTableCompaction(registry *compaction.Registry, mc *memtableCompaction) bool {
 	registration := registry.Register(-1, 0)
 	if registration == nil {
 		return false
 	}
-	m := db.manifest
+	db, m := cf.db, cf.db.manifest
 	fileNumber, nextFileNumber := m.NewFileNumber()
 	fileName := files.TableFileName(db.name, fileNumber)
-	compactor := compactor.NewMemTableCompactor(fileNumber, fileName, db.getSmallestSnapshot(), mem, db.options)
+	var blobNumber uint64
+	var blobName string
+	if cf.options.MinBlobSize > 0 {
+		blobNumber, nextFileNumber = m.NewFileNumber()
+		blobName = files.BlobFileName(db.name, blobNumber)
+	}
+	smallestSequence, snapshots := db.getSmallestSnapshot()
+	compactor := compactor.NewMemTableCompactor(fileNumber, fileName, blobNumber, blobName, smallestSequence, snapshots, mc.mem, cf.options)
 	edit := &manifest.Edit{
-		LogNumber:      db.logNumber,
+		LogNumber:      mc.logNumber,
 		NextFileNumber: nextFileNumber,
 	}
 	registration.NextFileNumber = fileNumber
-	go db.compact(compactor, edit)
+	go cf.compact(compactor, edit, 0)
 	return true
 }
 
-func (db *DB) startLevelCompactions(compactions []*manifest.Compaction) {
+func (cf *ColumnFamily) startLevelCompactions(compactions []*manifest.Compaction) {
 	if len(compactions) == 0 {
 		return
 	}
-	smallestSequence := db.getSmallestSnapshot()
+	db := cf.db
+	smallestSequence, snapshots := db.getSmallestSnapshot()
 	for _, c := range compactions {
 		edit := &manifest.Edit{
 			NextFileNumber: c.Registration.NextFileNumber,
 		}
-		compactor := compactor.NewLevelCompactor(db.name, smallestSequence, c, db.manifest, db.options)
-		go db.compact(compactor, edit)
+		compactor := compactor.NewLevelCompactor(db.name, smallestSequence, snapshots, c, db.manifest, cf.options)
+		var bytesRead uint64
+		if !c.IsTrivialMove() {
+			bytesRead = c.Inputs[0].TotalFileSize() + c.Inputs[1].TotalFileSize()
+		}
+		go cf.compact(compactor, edit, bytesRead)
+	}
+}
+
+// startRangeCompaction starts next compaction for given range compaction. It
+// returns true if there is no more files to compact.
+func (cf *ColumnFamily) startRangeCompaction(registry *compaction.Registry, r *rangeCompaction) bool {
+	v := cf.family.Version()
+	for ; r.level < r.maxLevel; r.level, r.next = r.level+1, r.start {
+		c, resume := v.NewRangeCompaction(r.level, r.next, r.limit)
+		if c == nil {
+			continue
+		}
+		registration := registry.Register(r.level, r.level+1)
+		if registration == nil {
+			return false
+		}
+		registration.NextFileNumber = cf.db.manifest.NextFileNumber()
+		c.Registration = registration
+		r.resume = resume
+		r.running = true
+		cf.startLevelCompactions([]*manifest.Compaction{c})
+		return false
+	}
+	return true
+}
+
+// serveRangeCompactions starts range compactions in order, and returns pending
+// ones. If closing is true, all range compactions not running are aborted.
+func (db *DB) serveRangeCompactions(compactions familyCompactions, ranges []*rangeCompaction, closing bool) []*rangeCompaction {
+	for len(ranges) != 0 {
+		r := ranges[0]
+		switch {
+		case r.running:
+			return ranges
+		case closing:
+			r.done <- errors.ErrDBClosed
+		case r.family.isDropped():
+			r.done <- errors.ErrColumnFamilyDropped
+		case !r.family.startRangeCompaction(&compactions.get(r.family).registry, r):
+			return ranges
+		default:
+			r.done <- nil
+		}
+		ranges[0] = nil
+		ranges = ranges[1:]
 	}
+	return nil
 }
 
 func (db *DB) serveCompaction(done chan struct{}) {
-	go db.serveVersionEdit(db.manifest.Version())
+	go db.serveVersionEdit()
 	defer close(done)
 	defer close(db.compactionEdit)
-	var registry compaction.Registry
+	compactions := make(familyCompactions)
 	var ongoingObsoleteFiles chan struct{}
-	var pendingMemtable *memtable.MemTable
 	var compactionErr, manifestErr error
-	var pendingFiles [configs.NumberLevels - 1]manifest.FileList
 	var pendingObsoleteFiles uint64
+	var pendingRanges []*rangeCompaction
+	// Memtable edits are queued to not block on serveVersionEdit, which may
+	// be blocking on sending results to us.
+	var pendingEdits []compactionEdit
 	closing := db.bgClosing
-	registry.Recap(db.options.CompactionConcurrency)
 	db.removeObsoleteFilesAsync(0)
-	for !(closing == nil && registry.Concurrency() == 0 && ongoingObsoleteFiles == nil && pendingObsoleteFiles == 0) {
-		var pendingLevelCompaction bool
+	// Requests in obsoleteFilesChan are served before quit, otherwise files
+	// obsoleted before closing could survive.
+	for !(closing == nil && compactions.idle() && ongoingObsoleteFiles == nil && pendingObsoleteFiles == 0 && len(db.obsoleteFilesChan) == 0) {
+		var edits chan compactionEdit
+		var pendingEdit compactionEdit
+		if len(pendingEdits) != 0 {
+			edits, pendingEdit = db.compactionEdit, pendingEdits[0]
+		}
 		select {
 		case tableNumber := <-db.obsoleteFilesChan:
 			pendingObsoleteFiles = db.updateObsoleteTableNumber(pendingObsoleteFiles, tableNumber)
@@ -102,34 +304,57 @@ func (db *DB) serveCompaction(done chan struct{}) {
 			ongoingObsoleteFiles = nil
 		case <-closing:
 			closing = nil
+		case edits <- pendingEdit:
+			pendingEdits[0] = compactionEdit{}
+			pendingEdits = pendingEdits[1:]
 		case edit := <-db.memtableEdit:
-			output := edit.AddedFiles[0].FileMeta
-			maxLevel := db.manifest.Version().PickLevelForMemTableOutput(output.Smallest, output.Largest)
+			output := edit.edit.AddedFiles[0].FileMeta
+			maxLevel := edit.family.family.Version().PickLevelForMemTableOutput(output.Smallest, output.Largest)
 			if maxLevel > 0 {
-				edit.AddedFiles[0].Level = registry.ExpandTo(-1, maxLevel)
+				edit.edit.AddedFiles[0].Level = compactions.get(edit.family).registry.ExpandTo(-1, maxLevel)
 			}
-			db.compactionEdit <- compactionEdit{level: -1, edit: edit}
+			pendingEdits = append(pendingEdits, edit)
 		case <-db.compactionLevel:
-			pendingLevelCompaction = true
-		case mem := <-db.compactionMemtable:
-			pendingMemtable = mem
-		case file := <-db.compactionFile:
-			pendingFiles[file.Level] = append(pendingFiles[file.Level], file.FileMeta)
-			pendingLevelCompaction = true
+			for _, cf := range db.columnFamilies() {
+				compactions.get(cf).pendingLevelCompaction = true
+			}
+		case r := <-db.compactionRange:
+			// Compact level-0 files to level-1 at least.
+			r.maxLevel = r.family.family.Version().MaxOverlappingLevel(r.start, r.limit)
+			if r.maxLevel < 1 {
+				r.maxLevel = 1
+			}
+			pendingRanges = append(pendingRanges, r)
+		case mc := <-db.compactionMemtable:
+			compactions.get(mc.family).pendingMemtable = &mc
+		case fc := <-db.compactionFile:
+			c := compactions.get(fc.family)
+			c.pendingFiles[fc.file.Level] = append(c.pendingFiles[fc.file.Level], fc.file.FileMeta)
+			c.pendingLevelCompaction = true
 		case result := <-db.compactionResult:
+			if len(pendingRanges) != 0 && pendingRanges[0].complete(result.family, result.level, result.err) {
+				pendingRanges[0] = nil
+				pendingRanges = pendingRanges[1:]
+			}
+			c := compactions.get(result.family)
 			switch {
 			case result.err == nil:
-				db.switchVersion(result.level, result.version)
-				registry.Complete(result.level)
-				pendingObsoleteFiles = db.updateObsoleteTableNumber(pendingObsoleteFiles, registry.NextFileNumber(0))
-				pendingLevelCompaction = true
+				result.family.switchVersion(result.level, result.version)
+				c.registry.Complete(result.level)
+				pendingObsoleteFiles = db.updateObsoleteTableNumber(pendingObsoleteFiles, compactions.nextFileNumber())
+				c.pendingLevelCompaction = true
+			case result.err == errors.ErrColumnFamilyDropped:
+				// Outputs of this compaction are not referenced by any
+				// version, they will be collected as obsolete files.
+				c.registry.Complete(result.level)
+				pendingObsoleteFiles = db.updateObsoleteTableNumber(pendingObsoleteFiles, compactions.nextFileNumber())
 			case result.version == nil:
 				if compactionErr == nil {
 					compactionErr = result.err
 					db.compactionErrChan <- compactionErr
 				}
-				registry.Complete(result.level)
-				pendingObsoleteFiles = db.updateObsoleteTableNumber(pendingObsoleteFiles, registry.NextFileNumber(0))
+				c.registry.Complete(result.level)
+				pendingObsoleteFiles = db.updateObsoleteTableNumber(pendingObsoleteFiles, compactions.nextFileNumber())
 			default:
 				if manifestErr == nil {
 					manifestErr = result.err
@@ -137,15 +362,27 @@ func (db *DB) serveCompaction(done chan struct{}) {
 				}
 				// We don't known whether version edit was written or not,
 				// so we can't collect table files generated by this compaction.
-				registry.Corrupt(result.level)
+				c.registry.Corrupt(result.level)
 			}
 		}
-		if pendingMemtable != nil && db.startMemTableCompaction(&registry, pendingMemtable) {
-			pendingMemtable = nil
-		}
-		if pendingLevelCompaction {
-			compactions := db.manifest.PickCompactions(&registry, pendingFiles[:])
-			db.startLevelCompactions(compactions)
+		pendingRanges = db.serveRangeCompactions(compactions, pendingRanges, closing == nil)
+		for cf, c := range compactions {
+			if cf.isDropped() {
+				// Files of dropped column family are collected once all
+				// its ongoing compactions completed.
+				if c.registry.Concurrency() == 0 {
+					delete(compactions, cf)
+				}
+				continue
+			}
+			if c.pendingMemtable != nil && cf.startMemTableCompaction(&c.registry, c.pendingMemtable) {
+				c.pendingMemtable = nil
+			}
+			if c.pendingLevelCompaction {
+				c.pendingLevelCompaction = false
+				compactions := cf.family.PickCompactions(&c.registry, c.pendingFiles[:])
+				cf.startLevelCompactions(compactions)
+			}
 		}
 		if pendingObsoleteFiles != 0 && ongoingObsoleteFiles == nil {
 			ongoingObsoleteFiles = make(chan struct{})
@@ -155,21 +392,41 @@ func (db *DB) serveCompaction(done chan struct{}) {
 	}
 }
 
-func (db *DB) serveVersionEdit(tip *manifest.Version) {
+func (db *DB) serveVersionEdit() {
 	var lastErr error
+	// Latest logged versions of column families.
+	tips := make(map[*ColumnFamily]*manifest.Version)
 	for edit := range db.compactionEdit {
+		// Release versions of dropped column families, so their files
+		// could be collected.
+		for cf := range tips {
+			if cf.isDropped() {
+				delete(tips, cf)
+			}
+		}
+		cf := edit.family
+		tip := tips[cf]
+		if tip == nil {
+			tip = cf.family.Version()
+		}
 		next, err := db.manifest.Log(tip, edit.edit)
+		if err == errors.ErrColumnFamilyDropped {
+			delete(tips, cf)
+			db.compactionResult <- compactionResult{family: cf, err: err, level: edit.level}
+			continue
+		}
 		if err != nil {
 			lastErr = err
-			db.compactionResult <- compactionResult{err: err, level: edit.level, version: tip}
+			tips[cf] = tip
+			db.compactionResult <- compactionResult{family: cf, err: err, level: edit.level, version: tip}
 			break
 		}
-		tip = next
-		db.compactionResult <- compactionResult{level: edit.level, version: tip}
+		tips[cf] = next
+		db.compactionResult <- compactionResult{family: cf, level: edit.level, version: next}
 	}
 	if lastErr != nil {
 		for edit := range db.compactionEdit {
-			db.compactionResult <- compactionResult{err: lastErr, level: edit.level, version: tip}
+			db.compactionResult <- compactionResult{family: edit.family, err: lastErr, level: edit.level, version: tips[edit.family]}
 		}
 	}
 }
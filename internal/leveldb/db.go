@@ -8,6 +8,7 @@ import (
 	"sort"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/kezhuw/leveldb/internal/batch"
 	"github.com/kezhuw/leveldb/internal/compactor"
@@ -23,6 +24,7 @@ import (
 	"github.com/kezhuw/leveldb/internal/options"
 	"github.com/kezhuw/leveldb/internal/record"
 	"github.com/kezhuw/leveldb/internal/request"
+	"github.com/kezhuw/leveldb/internal/ttl"
 )
 
 type DB struct {
@@ -32,7 +34,10 @@ type DB struct {
 	requests chan request.Request
 	requestw chan struct{}
 
-	bundle *bundle
+	defaultFamily *ColumnFamily
+	// Live column families ordered by id, which is copied on write.
+	families   []*ColumnFamily
+	familiesMu sync.RWMutex
 
 	manifest *manifest.Manifest
 
@@ -46,6 +51,10 @@ type DB struct {
 	options *options.Options
 	locker  io.Closer
 
+	// readOnly is true if db is opened by OpenReadOnly, which runs no
+	// write and compaction goroutines.
+	readOnly bool
+
 	log       *record.Writer
 	logFile   file.File
 	logNumber uint64
@@ -57,9 +66,20 @@ type DB struct {
 
 	compactionErr error
 
+	// closeErr is error from compacting memtables containing writes not
+	// logged in closing.
+	closeErr error
+
 	manifestErrChan   chan error
 	compactionErrChan chan error
 
+	flushRequests      chan *flushRequest
+	checkpointRequests chan *checkpointRequest
+
+	// Log files pinned by ongoing checkpoints, which must not be deleted.
+	pinnedLogs   map[uint64]int
+	pinnedLogsMu sync.Mutex
+
 	nextLogFile    chan file.File
 	nextLogNumber  uint64
 	nextLogFileErr chan error
@@ -67,11 +87,17 @@ type DB struct {
 	snapshots   snapshotList
 	snapshotsMu sync.Mutex
 
-	compactionFile     chan manifest.LevelFileMeta
+	// Key locks of pessimistic transactions.
+	locks lockManager
+
+	logRetention logRetention
+
+	compactionFile     chan fileCompaction
 	compactionLevel    chan struct{}
-	compactionMemtable chan *memtable.MemTable
+	compactionMemtable chan memtableCompaction
+	compactionRange    chan *rangeCompaction
 
-	memtableEdit     chan *manifest.Edit
+	memtableEdit     chan compactionEdit
 	compactionEdit   chan compactionEdit
 	compactionResult chan compactionResult
 
@@ -79,6 +105,9 @@ type DB struct {
 }
 
 func Open(dbname string, opts *options.Options) (db *DB, err error) {
+	if err := opts.CheckCompression(); err != nil {
+		return nil, err
+	}
 	fs := opts.FileSystem
 	fs.MkdirAll(dbname)
 
@@ -121,6 +150,56 @@ func Open(dbname string, opts *options.Options) (db *DB, err error) {
 	}
 }
 
+// OpenReadOnly opens an existing db for reads only. It neither locks db nor
+// writes any files. Updates in log files are replayed to memtables, which
+// are never compacted to tables.
+func OpenReadOnly(dbname string, opts *options.Options) (*DB, error) {
+	if err := opts.CheckCompression(); err != nil {
+		return nil, err
+	}
+	if !opts.FileSystem.Exists(files.CurrentFileName(dbname)) {
+		return nil, errors.ErrDBMissing
+	}
+	if opts.Logger == nil {
+		// Don't modify opts, which may be shared, e.g. options.DefaultOptions.
+		o := *opts
+		o.Logger = logger.Discard
+		opts = &o
+	}
+	manifest, err := manifest.RecoverReadOnly(dbname, opts)
+	if err != nil {
+		return nil, err
+	}
+	logs, err := recoverableLogs(dbname, manifest, opts)
+	if err != nil {
+		return nil, err
+	}
+	db := &DB{readOnly: true}
+	initDB(db, dbname, manifest, nil, opts)
+	if err := db.replayLogs(logs); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// replayLogs replays logs to memtables without compacting them.
+func (db *DB) replayLogs(logs []uint64) error {
+	sort.Sort(byOldestFileNumber(logs))
+	maxSequence := db.manifest.LastSequence()
+	for _, logNumber := range logs {
+		logFile, _, err := db.loadLog(logNumber, os.O_RDONLY, &maxSequence)
+		if err != nil {
+			return err
+		}
+		logFile.Close()
+	}
+	for _, cf := range db.families {
+		cf.bundle = &bundle{mem: cf.mem, version: cf.family.Version()}
+	}
+	db.manifest.StoreLastSequence(maxSequence)
+	return nil
+}
+
 func (db *DB) newLogFile() (file.File, uint64, error) {
 	logNumber, _ := db.manifest.NewFileNumber()
 	logName := files.LogFileName(db.name, logNumber)
@@ -132,7 +211,25 @@ func (db *DB) newLogFile() (file.File, uint64, error) {
 	return logFile, logNumber, nil
 }
 
-func (db *DB) loadLog(mem *memtable.MemTable, logNumber uint64, flag int, maxSequence *keys.Sequence) (file.File, int64, error) {
+// logLoader inserts updates in log file to memtables of column families,
+// skipping updates which have been written to tables of their column
+// families.
+type logLoader struct {
+	db        *DB
+	logNumber uint64
+}
+
+func (l logLoader) Add(seq keys.Sequence, kind keys.Kind, key, value []byte) {
+	l.AddColumnFamily(0, seq, kind, key, value)
+}
+
+func (l logLoader) AddColumnFamily(family uint32, seq keys.Sequence, kind keys.Kind, key, value []byte) {
+	if cf := l.db.columnFamily(family); cf != nil && cf.family.LogNumber() <= l.logNumber {
+		cf.mem.Add(seq, kind, key, value)
+	}
+}
+
+func (db *DB) loadLog(logNumber uint64, flag int, maxSequence *keys.Sequence) (file.File, int64, error) {
 	logName := files.LogFileName(db.name, logNumber)
 	logFile, err := db.fs.Open(logName, flag)
 	if err != nil {
@@ -149,7 +246,9 @@ func (db *DB) loadLog(mem *memtable.MemTable, logNumber uint64, flag int, maxSeq
 			return logFile, r.Offset(), nil
 		case record.ErrIncompleteRecord:
 			offset := r.Offset()
-			logFile.Truncate(offset)
+			if flag != os.O_RDONLY {
+				logFile.Truncate(offset)
+			}
 			_, err = logFile.Seek(offset, io.SeekStart)
 			return logFile, offset, err
 		default:
@@ -157,7 +256,7 @@ func (db *DB) loadLog(mem *memtable.MemTable, logNumber uint64, flag int, maxSeq
 			return nil, 0, err
 		}
 		batch.Reset(buf)
-		err = batch.Iterate(mem)
+		err = batch.Iterate(logLoader{db: db, logNumber: logNumber})
 		if err != nil {
 			logFile.Close()
 			return nil, 0, err
@@ -189,48 +288,66 @@ func (db *DB) recoverLogs(logs []uint64) error {
 		if err != nil {
 			return err
 		}
-		db.bundle.mem = memtable.New(db.options.Comparator)
 		db.openLog(logFile, 0, logNumber)
 		return nil
 	}
 	maxSequence := db.manifest.LastSequence()
 	if n != 1 {
 		sort.Sort(byOldestFileNumber(logs))
-		var edit manifest.Edit
+		edits := make(map[*ColumnFamily]*manifest.Edit)
 		for _, logNumber := range logs[:n-1] {
-			mem := memtable.New(db.options.Comparator)
-			logFile, _, err := db.loadLog(mem, logNumber, os.O_RDONLY, &maxSequence)
+			logFile, _, err := db.loadLog(logNumber, os.O_RDONLY, &maxSequence)
 			if err != nil {
 				return err
 			}
 			logFile.Close()
-			fileNumber, _ := db.manifest.NewFileNumber()
-			fileName := files.TableFileName(db.name, fileNumber)
-			file, err := compactor.CompactMemTable(fileNumber, fileName, keys.MaxSequence, mem, db.options)
-			if err != nil {
-				return err
-			}
-			if file != nil {
-				edit.AddedFiles = append(edit.AddedFiles[:0], manifest.LevelFileMeta{Level: 0, FileMeta: file})
+			for _, cf := range db.families {
+				if cf.mem.Empty() {
+					continue
+				}
+				fileNumber, _ := db.manifest.NewFileNumber()
+				fileName := files.TableFileName(db.name, fileNumber)
+				file, err := compactor.CompactMemTable(fileNumber, fileName, keys.MaxSequence, cf.mem, cf.options)
+				if err != nil {
+					return err
+				}
+				cf.mem = memtable.New(cf.options.Comparator)
+				if file == nil {
+					continue
+				}
+				edit := edits[cf]
+				if edit == nil {
+					edit = &manifest.Edit{ColumnFamily: cf.ID()}
+					edits[cf] = edit
+				}
+				edit.AddedFiles = append(edit.AddedFiles, manifest.LevelFileMeta{Level: 0, FileMeta: file})
 			}
 		}
-		if len(edit.AddedFiles) != 0 {
+		// Tables above contain updates up to maxSequence.
+		db.manifest.StoreLastSequence(maxSequence)
+		for _, cf := range db.families {
+			edit := edits[cf]
+			if edit == nil {
+				continue
+			}
 			edit.LogNumber = logs[n-1]
 			edit.NextFileNumber = db.manifest.NextFileNumber()
-			edit.LastSequence = maxSequence
-			err := db.manifest.Apply(&edit)
+			err := db.manifest.Apply(edit)
 			if err != nil {
 				return err
 			}
 		}
 	}
-	mem := memtable.New(db.options.Comparator)
 	logNumber := logs[n-1]
-	logFile, offset, err := db.loadLog(mem, logNumber, os.O_RDWR, &maxSequence)
+	logFile, offset, err := db.loadLog(logNumber, os.O_RDWR, &maxSequence)
 	if err != nil {
 		return err
 	}
-	db.bundle.mem = mem
+	for _, cf := range db.families {
+		cf.bundle = &bundle{mem: cf.mem, version: cf.family.Version()}
+		// Older updates in mem may have been compacted to tables.
+		cf.memSequence = maxSequence
+	}
 	db.openLog(logFile, offset, logNumber)
 	db.manifest.StoreLastSequence(maxSequence)
 	db.manifest.MarkFileNumberUsed(logNumber)
@@ -252,30 +369,57 @@ func (db *DB) releaseSnapshot(ss *Snapshot) {
 	db.snapshotsMu.Unlock()
 }
 
-func (db *DB) getSmallestSnapshot() keys.Sequence {
+// getSmallestSnapshot returns sequence of the oldest live snapshot, or last
+// sequence if no snapshots are live. It also returns whether any snapshots
+// are live.
+func (db *DB) getSmallestSnapshot() (keys.Sequence, bool) {
 	db.snapshotsMu.Lock()
 	defer db.snapshotsMu.Unlock()
 	if db.snapshots.Empty() {
-		return db.manifest.LoadLastSequence()
+		return db.manifest.LoadLastSequence(), false
 	}
-	return db.snapshots.Oldest()
+	return db.snapshots.Oldest(), true
 }
 
 func (db *DB) Put(key, value []byte, opts *options.WriteOptions) error {
-	var batch batch.Batch
-	batch.Put(key, value)
-	return db.Write(batch, opts)
+	return db.defaultFamily.Put(key, value, opts)
+}
+
+// PutWithTTL puts key/value which expires after d.
+func (db *DB) PutWithTTL(key, value []byte, d time.Duration, opts *options.WriteOptions) error {
+	return db.defaultFamily.PutWithTTL(key, value, d, opts)
+}
+
+// Expiration returns expiration time of values written now with time to
+// live d.
+func (db *DB) Expiration(d time.Duration) int64 {
+	return ttl.Expiration(db.options.Now(), d)
 }
 
 func (db *DB) Delete(key []byte, opts *options.WriteOptions) error {
-	var batch batch.Batch
-	batch.Delete(key)
-	return db.Write(batch, opts)
+	return db.defaultFamily.Delete(key, opts)
+}
+
+// Merge merges operand into value of key using merge operator.
+func (db *DB) Merge(key, operand []byte, opts *options.WriteOptions) error {
+	return db.defaultFamily.Merge(key, operand, opts)
+}
+
+// DeleteRange deletes all keys in range [start, limit).
+func (db *DB) DeleteRange(start, limit []byte, opts *options.WriteOptions) error {
+	return db.defaultFamily.DeleteRange(start, limit, opts)
 }
 
 func (db *DB) Write(b batch.Batch, opts *options.WriteOptions) error {
+	return db.write(b, opts, nil)
+}
+
+func (db *DB) write(b batch.Batch, opts *options.WriteOptions, check func() error) error {
+	if db.readOnly {
+		return errors.ErrReadOnly
+	}
 	replyc := make(chan error, 1)
-	db.requestc <- request.Request{Sync: opts.Sync, Batch: b, Reply: replyc}
+	db.requestc <- request.Request{Sync: opts.Sync, DisableWAL: opts.DisableWAL, Batch: b, Reply: replyc, Check: check}
 	return <-replyc
 }
 
@@ -288,79 +432,36 @@ func (db *DB) Close() error {
 
 	close(db.bgClosing)
 	db.bgGroup.Wait()
-	db.storeBundle(nil)
+	for _, cf := range db.columnFamilies() {
+		cf.storeBundle(nil)
+	}
 	if db.locker != nil {
 		db.locker.Close()
 		db.locker = nil
 	}
 	db.closeLog(nil)
 	db.options.Logger.Close()
-	return nil
+	return db.closeErr
 }
 
 func (db *DB) Get(key []byte, opts *options.ReadOptions) ([]byte, error) {
-	return db.get(key, db.manifest.LoadLastSequence(), opts)
-}
-
-func (db *DB) get(key []byte, seq keys.Sequence, opts *options.ReadOptions) ([]byte, error) {
-	bundle := db.loadBundle()
-	if bundle == nil {
-		return nil, errors.ErrDBClosed
-	}
-	ikey := keys.NewInternalKey(key, seq, keys.Seek)
-	memtables := [2]*memtable.MemTable{bundle.mem, bundle.imm}
-	for _, mem := range memtables {
-		if mem == nil {
-			continue
-		}
-		value, err, ok := mem.Get(ikey)
-		if ok {
-			return value, err
-		}
-	}
-	value, seekThroughFile, err := bundle.version.Get(ikey, opts)
-	if seekThroughFile.FileMeta != nil {
-		db.tryCompactFile(seekThroughFile)
-	}
-	return value, err
+	return db.defaultFamily.Get(key, opts)
 }
 
 func (db *DB) All(opts *options.ReadOptions) iterator.Iterator {
-	return db.between(nil, nil, db.manifest.LoadLastSequence(), opts)
+	return db.defaultFamily.All(opts)
 }
 
 func (db *DB) Find(start []byte, opts *options.ReadOptions) iterator.Iterator {
-	return db.between(start, nil, db.manifest.LoadLastSequence(), opts)
+	return db.defaultFamily.Find(start, opts)
 }
 
 func (db *DB) Range(start, limit []byte, opts *options.ReadOptions) iterator.Iterator {
-	return db.between(start, limit, db.manifest.LoadLastSequence(), opts)
+	return db.defaultFamily.Range(start, limit, opts)
 }
 
 func (db *DB) Prefix(prefix []byte, opts *options.ReadOptions) iterator.Iterator {
-	return db.prefix(prefix, db.manifest.LoadLastSequence(), opts)
-}
-
-func (db *DB) prefix(prefix []byte, seq keys.Sequence, opts *options.ReadOptions) iterator.Iterator {
-	limit := db.options.Comparator.UserKeyComparator.MakePrefixSuccessor(prefix)
-	return db.between(prefix, limit, seq, opts)
-}
-
-func (db *DB) between(start, limit []byte, seq keys.Sequence, opts *options.ReadOptions) iterator.Iterator {
-	bundle := db.loadBundle()
-	if bundle == nil {
-		return iterator.Error(errors.ErrDBClosed)
-	}
-	iters := make([]iterator.Iterator, 1, 16)
-	iters[0] = bundle.mem.NewIterator()
-	if bundle.imm != nil {
-		iters = append(iters, bundle.imm.NewIterator())
-	}
-	iters = bundle.version.AppendIterators(iters, opts)
-	mergeIt := iterator.NewMergeIterator(db.options.Comparator, iters...)
-	dbIt := newDBIterator(db, bundle.version, seq, mergeIt)
-	ucmp := db.options.Comparator.UserKeyComparator
-	return iterator.NewRangeIterator(start, limit, ucmp, dbIt)
+	return db.defaultFamily.Prefix(prefix, opts)
 }
 
 func (db *DB) finalize() {
@@ -375,22 +476,29 @@ func initDB(db *DB, name string, m *manifest.Manifest, locker io.Closer, opts *o
 	db.options = opts
 	db.closed = make(chan struct{})
 	db.bgClosing = make(chan struct{})
-	db.bundle = &bundle{version: m.Version()}
+	for _, family := range m.ColumnFamilies() {
+		db.families = append(db.families, newColumnFamily(db, family))
+	}
+	db.defaultFamily = db.families[0]
 	db.requestc = make(chan request.Request, 1024)
 	db.requests = make(chan request.Request)
 	db.requestw = make(chan struct{}, 1)
+	db.flushRequests = make(chan *flushRequest)
+	db.checkpointRequests = make(chan *checkpointRequest)
 	db.nextLogFile = make(chan file.File, 1)
 	db.nextLogFileErr = make(chan error, 1)
 	db.manifestErrChan = make(chan error, 1)
 	db.compactionErrChan = make(chan error, 1)
-	db.memtableEdit = make(chan *manifest.Edit, 1)
+	db.memtableEdit = make(chan compactionEdit, 1)
 	db.compactionEdit = make(chan compactionEdit, configs.NumberLevels)
 	db.compactionResult = make(chan compactionResult, configs.NumberLevels)
-	db.compactionFile = make(chan manifest.LevelFileMeta, 128)
+	db.compactionFile = make(chan fileCompaction, 128)
 	db.compactionLevel = make(chan struct{}, 1)
-	db.compactionMemtable = make(chan *memtable.MemTable, 1)
+	db.compactionMemtable = make(chan memtableCompaction, 1)
+	db.compactionRange = make(chan *rangeCompaction)
 	db.obsoleteFilesChan = make(chan uint64, configs.NumberLevels)
 	db.snapshots.Init()
+	db.locks.init()
 	runtime.SetFinalizer(db, (*DB).finalize)
 }
 
@@ -407,7 +515,6 @@ func createDB(dbname string, locker io.Closer, opts *options.Options) (*DB, erro
 	}
 	db := &DB{}
 	initDB(db, dbname, manifest, locker, opts)
-	db.bundle.mem = memtable.New(opts.Comparator)
 	db.openLog(logFile, 0, logNumber)
 	db.bgGroup.Add(1)
 	go db.serveWrite()
@@ -419,6 +526,24 @@ func recoverDB(dbname string, locker io.Closer, opts *options.Options) (db *DB,
 	if err != nil {
 		return nil, err
 	}
+	logs, err := recoverableLogs(dbname, manifest, opts)
+	if err != nil {
+		return nil, err
+	}
+	db = &DB{}
+	initDB(db, dbname, manifest, locker, opts)
+	if err := db.recoverLogs(logs); err != nil {
+		db.closeLog(nil)
+		return nil, err
+	}
+	db.bgGroup.Add(1)
+	go db.serveWrite()
+	return db, nil
+}
+
+// recoverableLogs returns log files which may contain updates not written
+// to tables. It fails if files referenced by manifest are missing.
+func recoverableLogs(dbname string, manifest *manifest.Manifest, opts *options.Options) ([]uint64, error) {
 	filenames, err := opts.FileSystem.List(dbname)
 	if err != nil {
 		return nil, err
@@ -429,7 +554,7 @@ func recoverDB(dbname string, locker io.Closer, opts *options.Options) (db *DB,
 	for _, filename := range filenames {
 		kind, number := files.Parse(filename)
 		switch kind {
-		case files.Table:
+		case files.Table, files.Blob:
 			delete(tables, number)
 		case files.Log:
 			if number >= logNumber {
@@ -438,15 +563,7 @@ func recoverDB(dbname string, locker io.Closer, opts *options.Options) (db *DB,
 		}
 	}
 	if len(tables) != 0 {
-		return nil, fmt.Errorf("leveldb: missing tables: %v", tables)
-	}
-	db = &DB{}
-	initDB(db, dbname, manifest, locker, opts)
-	if err := db.recoverLogs(logs); err != nil {
-		db.closeLog(nil)
-		return nil, err
+		return nil, fmt.Errorf("leveldb: missing tables or blob files: %v", tables)
 	}
-	db.bgGroup.Add(1)
-	go db.serveWrite()
-	return db, nil
+	return logs, nil
 }
@@ -8,16 +8,27 @@ import (
 	"github.com/kezhuw/leveldb/internal/iterator"
 	"github.com/kezhuw/leveldb/internal/keys"
 	"github.com/kezhuw/leveldb/internal/manifest"
+	"github.com/kezhuw/leveldb/internal/merge"
+	"github.com/kezhuw/leveldb/internal/rangedel"
+	"github.com/kezhuw/leveldb/internal/ttl"
 	"github.com/kezhuw/leveldb/internal/util"
 )
 
 type dbIterator struct {
-	db *DB
+	cf *ColumnFamily
 	// Keep it away from GC, this way level files iterator seeks in
-	// wouldn't got deleted due to umount from manifest.
+	// wouldn't got deleted due to umount from manifest. It is nil if
+	// this iterator does not iterate level files directly.
 	base     *manifest.Version
 	ucmp     keys.Comparator
 	sequence keys.Sequence
+	// Time in nanoseconds since Unix epoch to expire values.
+	now int64
+
+	// Range tombstones from memtables and files of base.
+	tombstones rangedel.List
+	// Nil if base is nil.
+	tombstoneLookup *manifest.TombstoneLookup
 
 	err       error
 	status    iterator.Status
@@ -30,6 +41,21 @@ type dbIterator struct {
 	lastValue []byte
 	parsedKey keys.ParsedInternalKey
 
+	// Merge operands of current key. In forward direction, merged is true
+	// if lastValue is merged value and underlying iterator has moved past
+	// entries of current key. In reverse direction, operands are ordered
+	// from oldest to newest, and existing states whether lastValue contains
+	// value they merge into.
+	operands [][]byte
+	existing bool
+	merged   bool
+
+	// Whether current entry in forward direction is an expiring value.
+	expiring bool
+	// Whether current entry in forward direction is a blob index, whose
+	// value is read into lastValue.
+	blob bool
+
 	rnd         *rand.Rand
 	sampleBytes int
 
@@ -39,6 +65,7 @@ type dbIterator struct {
 
 func (it *dbIterator) First() bool {
 	it.err = nil
+	it.merged = false
 	it.direction = iterator.Forward
 	if !it.iterator.First() {
 		it.status = iterator.Invalid
@@ -50,6 +77,7 @@ func (it *dbIterator) First() bool {
 
 func (it *dbIterator) Last() bool {
 	it.err = nil
+	it.merged = false
 	it.direction = iterator.Reverse
 	if !it.iterator.Last() {
 		it.err = it.iterator.Err()
@@ -62,6 +90,7 @@ func (it *dbIterator) Last() bool {
 
 func (it *dbIterator) Seek(key []byte) bool {
 	it.err = nil
+	it.merged = false
 	it.lastKey = append(it.lastKey[:0], key...)
 	it.lastKey = append(it.lastKey, it.tag[:]...)
 	if !it.iterator.Seek(it.lastKey) {
@@ -100,6 +129,14 @@ func (it *dbIterator) Next() bool {
 		it.direction = iterator.Forward
 		fallthrough
 	default:
+		if it.merged {
+			it.merged = false
+			if !it.iterator.Valid() {
+				it.err = it.iterator.Err()
+				it.status = iterator.Invalid
+				return false
+			}
+		}
 		return it.findNextEntry(true)
 	}
 }
@@ -115,6 +152,13 @@ func (it *dbIterator) Prev() bool {
 		// sequence(<= it.sequence) and Kind keys.Value. There is no
 		// possibility that previous valid entry has same user key as
 		// current, if that, it should shadow current one.
+		//
+		// If current value is merged, underlying iterator has moved past
+		// entries of current key, seek back to them first.
+		if it.merged {
+			it.merged = false
+			it.iterator.Seek(keys.NewInternalKey(it.lastKey, it.sequence, keys.Seek))
+		}
 		it.iterator.Prev()
 		it.direction = iterator.Reverse
 		fallthrough
@@ -132,8 +176,14 @@ func (it *dbIterator) Key() []byte {
 }
 
 func (it *dbIterator) Value() []byte {
-	switch it.direction {
-	case iterator.Forward:
+	switch {
+	case it.direction == iterator.Forward && !it.merged:
+		if it.blob {
+			return it.lastValue
+		}
+		if it.expiring {
+			return it.iterator.Value()[ttl.HeaderSize:]
+		}
 		return it.iterator.Value()
 	default:
 		return it.lastValue
@@ -149,10 +199,11 @@ func (it *dbIterator) finalize() error {
 		return nil
 	}
 	it.status = iterator.Closed
-	it.db = nil
+	it.cf = nil
 	it.err = util.FirstError(it.err, it.iterator.Close())
 	it.base = nil
 	it.iterator = nil
+	it.tombstoneLookup = nil
 	return it.Err()
 }
 
@@ -161,6 +212,30 @@ func (it *dbIterator) Close() error {
 	return it.finalize()
 }
 
+// isRangeDeleted returns whether entry ikey is deleted by range tombstones
+// visible to this iterator.
+func (it *dbIterator) isRangeDeleted(ikey *keys.ParsedInternalKey) (bool, error) {
+	switch {
+	case it.tombstones.MaxSequence(it.ucmp, ikey.UserKey, it.sequence) > ikey.Sequence:
+		return true, nil
+	case it.tombstoneLookup == nil:
+		return false, nil
+	}
+	seq, err := it.tombstoneLookup.MaxSequence(ikey.UserKey, it.sequence)
+	return seq > ikey.Sequence, err
+}
+
+// readBlob reads value of current blob index entry.
+func (it *dbIterator) readBlob() ([]byte, error) {
+	return it.cf.db.manifest.Blobs().ReadBlob(it.iterator.Value())
+}
+
+// isExpired returns whether expiring value has expired to this iterator.
+func (it *dbIterator) isExpired(value []byte) (bool, error) {
+	_, live, err := ttl.Live(value, it.now)
+	return !live, err
+}
+
 func (it *dbIterator) findNextEntry(skip bool) bool {
 	ikey := &it.parsedKey
 	for {
@@ -174,11 +249,38 @@ func (it *dbIterator) findNextEntry(skip bool) bool {
 			case keys.Delete:
 				it.lastKey = append(it.lastKey[:0], ikey.UserKey...)
 				skip = true
-			case keys.Value:
+			case keys.Value, keys.Merge, keys.ExpiringValue, keys.BlobIndex:
 				if skip && it.ucmp.Compare(ikey.UserKey, it.lastKey) <= 0 {
 					break
 				}
+				deleted, err := it.isRangeDeleted(ikey)
+				if err == nil && !deleted && ikey.Kind == keys.ExpiringValue {
+					deleted, err = it.isExpired(it.iterator.Value())
+				}
+				if err != nil {
+					it.err = err
+					it.status = iterator.Invalid
+					return false
+				}
 				it.lastKey = append(it.lastKey[:0], ikey.UserKey...)
+				if deleted {
+					skip = true
+					break
+				}
+				if ikey.Kind == keys.Merge {
+					return it.mergeNext()
+				}
+				if ikey.Kind == keys.BlobIndex {
+					value, err := it.readBlob()
+					if err != nil {
+						it.err = err
+						it.status = iterator.Invalid
+						return false
+					}
+					it.lastValue = value
+				}
+				it.expiring = ikey.Kind == keys.ExpiringValue
+				it.blob = ikey.Kind == keys.BlobIndex
 				return true
 			default:
 				it.err = errors.ErrCorruptInternalKey
@@ -194,8 +296,109 @@ func (it *dbIterator) findNextEntry(skip bool) bool {
 	}
 }
 
+// mergeNext folds merge operands, starting from current entry, with older
+// entries of current key in forward direction.
+func (it *dbIterator) mergeNext() bool {
+	ikey := &it.parsedKey
+	operands := append(it.operands[:0], dupBytes(it.iterator.Value()))
+	var existing []byte
+	for it.iterator.Next() {
+		if !ikey.Parse(it.iterator.Key()) {
+			it.err = errors.ErrCorruptInternalKey
+			it.status = iterator.Invalid
+			return false
+		}
+		if it.ucmp.Compare(ikey.UserKey, it.lastKey) != 0 {
+			break
+		}
+		deleted, err := it.isRangeDeleted(ikey)
+		if err != nil {
+			it.err = err
+			it.status = iterator.Invalid
+			return false
+		}
+		if deleted || ikey.Kind == keys.Delete {
+			break
+		}
+		if ikey.Kind == keys.Value {
+			existing = append([]byte{}, it.iterator.Value()...)
+			break
+		}
+		if ikey.Kind == keys.ExpiringValue {
+			value, live, err := ttl.Live(it.iterator.Value(), it.now)
+			if err != nil {
+				it.err = err
+				it.status = iterator.Invalid
+				return false
+			}
+			if live {
+				existing = append([]byte{}, value...)
+			}
+			break
+		}
+		if ikey.Kind == keys.BlobIndex {
+			value, err := it.readBlob()
+			if err != nil {
+				it.err = err
+				it.status = iterator.Invalid
+				return false
+			}
+			existing = value
+			break
+		}
+		if ikey.Kind != keys.Merge {
+			it.err = errors.ErrCorruptInternalKey
+			it.status = iterator.Invalid
+			return false
+		}
+		operands = append(operands, dupBytes(it.iterator.Value()))
+	}
+	it.operands = operands
+	if err := it.iterator.Err(); err != nil {
+		it.err = err
+		it.status = iterator.Invalid
+		return false
+	}
+	value, err := merge.Merge(it.cf.options.MergeOperator, it.lastKey, existing, operands)
+	if err != nil {
+		it.err = err
+		it.status = iterator.Invalid
+		return false
+	}
+	it.lastValue = value
+	it.merged = true
+	return true
+}
+
+// mergePrev folds merge operands of current key in reverse direction.
+func (it *dbIterator) mergePrev() bool {
+	n := len(it.operands)
+	if n == 0 {
+		return true
+	}
+	for i := 0; i < n/2; i++ {
+		it.operands[i], it.operands[n-1-i] = it.operands[n-1-i], it.operands[i]
+	}
+	var existing []byte
+	if it.existing {
+		existing = it.lastValue
+	}
+	value, err := merge.Merge(it.cf.options.MergeOperator, it.lastKey, existing, it.operands)
+	if err != nil {
+		it.err = err
+		it.status = iterator.Invalid
+		return false
+	}
+	it.lastValue = value
+	return true
+}
+
+func dupBytes(b []byte) []byte {
+	return append([]byte(nil), b...)
+}
+
 func (it *dbIterator) randomSampleBytes() int {
-	return it.rnd.Intn(2 * it.db.options.IterationBytesPerSampleSeek)
+	return it.rnd.Intn(2 * it.cf.options.IterationBytesPerSampleSeek)
 }
 
 func (it *dbIterator) parseKey(ikey *keys.ParsedInternalKey) bool {
@@ -205,12 +408,15 @@ func (it *dbIterator) parseKey(ikey *keys.ParsedInternalKey) bool {
 		it.status = iterator.Invalid
 		return false
 	}
+	if it.base == nil {
+		return true
+	}
 	it.sampleBytes -= len(key) + len(it.iterator.Value())
 	for it.sampleBytes < 0 {
 		it.sampleBytes += it.randomSampleBytes()
-		seekOverlapFile := it.db.manifest.Version().SeekOverlap(key, nil)
+		seekOverlapFile := it.cf.family.Version().SeekOverlap(key, nil)
 		if seekOverlapFile.FileMeta != nil {
-			it.db.tryCompactFile(seekOverlapFile)
+			it.cf.tryCompactFile(seekOverlapFile)
 		}
 	}
 	return true
@@ -231,17 +437,53 @@ func (it *dbIterator) findPrevEntry() bool {
 		}
 		if ikey.Sequence <= it.sequence {
 			if !skip && it.ucmp.Compare(ikey.UserKey, it.lastKey) < 0 {
-				return true
+				return it.mergePrev()
+			}
+			deleted := ikey.Kind == keys.Delete
+			if ikey.Kind == keys.Value || ikey.Kind == keys.Merge || ikey.Kind == keys.ExpiringValue || ikey.Kind == keys.BlobIndex {
+				var err error
+				deleted, err = it.isRangeDeleted(ikey)
+				if err == nil && !deleted && ikey.Kind == keys.ExpiringValue {
+					deleted, err = it.isExpired(it.iterator.Value())
+				}
+				if err != nil {
+					it.err = err
+					it.status = iterator.Invalid
+					return false
+				}
 			}
 			switch {
-			case ikey.Kind == keys.Delete:
+			case deleted:
 				skip = true
 				it.status = iterator.Invalid
-			case ikey.Kind == keys.Value:
+			case ikey.Kind == keys.Value || ikey.Kind == keys.ExpiringValue || ikey.Kind == keys.BlobIndex:
+				value := it.iterator.Value()
+				switch ikey.Kind {
+				case keys.ExpiringValue:
+					value = value[ttl.HeaderSize:]
+				case keys.BlobIndex:
+					var err error
+					if value, err = it.readBlob(); err != nil {
+						it.err = err
+						it.status = iterator.Invalid
+						return false
+					}
+				}
 				skip = false
 				it.status = iterator.Valid
 				it.lastKey = append(it.lastKey[:0], ikey.UserKey...)
-				it.lastValue = append(it.lastValue[:0], it.iterator.Value()...)
+				it.lastValue = append(it.lastValue[:0], value...)
+				it.operands = it.operands[:0]
+				it.existing = true
+			case ikey.Kind == keys.Merge:
+				if skip {
+					it.operands = it.operands[:0]
+					it.existing = false
+				}
+				skip = false
+				it.status = iterator.Valid
+				it.lastKey = append(it.lastKey[:0], ikey.UserKey...)
+				it.operands = append(it.operands, dupBytes(it.iterator.Value()))
 			default:
 				it.err = errors.ErrCorruptInternalKey
 				it.status = iterator.Invalid
@@ -256,20 +498,25 @@ func (it *dbIterator) findPrevEntry() bool {
 				it.status = iterator.Invalid
 				return false
 			}
-			return skip == false
+			return skip == false && it.mergePrev()
 		}
 	}
 }
 
-func newDBIterator(db *DB, base *manifest.Version, seq keys.Sequence, it iterator.Iterator) iterator.Iterator {
+func newDBIterator(cf *ColumnFamily, base *manifest.Version, tombstones rangedel.List, seq keys.Sequence, it iterator.Iterator) iterator.Iterator {
 	rnd := rand.New(rand.NewSource(rand.Int63()))
 	dbIt := &dbIterator{
-		db:       db,
-		base:     base,
-		ucmp:     db.options.Comparator.UserKeyComparator,
-		iterator: it,
-		sequence: seq,
-		rnd:      rnd,
+		cf:         cf,
+		base:       base,
+		ucmp:       cf.options.Comparator.UserKeyComparator,
+		iterator:   it,
+		sequence:   seq,
+		now:        cf.options.Now(),
+		tombstones: tombstones,
+		rnd:        rnd,
+	}
+	if base != nil {
+		dbIt.tombstoneLookup = base.NewTombstoneLookup()
 	}
 	runtime.SetFinalizer(dbIt, (*dbIterator).finalize)
 	keys.CombineTag(dbIt.tag[:], seq, keys.Seek)
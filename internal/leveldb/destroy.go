@@ -0,0 +1,50 @@
+package leveldb
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/kezhuw/leveldb/internal/files"
+	"github.com/kezhuw/leveldb/internal/options"
+)
+
+// Destroy removes all database files in directory dbname, and the directory
+// itself if it becomes empty. Unrecognized files are left untouched.
+func Destroy(dbname string, opts *options.Options) error {
+	fs := opts.FileSystem
+	filenames, err := fs.List(dbname)
+	switch {
+	case os.IsNotExist(err):
+		return nil
+	case err != nil:
+		return err
+	}
+	lockName := files.LockFileName(dbname)
+	locker, err := fs.Lock(lockName)
+	if err != nil {
+		return err
+	}
+	var removed int
+	for _, name := range filenames {
+		kind, _ := files.Parse(name)
+		switch kind {
+		case files.Invalid:
+			continue
+		case files.Lock:
+			// Remove it after unlock.
+			removed++
+			continue
+		}
+		if rerr := fs.Remove(filepath.Join(dbname, name)); rerr != nil && err == nil {
+			err = rerr
+			continue
+		}
+		removed++
+	}
+	locker.Close()
+	fs.Remove(lockName)
+	if err == nil && removed == len(filenames) {
+		fs.Remove(dbname)
+	}
+	return err
+}
@@ -0,0 +1,99 @@
+package leveldb
+
+import (
+	"sync"
+	"time"
+
+	"github.com/kezhuw/leveldb/internal/errors"
+)
+
+type keyLock struct {
+	owner *Txn
+	// Closed after lock released.
+	released chan struct{}
+}
+
+// lockManager manages exclusive key locks held by pessimistic transactions.
+type lockManager struct {
+	mu    sync.Mutex
+	locks map[string]*keyLock
+	// Keys which blocked transactions are waiting for. A transaction waits
+	// for at most one key at a time, so waiting transactions and owners of
+	// keys they are waiting for form chains, and deadlocks are cycles.
+	waiting map[*Txn]string
+}
+
+func (m *lockManager) init() {
+	m.locks = make(map[string]*keyLock)
+	m.waiting = make(map[*Txn]string)
+}
+
+// deadlocked returns whether waiting for key owned by owner forms a cycle
+// back to txn.
+func (m *lockManager) deadlocked(txn, owner *Txn) bool {
+	for owner != txn {
+		key, ok := m.waiting[owner]
+		if !ok {
+			return false
+		}
+		l := m.locks[key]
+		if l == nil {
+			// Lock released, owner is going to acquire it.
+			return false
+		}
+		owner = l.owner
+	}
+	return true
+}
+
+// Lock locks key for txn. It returns ErrDeadlock if waiting for this key
+// will cause deadlock, or ErrLockTimeout if key is not unlocked by others
+// in timeout.
+func (m *lockManager) Lock(txn *Txn, key string, timeout time.Duration) error {
+	var timer *time.Timer
+	m.mu.Lock()
+	for {
+		l := m.locks[key]
+		switch {
+		case l == nil:
+			delete(m.waiting, txn)
+			m.locks[key] = &keyLock{owner: txn, released: make(chan struct{})}
+			m.mu.Unlock()
+			return nil
+		case l.owner == txn:
+			m.mu.Unlock()
+			return nil
+		case m.deadlocked(txn, l.owner):
+			delete(m.waiting, txn)
+			m.mu.Unlock()
+			return errors.ErrDeadlock
+		}
+		m.waiting[txn] = key
+		m.mu.Unlock()
+		if timer == nil {
+			timer = time.NewTimer(timeout)
+			defer timer.Stop()
+		}
+		select {
+		case <-l.released:
+		case <-timer.C:
+			m.mu.Lock()
+			delete(m.waiting, txn)
+			m.mu.Unlock()
+			return errors.ErrLockTimeout
+		}
+		m.mu.Lock()
+	}
+}
+
+// Unlock unlocks keys locked by txn.
+func (m *lockManager) Unlock(txn *Txn, keys []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, key := range keys {
+		if l := m.locks[key]; l != nil && l.owner == txn {
+			delete(m.locks, key)
+			close(l.released)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package leveldb
 
 import (
 	"path/filepath"
+	"sort"
 
 	"github.com/kezhuw/leveldb/internal/files"
 )
@@ -41,19 +42,39 @@ func (db *DB) removeObsoleteFiles(tableNumber, logNumber, manifestNumber uint64,
 	}
 	lives := db.manifest.AddLiveFiles(make(map[uint64]struct{}))
 	filenames, _ := db.fs.List(db.name)
+	var logs []uint64
+	if db.options.RetainLogs {
+		for _, name := range filenames {
+			if kind, number := files.Parse(name); kind == files.Log {
+				logs = append(logs, number)
+			}
+		}
+		sort.Slice(logs, func(i, j int) bool { return logs[i] < logs[j] })
+	}
 	for _, name := range filenames {
 		kind, number := files.Parse(name)
 		switch kind {
 		case files.Invalid, files.Lock, files.Current, files.InfoLog, files.Temp:
 			continue
 		case files.Log:
-			if number >= logNumber {
+			if number >= logNumber || db.isLogPinned(number) {
 				continue
 			}
+			if db.options.RetainLogs {
+				if db.isLogRetained(number, logs) {
+					continue
+				}
+				db.forgetLog(number)
+			}
 		case files.Table, files.SSTTable:
 			if _, ok := lives[number]; ok || number >= tableNumber {
 				continue
 			}
+		case files.Blob:
+			if _, ok := lives[number]; ok || number >= tableNumber {
+				continue
+			}
+			db.manifest.Blobs().Evict(number)
 		case files.Manifest:
 			if number >= manifestNumber {
 				continue
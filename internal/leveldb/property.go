@@ -0,0 +1,74 @@
+package leveldb
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/kezhuw/leveldb/internal/configs"
+)
+
+const (
+	propertyPrefix              = "leveldb."
+	propertyNumFilesAtLevel     = "num-files-at-level"
+	propertyStats               = "stats"
+	propertySSTables            = "sstables"
+	propertyApproximateMemUsage = "approximate-memory-usage"
+)
+
+// GetProperty returns value for property name of default column family and
+// true if name is a valid property.
+func (db *DB) GetProperty(name string) (string, bool) {
+	return db.defaultFamily.GetProperty(name)
+}
+
+// GetProperty returns value for property name and true if name is a valid
+// property.
+func (cf *ColumnFamily) GetProperty(name string) (string, bool) {
+	if !strings.HasPrefix(name, propertyPrefix) {
+		return "", false
+	}
+	bundle := cf.loadBundle()
+	if bundle == nil {
+		return "", false
+	}
+	version := bundle.version
+	name = name[len(propertyPrefix):]
+	switch {
+	case strings.HasPrefix(name, propertyNumFilesAtLevel):
+		level, err := strconv.ParseUint(name[len(propertyNumFilesAtLevel):], 10, 32)
+		if err != nil || level >= configs.NumberLevels {
+			return "", false
+		}
+		return strconv.Itoa(len(version.Levels[level])), true
+	case name == propertyStats:
+		var buf bytes.Buffer
+		buf.WriteString("                               Compactions\n")
+		buf.WriteString("Level  Files Size(MB) Time(sec) Read(MB) Write(MB)\n")
+		buf.WriteString("--------------------------------------------------\n")
+		for level, files := range version.Levels[:] {
+			stats := cf.loadCompactionStats(level)
+			if len(files) == 0 && stats.count == 0 {
+				continue
+			}
+			fmt.Fprintf(&buf, "%3d %8d %8.0f %9.0f %8.0f %9.0f\n",
+				level,
+				len(files),
+				float64(files.TotalFileSize())/1048576.0,
+				stats.duration.Seconds(),
+				float64(stats.bytesRead)/1048576.0,
+				float64(stats.bytesWritten)/1048576.0)
+		}
+		return buf.String(), true
+	case name == propertySSTables:
+		return version.String(), true
+	case name == propertyApproximateMemUsage:
+		usage := bundle.mem.ApproximateMemoryUsage()
+		if bundle.imm != nil {
+			usage += bundle.imm.ApproximateMemoryUsage()
+		}
+		return strconv.Itoa(usage), true
+	}
+	return "", false
+}
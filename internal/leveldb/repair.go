@@ -0,0 +1,625 @@
+package leveldb
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/kezhuw/leveldb/internal/batch"
+	"github.com/kezhuw/leveldb/internal/blob"
+	"github.com/kezhuw/leveldb/internal/compactor"
+	"github.com/kezhuw/leveldb/internal/file"
+	"github.com/kezhuw/leveldb/internal/files"
+	"github.com/kezhuw/leveldb/internal/keys"
+	"github.com/kezhuw/leveldb/internal/logger"
+	"github.com/kezhuw/leveldb/internal/manifest"
+	"github.com/kezhuw/leveldb/internal/memtable"
+	"github.com/kezhuw/leveldb/internal/options"
+	"github.com/kezhuw/leveldb/internal/record"
+	"github.com/kezhuw/leveldb/internal/table"
+)
+
+const lostDirName = "lost"
+
+type repairer struct {
+	dbname  string
+	fs      file.FileSystem
+	options *options.Options
+	logger  logger.Logger
+
+	manifests []uint64
+	logs      []uint64
+	tables    map[uint64]string
+	blobs     map[uint64]string
+	// Bytes of blob values referenced from recovered tables.
+	blobBytes map[uint64]uint64
+
+	// Live column families, dropped ones and column families of tables
+	// and blob files, recovered from old manifests and tables.
+	families        map[uint32]*repairFamily
+	dropped         map[uint32]bool
+	maxColumnFamily uint32
+	tableFamilies   map[uint64]uint32
+	blobFamilies    map[uint64]uint32
+
+	nextFileNumber uint64
+	lastSequence   keys.Sequence
+}
+
+// repairFamily is a column family being repaired.
+type repairFamily struct {
+	id      uint32
+	name    string
+	options *options.Options
+	cache   *table.Cache
+
+	files manifest.FileList
+}
+
+// Repair recovers as much data as possible from a database which can't be
+// opened. All tables and logs in database directory are scanned, logs are
+// converted to tables. Unreadable files are moved to directory "lost" in
+// database directory. A new manifest is written to reference all recovered
+// tables in level 0, and blob files referenced by them.
+//
+// Column families, and which column families tables belong to, are recovered
+// from old manifests. Tables of unknown column families are moved to "lost"
+// too. If no manifest is readable, all tables are assumed to belong to default
+// column family. Repair fails if logs contain updates of unknown column
+// families.
+func Repair(dbname string, opts *options.Options) error {
+	fs := opts.FileSystem
+	locker, err := fs.Lock(files.LockFileName(dbname))
+	if err != nil {
+		return err
+	}
+	defer locker.Close()
+
+	r := &repairer{
+		dbname:    dbname,
+		fs:        fs,
+		options:   opts,
+		logger:    opts.Logger,
+		tables:    make(map[uint64]string),
+		blobs:     make(map[uint64]string),
+		blobBytes: make(map[uint64]uint64),
+
+		families:      make(map[uint32]*repairFamily),
+		dropped:       make(map[uint32]bool),
+		tableFamilies: make(map[uint64]uint32),
+		blobFamilies:  make(map[uint64]uint32),
+	}
+	if r.logger == nil {
+		r.logger = logger.Discard
+	}
+	r.addFamily(0, manifest.DefaultColumnFamilyName)
+	if err := r.findFiles(); err != nil {
+		return err
+	}
+	r.readManifests()
+	if err := r.convertLogs(); err != nil {
+		return err
+	}
+	if err := r.scanTables(); err != nil {
+		return err
+	}
+	return r.writeManifest()
+}
+
+func (r *repairer) findFiles() error {
+	filenames, err := r.fs.List(r.dbname)
+	if err != nil {
+		return err
+	}
+	for _, name := range filenames {
+		kind, number := files.Parse(name)
+		switch kind {
+		case files.Manifest:
+			r.manifests = append(r.manifests, number)
+		case files.Log:
+			r.logs = append(r.logs, number)
+		case files.Table:
+			r.tables[number] = filepath.Join(r.dbname, name)
+		case files.Blob:
+			r.blobs[number] = filepath.Join(r.dbname, name)
+		default:
+			continue
+		}
+		if number >= r.nextFileNumber {
+			r.nextFileNumber = number + 1
+		}
+	}
+	if len(r.manifests) == 0 && len(r.logs) == 0 && len(r.tables) == 0 {
+		return fmt.Errorf("leveldb: repair found no files in %s", r.dbname)
+	}
+	sort.Sort(byOldestFileNumber(r.manifests))
+	sort.Sort(byOldestFileNumber(r.logs))
+	return nil
+}
+
+func (r *repairer) addFamily(id uint32, name string) {
+	if r.dropped[id] {
+		return
+	}
+	opts := r.options
+	if id != 0 {
+		opts = opts.ColumnFamilyOptions(name)
+	}
+	r.families[id] = &repairFamily{
+		id:      id,
+		name:    name,
+		options: opts,
+		cache:   table.NewCache(r.dbname, opts),
+	}
+}
+
+// readManifests recovers column families and which column families tables
+// belong to from manifests. Records after the first corrupted one in each
+// manifest are ignored.
+func (r *repairer) readManifests() {
+	for _, number := range r.manifests {
+		if err := r.readManifest(number); err != nil {
+			r.logger.Errorf("repair: ignore records after corruption in manifest %d: %s", number, err)
+		}
+	}
+}
+
+func (r *repairer) readManifest(number uint64) error {
+	f, err := r.fs.Open(files.ManifestFileName(r.dbname, number), os.O_RDONLY)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	reader := record.NewReader(f)
+	var edit manifest.Edit
+	var buf []byte
+	for {
+		buf, err = reader.AppendRecord(buf[:0])
+		switch err {
+		case nil:
+		case io.EOF:
+			return nil
+		default:
+			return err
+		}
+		edit.Reset()
+		if err := edit.Decode(buf); err != nil {
+			return err
+		}
+		id := edit.ColumnFamily
+		switch {
+		case edit.AddedColumnFamily != "":
+			r.addFamily(id, edit.AddedColumnFamily)
+		case edit.DroppedColumnFamily:
+			delete(r.families, id)
+			r.dropped[id] = true
+		}
+		if id > r.maxColumnFamily {
+			r.maxColumnFamily = id
+		}
+		if edit.MaxColumnFamily > r.maxColumnFamily {
+			r.maxColumnFamily = edit.MaxColumnFamily
+		}
+		for _, f := range edit.AddedFiles {
+			r.tableFamilies[f.Number] = id
+		}
+	}
+}
+
+func (r *repairer) newFileNumber() uint64 {
+	number := r.nextFileNumber
+	r.nextFileNumber++
+	return number
+}
+
+// archiveFile moves file to lost directory, so it will not be picked up by
+// later repairs.
+func (r *repairer) archiveFile(name string) error {
+	lostDir := filepath.Join(r.dbname, lostDirName)
+	err := r.fs.MkdirAll(lostDir)
+	if err == nil {
+		err = r.fs.Rename(name, filepath.Join(lostDir, filepath.Base(name)))
+	}
+	if err != nil {
+		r.logger.Errorf("repair: fail to archive file %s: %s", name, err)
+	}
+	return err
+}
+
+func (r *repairer) convertLogs() error {
+	for _, logNumber := range r.logs {
+		// Keep log if its updates could not be written to table, so they
+		// could be recovered by later repairs.
+		if err := r.convertLog(logNumber); err != nil {
+			r.logger.Errorf("repair: fail to convert log %d to table: %s", logNumber, err)
+			return err
+		}
+		if err := r.archiveFile(files.LogFileName(r.dbname, logNumber)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// convertLog replays records in log to table files, one for each column
+// family. Records after the first corrupted one are dropped. Errors in
+// reading log are logged, not returned.
+func (r *repairer) convertLog(logNumber uint64) error {
+	logFile, err := r.fs.Open(files.LogFileName(r.dbname, logNumber), os.O_RDONLY)
+	if err != nil {
+		r.logger.Errorf("repair: fail to open log %d: %s", logNumber, err)
+		return nil
+	}
+	defer logFile.Close()
+	inserter := familyInserter{repairer: r, mems: make(map[uint32]*memtable.MemTable)}
+	reader := record.NewReader(logFile)
+	var batch batch.Batch
+	var buf []byte
+	for {
+		buf, err = reader.AppendRecord(buf[:0])
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			batch.Reset(buf)
+			err = batch.Iterate(&inserter)
+		}
+		if err != nil {
+			r.logger.Errorf("repair: drop records after offset %d in log %d: %s", reader.Offset(), logNumber, err)
+			break
+		}
+	}
+	if inserter.unknown {
+		return fmt.Errorf("leveldb: log %d contains updates of unknown column family %d", logNumber, inserter.unknownFamily)
+	}
+	ids := make([]uint32, 0, len(inserter.mems))
+	for id := range inserter.mems {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	var tables []uint64
+	for _, id := range ids {
+		tableNumber := r.newFileNumber()
+		tableName := files.TableFileName(r.dbname, tableNumber)
+		file, err := compactor.CompactMemTable(tableNumber, tableName, keys.MaxSequence, inserter.mems[id], r.families[id].options)
+		switch {
+		case err != nil:
+			// Tables not referenced by manifests are of unknown column
+			// families to later repairs.
+			for _, number := range tables {
+				r.fs.Remove(r.tables[number])
+				delete(r.tables, number)
+				delete(r.tableFamilies, number)
+			}
+			return err
+		case file == nil:
+			r.fs.Remove(tableName)
+			continue
+		}
+		r.tables[tableNumber] = tableName
+		r.tableFamilies[tableNumber] = id
+		tables = append(tables, tableNumber)
+	}
+	return nil
+}
+
+// familyInserter inserts updates to memtables of their column families.
+// Updates of dropped column families are discarded.
+type familyInserter struct {
+	*repairer
+	mems map[uint32]*memtable.MemTable
+
+	unknown       bool
+	unknownFamily uint32
+}
+
+func (ins *familyInserter) Add(seq keys.Sequence, kind keys.Kind, key, value []byte) {
+	ins.AddColumnFamily(0, seq, kind, key, value)
+}
+
+func (ins *familyInserter) AddColumnFamily(id uint32, seq keys.Sequence, kind keys.Kind, key, value []byte) {
+	family := ins.families[id]
+	switch {
+	case family == nil && ins.dropped[id]:
+		return
+	case family == nil:
+		ins.unknown, ins.unknownFamily = true, id
+		return
+	}
+	mem := ins.mems[id]
+	if mem == nil {
+		mem = memtable.New(family.options.Comparator)
+		ins.mems[id] = mem
+	}
+	mem.Add(seq, kind, key, value)
+}
+
+func (r *repairer) scanTables() error {
+	numbers := make([]uint64, 0, len(r.tables))
+	for number := range r.tables {
+		numbers = append(numbers, number)
+	}
+	sort.Sort(byOldestFileNumber(numbers))
+	for _, number := range numbers {
+		name := r.tables[number]
+		family, ok := r.tableFamily(number)
+		switch {
+		case !ok:
+			// Tables of dropped column families are obsolete.
+			continue
+		case family == nil:
+			r.logger.Errorf("repair: column family of table %d is unknown", number)
+		default:
+			file, err := r.scanTable(family, number, name)
+			if err == nil {
+				family.files = append(family.files, file)
+				continue
+			}
+			if file != nil {
+				// Salvage entries before the first corruption.
+				if file, err = r.salvageTable(family, file); err == nil {
+					family.files = append(family.files, file)
+				}
+			}
+		}
+		if err := r.archiveFile(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tableFamily returns column family of table, nil if that column family is
+// unknown. It returns false if table belongs to a dropped column family.
+func (r *repairer) tableFamily(number uint64) (*repairFamily, bool) {
+	id, ok := r.tableFamilies[number]
+	switch {
+	case !ok && len(r.families) == 1 && r.maxColumnFamily == 0:
+		// No column families other than default one.
+		return r.families[0], true
+	case !ok:
+		return nil, true
+	case r.dropped[id]:
+		return nil, false
+	}
+	return r.families[id], true
+}
+
+func (r *repairer) fileSize(name string) (uint64, error) {
+	f, err := r.fs.Open(name, os.O_RDONLY)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(size), nil
+}
+
+// scanTable iterates all entries in table to find its key range and largest
+// sequence. If table is corrupted after some valid entries, it returns an
+// error along with file meta which describes these valid entries.
+func (r *repairer) scanTable(family *repairFamily, number uint64, name string) (*manifest.FileMeta, error) {
+	size, err := r.fileSize(name)
+	if err != nil {
+		return nil, err
+	}
+	defer family.cache.Evict(number)
+	file := &manifest.FileMeta{Number: number, Size: size}
+	it := family.cache.NewIterator(number, size, &options.ReadOptions{VerifyChecksums: true, DontFillCache: true})
+	defer it.Close()
+	var lastSequence keys.Sequence
+	var key keys.ParsedInternalKey
+	for ok := it.First(); ok; ok = it.Next() {
+		if !key.Parse(it.Key()) {
+			err = fmt.Errorf("leveldb: corrupt internal key in table %d", number)
+			break
+		}
+		if file.Smallest == nil {
+			file.Smallest = keys.InternalKey(it.Key()).Dup()
+		}
+		if key.Kind == keys.BlobIndex {
+			p, perr := blob.DecodePointer(it.Value())
+			if perr != nil {
+				err = fmt.Errorf("leveldb: corrupt blob pointer in table %d", number)
+				break
+			}
+			r.blobBytes[p.FileNumber] += p.EntrySize()
+			r.blobFamilies[p.FileNumber] = family.id
+		}
+		file.Largest = append(file.Largest[:0], it.Key()...)
+		if key.Sequence > lastSequence {
+			lastSequence = key.Sequence
+		}
+	}
+	if err == nil {
+		err = it.Err()
+	}
+	if err == nil {
+		err = r.scanRangeTombstones(family, file, &lastSequence)
+	}
+	if file.Smallest == nil {
+		if err == nil {
+			err = fmt.Errorf("leveldb: empty table %d", number)
+		}
+		return nil, err
+	}
+	if err == nil && lastSequence > r.lastSequence {
+		r.lastSequence = lastSequence
+	}
+	return file, err
+}
+
+// scanRangeTombstones expands key range of file to include its range
+// tombstones, and updates lastSequence.
+func (r *repairer) scanRangeTombstones(family *repairFamily, file *manifest.FileMeta, lastSequence *keys.Sequence) error {
+	tombstones, err := family.cache.RangeTombstones(file.Number, file.Size)
+	if err != nil {
+		return err
+	}
+	for i := range tombstones {
+		t := &tombstones[i]
+		file.ExpandRange(family.options.Comparator, t)
+		if t.Sequence > *lastSequence {
+			*lastSequence = t.Sequence
+		}
+	}
+	return nil
+}
+
+// salvageTable copies entries in range [file.Smallest, file.Largest] and
+// range tombstones to a new table.
+func (r *repairer) salvageTable(family *repairFamily, file *manifest.FileMeta) (*manifest.FileMeta, error) {
+	tableNumber := r.newFileNumber()
+	tableName := files.TableFileName(r.dbname, tableNumber)
+	f, err := r.fs.Open(tableName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		f.Close()
+		if err != nil {
+			r.fs.Remove(tableName)
+		}
+	}()
+
+	defer family.cache.Evict(file.Number)
+	it := family.cache.NewIterator(file.Number, file.Size, &options.ReadOptions{VerifyChecksums: true, DontFillCache: true})
+	defer it.Close()
+
+	icmp := family.options.Comparator
+	var w table.Writer
+	w.Reset(f, 0, family.options)
+	var lastSequence keys.Sequence
+	for ok := it.First(); ok && icmp.Compare(it.Key(), file.Largest) <= 0; ok = it.Next() {
+		if _, seq, _ := keys.InternalKey(it.Key()).Split(); seq > lastSequence {
+			lastSequence = seq
+		}
+		w.Add(it.Key(), it.Value())
+	}
+	salvaged := &manifest.FileMeta{
+		Number:   tableNumber,
+		Smallest: file.Smallest,
+		Largest:  file.Largest.Dup(),
+	}
+	tombstones, err := family.cache.RangeTombstones(file.Number, file.Size)
+	if err != nil {
+		return nil, err
+	}
+	for i := range tombstones {
+		t := &tombstones[i]
+		w.AddRangeTombstone(*t)
+		salvaged.ExpandRange(icmp, t)
+		if t.Sequence > lastSequence {
+			lastSequence = t.Sequence
+		}
+	}
+	if err = w.Finish(); err != nil {
+		return nil, err
+	}
+	if err = f.Sync(); err != nil {
+		return nil, err
+	}
+	if lastSequence > r.lastSequence {
+		r.lastSequence = lastSequence
+	}
+	salvaged.Size = uint64(w.FileSize())
+	return salvaged, nil
+}
+
+func (r *repairer) writeManifest() error {
+	ids := make([]uint32, 0, len(r.families))
+	for id := range r.families {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	manifestNumber := r.newFileNumber()
+	edits := make([]*manifest.Edit, 0, len(ids))
+	for _, id := range ids {
+		family := r.families[id]
+		edit := &manifest.Edit{
+			ColumnFamily:   id,
+			ComparatorName: family.options.Comparator.UserKeyComparator.Name(),
+			LogNumber:      r.nextFileNumber,
+		}
+		if id != 0 {
+			edit.AddedColumnFamily = family.name
+		}
+		for _, file := range family.files {
+			edit.AddedFiles = append(edit.AddedFiles, manifest.LevelFileMeta{Level: 0, FileMeta: file})
+		}
+		edit.AddedBlobFiles = r.blobFiles(id)
+		edits = append(edits, edit)
+	}
+	edits[0].NextFileNumber = r.nextFileNumber
+	edits[0].LastSequence = r.lastSequence
+	edits[0].MaxColumnFamily = r.maxColumnFamily
+	if err := writeManifest(r.fs, r.dbname, manifestNumber, edits...); err != nil {
+		return err
+	}
+	for _, number := range r.manifests {
+		if err := r.archiveFile(files.ManifestFileName(r.dbname, number)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// blobFiles returns blob files referenced from recovered tables of column
+// family id, bytes not referenced are garbage. Blob files not referenced are
+// left as obsolete.
+func (r *repairer) blobFiles(id uint32) []manifest.BlobFileMeta {
+	var blobFiles []manifest.BlobFileMeta
+	for number, name := range r.blobs {
+		live := r.blobBytes[number]
+		if live == 0 || r.blobFamilies[number] != id {
+			continue
+		}
+		size, err := r.fileSize(name)
+		if err != nil {
+			continue
+		}
+		f := manifest.BlobFileMeta{Number: number, Size: size}
+		if size > live {
+			f.Garbage = size - live
+		}
+		blobFiles = append(blobFiles, f)
+	}
+	sort.Slice(blobFiles, func(i, j int) bool { return blobFiles[i].Number < blobFiles[j].Number })
+	return blobFiles
+}
+
+// writeManifest writes edits to a new manifest file, and points CURRENT to it.
+func writeManifest(fs file.FileSystem, dbname string, manifestNumber uint64, edits ...*manifest.Edit) (err error) {
+	manifestName := files.ManifestFileName(dbname, manifestNumber)
+	manifestFile, err := fs.Open(manifestName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			fs.Remove(manifestName)
+		}
+	}()
+
+	log := record.NewWriter(manifestFile, 0)
+	for _, edit := range edits {
+		if err = log.Write(edit.Encode(nil)); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = manifestFile.Sync()
+	}
+	if closeErr := manifestFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return files.SetCurrentManifest(fs, dbname, files.CurrentFileName(dbname), manifestNumber)
+}
@@ -56,21 +56,21 @@ func (ss *Snapshot) Release() {
 }
 
 func (ss *Snapshot) Get(key []byte, opts *options.ReadOptions) ([]byte, error) {
-	return ss.db.get(key, ss.seq, opts)
+	return ss.db.defaultFamily.get(key, ss.seq, opts)
 }
 
 func (ss *Snapshot) All(opts *options.ReadOptions) iterator.Iterator {
-	return ss.db.between(nil, nil, ss.seq, opts)
+	return ss.db.defaultFamily.between(nil, nil, ss.seq, opts)
 }
 
 func (ss *Snapshot) Find(start []byte, opts *options.ReadOptions) iterator.Iterator {
-	return ss.db.between(start, nil, ss.seq, opts)
+	return ss.db.defaultFamily.between(start, nil, ss.seq, opts)
 }
 
 func (ss *Snapshot) Range(start, limit []byte, opts *options.ReadOptions) iterator.Iterator {
-	return ss.db.between(start, limit, ss.seq, opts)
+	return ss.db.defaultFamily.between(start, limit, ss.seq, opts)
 }
 
 func (ss *Snapshot) Prefix(prefix []byte, opts *options.ReadOptions) iterator.Iterator {
-	return ss.db.prefix(prefix, ss.seq, opts)
+	return ss.db.defaultFamily.prefix(prefix, ss.seq, opts)
 }
@@ -0,0 +1,49 @@
+package leveldb
+
+import (
+	"time"
+
+	"github.com/kezhuw/leveldb/internal/manifest"
+)
+
+// compactionStats records statistics for compactions whose outputs go to
+// one level.
+type compactionStats struct {
+	count        int
+	duration     time.Duration
+	bytesRead    uint64
+	bytesWritten uint64
+}
+
+func (cf *ColumnFamily) recordCompactionStats(level int, duration time.Duration, bytesRead uint64, edit *manifest.Edit) {
+	var bytesWritten uint64
+	for _, added := range edit.AddedFiles {
+		// Trivial move doesn't write new tables.
+		moved := false
+		for _, deleted := range edit.DeletedFiles {
+			if deleted.Number == added.Number {
+				moved = true
+				break
+			}
+		}
+		if !moved {
+			bytesWritten += added.Size
+		}
+	}
+	for _, added := range edit.AddedBlobFiles {
+		bytesWritten += added.Size
+	}
+	cf.statsMu.Lock()
+	defer cf.statsMu.Unlock()
+	stats := &cf.stats[level]
+	stats.count++
+	stats.duration += duration
+	stats.bytesRead += bytesRead
+	stats.bytesWritten += bytesWritten
+}
+
+func (cf *ColumnFamily) loadCompactionStats(level int) compactionStats {
+	cf.statsMu.Lock()
+	defer cf.statsMu.Unlock()
+	return cf.stats[level]
+}
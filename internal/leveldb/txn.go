@@ -0,0 +1,227 @@
+package leveldb
+
+import (
+	"sort"
+	"time"
+
+	"github.com/kezhuw/leveldb/internal/batch"
+	"github.com/kezhuw/leveldb/internal/errors"
+	"github.com/kezhuw/leveldb/internal/iterator"
+	"github.com/kezhuw/leveldb/internal/keys"
+	"github.com/kezhuw/leveldb/internal/options"
+)
+
+// Txn is a transaction buffering its writes and reading them back.
+//
+// Optimistic transaction reads from snapshot taken at its beginning. It
+// commits only if no keys it read or wrote have been modified since that
+// snapshot.
+//
+// Pessimistic transaction reads latest data. It locks keys it writes or
+// reads for update until it is committed or rolled back.
+type Txn struct {
+	db   *DB
+	done bool
+
+	// Nil for pessimistic transaction.
+	snapshot *Snapshot
+
+	pessimistic bool
+	lockTimeout time.Duration
+
+	batch batch.Batch
+	index *BatchIndex
+
+	// Keys to check conflicts in optimistic transaction, or keys locked
+	// by pessimistic transaction.
+	tracked map[string]struct{}
+}
+
+func (db *DB) BeginTransaction() *Txn {
+	return &Txn{
+		db:       db,
+		snapshot: db.NewSnapshot(),
+		index:    db.NewBatchIndex(),
+		tracked:  make(map[string]struct{}),
+	}
+}
+
+func (db *DB) BeginPessimisticTransaction(opts *options.TxnOptions) *Txn {
+	return &Txn{
+		db:          db,
+		pessimistic: true,
+		lockTimeout: opts.LockTimeout,
+		index:       db.NewBatchIndex(),
+		tracked:     make(map[string]struct{}),
+	}
+}
+
+// track tracks key for conflict checking in optimistic transaction, or locks
+// key in pessimistic transaction.
+func (t *Txn) track(key []byte) error {
+	if _, ok := t.tracked[string(key)]; ok {
+		return nil
+	}
+	if t.pessimistic {
+		if err := t.db.locks.Lock(t, string(key), t.lockTimeout); err != nil {
+			return err
+		}
+	}
+	t.tracked[string(key)] = struct{}{}
+	return nil
+}
+
+func (t *Txn) Get(key []byte, opts *options.ReadOptions) ([]byte, error) {
+	if t.done {
+		return nil, errors.ErrTxnDone
+	}
+	if !t.pessimistic {
+		t.track(key)
+	}
+	return t.get(key, opts)
+}
+
+// GetForUpdate is same as Get except that pessimistic transaction locks key
+// before reading.
+func (t *Txn) GetForUpdate(key []byte, opts *options.ReadOptions) ([]byte, error) {
+	if t.done {
+		return nil, errors.ErrTxnDone
+	}
+	if err := t.track(key); err != nil {
+		return nil, err
+	}
+	return t.get(key, opts)
+}
+
+func (t *Txn) get(key []byte, opts *options.ReadOptions) ([]byte, error) {
+	if t.snapshot != nil {
+		return t.index.get(t.db, key, t.snapshot.seq, opts)
+	}
+	return t.index.Get(t.db, key, opts)
+}
+
+func (t *Txn) add(kind keys.Kind, key, value []byte) error {
+	switch {
+	case t.done:
+		return errors.ErrTxnDone
+	case kind == keys.Merge && t.db.options.MergeOperator == nil:
+		return errors.ErrNoMergeOperator
+	}
+	if err := t.track(key); err != nil {
+		return err
+	}
+	switch kind {
+	case keys.Value:
+		t.batch.Put(key, value)
+	case keys.Delete:
+		t.batch.Delete(key)
+	case keys.Merge:
+		t.batch.Merge(key, value)
+	}
+	if err := t.batch.Err(); err != nil {
+		return err
+	}
+	t.index.Add(keys.Sequence(t.batch.Count()), kind, key, value)
+	return nil
+}
+
+func (t *Txn) Put(key, value []byte) error {
+	return t.add(keys.Value, key, value)
+}
+
+func (t *Txn) Delete(key []byte) error {
+	return t.add(keys.Delete, key, nil)
+}
+
+func (t *Txn) Merge(key, operand []byte) error {
+	return t.add(keys.Merge, key, operand)
+}
+
+// Commit writes buffered writes to db. Optimistic transaction returns
+// ErrConflict if any tracked keys have been modified since its snapshot, or
+// ErrTryAgain if that can't be told from memtables. Checking and writing
+// happen atomically in write goroutine.
+func (t *Txn) Commit(opts *options.WriteOptions) error {
+	if t.done {
+		return errors.ErrTxnDone
+	}
+	defer t.Rollback()
+	switch {
+	case t.batch.Empty():
+		return nil
+	case t.pessimistic:
+		return t.db.Write(t.batch, opts)
+	}
+	ucmp := t.db.options.Comparator.UserKeyComparator
+	tracked := make([][]byte, 0, len(t.tracked))
+	for key := range t.tracked {
+		tracked = append(tracked, []byte(key))
+	}
+	sort.Slice(tracked, func(i, j int) bool { return ucmp.Compare(tracked[i], tracked[j]) < 0 })
+	seq := t.snapshot.seq
+	return t.db.write(t.batch, opts, func() error { return t.db.checkConflict(tracked, seq) })
+}
+
+// Rollback discards buffered writes, and releases snapshot or locks of this
+// transaction.
+func (t *Txn) Rollback() error {
+	if t.done {
+		return errors.ErrTxnDone
+	}
+	t.done = true
+	if t.snapshot != nil {
+		t.snapshot.Release()
+		t.snapshot = nil
+	}
+	if t.pessimistic {
+		locked := make([]string, 0, len(t.tracked))
+		for key := range t.tracked {
+			locked = append(locked, key)
+		}
+		t.db.locks.Unlock(t, locked)
+	}
+	t.batch = batch.Batch{}
+	t.index = nil
+	t.tracked = nil
+	return nil
+}
+
+// checkConflict returns ErrConflict if any of ukeys, which are sorted, has
+// been written after seq, or ErrTryAgain if memtables don't cover all writes
+// after seq. It must be called in write goroutine, so that no writes could
+// sneak in between checking and writing. It reads no tables to not block
+// other writes.
+func (db *DB) checkConflict(ukeys [][]byte, seq keys.Sequence) error {
+	cf := db.defaultFamily
+	bundle := cf.loadBundle()
+	earliestSequence := cf.memSequence
+	iters := make([]iterator.Iterator, 1, 2)
+	iters[0] = bundle.mem.NewIterator()
+	tombstones := bundle.mem.RangeTombstones()
+	if bundle.imm != nil {
+		earliestSequence = cf.immSequence
+		iters = append(iters, bundle.imm.NewIterator())
+		tombstones = append(tombstones, bundle.imm.RangeTombstones()...)
+	}
+	it := iterator.NewMergeIterator(db.options.Comparator, iters...)
+	defer it.Close()
+	if seq < earliestSequence {
+		return errors.ErrTryAgain
+	}
+	ucmp := db.options.Comparator.UserKeyComparator
+	for _, ukey := range ukeys {
+		if tombstones.MaxSequence(ucmp, ukey, keys.MaxSequence) > seq {
+			return errors.ErrConflict
+		}
+		if it.Seek(keys.NewInternalKey(ukey, keys.MaxSequence, keys.Seek)) {
+			key, sequence, _ := keys.InternalKey(it.Key()).Split()
+			if sequence > seq && ucmp.Compare(key, ukey) == 0 {
+				return errors.ErrConflict
+			}
+		}
+		if err := it.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,252 @@
+package leveldb
+
+import (
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/kezhuw/leveldb/internal/batch"
+	"github.com/kezhuw/leveldb/internal/errors"
+	"github.com/kezhuw/leveldb/internal/file"
+	"github.com/kezhuw/leveldb/internal/files"
+	"github.com/kezhuw/leveldb/internal/keys"
+	"github.com/kezhuw/leveldb/internal/record"
+)
+
+// logRetention tracks acknowledged updates and first sequences of log files
+// to decide which obsolete log files could be deleted.
+type logRetention struct {
+	// Accessed atomically.
+	acknowledged uint64
+
+	mu             sync.Mutex
+	firstSequences map[uint64]keys.Sequence
+}
+
+// listLogs returns numbers of log files in db directory in ascending order.
+func (db *DB) listLogs() ([]uint64, error) {
+	filenames, err := db.fs.List(db.name)
+	if err != nil {
+		return nil, err
+	}
+	var logs []uint64
+	for _, name := range filenames {
+		if kind, number := files.Parse(name); kind == files.Log {
+			logs = append(logs, number)
+		}
+	}
+	sort.Slice(logs, func(i, j int) bool { return logs[i] < logs[j] })
+	return logs, nil
+}
+
+// firstLogSequence returns sequence of first batch in log file, or zero if
+// that log file contains no batches.
+func (db *DB) firstLogSequence(number uint64) (keys.Sequence, error) {
+	r := &db.logRetention
+	r.mu.Lock()
+	seq, ok := r.firstSequences[number]
+	r.mu.Unlock()
+	if ok {
+		return seq, nil
+	}
+	f, err := db.fs.Open(files.LogFileName(db.name, number), os.O_RDONLY)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	buf, err := record.NewReader(f).AppendRecord(nil)
+	switch err {
+	case nil:
+	case io.EOF, record.ErrIncompleteRecord:
+		return 0, nil
+	default:
+		return 0, err
+	}
+	var b batch.Batch
+	b.Reset(buf)
+	if b.Empty() {
+		return 0, errors.ErrCorruptWriteBatch
+	}
+	seq = b.Sequence()
+	r.mu.Lock()
+	if r.firstSequences == nil {
+		r.firstSequences = make(map[uint64]keys.Sequence)
+	}
+	r.firstSequences[number] = seq
+	r.mu.Unlock()
+	return seq, nil
+}
+
+// isLogRetained returns whether log file number, which is obsolete, should
+// be retained. logs are numbers of all log files in ascending order.
+func (db *DB) isLogRetained(number uint64, logs []uint64) bool {
+	i := sort.Search(len(logs), func(i int) bool { return logs[i] > number })
+	if i == len(logs) {
+		return true
+	}
+	// Updates in this log file precede first update in next log file.
+	next, err := db.firstLogSequence(logs[i])
+	if err != nil || next == 0 {
+		return true
+	}
+	acknowledged := keys.Sequence(atomic.LoadUint64(&db.logRetention.acknowledged))
+	return next > acknowledged+1
+}
+
+func (db *DB) forgetLog(number uint64) {
+	r := &db.logRetention
+	r.mu.Lock()
+	delete(r.firstSequences, number)
+	r.mu.Unlock()
+}
+
+// AcknowledgeUpdates acknowledges that all updates up to seq have been
+// consumed, so log files containing only these updates could be deleted.
+func (db *DB) AcknowledgeUpdates(seq keys.Sequence) {
+	for {
+		acknowledged := atomic.LoadUint64(&db.logRetention.acknowledged)
+		if uint64(seq) <= acknowledged {
+			return
+		}
+		if atomic.CompareAndSwapUint64(&db.logRetention.acknowledged, acknowledged, uint64(seq)) {
+			break
+		}
+	}
+	// Table number one protects all table files from being deleted, as
+	// compactions may be in progress.
+	select {
+	case db.obsoleteFilesChan <- 1:
+	default:
+	}
+}
+
+// UpdateIterator iterates batches written to log files.
+type UpdateIterator struct {
+	db   *DB
+	logs []uint64
+
+	since keys.Sequence
+	last  keys.Sequence
+
+	file   file.File
+	reader *record.Reader
+	buf    []byte
+	batch  batch.Batch
+	err    error
+}
+
+// GetUpdatesSince returns an iterator which iterates batches in log files
+// starting from the one containing update seq, and ending with the last
+// update written before this call.
+func (db *DB) GetUpdatesSince(seq keys.Sequence) (*UpdateIterator, error) {
+	// Manifest of new db starts with last sequence one, so updates are
+	// numbered from two.
+	if seq < 2 {
+		seq = 2
+	}
+	last := db.manifest.LoadLastSequence()
+	it := &UpdateIterator{db: db, since: seq, last: last}
+	if seq > last {
+		return it, nil
+	}
+	logs, err := db.listLogs()
+	if err != nil {
+		return nil, err
+	}
+	for i := len(logs) - 1; i >= 0; i-- {
+		first, err := db.firstLogSequence(logs[i])
+		switch {
+		case err != nil:
+			return nil, err
+		case first != 0 && first <= seq:
+			it.logs = logs[i:]
+			return it, nil
+		}
+	}
+	return nil, errors.ErrUpdatesUnavailable
+}
+
+// Next moves to next batch. It returns false if there are no more batches
+// or an error occurs.
+func (it *UpdateIterator) Next() bool {
+	for it.err == nil {
+		if it.reader == nil && !it.openNextLog() {
+			return false
+		}
+		buf, err := it.reader.AppendRecord(it.buf[:0])
+		switch err {
+		case nil:
+		case io.EOF, record.ErrIncompleteRecord:
+			// Log file may be written concurrently, ending with an
+			// incomplete record.
+			it.closeLog()
+			continue
+		default:
+			it.err = err
+			return false
+		}
+		it.buf = buf
+		it.batch.Reset(buf)
+		if it.batch.Empty() {
+			it.err = errors.ErrCorruptWriteBatch
+			return false
+		}
+		seq := it.batch.Sequence()
+		switch {
+		case seq > it.last:
+			it.closeLog()
+			it.logs = nil
+			return false
+		case seq.Next(uint64(it.batch.Count())) <= it.since:
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+func (it *UpdateIterator) openNextLog() bool {
+	if len(it.logs) == 0 {
+		return false
+	}
+	number := it.logs[0]
+	it.logs = it.logs[1:]
+	f, err := it.db.fs.Open(files.LogFileName(it.db.name, number), os.O_RDONLY)
+	if err != nil {
+		it.err = err
+		return false
+	}
+	it.file = f
+	it.reader = record.NewReader(f)
+	return true
+}
+
+func (it *UpdateIterator) closeLog() {
+	if it.file != nil {
+		it.file.Close()
+		it.file = nil
+		it.reader = nil
+	}
+}
+
+// Sequence returns sequence of first update in current batch.
+func (it *UpdateIterator) Sequence() keys.Sequence {
+	return it.batch.Sequence()
+}
+
+// Batch returns current batch. It is valid until next call to Next.
+func (it *UpdateIterator) Batch() *batch.Batch {
+	return &it.batch
+}
+
+func (it *UpdateIterator) Err() error {
+	return it.err
+}
+
+func (it *UpdateIterator) Close() error {
+	it.closeLog()
+	it.logs = nil
+	return it.err
+}
@@ -4,10 +4,12 @@ import (
 	"os"
 	"time"
 
-	"github.com/kezhuw/leveldb/internal/batch"
+	"github.com/kezhuw/leveldb/internal/compactor"
 	"github.com/kezhuw/leveldb/internal/errors"
 	"github.com/kezhuw/leveldb/internal/file"
 	"github.com/kezhuw/leveldb/internal/files"
+	"github.com/kezhuw/leveldb/internal/keys"
+	"github.com/kezhuw/leveldb/internal/manifest"
 	"github.com/kezhuw/leveldb/internal/memtable"
 	"github.com/kezhuw/leveldb/internal/record"
 	"github.com/kezhuw/leveldb/internal/request"
@@ -16,7 +18,7 @@ import (
 var elapsedSlowDown = make(chan time.Time)
 
 func (db *DB) tryOpenNextLog() {
-	if db.nextLogNumber != 0 || db.loadBundle().imm != nil {
+	if db.nextLogNumber != 0 || db.hasImmutableMemTables() {
 		return
 	}
 	db.nextLogNumber, _ = db.manifest.NewFileNumber()
@@ -75,7 +77,68 @@ func (db *DB) writeLog(sync bool, b []byte) error {
 	return err
 }
 
-func (db *DB) writeBatch(mem *memtable.MemTable, sync bool, batch batch.Batch, reply chan error) error {
+// hasImmutableMemTables returns true if any column family has an immutable
+// memtable pending for compaction.
+func (db *DB) hasImmutableMemTables() bool {
+	for _, cf := range db.columnFamilies() {
+		if cf.loadBundle().imm != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// memTablesEmpty returns true if no column family has updates in its current
+// memtable.
+func (db *DB) memTablesEmpty() bool {
+	for _, cf := range db.columnFamilies() {
+		if !cf.mem.Empty() {
+			return false
+		}
+	}
+	return true
+}
+
+// switchMemTables switches non empty memtables of column families after new
+// log opened. Column families with empty memtables need no updates from
+// older logs.
+func (db *DB) switchMemTables() {
+	for _, cf := range db.columnFamilies() {
+		if cf.mem.Empty() {
+			db.manifest.AdvanceLogNumber(cf.family, db.logNumber)
+			continue
+		}
+		cf.switchMemTable()
+	}
+}
+
+// memtableInserter inserts updates of batch to memtables of their column
+// families. Updates to dropped column families are ignored.
+type memtableInserter struct {
+	db *DB
+	// Whether any memtable reaches its write buffer size.
+	full bool
+}
+
+func (x *memtableInserter) Add(seq keys.Sequence, kind keys.Kind, key, value []byte) {
+	x.add(x.db.defaultFamily, seq, kind, key, value)
+}
+
+func (x *memtableInserter) AddColumnFamily(family uint32, seq keys.Sequence, kind keys.Kind, key, value []byte) {
+	if cf := x.db.columnFamily(family); cf != nil {
+		x.add(cf, seq, kind, key, value)
+	}
+}
+
+func (x *memtableInserter) add(cf *ColumnFamily, seq keys.Sequence, kind keys.Kind, key, value []byte) {
+	cf.mem.Add(seq, kind, key, value)
+	if cf.mem.ApproximateMemoryUsage() >= cf.options.WriteBufferSize {
+		x.full = true
+	}
+}
+
+func (db *DB) writeBatch(req *request.Request) error {
+	batch, reply := req.Batch, req.Reply
 	switch {
 	case db.logErr != nil:
 		reply <- db.logErr
@@ -87,16 +150,26 @@ func (db *DB) writeBatch(mem *memtable.MemTable, sync bool, batch batch.Batch, r
 		reply <- db.compactionErr
 		return db.compactionErr
 	}
+	if req.Check != nil {
+		if err := req.Check(); err != nil {
+			reply <- err
+			return nil
+		}
+	}
+	batch.ResolveTTL(db.options.Now())
 	lastSequence := db.manifest.LastSequence()
 	batch.SetSequence(lastSequence + 1)
 	lastSequence = lastSequence.Next(uint64(batch.Count()))
-	err := db.writeLog(sync, batch.Bytes())
-	if err != nil {
-		db.closeLog(err)
-		reply <- err
-		return err
+	if !req.DisableWAL {
+		err := db.writeLog(req.Sync, batch.Bytes())
+		if err != nil {
+			db.closeLog(err)
+			reply <- err
+			return err
+		}
 	}
-	if err = batch.Iterate(mem); err != nil {
+	inserter := memtableInserter{db: db}
+	if err := batch.Iterate(&inserter); err != nil {
 		db.logErr = err
 		reply <- err
 		return err
@@ -106,14 +179,47 @@ func (db *DB) writeBatch(mem *memtable.MemTable, sync bool, batch batch.Batch, r
 	// sequence eventually. So we don't do any sychronization here.
 	db.manifest.StoreLastSequence(lastSequence)
 	reply <- nil
-	if mem.ApproximateMemoryUsage() >= db.options.WriteBufferSize {
+	if inserter.full {
 		db.tryOpenNextLog()
 	}
 	return nil
 }
 
+// flushRequest requests to compact current memtable to table.
+type flushRequest struct {
+	wait bool
+	done chan error
+}
+
+// Flush compacts current memtable to table. If wait is true, it blocks until
+// compaction done, otherwise it returns after memtable switched.
+func (db *DB) Flush(wait bool) error {
+	if db.readOnly {
+		return errors.ErrReadOnly
+	}
+	return db.flush(wait)
+}
+
+func (db *DB) flush(wait bool) error {
+	r := &flushRequest{wait: wait, done: make(chan error, 1)}
+	select {
+	case <-db.bgClosing:
+		return errors.ErrDBClosed
+	case db.flushRequests <- r:
+	}
+	return <-r.done
+}
+
+func replyFlushes(flushes []*flushRequest, err error) {
+	for _, r := range flushes {
+		r.done <- err
+	}
+}
+
+// wakeupWrite wakes up write goroutine after completion of memtable or
+// level-0 compaction.
 func (db *DB) wakeupWrite(level int) {
-	if level != 0 {
+	if level > 0 {
 		return
 	}
 	select {
@@ -133,14 +239,25 @@ func (db *DB) slowdownLog(c <-chan time.Time) (chan request.Request, <-chan time
 	}
 }
 
+// level0Throttled reports whether any column family has too many level-0
+// files to slow down or stop writes.
+func (db *DB) level0Throttled() (slowdown, stop bool) {
+	for _, cf := range db.columnFamilies() {
+		level0NumFiles := len(cf.family.Version().Levels[0])
+		slowdown = slowdown || level0NumFiles >= cf.options.Level0SlowdownWriteFiles
+		stop = stop || level0NumFiles >= cf.options.Level0StopWriteFiles
+	}
+	return slowdown, stop
+}
+
 func (db *DB) throttleLog(slowdown <-chan time.Time) (chan request.Request, <-chan time.Time) {
-	level0NumFiles := len(db.manifest.Version().Levels[0])
+	level0Slowdown, level0Stop := db.level0Throttled()
 	switch {
 	case db.logErr != nil || db.compactionErr != nil || db.manifestErr != nil:
 		return db.requests, nil
-	case level0NumFiles >= db.options.Level0SlowdownWriteFiles:
+	case level0Slowdown:
 		return db.slowdownLog(slowdown)
-	case level0NumFiles >= db.options.Level0StopWriteFiles:
+	case level0Stop:
 		return nil, nil
 	default:
 		return db.requests, nil
@@ -188,11 +305,34 @@ func (db *DB) serveWrite() {
 	var lastErr error
 	var requests chan request.Request
 	var slowdown <-chan time.Time
-	mem := db.bundle.mem
+	// Whether mem and imm contain writes not logged.
+	var memUnlogged, immUnlogged bool
+	// Flushes waiting for switching of mem and compaction of imm.
+	var memFlushes, immFlushes []*flushRequest
+	// Checkpoints waiting for compaction of imm and unlogged writes.
+	var checkpoints []*checkpointRequest
 	// There may be too many files in level-0 to throttle writes, fire
 	// an level compaction to solve this.
 	db.tryLevelCompaction()
 	for db.requests != nil || db.nextLogNumber != 0 || compactionClosed != nil {
+		if len(immFlushes) != 0 && !db.hasImmutableMemTables() {
+			replyFlushes(immFlushes, nil)
+			immFlushes = immFlushes[:0]
+		}
+		if len(checkpoints) != 0 {
+			switch {
+			case memUnlogged:
+				db.tryOpenNextLog()
+			case !db.hasImmutableMemTables():
+				for _, r := range checkpoints {
+					r.state <- db.captureCheckpoint()
+				}
+				checkpoints = checkpoints[:0]
+			}
+		}
+		if len(memFlushes) != 0 {
+			db.tryOpenNextLog()
+		}
 		requests, slowdown = db.throttleLog(slowdown)
 		select {
 		case <-compactionClosed:
@@ -203,14 +343,51 @@ func (db *DB) serveWrite() {
 				break
 			}
 			db.openLog(logFile, 0, db.nextLogNumber)
-			mem = db.switchMemTable(mem)
+			db.switchMemTables()
+			memUnlogged, immUnlogged = false, memUnlogged
 			db.nextLogNumber = 0
 			lastErr = nil
+			for _, r := range memFlushes {
+				if !r.wait {
+					r.done <- nil
+					continue
+				}
+				immFlushes = append(immFlushes, r)
+			}
+			memFlushes = memFlushes[:0]
 		case lastErr = <-db.nextLogFileErr:
 		case lastErr = <-db.manifestErrChan:
 			db.manifestErr = lastErr
+			replyCheckpoints(checkpoints, lastErr)
+			checkpoints = checkpoints[:0]
 		case lastErr = <-db.compactionErrChan:
 			db.compactionErr = lastErr
+			replyFlushes(immFlushes, lastErr)
+			immFlushes = immFlushes[:0]
+			replyCheckpoints(checkpoints, lastErr)
+			checkpoints = checkpoints[:0]
+		case r := <-db.flushRequests:
+			switch {
+			case db.requests == nil:
+				r.done <- errors.ErrDBClosed
+			case !db.memTablesEmpty():
+				memFlushes = append(memFlushes, r)
+			case r.wait && db.hasImmutableMemTables():
+				immFlushes = append(immFlushes, r)
+			default:
+				r.done <- nil
+			}
+		case r := <-db.checkpointRequests:
+			switch {
+			case db.requests == nil:
+				r.state <- checkpointState{err: errors.ErrDBClosed}
+			case db.compactionErr != nil:
+				r.state <- checkpointState{err: db.compactionErr}
+			case db.manifestErr != nil:
+				r.state <- checkpointState{err: db.manifestErr}
+			default:
+				checkpoints = append(checkpoints, r)
+			}
 		case <-db.requestw:
 		case <-slowdown:
 			slowdown = elapsedSlowDown
@@ -221,9 +398,61 @@ func (db *DB) serveWrite() {
 			case lastErr != nil:
 				req.Reply <- lastErr
 			default:
-				lastErr = db.writeBatch(mem, req.Sync, req.Batch, req.Reply)
+				lastErr = db.writeBatch(&req)
+				memUnlogged = memUnlogged || req.DisableWAL
 			}
 			slowdown = nil
 		}
 	}
+	replyFlushes(memFlushes, errors.ErrDBClosed)
+	replyCheckpoints(checkpoints, errors.ErrDBClosed)
+	if db.hasImmutableMemTables() {
+		replyFlushes(immFlushes, errors.ErrDBClosed)
+	} else {
+		replyFlushes(immFlushes, nil)
+	}
+	if memUnlogged || immUnlogged {
+		db.closeErr = db.compactUnloggedMemTables()
+	}
+}
+
+// compactUnloggedMemTables compacts memtables to level-0 tables after
+// compaction goroutine exited. It is called before closing if memtables
+// contain writes not logged.
+func (db *DB) compactUnloggedMemTables() error {
+	smallestSequence, snapshots := db.getSmallestSnapshot()
+	for _, cf := range db.columnFamilies() {
+		bundle := cf.loadBundle()
+		edit := manifest.Edit{ColumnFamily: cf.ID()}
+		for _, mem := range [2]*memtable.MemTable{bundle.imm, bundle.mem} {
+			if mem == nil || mem.Empty() {
+				continue
+			}
+			fileNumber, _ := db.manifest.NewFileNumber()
+			fileName := files.TableFileName(db.name, fileNumber)
+			var blobNumber uint64
+			var blobName string
+			if cf.options.MinBlobSize > 0 {
+				blobNumber, _ = db.manifest.NewFileNumber()
+				blobName = files.BlobFileName(db.name, blobNumber)
+			}
+			var output manifest.Edit
+			c := compactor.NewMemTableCompactor(fileNumber, fileName, blobNumber, blobName, smallestSequence, snapshots, mem, cf.options)
+			switch err := c.Compact(&output); err {
+			case nil:
+				edit.AddedFiles = append(edit.AddedFiles, output.AddedFiles...)
+				edit.AddedBlobFiles = append(edit.AddedBlobFiles, output.AddedBlobFiles...)
+			case errors.ErrEmptyMemTable:
+			default:
+				return err
+			}
+		}
+		// All entries in logs are compacted to tables now.
+		edit.LogNumber = db.manifest.NextFileNumber()
+		edit.NextFileNumber = edit.LogNumber
+		if err := db.manifest.Apply(&edit); err != nil {
+			return err
+		}
+	}
+	return nil
 }
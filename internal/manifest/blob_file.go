@@ -0,0 +1,27 @@
+package manifest
+
+// BlobFileMeta contains meta info for a blob file. It is immutable once
+// added to version, garbage accumulated later results in new meta.
+type BlobFileMeta struct {
+	Number uint64
+	// Size of blob file in bytes.
+	Size uint64
+	// Garbage is number of bytes in blob file occupied by values no longer
+	// referenced from tables.
+	Garbage uint64
+}
+
+// GarbageRatio returns ratio of garbage bytes in blob file.
+func (f *BlobFileMeta) GarbageRatio() float64 {
+	if f.Size == 0 {
+		return 1
+	}
+	return float64(f.Garbage) / float64(f.Size)
+}
+
+// BlobGarbage records bytes of values in blob file, which become garbage
+// due to compaction.
+type BlobGarbage struct {
+	Number uint64
+	Bytes  uint64
+}
@@ -11,20 +11,22 @@ import (
 
 type builder struct {
 	Scratch        []byte
-	Comparator     *keys.InternalComparator
 	ManifestFile   file.File
 	ManifestNumber uint64
+	// ReadOnly prevents truncation of incomplete record in ManifestFile.
+	ReadOnly bool
 
-	LogNumber      uint64
 	NextFileNumber uint64
 	LastSequence   keys.Sequence
 }
 
-func (b *builder) Build(v *Version) (int64, error) {
+// Build builds column families of m from manifest file.
+func (b *builder) Build(m *Manifest) (int64, error) {
+	m.defaultFamily = m.newColumnFamily(0, DefaultColumnFamilyName, m.options)
+	m.families = map[uint32]*ColumnFamily{0: m.defaultFamily}
 	var edit Edit
 	r := record.NewReader(b.ManifestFile)
 	buf := b.Scratch
-	comparatorName := b.Comparator.UserKeyComparator.Name()
 	var err error
 	for {
 		buf, err = r.AppendRecord(buf[:0])
@@ -33,9 +35,11 @@ func (b *builder) Build(v *Version) (int64, error) {
 		case io.EOF:
 			goto done
 		case record.ErrIncompleteRecord:
-			offset := r.Offset()
-			b.ManifestFile.Truncate(offset)
-			b.ManifestFile.Seek(offset, io.SeekStart)
+			if !b.ReadOnly {
+				offset := r.Offset()
+				b.ManifestFile.Truncate(offset)
+				b.ManifestFile.Seek(offset, io.SeekStart)
+			}
 			goto done
 		default:
 			return 0, err
@@ -44,24 +48,54 @@ func (b *builder) Build(v *Version) (int64, error) {
 		if err := edit.Decode(buf); err != nil {
 			return 0, err
 		}
-		if edit.ComparatorName != "" && edit.ComparatorName != comparatorName {
-			return 0, errors.ErrComparatorMismatch
-		}
-		if err := v.apply(&edit); err != nil {
+		if err := b.apply(m, &edit, r.Offset()); err != nil {
 			return 0, err
 		}
-		if edit.LogNumber != 0 {
-			b.LogNumber = edit.LogNumber
-		}
 		if edit.NextFileNumber != 0 {
 			b.NextFileNumber = edit.NextFileNumber
 		}
 		if edit.LastSequence != 0 {
 			b.LastSequence = edit.LastSequence
 		}
+		if edit.MaxColumnFamily > m.maxColumnFamily {
+			m.maxColumnFamily = edit.MaxColumnFamily
+		}
 	}
 done:
 	b.Scratch = buf
-	v.computeCompactionScore()
+	for _, family := range m.families {
+		family.tip.computeCompactionScore()
+	}
 	return r.Offset(), nil
 }
+
+func (b *builder) apply(m *Manifest, edit *Edit, offset int64) error {
+	family := m.families[edit.ColumnFamily]
+	switch {
+	case edit.AddedColumnFamily != "":
+		if family != nil {
+			return errors.NewCorruption(b.ManifestNumber, "manifest", offset, "column family exists")
+		}
+		opts := m.options.ColumnFamilyOptions(edit.AddedColumnFamily)
+		family = m.newColumnFamily(edit.ColumnFamily, edit.AddedColumnFamily, opts)
+		m.families[family.ID] = family
+	case family == nil:
+		return errors.NewCorruption(b.ManifestNumber, "manifest", offset, "unknown column family")
+	case edit.DroppedColumnFamily:
+		delete(m.families, family.ID)
+		return nil
+	}
+	if edit.ColumnFamily > m.maxColumnFamily {
+		m.maxColumnFamily = edit.ColumnFamily
+	}
+	if edit.ComparatorName != "" && edit.ComparatorName != family.options.Comparator.UserKeyComparator.Name() {
+		return errors.ErrComparatorMismatch
+	}
+	if err := family.tip.apply(edit); err != nil {
+		return err
+	}
+	if edit.LogNumber != 0 {
+		family.logNumber = edit.LogNumber
+	}
+	return nil
+}
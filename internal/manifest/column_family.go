@@ -0,0 +1,74 @@
+package manifest
+
+import (
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/kezhuw/leveldb/internal/compaction"
+	"github.com/kezhuw/leveldb/internal/options"
+	"github.com/kezhuw/leveldb/internal/table"
+)
+
+// DefaultColumnFamilyName is name of column family with id zero, which
+// exists in all dbs.
+const DefaultColumnFamilyName = "default"
+
+// ColumnFamily is a keyspace with its own levels of tables. All column
+// families of a db share log files and manifest.
+type ColumnFamily struct {
+	ID   uint32
+	Name string
+
+	manifest *Manifest
+	options  *options.Options
+	cache    *table.Cache
+
+	version *Version
+
+	// Fields below are guarded by manifest.mu.
+
+	// Latest logged version, which may be not appended yet.
+	tip *Version
+	// Updates in log files older than logNumber have been written to tables
+	// of this column family. Stored atomically.
+	logNumber uint64
+	dropped   bool
+}
+
+func (m *Manifest) newColumnFamily(id uint32, name string, opts *options.Options) *ColumnFamily {
+	family := &ColumnFamily{
+		ID:       id,
+		Name:     name,
+		manifest: m,
+		options:  opts,
+		cache:    table.NewCache(m.dbname, opts),
+	}
+	family.tip = &Version{options: opts, cache: family.cache, manifest: m, family: family}
+	return family
+}
+
+// Options returns options of this column family.
+func (family *ColumnFamily) Options() *options.Options {
+	return family.options
+}
+
+// LogNumber returns number of oldest log file which may contain updates not
+// written to tables of this column family.
+func (family *ColumnFamily) LogNumber() uint64 {
+	return atomic.LoadUint64(&family.logNumber)
+}
+
+// Version returns current version of this column family.
+func (family *ColumnFamily) Version() *Version {
+	return (*Version)(atomic.LoadPointer((*unsafe.Pointer)(unsafe.Pointer(&family.version))))
+}
+
+func (family *ColumnFamily) append(tip *Version) {
+	atomic.StorePointer((*unsafe.Pointer)(unsafe.Pointer(&family.version)), unsafe.Pointer(tip))
+	family.manifest.mountVersion(tip)
+}
+
+// PickCompactions picks compactions for this column family.
+func (family *ColumnFamily) PickCompactions(registry *compaction.Registry, pendingFiles []FileList) []*Compaction {
+	return family.Version().pickCompactions(registry, pendingFiles, family.manifest.NextFileNumber())
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/kezhuw/leveldb/internal/iterator"
 	"github.com/kezhuw/leveldb/internal/keys"
 	"github.com/kezhuw/leveldb/internal/options"
+	"github.com/kezhuw/leveldb/internal/rangedel"
 )
 
 type Compaction struct {
@@ -16,6 +17,14 @@ type Compaction struct {
 	Grandparents       FileList
 	MaxOutputFileSize  int64
 	NextCompactPointer keys.InternalKey
+
+	// MaxGrandparentOverlappingBytes limits total size of grandparent files
+	// an output file could overlap with.
+	MaxGrandparentOverlappingBytes uint64
+
+	// Manual states that this compaction is requested by client, it should
+	// rewrite all its input files.
+	Manual bool
 }
 
 func (c *Compaction) NewIterator() iterator.Iterator {
@@ -40,6 +49,45 @@ func (c *Compaction) NewIterator() iterator.Iterator {
 	return iterator.NewMergeIterator(icmp, iterators...)
 }
 
+// IsTrivialMove returns true if this compaction can be done by moving its
+// single input file to next level. Files are not moved across levels with
+// different compression types, so tables in each level are compressed as
+// configured.
 func (c *Compaction) IsTrivialMove() bool {
-	return len(c.Inputs[0]) == 1 && len(c.Inputs[1]) == 0 && c.Grandparents.TotalFileSize() < configs.MaxGrandparentOverlappingBytes
+	if c.Manual || len(c.Inputs[0]) != 1 || len(c.Inputs[1]) != 0 {
+		return false
+	}
+	opts := c.Base.options
+	if opts.CompressionOfLevel(c.Level) != opts.CompressionOfLevel(c.Level+1) {
+		return false
+	}
+	return c.Grandparents.TotalFileSize() < c.MaxGrandparentOverlappingBytes
+}
+
+// RangeTombstones returns range tombstones in input files of this compaction.
+func (c *Compaction) RangeTombstones() (rangedel.List, error) {
+	var tombstones rangedel.List
+	for _, files := range c.Inputs {
+		for _, f := range files {
+			list, err := c.Base.cache.RangeTombstones(f.Number, f.Size)
+			if err != nil {
+				return nil, err
+			}
+			tombstones = append(tombstones, list...)
+		}
+	}
+	return tombstones, nil
+}
+
+// IsBaseLevelForRange returns true if no files in levels deeper than output
+// level of this compaction overlap with user key range [start, limit).
+func (c *Compaction) IsBaseLevelForRange(start, limit []byte) bool {
+	smallest := keys.NewInternalKey(start, keys.MaxSequence, keys.Seek)
+	largest := keys.NewInternalKey(limit, keys.MaxSequence, keys.Seek)
+	for level := c.Level + 2; level < configs.NumberLevels; level++ {
+		if c.Base.isOverlappingWithLevel(level, smallest, largest) {
+			return false
+		}
+	}
+	return true
 }
@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"math"
 
 	"github.com/kezhuw/leveldb/internal/configs"
 	"github.com/kezhuw/leveldb/internal/keys"
@@ -20,6 +21,13 @@ const (
 	tagDeletedFile    = 6
 	tagNewFile        = 7
 	tagPrevLogNumber  = 9
+	tagNewBlobFile    = 10
+	tagBlobGarbage    = 11
+
+	tagColumnFamily        = 200
+	tagAddedColumnFamily   = 201
+	tagDroppedColumnFamily = 202
+	tagMaxColumnFamily     = 203
 )
 
 var (
@@ -32,6 +40,9 @@ var (
 	ErrCorruptEditDeletedFile    = errors.New("corrupt version edit: deleted file")
 	ErrCorruptEditNewFile        = errors.New("corrupt version edit: new file")
 	ErrCorruptEditComparatorName = errors.New("corrupt version edit: comparator name")
+	ErrCorruptEditNewBlobFile    = errors.New("corrupt version edit: new blob file")
+	ErrCorruptEditBlobGarbage    = errors.New("corrupt version edit: blob garbage")
+	ErrCorruptEditColumnFamily   = errors.New("corrupt version edit: column family")
 )
 
 type LevelFileNumber struct {
@@ -55,11 +66,35 @@ type Edit struct {
 	CompactPointers []LevelCompactPointer
 	AddedFiles      []LevelFileMeta
 	DeletedFiles    []LevelFileNumber
+	// Blob files are deleted from version once all their bytes become
+	// garbage.
+	AddedBlobFiles []BlobFileMeta
+	BlobGarbages   []BlobGarbage
+	// ColumnFamily is id of column family this edit applies to, zero for
+	// default column family. LogNumber, ComparatorName and files are per
+	// column family.
+	ColumnFamily        uint32
+	AddedColumnFamily   string
+	DroppedColumnFamily bool
+	// MaxColumnFamily is the largest id of column families ever created.
+	MaxColumnFamily uint32
 	scratch         [binary.MaxVarintLen64]byte
 }
 
 func (edit *Edit) String() string {
 	var s string
+	if edit.ColumnFamily != 0 {
+		s += fmt.Sprintf("ColumnFamily: %d\n", edit.ColumnFamily)
+	}
+	if edit.AddedColumnFamily != "" {
+		s += fmt.Sprintf("AddedColumnFamily: %q\n", edit.AddedColumnFamily)
+	}
+	if edit.DroppedColumnFamily {
+		s += "DroppedColumnFamily: true\n"
+	}
+	if edit.MaxColumnFamily != 0 {
+		s += fmt.Sprintf("MaxColumnFamily: %d\n", edit.MaxColumnFamily)
+	}
 	if edit.ComparatorName != "" {
 		s += fmt.Sprintf("ComparatorName: %q\n", edit.ComparatorName)
 	}
@@ -81,6 +116,12 @@ func (edit *Edit) String() string {
 	for _, f := range edit.AddedFiles {
 		s += fmt.Sprintf("level %d: add file %d: size %d, smallest key: %q, largest key: %q\n", f.Level, f.Number, f.Size, f.Smallest, f.Largest)
 	}
+	for _, f := range edit.AddedBlobFiles {
+		s += fmt.Sprintf("add blob file %d: size %d, garbage %d\n", f.Number, f.Size, f.Garbage)
+	}
+	for _, g := range edit.BlobGarbages {
+		s += fmt.Sprintf("blob file %d: garbage %d\n", g.Number, g.Bytes)
+	}
 	return s
 }
 
@@ -93,10 +134,29 @@ func (edit *Edit) Reset() {
 	edit.CompactPointers = edit.CompactPointers[:0]
 	edit.AddedFiles = edit.AddedFiles[:0]
 	edit.DeletedFiles = edit.DeletedFiles[:0]
+	edit.AddedBlobFiles = edit.AddedBlobFiles[:0]
+	edit.BlobGarbages = edit.BlobGarbages[:0]
+	edit.ColumnFamily = 0
+	edit.AddedColumnFamily = ""
+	edit.DroppedColumnFamily = false
+	edit.MaxColumnFamily = 0
 }
 
 // Encode appends binary encoded Edit to buf.
 func (edit *Edit) Encode(buf []byte) []byte {
+	if edit.ColumnFamily != 0 {
+		buf = edit.appendTagAndUint64(buf, tagColumnFamily, uint64(edit.ColumnFamily))
+	}
+	if edit.AddedColumnFamily != "" {
+		buf = edit.appendUvarint(buf, tagAddedColumnFamily)
+		buf = edit.appendLengthPrefixedString(buf, edit.AddedColumnFamily)
+	}
+	if edit.DroppedColumnFamily {
+		buf = edit.appendUvarint(buf, tagDroppedColumnFamily)
+	}
+	if edit.MaxColumnFamily != 0 {
+		buf = edit.appendTagAndUint64(buf, tagMaxColumnFamily, uint64(edit.MaxColumnFamily))
+	}
 	if edit.ComparatorName != "" {
 		buf = edit.appendUvarint(buf, tagComparatorName)
 		buf = edit.appendLengthPrefixedString(buf, edit.ComparatorName)
@@ -128,6 +188,17 @@ func (edit *Edit) Encode(buf []byte) []byte {
 		buf = edit.appendLengthPrefixedBytes(buf, file.Smallest)
 		buf = edit.appendLengthPrefixedBytes(buf, file.Largest)
 	}
+	for _, file := range edit.AddedBlobFiles {
+		buf = edit.appendUvarint(buf, tagNewBlobFile)
+		buf = edit.appendUvarint(buf, file.Number)
+		buf = edit.appendUvarint(buf, file.Size)
+		buf = edit.appendUvarint(buf, file.Garbage)
+	}
+	for _, garbage := range edit.BlobGarbages {
+		buf = edit.appendUvarint(buf, tagBlobGarbage)
+		buf = edit.appendUvarint(buf, garbage.Number)
+		buf = edit.appendUvarint(buf, garbage.Bytes)
+	}
 	return buf
 }
 
@@ -155,6 +226,20 @@ func (edit *Edit) Decode(buf []byte) (err error) {
 			buf = edit.decodeAddedFile(buf)
 		case tagPrevLogNumber:
 			buf = edit.decodeUint64(buf, &prevLogNumber, ErrCorruptEditPrevLogNumber)
+		case tagNewBlobFile:
+			buf = edit.decodeAddedBlobFile(buf)
+		case tagBlobGarbage:
+			buf = edit.decodeBlobGarbage(buf)
+		case tagColumnFamily:
+			buf = edit.decodeColumnFamilyID(buf, &edit.ColumnFamily)
+		case tagAddedColumnFamily:
+			var name []byte
+			buf = edit.decodeBytes(buf, &name, ErrCorruptEditColumnFamily)
+			edit.AddedColumnFamily = string(name)
+		case tagDroppedColumnFamily:
+			edit.DroppedColumnFamily = true
+		case tagMaxColumnFamily:
+			buf = edit.decodeColumnFamilyID(buf, &edit.MaxColumnFamily)
 		default:
 			return fmt.Errorf("unknown version edit tag: %d", tag)
 		}
@@ -264,3 +349,30 @@ func (edit *Edit) decodeAddedFile(buf []byte) []byte {
 	edit.AddedFiles = append(edit.AddedFiles, file)
 	return buf
 }
+
+func (edit *Edit) decodeAddedBlobFile(buf []byte) []byte {
+	var file BlobFileMeta
+	buf = edit.decodeUint64(buf, &file.Number, ErrCorruptEditNewBlobFile)
+	buf = edit.decodeUint64(buf, &file.Size, ErrCorruptEditNewBlobFile)
+	buf = edit.decodeUint64(buf, &file.Garbage, ErrCorruptEditNewBlobFile)
+	edit.AddedBlobFiles = append(edit.AddedBlobFiles, file)
+	return buf
+}
+
+func (edit *Edit) decodeBlobGarbage(buf []byte) []byte {
+	var garbage BlobGarbage
+	buf = edit.decodeUint64(buf, &garbage.Number, ErrCorruptEditBlobGarbage)
+	buf = edit.decodeUint64(buf, &garbage.Bytes, ErrCorruptEditBlobGarbage)
+	edit.BlobGarbages = append(edit.BlobGarbages, garbage)
+	return buf
+}
+
+func (edit *Edit) decodeColumnFamilyID(buf []byte, id *uint32) []byte {
+	var x uint64
+	buf = edit.decodeUint64(buf, &x, ErrCorruptEditColumnFamily)
+	if x > math.MaxUint32 {
+		panic(ErrCorruptEditColumnFamily)
+	}
+	*id = uint32(x)
+	return buf
+}
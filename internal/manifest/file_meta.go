@@ -6,6 +6,7 @@ import (
 	"sync/atomic"
 
 	"github.com/kezhuw/leveldb/internal/keys"
+	"github.com/kezhuw/leveldb/internal/rangedel"
 )
 
 // FileMeta contains meta info for a sorted table.
@@ -41,6 +42,16 @@ func (f *FileMeta) seekThrough() bool {
 	return r <= 0 && r > -2
 }
 
+// ExpandRange expands key range of this file to include range tombstone t.
+func (f *FileMeta) ExpandRange(icmp *keys.InternalComparator, t *rangedel.Tombstone) {
+	if smallest := t.SmallestKey(); len(f.Smallest) == 0 || icmp.Compare(smallest, f.Smallest) < 0 {
+		f.Smallest = append(f.Smallest[:0], smallest...)
+	}
+	if largest := t.LargestKey(); len(f.Largest) == 0 || icmp.Compare(largest, f.Largest) > 0 {
+		f.Largest = append(f.Largest[:0], largest...)
+	}
+}
+
 // GoString implements fmt.GoStringer.
 func (f *FileMeta) GoString() string {
 	return fmt.Sprintf("%#v", *f)
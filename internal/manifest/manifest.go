@@ -2,13 +2,15 @@ package manifest
 
 import (
 	"fmt"
+	"math"
 	"os"
 	"runtime"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"unsafe"
 
-	"github.com/kezhuw/leveldb/internal/compaction"
+	"github.com/kezhuw/leveldb/internal/blob"
 	"github.com/kezhuw/leveldb/internal/configs"
 	"github.com/kezhuw/leveldb/internal/errors"
 	"github.com/kezhuw/leveldb/internal/file"
@@ -16,7 +18,6 @@ import (
 	"github.com/kezhuw/leveldb/internal/keys"
 	"github.com/kezhuw/leveldb/internal/options"
 	"github.com/kezhuw/leveldb/internal/record"
-	"github.com/kezhuw/leveldb/internal/table"
 )
 
 type Manifest struct {
@@ -25,16 +26,21 @@ type Manifest struct {
 	options     *options.Options
 	fs          file.FileSystem
 
-	version *Version
+	blobCache *blob.Cache
 
-	tableCache *table.Cache
+	// mu serializes manifest logging and guards column families.
+	mu              sync.Mutex
+	families        map[uint32]*ColumnFamily
+	defaultFamily   *ColumnFamily
+	maxColumnFamily uint32
 
 	lastSequence   keys.Sequence
 	nextFileNumber uint64
 
 	// Update only after successful manifest logging, which means that
 	// memtable log files older than logFileNumber and manifest files older
-	// than manifestNumber are obsolete and eligible to be deleted.
+	// than manifestNumber are obsolete and eligible to be deleted. It is the
+	// minimum log number of all column families.
 	logFileNumber  uint64
 	manifestNumber uint64
 
@@ -48,6 +54,11 @@ type Manifest struct {
 	scratch []byte
 }
 
+// Blobs returns cache to read values from blob files.
+func (m *Manifest) Blobs() *blob.Cache {
+	return m.blobCache
+}
+
 func (m *Manifest) LogFileNumber() uint64 {
 	return atomic.LoadUint64(&m.logFileNumber)
 }
@@ -99,9 +110,9 @@ func (m *Manifest) AddLiveFiles(files map[uint64]struct{}) map[uint64]struct{} {
 	return files
 }
 
-func (m *Manifest) resetCurrentManifest(snapshot *Edit) error {
+func (m *Manifest) resetCurrentManifest(snapshot []*Edit) error {
 	if m.manifestNextNumber == 0 {
-		m.manifestNextNumber, snapshot.NextFileNumber = m.NewFileNumber()
+		m.manifestNextNumber, snapshot[0].NextFileNumber = m.NewFileNumber()
 	}
 	manifestNumber := m.manifestNextNumber
 
@@ -112,7 +123,7 @@ func (m *Manifest) resetCurrentManifest(snapshot *Edit) error {
 	}
 
 	manifestLog := record.NewWriter(manifestFile, 0)
-	err = m.writeEdit(manifestLog, manifestFile, snapshot)
+	err = m.writeEdits(manifestLog, manifestFile, snapshot...)
 	if err != nil {
 		manifestFile.Close()
 		m.fs.Remove(manifestName)
@@ -134,17 +145,73 @@ func (m *Manifest) resetCurrentManifest(snapshot *Edit) error {
 	return nil
 }
 
-func (m *Manifest) writeEdit(log *record.Writer, file file.File, edit *Edit) error {
-	m.scratch = edit.Encode(m.scratch[:0])
-	if err := log.Write(m.scratch); err != nil {
-		return err
+func (m *Manifest) writeEdits(log *record.Writer, file file.File, edits ...*Edit) error {
+	for _, edit := range edits {
+		m.scratch = edit.Encode(m.scratch[:0])
+		if err := log.Write(m.scratch); err != nil {
+			return err
+		}
 	}
 	return file.Sync()
 }
 
-// Log writes edit to manifest file.
+// snapshot returns edits recording all column families, with next as tip of
+// its column family.
+func (m *Manifest) snapshot(next *Version, logNumber uint64) []*Edit {
+	edits := make([]*Edit, 0, len(m.families))
+	edits = append(edits, &Edit{
+		LastSequence:    m.LoadLastSequence(),
+		NextFileNumber:  m.NextFileNumber(),
+		MaxColumnFamily: m.maxColumnFamily,
+	})
+	for _, family := range m.sortedFamilies() {
+		tip, familyLogNumber := family.tip, family.logNumber
+		if family == next.family {
+			tip, familyLogNumber = next, logNumber
+		}
+		edit := edits[0]
+		if family.ID != 0 {
+			edit = &Edit{ColumnFamily: family.ID, AddedColumnFamily: family.Name}
+			edits = append(edits, edit)
+		}
+		edit.ComparatorName = family.options.Comparator.UserKeyComparator.Name()
+		edit.LogNumber = familyLogNumber
+		tip.Snapshot(edit)
+	}
+	return edits
+}
+
+func (m *Manifest) sortedFamilies() []*ColumnFamily {
+	families := make([]*ColumnFamily, 0, len(m.families))
+	for _, family := range m.families {
+		families = append(families, family)
+	}
+	sort.Slice(families, func(i, j int) bool { return families[i].ID < families[j].ID })
+	return families
+}
+
+func (m *Manifest) updateLogFileNumber() {
+	logNumber := uint64(math.MaxUint64)
+	for _, family := range m.families {
+		if family.logNumber < logNumber {
+			logNumber = family.logNumber
+		}
+	}
+	atomic.StoreUint64(&m.logFileNumber, logNumber)
+}
+
+// Log writes edit to manifest file. tip must be the latest logged version
+// of its column family. It returns errors.ErrColumnFamilyDropped if that
+// column family has been dropped.
 func (m *Manifest) Log(tip *Version, edit *Edit) (*Version, error) {
-	if v := m.version; v.number > tip.number {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	family := tip.family
+	if family.dropped {
+		return nil, errors.ErrColumnFamilyDropped
+	}
+	if v := family.tip; v.number > tip.number {
 		panic("current version number > tip version number")
 	}
 
@@ -153,10 +220,9 @@ func (m *Manifest) Log(tip *Version, edit *Edit) (*Version, error) {
 		panic(fmt.Errorf("leveldb: fail to edit version:\nversion:\n%s\n\nedit:%s", tip, edit))
 	}
 
+	edit.ColumnFamily = family.ID
 	edit.LastSequence = m.LoadLastSequence()
-	// logFileNumber will only be written here later, so there is no need
-	// to do atomic loading here.
-	if logNumber := m.logFileNumber; edit.LogNumber < logNumber {
+	if logNumber := family.logNumber; edit.LogNumber < logNumber {
 		edit.LogNumber = logNumber
 	}
 	if nextFileNumber := m.NextFileNumber(); edit.NextFileNumber < nextFileNumber {
@@ -164,29 +230,117 @@ func (m *Manifest) Log(tip *Version, edit *Edit) (*Version, error) {
 	}
 
 	switch {
-	case m.manifestLog.Offset() >= configs.TargetFileSize:
-		var snapshot Edit
-		next.snapshot(&snapshot)
-		snapshot.ComparatorName = m.options.Comparator.UserKeyComparator.Name()
-		snapshot.LogNumber = edit.LogNumber
-		snapshot.LastSequence = edit.LastSequence
-		snapshot.NextFileNumber = edit.NextFileNumber
-		err := m.resetCurrentManifest(&snapshot)
-		edit.NextFileNumber = snapshot.NextFileNumber
+	case m.manifestLog.Offset() >= configs.MaxManifestFileSize:
+		snapshot := m.snapshot(next, edit.LogNumber)
+		err := m.resetCurrentManifest(snapshot)
+		edit.NextFileNumber = snapshot[0].NextFileNumber
 		if err == nil {
 			break
 		}
 		fallthrough
 	default:
-		err := m.writeEdit(m.manifestLog, m.manifestFile, edit)
+		err := m.writeEdits(m.manifestLog, m.manifestFile, edit)
 		if err != nil {
 			return nil, err
 		}
 	}
-	atomic.StoreUint64(&m.logFileNumber, edit.LogNumber)
+	family.tip = next
+	atomic.StoreUint64(&family.logNumber, edit.LogNumber)
+	m.updateLogFileNumber()
 	return next, nil
 }
 
+// AdvanceLogNumber marks log files older than logNumber obsolete for family,
+// which must have no updates in memtables. It is logged along with next edit
+// of family.
+func (m *Manifest) AdvanceLogNumber(family *ColumnFamily, logNumber uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if family.dropped || family.logNumber >= logNumber {
+		return
+	}
+	atomic.StoreUint64(&family.logNumber, logNumber)
+	m.updateLogFileNumber()
+}
+
+// CreateColumnFamily creates and logs a column family with given name and
+// options. It returns errors.ErrColumnFamilyExists if name is in use.
+func (m *Manifest) CreateColumnFamily(name string, opts *options.Options) (*ColumnFamily, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.lookupColumnFamily(name) != nil {
+		return nil, errors.ErrColumnFamilyExists
+	}
+	id := m.maxColumnFamily + 1
+	edit := Edit{
+		ColumnFamily:      id,
+		AddedColumnFamily: name,
+		ComparatorName:    opts.Comparator.UserKeyComparator.Name(),
+		LogNumber:         m.logFileNumber,
+		LastSequence:      m.LoadLastSequence(),
+		NextFileNumber:    m.NextFileNumber(),
+		MaxColumnFamily:   id,
+	}
+	if err := m.writeEdits(m.manifestLog, m.manifestFile, &edit); err != nil {
+		return nil, err
+	}
+	family := m.newColumnFamily(id, name, opts)
+	family.logNumber = edit.LogNumber
+	family.append(family.tip)
+	m.families[id] = family
+	m.maxColumnFamily = id
+	return family, nil
+}
+
+// DropColumnFamily logs dropping of family. Files of family become obsolete
+// after all its versions are released.
+func (m *Manifest) DropColumnFamily(family *ColumnFamily) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if family.dropped {
+		return errors.ErrColumnFamilyDropped
+	}
+	edit := Edit{
+		ColumnFamily:        family.ID,
+		DroppedColumnFamily: true,
+		LastSequence:        m.LoadLastSequence(),
+		NextFileNumber:      m.NextFileNumber(),
+	}
+	if err := m.writeEdits(m.manifestLog, m.manifestFile, &edit); err != nil {
+		return err
+	}
+	family.dropped = true
+	// Current version references family, which references that version.
+	// Replace it with an empty one to break this cycle, so that it could
+	// be finalized to release its files.
+	family.tip = &Version{options: family.options, cache: family.cache, manifest: m, family: family}
+	atomic.StorePointer((*unsafe.Pointer)(unsafe.Pointer(&family.version)), unsafe.Pointer(family.tip))
+	delete(m.families, family.ID)
+	m.updateLogFileNumber()
+	return nil
+}
+
+func (m *Manifest) lookupColumnFamily(name string) *ColumnFamily {
+	for _, family := range m.families {
+		if family.Name == name {
+			return family
+		}
+	}
+	return nil
+}
+
+// DefaultColumnFamily returns the default column family.
+func (m *Manifest) DefaultColumnFamily() *ColumnFamily {
+	return m.defaultFamily
+}
+
+// ColumnFamilies returns all live column families ordered by id.
+func (m *Manifest) ColumnFamilies() []*ColumnFamily {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.sortedFamilies()
+}
+
 func (m *Manifest) mountVersion(v *Version) {
 	m.liveFilesMu.Lock()
 	defer m.liveFilesMu.Unlock()
@@ -200,17 +354,26 @@ func (m *Manifest) unmountVersion(v *Version) {
 	v.unrefFiles(m.liveFiles)
 }
 
+// Version returns current version of default column family.
 func (m *Manifest) Version() *Version {
-	return (*Version)(atomic.LoadPointer((*unsafe.Pointer)(unsafe.Pointer(&m.version))))
+	return m.defaultFamily.Version()
 }
 
+// Append makes tip current version of its column family.
 func (m *Manifest) Append(tip *Version) {
-	atomic.StorePointer((*unsafe.Pointer)(unsafe.Pointer(&m.version)), unsafe.Pointer(tip))
-	m.mountVersion(tip)
+	tip.family.append(tip)
 }
 
+// Apply logs edit to column family edit.ColumnFamily and appends resulting
+// version.
 func (m *Manifest) Apply(edit *Edit) error {
-	next, err := m.Log(m.version, edit)
+	m.mu.Lock()
+	family := m.families[edit.ColumnFamily]
+	m.mu.Unlock()
+	if family == nil {
+		return errors.ErrColumnFamilyDropped
+	}
+	next, err := m.Log(family.Version(), edit)
 	if err != nil {
 		return err
 	}
@@ -218,10 +381,6 @@ func (m *Manifest) Apply(edit *Edit) error {
 	return nil
 }
 
-func (m *Manifest) PickCompactions(registry *compaction.Registry, pendingFiles []FileList) []*Compaction {
-	return m.version.pickCompactions(registry, pendingFiles, m.NextFileNumber())
-}
-
 func Create(dbname string, opts *options.Options) (manifest *Manifest, err error) {
 	fs := opts.FileSystem
 
@@ -285,14 +444,26 @@ func Create(dbname string, opts *options.Options) (manifest *Manifest, err error
 		manifestNumber: manifestNumber,
 		liveFiles:      make(map[uint64]int),
 		scratch:        record,
-		tableCache:     table.NewCache(dbname, opts),
+		blobCache:      blob.NewCache(dbname, fs),
 	}
-	version := &Version{options: opts, cache: manifest.tableCache, manifest: manifest}
-	manifest.Append(version)
+	manifest.defaultFamily = manifest.newColumnFamily(0, DefaultColumnFamilyName, opts)
+	manifest.defaultFamily.logNumber = edit.LogNumber
+	manifest.defaultFamily.append(manifest.defaultFamily.tip)
+	manifest.families = map[uint32]*ColumnFamily{0: manifest.defaultFamily}
 	return manifest, nil
 }
 
 func Recover(dbname string, opts *options.Options) (*Manifest, error) {
+	return recoverManifest(dbname, opts, false)
+}
+
+// RecoverReadOnly recovers manifest without modifying manifest file. The
+// returned manifest must not be used to log edits.
+func RecoverReadOnly(dbname string, opts *options.Options) (*Manifest, error) {
+	return recoverManifest(dbname, opts, true)
+}
+
+func recoverManifest(dbname string, opts *options.Options, readOnly bool) (*Manifest, error) {
 	fs := opts.FileSystem
 	currentName := files.CurrentFileName(dbname)
 	manifestName, err := files.GetCurrentManifest(fs, dbname, currentName)
@@ -305,20 +476,12 @@ func Recover(dbname string, opts *options.Options) (*Manifest, error) {
 		return nil, errors.NewCorruption(0, "CURRENT", 0, "invalid manifest name")
 	}
 
-	manifestFile, err := fs.Open(manifestName, os.O_RDWR)
-	if err != nil {
-		return nil, err
+	flag := os.O_RDWR
+	if readOnly {
+		flag = os.O_RDONLY
 	}
-
-	var builder builder
-	builder.Comparator = opts.Comparator
-	builder.ManifestFile = manifestFile
-	builder.ManifestNumber = manifestNumber
-
-	version := &Version{options: opts}
-	offset, err := builder.Build(version)
+	manifestFile, err := fs.Open(manifestName, flag)
 	if err != nil {
-		manifestFile.Close()
 		return nil, err
 	}
 
@@ -327,20 +490,31 @@ func Recover(dbname string, opts *options.Options) (*Manifest, error) {
 		currentName:    currentName,
 		fs:             fs,
 		options:        opts,
-		lastSequence:   builder.LastSequence,
-		logFileNumber:  builder.LogNumber,
-		nextFileNumber: builder.NextFileNumber,
-		manifestLog:    record.NewWriter(manifestFile, offset),
 		manifestFile:   manifestFile,
 		manifestNumber: manifestNumber,
 		liveFiles:      make(map[uint64]int),
-		scratch:        builder.Scratch,
-		tableCache:     table.NewCache(dbname, opts),
+		blobCache:      blob.NewCache(dbname, fs),
+	}
+
+	var builder builder
+	builder.ManifestFile = manifestFile
+	builder.ManifestNumber = manifestNumber
+	builder.ReadOnly = readOnly
+	offset, err := builder.Build(manifest)
+	if err != nil {
+		manifestFile.Close()
+		return nil, err
+	}
+
+	manifest.lastSequence = builder.LastSequence
+	manifest.nextFileNumber = builder.NextFileNumber
+	manifest.manifestLog = record.NewWriter(manifestFile, offset)
+	manifest.scratch = builder.Scratch
+	for _, family := range manifest.families {
+		family.append(family.tip)
+		manifest.MarkFileNumberUsed(family.logNumber)
 	}
-	version.cache = manifest.tableCache
-	version.manifest = manifest
-	manifest.Append(version)
-	manifest.MarkFileNumberUsed(manifest.logFileNumber)
+	manifest.updateLogFileNumber()
 
 	return manifest, nil
 }
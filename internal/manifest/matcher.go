@@ -2,6 +2,7 @@ package manifest
 
 import (
 	"github.com/kezhuw/leveldb/internal/keys"
+	"github.com/kezhuw/leveldb/internal/merge"
 	"github.com/kezhuw/leveldb/internal/options"
 	"github.com/kezhuw/leveldb/internal/table"
 )
@@ -11,10 +12,9 @@ type matcher interface {
 }
 
 type getMatcher struct {
-	cache *table.Cache
+	cache  *table.Cache
+	lookup *merge.Lookup
 
-	err         error
-	value       []byte
 	firstMatch  LevelFileMeta
 	seekThrough LevelFileMeta
 }
@@ -27,8 +27,7 @@ func (m *getMatcher) Match(level int, file *FileMeta, ikey keys.InternalKey, opt
 	case m.seekThrough.FileMeta == nil:
 		m.seekThrough = m.firstMatch
 	}
-	m.value, m.err, ok = m.cache.Get(file.Number, file.Size, ikey, opts)
-	return ok
+	return m.cache.Get(file.Number, file.Size, ikey, m.lookup, opts)
 }
 
 type seekOverlapMatcher struct {
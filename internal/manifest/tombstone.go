@@ -0,0 +1,85 @@
+package manifest
+
+import (
+	"sort"
+
+	"github.com/kezhuw/leveldb/internal/configs"
+	"github.com/kezhuw/leveldb/internal/keys"
+	"github.com/kezhuw/leveldb/internal/rangedel"
+)
+
+// TombstoneLookup finds range tombstones covering user keys in files of a
+// version. It caches tombstones of last visited file in each level, so it
+// is cheap to look up keys in order.
+type TombstoneLookup struct {
+	version *Version
+
+	level0Loaded bool
+	level0       rangedel.List
+
+	files      [configs.NumberLevels]*FileMeta
+	tombstones [configs.NumberLevels]rangedel.List
+}
+
+// NewTombstoneLookup creates a lookup for range tombstones in this version.
+func (v *Version) NewTombstoneLookup() *TombstoneLookup {
+	return &TombstoneLookup{version: v}
+}
+
+func (l *TombstoneLookup) loadLevel0() error {
+	v := l.version
+	for _, f := range v.Levels[0] {
+		list, err := v.cache.RangeTombstones(f.Number, f.Size)
+		if err != nil {
+			return err
+		}
+		l.level0 = append(l.level0, list...)
+	}
+	l.level0Loaded = true
+	return nil
+}
+
+// loadFile loads tombstones from file in given level which may contain
+// tombstones covering ukey. Tombstones in a file cover only user keys
+// smaller than user key of file's largest key.
+func (l *TombstoneLookup) loadFile(level int, ukey []byte) (rangedel.List, error) {
+	v := l.version
+	ucmp := v.options.Comparator.UserKeyComparator
+	if f := l.files[level]; f != nil && ucmp.Compare(f.Smallest.UserKey(), ukey) <= 0 && ucmp.Compare(ukey, f.Largest.UserKey()) < 0 {
+		return l.tombstones[level], nil
+	}
+	files := v.Levels[level]
+	n := len(files)
+	i := sort.Search(n, func(i int) bool { return ucmp.Compare(ukey, files[i].Largest.UserKey()) < 0 })
+	if i == n || ucmp.Compare(ukey, files[i].Smallest.UserKey()) < 0 {
+		return nil, nil
+	}
+	list, err := v.cache.RangeTombstones(files[i].Number, files[i].Size)
+	if err != nil {
+		return nil, err
+	}
+	l.files[level], l.tombstones[level] = files[i], list
+	return list, nil
+}
+
+// MaxSequence returns largest sequence, not larger than seq, of range
+// tombstones covering ukey. It returns zero if there is no such tombstone.
+func (l *TombstoneLookup) MaxSequence(ukey []byte, seq keys.Sequence) (keys.Sequence, error) {
+	if !l.level0Loaded {
+		if err := l.loadLevel0(); err != nil {
+			return 0, err
+		}
+	}
+	ucmp := l.version.options.Comparator.UserKeyComparator
+	max := l.level0.MaxSequence(ucmp, ukey, seq)
+	for level := 1; level < configs.NumberLevels; level++ {
+		list, err := l.loadFile(level, ukey)
+		if err != nil {
+			return 0, err
+		}
+		if s := list.MaxSequence(ucmp, ukey, seq); s > max {
+			max = s
+		}
+	}
+	return max, nil
+}
@@ -2,6 +2,7 @@ package manifest
 
 import (
 	"fmt"
+	"math"
 	"sort"
 
 	"github.com/kezhuw/leveldb/internal/compaction"
@@ -9,8 +10,10 @@ import (
 	"github.com/kezhuw/leveldb/internal/errors"
 	"github.com/kezhuw/leveldb/internal/iterator"
 	"github.com/kezhuw/leveldb/internal/keys"
+	"github.com/kezhuw/leveldb/internal/merge"
 	"github.com/kezhuw/leveldb/internal/options"
 	"github.com/kezhuw/leveldb/internal/table"
+	"github.com/kezhuw/leveldb/internal/util"
 )
 
 type compactionScore struct {
@@ -37,11 +40,15 @@ type Version struct {
 	cache    *table.Cache
 	options  *options.Options
 	manifest *Manifest
+	family   *ColumnFamily
 
 	// Levels[0], sorted from newest to oldest;
 	// Levels[n], sorted from smallest to largest.
 	Levels [configs.NumberLevels]FileList
 
+	// Blob files containing values referenced from tables in this version.
+	BlobFiles map[uint64]*BlobFileMeta
+
 	scores []compactionScore
 
 	CompactionPointers [configs.NumberLevels]keys.InternalKey
@@ -60,6 +67,9 @@ func (v *Version) String() string {
 		}
 		s += "\n"
 	}
+	for _, f := range v.sortedBlobFiles() {
+		s += fmt.Sprintf("blob file %d: size %d, garbage %d\n", f.Number, f.Size, f.Garbage)
+	}
 	s += fmt.Sprintf("compaction scores: %v\n", v.scores)
 	for level, pointer := range v.CompactionPointers[:] {
 		if len(pointer) == 0 {
@@ -139,13 +149,14 @@ func (v *Version) match(m matcher, ikey keys.InternalKey, opts *options.ReadOpti
 	return false
 }
 
-func (v *Version) Get(ikey keys.InternalKey, opts *options.ReadOptions) ([]byte, LevelFileMeta, error) {
+// Get feeds entries of ikey's user key in this version to l, from newest to
+// oldest, until l is resolved. It returns file to compact due to seeks.
+func (v *Version) Get(ikey keys.InternalKey, l *merge.Lookup, opts *options.ReadOptions) LevelFileMeta {
 	var matcher getMatcher
 	matcher.cache = v.cache
-	if v.match(&matcher, ikey, opts) {
-		return matcher.value, matcher.seekThrough.seekThrough(), matcher.err
-	}
-	return nil, matcher.seekThrough.seekThrough(), errors.ErrNotFound
+	matcher.lookup = l
+	v.match(&matcher, ikey, opts)
+	return matcher.seekThrough.seekThrough()
 }
 
 func (v *Version) SeekOverlap(ikey keys.InternalKey, opts *options.ReadOptions) LevelFileMeta {
@@ -156,6 +167,39 @@ func (v *Version) SeekOverlap(ikey keys.InternalKey, opts *options.ReadOptions)
 	return LevelFileMeta{}
 }
 
+// ApproximateOffsetOf returns approximate offset of ikey as if all files in
+// this version are concatenated level by level.
+func (v *Version) ApproximateOffsetOf(ikey keys.InternalKey) uint64 {
+	icmp := v.options.Comparator
+	var offset uint64
+	for _, f := range v.Levels[0] {
+		switch {
+		case icmp.Compare(f.Largest, ikey) <= 0:
+			offset += f.Size
+		case icmp.Compare(f.Smallest, ikey) <= 0:
+			offset += v.cache.ApproximateOffsetOf(f.Number, f.Size, ikey)
+		}
+	}
+	for level := 1; level < configs.NumberLevels; level++ {
+		files := v.Levels[level]
+		n := len(files)
+		i := sort.Search(n, func(i int) bool { return icmp.Compare(files[i].Largest, ikey) > 0 })
+		offset += files[:i].TotalFileSize()
+		if i != n && icmp.Compare(files[i].Smallest, ikey) <= 0 {
+			offset += v.cache.ApproximateOffsetOf(files[i].Number, files[i].Size, ikey)
+		}
+	}
+	return offset
+}
+
+// TotalFileSize returns total file size of all files in this version.
+func (v *Version) TotalFileSize() (size uint64) {
+	for _, files := range v.Levels[:] {
+		size += files.TotalFileSize()
+	}
+	return size
+}
+
 func (v *Version) computeLevel0CompactionScore() float64 {
 	numFiles := len(v.Levels[0])
 	score := float64(numFiles) / float64(v.options.Level0CompactionFiles)
@@ -169,13 +213,36 @@ func (v *Version) computeLevel0CompactionScore() float64 {
 	return score
 }
 
+// maxBytesForLevels returns maximum total size of tables for levels above
+// level 0.
+func (v *Version) maxBytesForLevels() (maxBytes [configs.NumberLevels]float64) {
+	opts := v.options
+	for level := 1; level < len(maxBytes); level++ {
+		maxBytes[level] = opts.MaxBytesForLevel(level)
+	}
+	if !opts.DynamicLevelBytes {
+		return maxBytes
+	}
+	bottom := len(v.Levels) - 1
+	for bottom > 1 && len(v.Levels[bottom]) == 0 {
+		bottom--
+	}
+	base := float64(opts.MaxBytesForLevelBase)
+	size := float64(v.Levels[bottom].TotalFileSize())
+	for level := bottom - 1; level >= 1; level-- {
+		size /= opts.MaxBytesForLevelMultiplier
+		maxBytes[level] = math.Max(size, base)
+	}
+	return maxBytes
+}
+
 func (v *Version) computeCompactionScore() {
 	if score := v.computeLevel0CompactionScore(); score > 1.0 {
 		v.scores = append(v.scores, compactionScore{level: 0, score: score})
 	}
-	maxBytes := 10 * 1024 * 1024
+	maxBytes := v.maxBytesForLevels()
 	for level := 1; level < len(v.Levels)-1; level++ {
-		if score := float64(v.Levels[level].TotalFileSize()) / float64(maxBytes); score > 1.0 {
+		if score := float64(v.Levels[level].TotalFileSize()) / maxBytes[level]; score > 1.0 {
 			v.scores = append(v.scores, compactionScore{level: level, score: score})
 		}
 	}
@@ -381,7 +448,7 @@ func (v *Version) expandLevelInputs(c *Compaction, smallest, largest *keys.Inter
 	}
 	inputs1Size := c.Inputs[1].TotalFileSize()
 	expandeds0Size := expandeds0.TotalFileSize()
-	if expandeds0Size+inputs1Size >= configs.ExpandedCompactionLimitBytes {
+	if expandeds0Size+inputs1Size >= v.options.ExpandedCompactionLimitBytes(c.Level) {
 		return
 	}
 	smallest0, largest0 = v.rangeOf(expandeds0)
@@ -404,12 +471,81 @@ func (v *Version) newLevelCompaction(registration *compaction.Registration, leve
 	if grandparentsLevel := c.Level + 2; grandparentsLevel < configs.NumberLevels {
 		c.Grandparents = v.appendOverlappingFiles(c.Grandparents[:0], grandparentsLevel, allSmallest, allLargest)
 	}
-	c.MaxOutputFileSize = configs.TargetFileSize
+	c.MaxOutputFileSize = v.options.TargetFileSizeOfLevel(c.Level + 1)
+	c.MaxGrandparentOverlappingBytes = v.options.MaxGrandparentOverlappingBytes(c.Level + 1)
 	c.NextCompactPointer = largest
 
 	return c
 }
 
+// rangeBounds returns bounds of user key range [start, limit] in level. Zero
+// length start and limit act as infinite small and infinite large.
+func (v *Version) rangeBounds(level int, start, limit []byte) (smallest, largest keys.InternalKey, ok bool) {
+	files := v.Levels[level]
+	if len(files) == 0 {
+		return nil, nil, false
+	}
+	smallest, largest = v.rangeOf(files)
+	if len(start) != 0 {
+		smallest = keys.NewInternalKey(start, keys.MaxSequence, keys.Seek)
+	}
+	if len(limit) != 0 {
+		largest = keys.NewInternalKey(limit, 0, keys.Delete)
+	}
+	return smallest, largest, true
+}
+
+// MaxOverlappingLevel returns the maximum level which contains files overlapping
+// with user key range [start, limit]. Zero length start and limit act as infinite
+// small and infinite large. It returns -1 if there is no such level.
+func (v *Version) MaxOverlappingLevel(start, limit []byte) int {
+	for level := configs.NumberLevels - 1; level >= 0; level-- {
+		smallest, largest, ok := v.rangeBounds(level, start, limit)
+		if ok && v.isOverlappingWithLevel(level, smallest, largest) {
+			return level
+		}
+	}
+	return -1
+}
+
+// NewRangeCompaction creates a compaction for files in level overlapping with
+// user key range [start, limit]. Zero length start and limit act as infinite
+// small and infinite large. It returns nil if there is no such files. If this
+// compaction doesn't cover all these files, next is a non nil user key where
+// following compaction should start from. Caller should fill Registration
+// before start this compaction.
+func (v *Version) NewRangeCompaction(level int, start, limit []byte) (c *Compaction, next []byte) {
+	smallest, largest, ok := v.rangeBounds(level, start, limit)
+	if !ok {
+		return nil, nil
+	}
+	inputs := v.appendOverlappingFiles(nil, level, smallest, largest)
+	if len(inputs) == 0 {
+		return nil, nil
+	}
+	// Files in level-0 may overlap with each other, we can't pick part of them.
+	// For other levels, don't compact too many files in one compaction.
+	var truncated bool
+	if level > 0 {
+		var size uint64
+		maxBytes := uint64(v.options.TargetFileSizeOfLevel(level))
+		for i, f := range inputs {
+			size += f.Size
+			if size >= maxBytes {
+				truncated = i+1 < len(inputs)
+				inputs = inputs[:i+1]
+				break
+			}
+		}
+	}
+	c = v.newLevelCompaction(nil, level, inputs)
+	c.Manual = true
+	if truncated {
+		next = util.DupBytes(c.NextCompactPointer.UserKey())
+	}
+	return c, next
+}
+
 func (v *Version) appendScoreCompactions(compactions []*Compaction, registry *compaction.Registry, nextFileNumber uint64) []*Compaction {
 	for _, score := range v.scores {
 		level := score.level
@@ -469,7 +605,12 @@ func (v *Version) PickLevelForMemTableOutput(smallest, largest []byte) int {
 		return 0
 	}
 	var size sizeOverlayer
+	compression := v.options.CompressionOfLevel(0)
 	for level := 1; level <= maxLevel; level++ {
+		// Memtable output is compressed as level 0 table.
+		if v.options.CompressionOfLevel(level) != compression {
+			return level - 1
+		}
 		if level+1 >= configs.NumberLevels {
 			return level
 		}
@@ -477,7 +618,7 @@ func (v *Version) PickLevelForMemTableOutput(smallest, largest []byte) int {
 		size.total = 0
 		v.overlapLeveln(&size, level+1, smallest, largest)
 		switch {
-		case size.total > configs.MaxGrandparentOverlappingBytes:
+		case uint64(size.total) > v.options.MaxGrandparentOverlappingBytes(level):
 			return level - 1
 		case size.total != 0:
 			return level
@@ -495,7 +636,8 @@ func (v *Version) edit(edit *Edit) (*Version, error) {
 	return v1, nil
 }
 
-func (v *Version) snapshot(edit *Edit) {
+// Snapshot records all files and compaction pointers of this version in edit.
+func (v *Version) Snapshot(edit *Edit) {
 	for level := 0; level < configs.NumberLevels; level++ {
 		if len(v.CompactionPointers[level]) != 0 {
 			edit.CompactPointers = append(edit.CompactPointers, LevelCompactPointer{Level: level, Largest: v.CompactionPointers[level]})
@@ -504,14 +646,30 @@ func (v *Version) snapshot(edit *Edit) {
 			edit.AddedFiles = append(edit.AddedFiles, LevelFileMeta{Level: level, FileMeta: f})
 		}
 	}
+	for _, f := range v.sortedBlobFiles() {
+		edit.AddedBlobFiles = append(edit.AddedBlobFiles, *f)
+	}
+}
+
+func (v *Version) sortedBlobFiles() []*BlobFileMeta {
+	blobFiles := make([]*BlobFileMeta, 0, len(v.BlobFiles))
+	for _, f := range v.BlobFiles {
+		blobFiles = append(blobFiles, f)
+	}
+	sort.Slice(blobFiles, func(i, j int) bool { return blobFiles[i].Number < blobFiles[j].Number })
+	return blobFiles
 }
 
 func (v *Version) clone() *Version {
-	copy := &Version{number: v.number + 1, options: v.options, cache: v.cache, manifest: v.manifest}
+	copy := &Version{number: v.number + 1, options: v.options, cache: v.cache, manifest: v.manifest, family: v.family}
 	for level := 0; level < configs.NumberLevels; level++ {
 		copy.Levels[level] = v.Levels[level].Dup()
 	}
 	copy.CompactionPointers = v.CompactionPointers
+	copy.BlobFiles = make(map[uint64]*BlobFileMeta, len(v.BlobFiles))
+	for number, f := range v.BlobFiles {
+		copy.BlobFiles[number] = f
+	}
 	return copy
 }
 
@@ -530,15 +688,48 @@ func (v *Version) apply(edit *Edit) error {
 	for _, pointer := range edit.CompactPointers {
 		v.CompactionPointers[pointer.Level] = pointer.Largest
 	}
+	v.applyBlobFiles(edit)
 	return v.SortFiles()
 }
 
+// applyBlobFiles adds blob files and their garbage in edit to this version.
+// Blob file is deleted once all its bytes become garbage, later garbage of it
+// is ignored.
+func (v *Version) applyBlobFiles(edit *Edit) {
+	if len(edit.AddedBlobFiles) == 0 && len(edit.BlobGarbages) == 0 {
+		return
+	}
+	if v.BlobFiles == nil {
+		v.BlobFiles = make(map[uint64]*BlobFileMeta)
+	}
+	for _, added := range edit.AddedBlobFiles {
+		f := added
+		v.BlobFiles[f.Number] = &f
+	}
+	for _, garbage := range edit.BlobGarbages {
+		old := v.BlobFiles[garbage.Number]
+		if old == nil {
+			continue
+		}
+		f := *old
+		f.Garbage += garbage.Bytes
+		if f.Garbage >= f.Size {
+			delete(v.BlobFiles, f.Number)
+			continue
+		}
+		v.BlobFiles[f.Number] = &f
+	}
+}
+
 func (v *Version) refFiles(files map[uint64]int) {
 	for level := 0; level < configs.NumberLevels; level++ {
 		for _, f := range v.Levels[level] {
 			files[f.Number]++
 		}
 	}
+	for number := range v.BlobFiles {
+		files[number]++
+	}
 }
 
 func (v *Version) unrefFiles(files map[uint64]int) {
@@ -552,6 +743,14 @@ func (v *Version) unrefFiles(files map[uint64]int) {
 			files[f.Number] = refs
 		}
 	}
+	for number := range v.BlobFiles {
+		refs := files[number] - 1
+		if refs <= 0 {
+			delete(files, number)
+			continue
+		}
+		files[number] = refs
+	}
 }
 
 func (v *Version) finalize() {
@@ -2,10 +2,13 @@ package memtable
 
 import (
 	"math/rand"
+	"sync"
+	"sync/atomic"
 
-	"github.com/kezhuw/leveldb/internal/errors"
 	"github.com/kezhuw/leveldb/internal/iterator"
 	"github.com/kezhuw/leveldb/internal/keys"
+	"github.com/kezhuw/leveldb/internal/merge"
+	"github.com/kezhuw/leveldb/internal/rangedel"
 )
 
 const (
@@ -27,6 +30,9 @@ type node struct {
 }
 
 type MemTable struct {
+	// Accessed atomically, keep it 64-bit aligned.
+	usage int64
+
 	rnd *rand.Rand
 
 	height int
@@ -34,16 +40,20 @@ type MemTable struct {
 	prevs  [maxHeight]*node
 	icmp   *keys.InternalComparator
 
-	usage int
 	bytes []byte
 	nexts []*node
 	nodes []node
 
 	mutex rwmutex
+
+	// Range tombstones are not stored in skiplist, so iterators see
+	// only point entries.
+	tombstonesMutex sync.RWMutex
+	tombstones      rangedel.List
 }
 
 func (m *MemTable) allocBytes(n int) []byte {
-	m.usage += n
+	atomic.AddInt64(&m.usage, int64(n))
 	len, cap := len(m.bytes), cap(m.bytes)
 	size := len + n
 	if size <= cap {
@@ -147,15 +157,44 @@ func (m *MemTable) findGreaterOrEqual(ikey []byte, prevs []*node) (n *node, hit
 	return
 }
 
+// ApproximateMemoryUsage returns approximate memory used by this memtable.
+// It is safe to call it concurrently with writes.
 func (m *MemTable) ApproximateMemoryUsage() int {
-	return m.usage
+	return int(atomic.LoadInt64(&m.usage))
 }
 
 func (m *MemTable) Empty() bool {
-	return m.head.Next(0) == nil
+	return m.head.Next(0) == nil && len(m.RangeTombstones()) == 0
+}
+
+// RangeTombstones returns range tombstones added to this memtable so far.
+func (m *MemTable) RangeTombstones() rangedel.List {
+	m.tombstonesMutex.RLock()
+	tombstones := m.tombstones
+	m.tombstonesMutex.RUnlock()
+	n := len(tombstones)
+	return tombstones[:n:n]
+}
+
+func (m *MemTable) addRangeTombstone(seq keys.Sequence, start, limit []byte) {
+	// Empty range deletes nothing.
+	if m.icmp.UserKeyComparator.Compare(start, limit) >= 0 {
+		return
+	}
+	b := m.allocBytes(len(start) + len(limit))
+	n := copy(b, start)
+	copy(b[n:], limit)
+	t := rangedel.Tombstone{Start: b[:n:n], Limit: b[n:], Sequence: seq}
+	m.tombstonesMutex.Lock()
+	m.tombstones = append(m.tombstones, t)
+	m.tombstonesMutex.Unlock()
 }
 
 func (m *MemTable) Add(seq keys.Sequence, kind keys.Kind, key, value []byte) {
+	if kind == keys.RangeDelete {
+		m.addRangeTombstone(seq, key, value)
+		return
+	}
 	ikeyLen := len(key) + keys.TagBytes
 	b := m.allocBytes(ikeyLen + len(value))
 	ikey := []byte(keys.MakeInternalKey(b, key, seq, kind))
@@ -168,7 +207,7 @@ func (m *MemTable) Add(seq keys.Sequence, kind keys.Kind, key, value []byte) {
 
 	n := m.allocNode()
 	n.ikey = ikey
-	if kind == keys.Value {
+	if kind != keys.Delete {
 		n.value = b[ikeyLen:]
 		copy(n.value, value)
 	}
@@ -191,17 +230,37 @@ func (m *MemTable) Add(seq keys.Sequence, kind keys.Kind, key, value []byte) {
 	m.mutex.Unlock()
 }
 
-func (m *MemTable) Get(ikey keys.InternalKey) (value []byte, err error, ok bool) {
+// Get feeds entries of ikey's user key with sequence not greater than ikey's
+// to l, from newest to oldest. It returns true if l is resolved.
+func (m *MemTable) Get(ikey keys.InternalKey, l *merge.Lookup) bool {
+	ukey, seq, _ := ikey.Split()
+	ucmp := m.icmp.UserKeyComparator
+	deletedSequence := m.RangeTombstones().MaxSequence(ucmp, ukey, seq)
+	// Merge operands are collected with lock held, final merging happens
+	// after lock released.
+	var last *node
 	m.mutex.RLock()
-	n, hit := m.findGreaterOrEqual(ikey, nil)
-	m.mutex.RUnlock()
-	if n != nil && (hit || m.icmp.UserKeyComparator.Compare(ikey.UserKey(), keys.InternalKey(n.ikey).UserKey()) == 0) {
-		if n.value == nil {
-			err = errors.ErrNotFound
+	n, _ := m.findGreaterOrEqual(ikey, nil)
+	for ; n != nil; n = n.Next(0) {
+		currentUserKey, currentSequence, kind := keys.InternalKey(n.ikey).Split()
+		if currentSequence <= deletedSequence || ucmp.Compare(ukey, currentUserKey) != 0 {
+			break
 		}
-		return n.value, err, true
+		if kind != keys.Merge {
+			last = n
+			break
+		}
+		l.Add(kind, n.value)
+	}
+	m.mutex.RUnlock()
+	switch {
+	case last != nil:
+		_, _, kind := keys.InternalKey(last.ikey).Split()
+		return l.Add(kind, last.value)
+	case deletedSequence != 0:
+		return l.Add(keys.Delete, nil)
 	}
-	return nil, nil, false
+	return false
 }
 
 func (m *MemTable) NewIterator() iterator.Iterator {
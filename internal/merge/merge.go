@@ -0,0 +1,128 @@
+// Package merge defines merge operator, which folds operands written by
+// merges into values lazily.
+package merge
+
+import (
+	"github.com/kezhuw/leveldb/internal/errors"
+	"github.com/kezhuw/leveldb/internal/keys"
+	"github.com/kezhuw/leveldb/internal/ttl"
+)
+
+// Operator merges operands into existing value of a key.
+type Operator interface {
+	Name() string
+	Merge(key, existing []byte, operands [][]byte) ([]byte, error)
+}
+
+// Merge folds operands, which are ordered from newest to oldest, into
+// existing value. Operator receives operands from oldest to newest.
+func Merge(operator Operator, key, existing []byte, operands [][]byte) ([]byte, error) {
+	if operator == nil {
+		return nil, errors.ErrNoMergeOperator
+	}
+	n := len(operands)
+	reversed := make([][]byte, n)
+	for i, operand := range operands {
+		reversed[n-1-i] = operand
+	}
+	return operator.Merge(key, existing, reversed)
+}
+
+// BlobReader reads values stored in blob files.
+type BlobReader interface {
+	// ReadBlob reads value located by pointer of keys.BlobIndex entry.
+	ReadBlob(pointer []byte) ([]byte, error)
+}
+
+// Lookup resolves value of a key from its entries, which are fed from
+// newest to oldest.
+type Lookup struct {
+	operator Operator
+	blobs    BlobReader
+	key      []byte
+	// Time in nanoseconds since Unix epoch to expire values.
+	now int64
+
+	// Merge operands from newest to oldest.
+	operands [][]byte
+
+	value    []byte
+	err      error
+	resolved bool
+}
+
+// Reset resets lookup to resolve value of key at time now. Values of
+// keys.BlobIndex entries are read through blobs.
+func (l *Lookup) Reset(operator Operator, blobs BlobReader, key []byte, now int64) {
+	l.operator = operator
+	l.blobs = blobs
+	l.key = key
+	l.now = now
+	l.operands = l.operands[:0]
+	l.value = nil
+	l.err = nil
+	l.resolved = false
+}
+
+// Add feeds entry of kind with value to lookup. It returns true if value
+// of key is resolved, no older entries are needed.
+func (l *Lookup) Add(kind keys.Kind, value []byte) bool {
+	switch kind {
+	case keys.Merge:
+		l.operands = append(l.operands, value)
+		return false
+	case keys.Value:
+		if value == nil {
+			value = []byte{}
+		}
+		l.resolve(value)
+	case keys.ExpiringValue:
+		value, live, err := ttl.Live(value, l.now)
+		switch {
+		case err != nil:
+			l.Fail(err)
+		case live:
+			l.resolve(value)
+		default:
+			// Operands are merged as if key did not exist.
+			l.resolve(nil)
+		}
+	case keys.BlobIndex:
+		value, err := l.blobs.ReadBlob(value)
+		if err != nil {
+			l.Fail(err)
+			break
+		}
+		l.resolve(value)
+	default:
+		l.resolve(nil)
+	}
+	return true
+}
+
+// Fail resolves lookup with err.
+func (l *Lookup) Fail(err error) {
+	l.err = err
+	l.resolved = true
+}
+
+func (l *Lookup) resolve(existing []byte) {
+	l.resolved = true
+	switch {
+	case len(l.operands) != 0:
+		l.value, l.err = Merge(l.operator, l.key, existing, l.operands)
+	case existing == nil:
+		l.err = errors.ErrNotFound
+	default:
+		l.value = existing
+	}
+}
+
+// Result returns value of key. If no entries terminating lookup were fed,
+// operands are merged as if key did not exist.
+func (l *Lookup) Result() ([]byte, error) {
+	if !l.resolved {
+		l.resolve(nil)
+	}
+	return l.value, l.err
+}
@@ -0,0 +1,99 @@
+package merge_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/kezhuw/leveldb/internal/errors"
+	"github.com/kezhuw/leveldb/internal/keys"
+	"github.com/kezhuw/leveldb/internal/merge"
+	"github.com/kezhuw/leveldb/internal/ttl"
+)
+
+type appendOperator struct{}
+
+func (appendOperator) Name() string {
+	return "append"
+}
+
+func (appendOperator) Merge(key, existing []byte, operands [][]byte) ([]byte, error) {
+	if existing == nil {
+		existing = []byte("-")
+	}
+	return bytes.Join(append([][]byte{existing}, operands...), []byte(",")), nil
+}
+
+func TestMerge(t *testing.T) {
+	operands := [][]byte{[]byte("c"), []byte("b"), []byte("a")}
+	value, err := merge.Merge(appendOperator{}, []byte("key"), []byte("v"), operands)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(value) != "v,a,b,c" {
+		t.Errorf("got %q, want %q", value, "v,a,b,c")
+	}
+	if _, err := merge.Merge(nil, []byte("key"), nil, operands); err != errors.ErrNoMergeOperator {
+		t.Errorf("got error %v, want %v", err, errors.ErrNoMergeOperator)
+	}
+}
+
+type entry struct {
+	kind  keys.Kind
+	value string
+}
+
+const now = 100
+
+func live(value string) string {
+	return string(ttl.Append(nil, now+1, []byte(value)))
+}
+
+func expired(value string) string {
+	return string(ttl.Append(nil, now, []byte(value)))
+}
+
+// prefixBlobs reads blob values as pointers prefixed with "blob:".
+type prefixBlobs struct{}
+
+func (prefixBlobs) ReadBlob(pointer []byte) ([]byte, error) {
+	if !bytes.HasPrefix(pointer, []byte("blob:")) {
+		return nil, errors.ErrCorruptBlobPointer
+	}
+	return pointer[len("blob:"):], nil
+}
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		entries []entry
+		value   string
+		err     error
+	}{
+		{[]entry{{keys.Value, "v"}}, "v", nil},
+		{[]entry{{keys.Delete, ""}}, "", errors.ErrNotFound},
+		{nil, "", errors.ErrNotFound},
+		{[]entry{{keys.Merge, "b"}, {keys.Merge, "a"}, {keys.Value, "v"}, {keys.Merge, "x"}}, "v,a,b", nil},
+		{[]entry{{keys.Merge, "b"}, {keys.Merge, "a"}, {keys.Delete, ""}}, "-,a,b", nil},
+		{[]entry{{keys.Merge, "a"}}, "-,a", nil},
+		{[]entry{{keys.ExpiringValue, live("v")}}, "v", nil},
+		{[]entry{{keys.ExpiringValue, expired("v")}}, "", errors.ErrNotFound},
+		{[]entry{{keys.Merge, "a"}, {keys.ExpiringValue, live("v")}}, "v,a", nil},
+		{[]entry{{keys.Merge, "a"}, {keys.ExpiringValue, expired("v")}, {keys.Value, "x"}}, "-,a", nil},
+		{[]entry{{keys.ExpiringValue, "v"}}, "", errors.ErrCorruptExpiration},
+		{[]entry{{keys.BlobIndex, "blob:v"}}, "v", nil},
+		{[]entry{{keys.Merge, "a"}, {keys.BlobIndex, "blob:v"}}, "v,a", nil},
+		{[]entry{{keys.BlobIndex, "v"}}, "", errors.ErrCorruptBlobPointer},
+	}
+	var l merge.Lookup
+	for i, test := range tests {
+		l.Reset(appendOperator{}, prefixBlobs{}, []byte("key"), now)
+		for _, e := range test.entries {
+			if l.Add(e.kind, []byte(e.value)) {
+				break
+			}
+		}
+		value, err := l.Result()
+		if err != test.err || string(value) != test.value {
+			t.Errorf("test %d: got %q %v, want %q %v", i, value, err, test.value, test.err)
+		}
+	}
+}
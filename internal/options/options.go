@@ -1,11 +1,15 @@
 package options
 
 import (
+	"time"
+
+	"github.com/kezhuw/leveldb/internal/compaction"
 	"github.com/kezhuw/leveldb/internal/compress"
 	"github.com/kezhuw/leveldb/internal/file"
 	"github.com/kezhuw/leveldb/internal/filter"
 	"github.com/kezhuw/leveldb/internal/keys"
 	"github.com/kezhuw/leveldb/internal/logger"
+	"github.com/kezhuw/leveldb/internal/merge"
 )
 
 const (
@@ -40,16 +44,49 @@ const (
 	DefaultLevel0CompactionFiles    = 4
 	DefaultLevel0SlowdownWriteFiles = DefaultLevel0CompactionFiles + DefaultLevel0ThrottleStepFiles
 	DefaultLevel0StopWriteFiles     = DefaultLevel0SlowdownWriteFiles + DefaultLevel0ThrottleStepFiles
+
+	DefaultMaxBytesForLevelBase       = 10 * 1024 * 1024
+	DefaultMaxBytesForLevelMultiplier = 10.0
+
+	DefaultTargetFileSize              = 2 * 1024 * 1024
+	DefaultTargetFileSizeMultiplier    = 1
+	DefaultExpandedCompactionFactor    = 25
+	DefaultMaxGrandparentOverlapFactor = 10
+
+	DefaultLockTimeout = time.Second
+
+	DefaultBlobGarbageRatio = 0.5
 )
 
 var DefaultInternalComparator keys.InternalComparator = keys.InternalComparator{UserKeyComparator: keys.BytewiseComparator}
 
+// Clock tells current time to expire values.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+var DefaultClock Clock = systemClock{}
+
 type Options struct {
-	Comparator  *keys.InternalComparator
-	Compression compress.Type
-	Filter      filter.Filter
-	Logger      logger.LogCloser
-	FileSystem  file.FileSystem
+	Comparator       *keys.InternalComparator
+	Compression      compress.Type
+	Filter           filter.Filter
+	MergeOperator    merge.Operator
+	CompactionFilter compaction.Filter
+	Clock            Clock
+	Logger           logger.LogCloser
+	FileSystem       file.FileSystem
+
+	LevelCompression []compress.Type
+
+	// Options of column families opened along with db.
+	ColumnFamilies map[string]*Options
 
 	BlockSize                   int
 	BlockRestartInterval        int
@@ -64,9 +101,20 @@ type Options struct {
 	Level0CompactionFiles       int
 	Level0SlowdownWriteFiles    int
 	Level0StopWriteFiles        int
+	MaxBytesForLevelBase        int
+	MaxBytesForLevelMultiplier  float64
+	TargetFileSize              int
+	TargetFileSizeMultiplier    int
+	ExpandedCompactionFactor    int
+	MaxGrandparentOverlapFactor int
+	MinBlobSize                 int
+	BlobGarbageRatio            float64
 
-	CreateIfMissing bool
-	ErrorIfExists   bool
+	DynamicLevelBytes       bool
+	CreateIfMissing         bool
+	ErrorIfExists           bool
+	RetainLogs              bool
+	CompactionFilterOnFlush bool
 }
 
 type ReadOptions struct {
@@ -75,13 +123,19 @@ type ReadOptions struct {
 }
 
 type WriteOptions struct {
-	Sync bool
+	Sync       bool
+	DisableWAL bool
+}
+
+type TxnOptions struct {
+	LockTimeout time.Duration
 }
 
 var DefaultOptions = Options{
 	Comparator:                  &DefaultInternalComparator,
 	Compression:                 compress.SnappyCompression,
 	FileSystem:                  file.DefaultFileSystem,
+	Clock:                       DefaultClock,
 	BlockSize:                   DefaultBlockSize,
 	BlockRestartInterval:        DefaultBlockRestartInterval,
 	BlockCompressionRatio:       DefaultBlockCompressionRatio,
@@ -95,6 +149,108 @@ var DefaultOptions = Options{
 	Level0CompactionFiles:       DefaultLevel0CompactionFiles,
 	Level0SlowdownWriteFiles:    DefaultLevel0SlowdownWriteFiles,
 	Level0StopWriteFiles:        DefaultLevel0StopWriteFiles,
+	MaxBytesForLevelBase:        DefaultMaxBytesForLevelBase,
+	MaxBytesForLevelMultiplier:  DefaultMaxBytesForLevelMultiplier,
+	TargetFileSize:              DefaultTargetFileSize,
+	TargetFileSizeMultiplier:    DefaultTargetFileSizeMultiplier,
+	ExpandedCompactionFactor:    DefaultExpandedCompactionFactor,
+	MaxGrandparentOverlapFactor: DefaultMaxGrandparentOverlapFactor,
+	BlobGarbageRatio:            DefaultBlobGarbageRatio,
 }
 var DefaultReadOptions = ReadOptions{}
 var DefaultWriteOptions = WriteOptions{}
+var DefaultTxnOptions = TxnOptions{LockTimeout: DefaultLockTimeout}
+
+// Now returns current time in nanoseconds since Unix epoch.
+func (opts *Options) Now() int64 {
+	if opts.Clock == nil {
+		return time.Now().UnixNano()
+	}
+	return opts.Clock.Now().UnixNano()
+}
+
+// ColumnFamilyOptions returns options of column family name, which are
+// opts for column families not in opts.ColumnFamilies.
+func (opts *Options) ColumnFamilyOptions(name string) *Options {
+	if familyOpts := opts.ColumnFamilies[name]; familyOpts != nil {
+		return opts.ForColumnFamily(familyOpts)
+	}
+	return opts
+}
+
+// ForColumnFamily returns a copy of familyOpts with fields shared by all
+// column families of db taken from opts.
+func (opts *Options) ForColumnFamily(familyOpts *Options) *Options {
+	o := *familyOpts
+	o.Clock = opts.Clock
+	o.Logger = opts.Logger
+	o.FileSystem = opts.FileSystem
+	o.CreateIfMissing = opts.CreateIfMissing
+	o.ErrorIfExists = opts.ErrorIfExists
+	o.RetainLogs = opts.RetainLogs
+	o.ColumnFamilies = nil
+	return &o
+}
+
+// CompressionOfLevel returns compression type for tables in given level.
+func (opts *Options) CompressionOfLevel(level int) compress.Type {
+	n := len(opts.LevelCompression)
+	switch {
+	case n == 0:
+		return opts.Compression
+	case level < n:
+		return opts.LevelCompression[level]
+	default:
+		return opts.LevelCompression[n-1]
+	}
+}
+
+// CheckCompression returns compress.ErrUnsupportedCompression if any
+// compression types in opts have no compressors.
+func (opts *Options) CheckCompression() error {
+	if !compress.Supported(opts.Compression) {
+		return compress.ErrUnsupportedCompression
+	}
+	for _, typ := range opts.LevelCompression {
+		if !compress.Supported(typ) {
+			return compress.ErrUnsupportedCompression
+		}
+	}
+	for _, familyOpts := range opts.ColumnFamilies {
+		if err := familyOpts.CheckCompression(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MaxBytesForLevel returns maximum total size of tables in given level, which
+// is not less than 1.
+func (opts *Options) MaxBytesForLevel(level int) float64 {
+	maxBytes := float64(opts.MaxBytesForLevelBase)
+	for ; level > 1; level-- {
+		maxBytes *= opts.MaxBytesForLevelMultiplier
+	}
+	return maxBytes
+}
+
+// TargetFileSizeOfLevel returns target size of table files in given level.
+func (opts *Options) TargetFileSizeOfLevel(level int) int64 {
+	fileSize := int64(opts.TargetFileSize)
+	for ; level > 1; level-- {
+		fileSize *= int64(opts.TargetFileSizeMultiplier)
+	}
+	return fileSize
+}
+
+// ExpandedCompactionLimitBytes returns maximum total size of files in a
+// compaction from given level after expanding.
+func (opts *Options) ExpandedCompactionLimitBytes(level int) uint64 {
+	return uint64(opts.ExpandedCompactionFactor) * uint64(opts.TargetFileSizeOfLevel(level))
+}
+
+// MaxGrandparentOverlappingBytes returns maximum total size of grandparent
+// files a table file in given level could overlap with.
+func (opts *Options) MaxGrandparentOverlappingBytes(level int) uint64 {
+	return uint64(opts.MaxGrandparentOverlapFactor) * uint64(opts.TargetFileSizeOfLevel(level))
+}
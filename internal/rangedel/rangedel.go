@@ -0,0 +1,72 @@
+// Package rangedel defines range tombstones which delete all keys in user key
+// range [Start, Limit) with sequence smaller than the tombstone.
+package rangedel
+
+import (
+	"sort"
+
+	"github.com/kezhuw/leveldb/internal/keys"
+)
+
+// Tombstone deletes keys in range [Start, Limit) written before it.
+type Tombstone struct {
+	Start    []byte
+	Limit    []byte
+	Sequence keys.Sequence
+}
+
+// Covers returns whether ukey falls in range of this tombstone.
+func (t *Tombstone) Covers(ucmp keys.Comparer, ukey []byte) bool {
+	return ucmp.Compare(t.Start, ukey) <= 0 && ucmp.Compare(ukey, t.Limit) < 0
+}
+
+// SmallestKey returns internal key which this tombstone is stored as.
+func (t *Tombstone) SmallestKey() keys.InternalKey {
+	return keys.NewInternalKey(t.Start, t.Sequence, keys.RangeDelete)
+}
+
+// LargestKey returns an internal key which is smaller than all keys with
+// user key Limit, but larger than all keys covered by this tombstone. It
+// serves as largest key of table files which contain this tombstone.
+func (t *Tombstone) LargestKey() keys.InternalKey {
+	return keys.NewInternalKey(t.Limit, keys.MaxSequence, keys.Seek)
+}
+
+// Clip returns part of this tombstone in range [start, limit). Empty start
+// and limit act as infinite small and infinite large. It returns false if
+// there is no overlapping.
+func (t Tombstone) Clip(ucmp keys.Comparer, start, limit []byte) (Tombstone, bool) {
+	if len(start) != 0 && ucmp.Compare(t.Start, start) < 0 {
+		t.Start = start
+	}
+	if len(limit) != 0 && ucmp.Compare(limit, t.Limit) < 0 {
+		t.Limit = limit
+	}
+	return t, ucmp.Compare(t.Start, t.Limit) < 0
+}
+
+// List is a list of range tombstones.
+type List []Tombstone
+
+// MaxSequence returns largest sequence, not larger than seq, of tombstones
+// covering ukey. It returns zero if there is no such tombstone.
+func (l List) MaxSequence(ucmp keys.Comparer, ukey []byte, seq keys.Sequence) keys.Sequence {
+	var max keys.Sequence
+	for i := range l {
+		t := &l[i]
+		if t.Sequence > max && t.Sequence <= seq && t.Covers(ucmp, ukey) {
+			max = t.Sequence
+		}
+	}
+	return max
+}
+
+// Sort sorts tombstones in order of their smallest keys.
+func (l List) Sort(ucmp keys.Comparer) {
+	sort.Slice(l, func(i, j int) bool {
+		if r := ucmp.Compare(l[i].Start, l[j].Start); r != 0 {
+			return r < 0
+		}
+		return l[i].Sequence > l[j].Sequence
+	})
+}
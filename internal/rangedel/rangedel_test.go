@@ -0,0 +1,117 @@
+package rangedel_test
+
+import (
+	"testing"
+
+	"github.com/kezhuw/leveldb/internal/keys"
+	"github.com/kezhuw/leveldb/internal/rangedel"
+)
+
+var ucmp = keys.BytewiseComparator
+
+func TestTombstoneCovers(t *testing.T) {
+	tombstone := rangedel.Tombstone{Start: []byte("b"), Limit: []byte("d"), Sequence: 10}
+	tests := []struct {
+		key    string
+		covers bool
+	}{
+		{"a", false},
+		{"b", true},
+		{"c", true},
+		{"cz", true},
+		{"d", false},
+		{"e", false},
+	}
+	for _, test := range tests {
+		if got := tombstone.Covers(ucmp, []byte(test.key)); got != test.covers {
+			t.Errorf("key=%q got=%t want=%t", test.key, got, test.covers)
+		}
+	}
+}
+
+func TestTombstoneClip(t *testing.T) {
+	tombstone := rangedel.Tombstone{Start: []byte("b"), Limit: []byte("f"), Sequence: 10}
+	tests := []struct {
+		start, limit string
+		ok           bool
+		wantStart    string
+		wantLimit    string
+	}{
+		{"", "", true, "b", "f"},
+		{"a", "g", true, "b", "f"},
+		{"c", "", true, "c", "f"},
+		{"", "d", true, "b", "d"},
+		{"c", "d", true, "c", "d"},
+		{"f", "", false, "f", "f"},
+		{"", "b", false, "b", "b"},
+	}
+	for _, test := range tests {
+		got, ok := tombstone.Clip(ucmp, []byte(test.start), []byte(test.limit))
+		if ok != test.ok {
+			t.Errorf("start=%q limit=%q got=%t want=%t", test.start, test.limit, ok, test.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if string(got.Start) != test.wantStart || string(got.Limit) != test.wantLimit || got.Sequence != tombstone.Sequence {
+			t.Errorf("start=%q limit=%q got=%q-%q@%d want=%q-%q@%d", test.start, test.limit, got.Start, got.Limit, got.Sequence, test.wantStart, test.wantLimit, tombstone.Sequence)
+		}
+	}
+}
+
+func TestTombstoneKeys(t *testing.T) {
+	icmp := keys.InternalComparator{UserKeyComparator: ucmp}
+	tombstone := rangedel.Tombstone{Start: []byte("b"), Limit: []byte("d"), Sequence: 10}
+	smallest, largest := tombstone.SmallestKey(), tombstone.LargestKey()
+	if icmp.Compare(smallest, keys.NewInternalKey([]byte("b"), 9, keys.Value)) >= 0 {
+		t.Errorf("smallest key %q not smaller than covered key", smallest)
+	}
+	if icmp.Compare(largest, keys.NewInternalKey([]byte("cz"), 1, keys.Value)) <= 0 {
+		t.Errorf("largest key %q not larger than covered key", largest)
+	}
+	if icmp.Compare(largest, keys.NewInternalKey([]byte("d"), keys.MaxSequence-1, keys.Value)) >= 0 {
+		t.Errorf("largest key %q not smaller than limit key", largest)
+	}
+}
+
+func TestListMaxSequence(t *testing.T) {
+	list := rangedel.List{
+		{Start: []byte("a"), Limit: []byte("c"), Sequence: 5},
+		{Start: []byte("b"), Limit: []byte("e"), Sequence: 8},
+		{Start: []byte("d"), Limit: []byte("f"), Sequence: 3},
+	}
+	tests := []struct {
+		key  string
+		seq  keys.Sequence
+		want keys.Sequence
+	}{
+		{"a", 10, 5},
+		{"b", 10, 8},
+		{"b", 7, 5},
+		{"b", 4, 0},
+		{"d", 10, 8},
+		{"e", 10, 3},
+		{"f", 10, 0},
+	}
+	for _, test := range tests {
+		if got := list.MaxSequence(ucmp, []byte(test.key), test.seq); got != test.want {
+			t.Errorf("key=%q seq=%d got=%d want=%d", test.key, test.seq, got, test.want)
+		}
+	}
+}
+
+func TestListSort(t *testing.T) {
+	list := rangedel.List{
+		{Start: []byte("c"), Limit: []byte("d"), Sequence: 1},
+		{Start: []byte("a"), Limit: []byte("b"), Sequence: 2},
+		{Start: []byte("c"), Limit: []byte("e"), Sequence: 3},
+	}
+	list.Sort(ucmp)
+	want := []keys.Sequence{2, 3, 1}
+	for i, t1 := range list {
+		if t1.Sequence != want[i] {
+			t.Errorf("index=%d got=%d want=%d", i, t1.Sequence, want[i])
+		}
+	}
+}
@@ -50,7 +50,11 @@ func (g *Group) Push(req Request) {
 		g.setCurrent(req)
 	case g.requests[current].Reply == nil:
 		g.setCurrent(req)
-	case !g.requests[current].Sync && req.Sync:
+	case g.requests[current].Check != nil, req.Check != nil:
+		// Checked request should not be merged with others.
+		g.current++
+		g.setCurrent(req)
+	case !g.requests[current].Sync && req.Sync, g.requests[current].DisableWAL != req.DisableWAL:
 		g.current++
 		g.setCurrent(req)
 	case len(g.replys[current]) == 0:
@@ -61,7 +65,7 @@ func (g *Group) Push(req Request) {
 		fallthrough
 	default:
 		g.batchSize += req.Batch.Size()
-		g.requests[current].Batch.Append(req.Batch.Bytes())
+		g.requests[current].Batch.AppendBatch(&req.Batch)
 		g.replys[current] = append(g.replys[current], req.Reply)
 	}
 }
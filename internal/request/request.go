@@ -3,7 +3,12 @@ package request
 import "github.com/kezhuw/leveldb/internal/batch"
 
 type Request struct {
-	Sync  bool
-	Batch batch.Batch
-	Reply chan error
+	Sync       bool
+	DisableWAL bool
+	Batch      batch.Batch
+	Reply      chan error
+
+	// Check, if not nil, is called in write goroutine before batch is
+	// written. Non nil error aborts this request.
+	Check func() error
 }
@@ -63,6 +63,10 @@ func NewBlock(contents []byte) *Block {
 		return b
 	}
 	restartsOffset := n - 4 - 4*restartsNumber
+	if restartsOffset == 0 {
+		// Empty block written by Writer contains one restart point.
+		return b
+	}
 	if !checkRestarts(contents[restartsOffset:n-4], restartsOffset) {
 		b.err = ErrCorruptBlock
 		return b
@@ -9,7 +9,9 @@ import (
 	"github.com/kezhuw/leveldb/internal/files"
 	"github.com/kezhuw/leveldb/internal/iterator"
 	"github.com/kezhuw/leveldb/internal/keys"
+	"github.com/kezhuw/leveldb/internal/merge"
 	"github.com/kezhuw/leveldb/internal/options"
+	"github.com/kezhuw/leveldb/internal/rangedel"
 )
 
 type node struct {
@@ -204,12 +206,34 @@ func (c *Cache) Evict(fileNumber uint64) {
 	}
 }
 
-func (c *Cache) Get(fileNumber uint64, fileSize uint64, ikey keys.InternalKey, opts *options.ReadOptions) ([]byte, error, bool) {
+// Get feeds entries of ikey's user key in given table to l. It returns true
+// if l is resolved.
+func (c *Cache) Get(fileNumber uint64, fileSize uint64, ikey keys.InternalKey, l *merge.Lookup, opts *options.ReadOptions) bool {
 	t, err := c.open(fileNumber, fileSize)
 	if err != nil {
-		return nil, err, true
+		l.Fail(err)
+		return true
 	}
-	return t.Get(ikey, opts)
+	return t.Get(ikey, l, opts)
+}
+
+// ApproximateOffsetOf returns approximate offset of ikey in given table, zero
+// if that table can't be opened.
+func (c *Cache) ApproximateOffsetOf(fileNumber uint64, fileSize uint64, ikey keys.InternalKey) uint64 {
+	t, err := c.open(fileNumber, fileSize)
+	if err != nil {
+		return 0
+	}
+	return t.ApproximateOffsetOf(ikey)
+}
+
+// RangeTombstones returns range tombstones in given table.
+func (c *Cache) RangeTombstones(fileNumber uint64, fileSize uint64) (rangedel.List, error) {
+	t, err := c.open(fileNumber, fileSize)
+	if err != nil {
+		return nil, err
+	}
+	return t.RangeTombstones(), nil
 }
 
 func (c *Cache) NewIterator(fileNumber uint64, fileSize uint64, opts *options.ReadOptions) iterator.Iterator {
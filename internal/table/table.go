@@ -7,7 +7,9 @@ import (
 	"github.com/kezhuw/leveldb/internal/file"
 	"github.com/kezhuw/leveldb/internal/iterator"
 	"github.com/kezhuw/leveldb/internal/keys"
+	"github.com/kezhuw/leveldb/internal/merge"
 	"github.com/kezhuw/leveldb/internal/options"
+	"github.com/kezhuw/leveldb/internal/rangedel"
 	"github.com/kezhuw/leveldb/internal/table/block"
 	"github.com/kezhuw/leveldb/internal/table/filter"
 )
@@ -19,24 +21,40 @@ type Table struct {
 	options    *options.Options
 	dataIndex  *block.Block
 	filter     *filter.Reader
+	tombstones rangedel.List
+
+	// Meta blocks are written after all data blocks.
+	metaIndexOffset uint64
 }
 
-func (t *Table) readMetaBlocks(metaIndexHandle block.Handle) {
-	if t.options.Filter == nil {
-		return
-	}
+// readMetaBlocks reads filter and range tombstones from meta blocks. Filter
+// is optional, so failures in reading it are ignored.
+func (t *Table) readMetaBlocks(metaIndexHandle block.Handle) error {
 	metaIndex, err := ReadDataBlock(t.f, t.fileNumber, metaIndexHandle, true)
 	if err != nil {
-		return
+		return err
 	}
-	cmp := keys.BytewiseComparator
-	it := metaIndex.NewIterator(cmp)
+	it := metaIndex.NewIterator(keys.BytewiseComparator)
 	defer it.Close()
-	filterName := []byte("filter." + t.options.Filter.Name())
-	if !it.Seek(filterName) || cmp.Compare(filterName, it.Key()) != 0 {
+	if t.options.Filter != nil {
+		t.readFilter(it)
+	}
+	return t.readRangeTombstones(it)
+}
+
+func seekMetaBlock(it iterator.Iterator, name string) ([]byte, bool) {
+	if !it.Seek([]byte(name)) || string(it.Key()) != name {
+		return nil, false
+	}
+	return it.Value(), true
+}
+
+func (t *Table) readFilter(metaIndexIt iterator.Iterator) {
+	handle, ok := seekMetaBlock(metaIndexIt, "filter."+t.options.Filter.Name())
+	if !ok {
 		return
 	}
-	h, n := block.DecodeHandle(it.Value())
+	h, n := block.DecodeHandle(handle)
 	if n <= 0 {
 		return
 	}
@@ -47,39 +65,108 @@ func (t *Table) readMetaBlocks(metaIndexHandle block.Handle) {
 	t.filter = filter.NewReader(t.options.Filter, buf)
 }
 
-func (t *Table) Get(ikey keys.InternalKey, opts *options.ReadOptions) ([]byte, error, bool) {
+func (t *Table) readRangeTombstones(metaIndexIt iterator.Iterator) error {
+	handle, ok := seekMetaBlock(metaIndexIt, rangeDelBlockName)
+	if !ok {
+		return metaIndexIt.Err()
+	}
+	h, n := block.DecodeHandle(handle)
+	if n <= 0 {
+		return errors.NewCorruption(t.fileNumber, "table meta index", -1, "invalid block handle")
+	}
+	b, err := ReadDataBlock(t.f, t.fileNumber, h, true)
+	if err != nil {
+		return err
+	}
+	it := b.NewIterator(t.options.Comparator)
+	defer it.Close()
+	var key keys.ParsedInternalKey
+	for ok := it.First(); ok; ok = it.Next() {
+		if !key.Parse(it.Key()) || key.Kind != keys.RangeDelete {
+			return errors.NewCorruption(t.fileNumber, "table range tombstones", int64(h.Offset), "invalid range tombstone")
+		}
+		t.tombstones = append(t.tombstones, rangedel.Tombstone{
+			Start:    append([]byte(nil), key.UserKey...),
+			Limit:    append([]byte(nil), it.Value()...),
+			Sequence: key.Sequence,
+		})
+	}
+	return it.Err()
+}
+
+// RangeTombstones returns range tombstones in this table.
+func (t *Table) RangeTombstones() rangedel.List {
+	return t.tombstones
+}
+
+// Get feeds entries of ikey's user key with sequence not greater than ikey's
+// to l, from newest to oldest. It returns true if l is resolved.
+func (t *Table) Get(ikey keys.InternalKey, l *merge.Lookup, opts *options.ReadOptions) bool {
+	// Range tombstones delete entries older than them.
+	ukey, seq, _ := ikey.Split()
+	deletedSequence := t.tombstones.MaxSequence(t.options.Comparator.UserKeyComparator, ukey, seq)
+	if done, err := t.get(ikey, deletedSequence, l, opts); err != nil {
+		l.Fail(err)
+		return true
+	} else if done {
+		return true
+	}
+	if deletedSequence != 0 {
+		return l.Add(keys.Delete, nil)
+	}
+	return false
+}
+
+func (t *Table) get(ikey keys.InternalKey, deletedSequence keys.Sequence, l *merge.Lookup, opts *options.ReadOptions) (bool, error) {
 	indexIt := t.dataIndex.NewIterator(t.options.Comparator)
 	if !indexIt.Seek(ikey) {
-		err := indexIt.Close()
-		return nil, err, err != nil
+		return false, indexIt.Close()
 	}
 	defer indexIt.Close()
 
-	h, n := block.DecodeHandle(indexIt.Value())
-	if n <= 0 {
-		return nil, errors.NewCorruption(t.fileNumber, "table data index", -1, "invalid block handle"), true
-	}
-	if t.filter != nil && !t.filter.Contains(h.Offset, []byte(ikey)) {
-		return nil, nil, false
-	}
+	ucmp := t.options.Comparator.UserKeyComparator
+	for first := true; ; first = false {
+		h, n := block.DecodeHandle(indexIt.Value())
+		if n <= 0 {
+			return false, errors.NewCorruption(t.fileNumber, "table data index", -1, "invalid block handle")
+		}
+		if first && t.filter != nil && !t.filter.Contains(h.Offset, []byte(ikey)) {
+			return false, nil
+		}
 
-	dataIt := t.readBlockHandleIterator(h, opts)
-	if !dataIt.Seek(ikey) {
-		err := dataIt.Close()
-		return nil, err, err != nil
+		// Entries of a key may span blocks due to merge operands.
+		dataIt := t.readBlockHandleIterator(h, opts)
+		for ok := dataIt.Seek(ikey); ok; ok = dataIt.Next() {
+			ukey, seq, kind := keys.InternalKey(dataIt.Key()).Split()
+			if seq <= deletedSequence || ucmp.Compare(ukey, ikey.UserKey()) != 0 {
+				return false, dataIt.Close()
+			}
+			if l.Add(kind, dataIt.Value()) {
+				return true, dataIt.Close()
+			}
+		}
+		if err := dataIt.Close(); err != nil {
+			return false, err
+		}
+		if !indexIt.Next() {
+			return false, indexIt.Err()
+		}
 	}
-	defer dataIt.Close()
+}
 
-	ukey, _, kind := keys.InternalKey(dataIt.Key()).Split()
-	if t.options.Comparator.UserKeyComparator.Compare(ukey, ikey.UserKey()) == 0 {
-		switch kind {
-		case keys.Delete:
-			return nil, errors.ErrNotFound, true
-		default:
-			return dataIt.Value(), nil, true
+// ApproximateOffsetOf returns approximate offset in file where data for ikey
+// begins, or would begin if ikey were present in table.
+func (t *Table) ApproximateOffsetOf(ikey keys.InternalKey) uint64 {
+	indexIt := t.dataIndex.NewIterator(t.options.Comparator)
+	defer indexIt.Close()
+	if indexIt.Seek(ikey) {
+		if h, n := block.DecodeHandle(indexIt.Value()); n > 0 {
+			return h.Offset
 		}
 	}
-	return nil, nil, false
+	// ikey is past the last key in table or index block is corrupt,
+	// approximate the offset with offset of meta index block.
+	return t.metaIndexOffset
 }
 
 func (t *Table) readBlockHandleIterator(h block.Handle, opts *options.ReadOptions) iterator.Iterator {
@@ -135,7 +222,11 @@ func OpenTable(f file.ReadCloser, blocks *BlockCache, opts *options.Options, num
 		blocks:     blocks,
 		options:    opts,
 		dataIndex:  dataIndex,
+
+		metaIndexOffset: footer.MetaIndexHandle.Offset,
+	}
+	if err := t.readMetaBlocks(footer.MetaIndexHandle); err != nil {
+		return nil, err
 	}
-	t.readMetaBlocks(footer.MetaIndexHandle)
 	return t, nil
 }
@@ -8,14 +8,17 @@ import (
 	"github.com/kezhuw/leveldb/internal/crc"
 	"github.com/kezhuw/leveldb/internal/endian"
 	filterp "github.com/kezhuw/leveldb/internal/filter"
+	"github.com/kezhuw/leveldb/internal/keys"
 	"github.com/kezhuw/leveldb/internal/options"
+	"github.com/kezhuw/leveldb/internal/rangedel"
 	"github.com/kezhuw/leveldb/internal/table/block"
 	"github.com/kezhuw/leveldb/internal/table/filter"
 )
 
 type Writer struct {
-	w       io.Writer
-	options *options.Options
+	w           io.Writer
+	options     *options.Options
+	compression compress.Type
 
 	err        error
 	offset     int64
@@ -28,13 +31,16 @@ type Writer struct {
 	dataIndexBlock   block.Writer
 	pendingDataIndex block.Handle
 	filterBlock      filter.Writer
+	rangeDelBlock    block.Writer
+	tombstones       rangedel.List
 
 	// Large enough to store encoded footer, block.Handle, etc.
 	scratch       [footerLength]byte
 	compressedBuf []byte
 }
 
-func (w *Writer) Reset(f io.Writer, opts *options.Options) {
+// Reset resets writer to write a table in given level to f.
+func (w *Writer) Reset(f io.Writer, level int, opts *options.Options) {
 	w.w = f
 	w.err = nil
 	w.offset = 0
@@ -44,10 +50,14 @@ func (w *Writer) Reset(f io.Writer, opts *options.Options) {
 	w.dataBlock.Reset()
 	w.filterBlock.Reset()
 	w.dataIndexBlock.Reset()
+	w.rangeDelBlock.Reset()
 	w.pendingDataIndex.Length = 0
+	w.tombstones = w.tombstones[:0]
 	w.options = opts
+	w.compression = opts.CompressionOfLevel(level)
 	w.dataBlock.RestartInterval = opts.BlockRestartInterval
 	w.dataIndexBlock.RestartInterval = 1
+	w.rangeDelBlock.RestartInterval = 1
 	if opts.Filter != nil && w.filterBlock.Generator == nil {
 		w.filterBlock.Generator = filterp.NewGenerator(opts.Filter)
 	}
@@ -74,8 +84,15 @@ func (w *Writer) Add(key, value []byte) error {
 	return w.err
 }
 
+// AddRangeTombstone adds a range tombstone to table. Data referenced by t
+// must not be modified until Finish returned.
+func (w *Writer) AddRangeTombstone(t rangedel.Tombstone) {
+	w.tombstones = append(w.tombstones, t)
+}
+
+// Empty returns true if neither entries nor range tombstones were added.
 func (w *Writer) Empty() bool {
-	return w.numEntries == 0
+	return w.numEntries == 0 && len(w.tombstones) == 0
 }
 
 func (w *Writer) flushPendingDataIndex(limit []byte) {
@@ -137,6 +154,21 @@ func (w *Writer) finishMetaBlocks(metaIndex *block.Writer) (block.Handle, error)
 		n := block.EncodeHandle(w.scratch[:], handle)
 		metaIndex.Add(name, w.scratch[:n])
 	}
+	if len(w.tombstones) != 0 {
+		w.tombstones.Sort(w.options.Comparator.UserKeyComparator)
+		var ikey []byte
+		for _, t := range w.tombstones {
+			key := keys.ParsedInternalKey{UserKey: t.Start, Sequence: t.Sequence, Kind: keys.RangeDelete}
+			ikey = key.Append(ikey[:0])
+			w.rangeDelBlock.Add(ikey, t.Limit)
+		}
+		handle, err := w.finishBlock(&w.rangeDelBlock)
+		if err != nil {
+			return handle, err
+		}
+		n := block.EncodeHandle(w.scratch[:], handle)
+		metaIndex.Add([]byte(rangeDelBlockName), w.scratch[:n])
+	}
 	return w.finishBlock(metaIndex)
 }
 
@@ -163,7 +195,7 @@ func (w *Writer) saveCompressedBuffer(buf *bytes.Buffer) {
 
 func (w *Writer) finishBlock(block *block.Writer) (block.Handle, error) {
 	buf := block.Finish()
-	compression := w.options.Compression
+	compression := w.compression
 	if compression != compress.NoCompression {
 		compressed, err := compress.Encode(compression, w.compressedBuf, buf.Bytes())
 		switch {
@@ -0,0 +1,49 @@
+// Package ttl encodes expiration times into values of keys.ExpiringValue
+// entries.
+package ttl
+
+import (
+	"math"
+	"time"
+
+	"github.com/kezhuw/leveldb/internal/endian"
+	"github.com/kezhuw/leveldb/internal/errors"
+)
+
+// HeaderSize is size of expiration time in front of value.
+const HeaderSize = 8
+
+// Expiration returns expiration time of values written at now with time to
+// live d. Both now and returned time are in nanoseconds since Unix epoch.
+func Expiration(now int64, d time.Duration) int64 {
+	if d > 0 && now > math.MaxInt64-int64(d) {
+		return math.MaxInt64
+	}
+	return now + int64(d)
+}
+
+// Append appends value prefixed with expiration, which is in nanoseconds
+// since Unix epoch, to dst.
+func Append(dst []byte, expiration int64, value []byte) []byte {
+	var header [HeaderSize]byte
+	endian.PutUint64(header[:], uint64(expiration))
+	dst = append(dst, header[:]...)
+	return append(dst, value...)
+}
+
+// Split splits encoded value into expiration and value.
+func Split(encoded []byte) (int64, []byte, error) {
+	if len(encoded) < HeaderSize {
+		return 0, nil, errors.ErrCorruptExpiration
+	}
+	return int64(endian.Uint64(encoded)), encoded[HeaderSize:], nil
+}
+
+// Live returns value of encoded and true if it has not expired at now.
+func Live(encoded []byte, now int64) ([]byte, bool, error) {
+	expiration, value, err := Split(encoded)
+	if err != nil || now >= expiration {
+		return nil, false, err
+	}
+	return value, true, nil
+}
@@ -0,0 +1,70 @@
+package ttl_test
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/kezhuw/leveldb/internal/errors"
+	"github.com/kezhuw/leveldb/internal/ttl"
+)
+
+func TestExpiration(t *testing.T) {
+	tests := []struct {
+		now        int64
+		d          time.Duration
+		expiration int64
+	}{
+		{now: 100, d: 10, expiration: 110},
+		{now: 100, d: 0, expiration: 100},
+		{now: 100, d: -10, expiration: 90},
+		{now: math.MaxInt64 - 5, d: 10, expiration: math.MaxInt64},
+		{now: 100, d: math.MaxInt64, expiration: math.MaxInt64},
+	}
+	for _, test := range tests {
+		if got := ttl.Expiration(test.now, test.d); got != test.expiration {
+			t.Errorf("test=expiration now=%d d=%d got=%d want=%d", test.now, test.d, got, test.expiration)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	encoded := ttl.Append(nil, 12345, []byte("value"))
+	if len(encoded) != ttl.HeaderSize+5 {
+		t.Fatalf("test=append got-size=%d want-size=%d", len(encoded), ttl.HeaderSize+5)
+	}
+	expiration, value, err := ttl.Split(encoded)
+	if err != nil || expiration != 12345 || !bytes.Equal(value, []byte("value")) {
+		t.Errorf("test=split got-expiration=%d got-value=%q got-err=%v", expiration, value, err)
+	}
+	if _, _, err := ttl.Split(encoded[:ttl.HeaderSize-1]); err != errors.ErrCorruptExpiration {
+		t.Errorf("test=split-corrupt got-err=%v want-err=%v", err, errors.ErrCorruptExpiration)
+	}
+}
+
+func TestLive(t *testing.T) {
+	encoded := ttl.Append(nil, 100, []byte("value"))
+	tests := []struct {
+		now  int64
+		live bool
+	}{
+		{now: 0, live: true},
+		{now: 99, live: true},
+		{now: 100, live: false},
+		{now: 101, live: false},
+	}
+	for _, test := range tests {
+		value, live, err := ttl.Live(encoded, test.now)
+		if err != nil || live != test.live {
+			t.Errorf("test=live now=%d got-live=%t want-live=%t got-err=%v", test.now, live, test.live, err)
+			continue
+		}
+		if live && !bytes.Equal(value, []byte("value")) {
+			t.Errorf("test=live now=%d got-value=%q", test.now, value)
+		}
+	}
+	if _, _, err := ttl.Live(nil, 0); err != errors.ErrCorruptExpiration {
+		t.Errorf("test=live-corrupt got-err=%v want-err=%v", err, errors.ErrCorruptExpiration)
+	}
+}
@@ -0,0 +1,22 @@
+package leveldb
+
+import "github.com/kezhuw/leveldb/internal/merge"
+
+// MergeOperator merges operands written by Merge into values. It enables
+// read-modify-write updates, such as counters and append-only lists, to be
+// written without reading existing values first. Operands are folded into
+// values lazily, when keys are read or compacted. Methods of a merge operator
+// may be called by concurrent goroutines.
+type MergeOperator interface {
+	// Name returns the name of this merge operator.
+	Name() string
+
+	// Merge merges operands, ordered from oldest to newest, into existing
+	// value of key. Existing value is nil if key does not exist. An error
+	// returned fails the read or compaction which triggered this merge.
+	Merge(key, existing []byte, operands [][]byte) ([]byte, error)
+}
+
+// Ensure merge.Operator equals to MergeOperator.
+var _ MergeOperator = (merge.Operator)(nil)
+var _ merge.Operator = (MergeOperator)(nil)
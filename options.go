@@ -1,6 +1,7 @@
 package leveldb
 
 import (
+	"time"
 	"unsafe"
 
 	"github.com/kezhuw/leveldb/internal/compaction"
@@ -30,6 +31,13 @@ const (
 	// SnappyCompression uses snappy compression to compress table block
 	// before store it to file.
 	SnappyCompression
+	// ZstdCompression uses zstd compression to compress table block. Block
+	// is stored as a zstd frame. Compressor registered through
+	// RegisterCompressor with ZstdCompressionID replaces the builtin one.
+	ZstdCompression
+	// LZ4Compression uses LZ4 compression to compress table block. Block is
+	// prefixed with varint encoded length of uncompressed data.
+	LZ4Compression
 )
 
 // Options contains options controlling various parts of the db instance.
@@ -45,6 +53,14 @@ type Options struct {
 	// The default value points to SnappyCompression.
 	Compression CompressionType
 
+	// LevelCompression specifies compression types for tables in each level.
+	// Tables in level i are compressed using LevelCompression[i], levels
+	// beyond its length use the last one. DefaultCompression entries fall
+	// back to Compression.
+	//
+	// The default value is nil, which means all levels use Compression.
+	LevelCompression []CompressionType
+
 	// BlockSize specifies the minimum uncompressed size in bytes for a table block.
 	//
 	// The default value is 4KiB.
@@ -133,6 +149,73 @@ type Options struct {
 	// The default value is Level0SlowdownWriteFiles + 4.
 	Level0StopWriteFiles int
 
+	// MaxBytesForLevelBase specifies the maximum total size in bytes of tables
+	// in level-1. A compaction is triggered for level-1 if its size exceeds
+	// this value.
+	//
+	// The default value is 10MiB.
+	MaxBytesForLevelBase int
+
+	// MaxBytesForLevelMultiplier specifies the ratio of maximum total size of
+	// tables in level n+1 to that in level n, for n >= 1.
+	//
+	// The default value is 10.
+	MaxBytesForLevelMultiplier float64
+
+	// DynamicLevelBytes specifies whether to size levels from the actual size
+	// of bottommost non-empty level. If true, maximum total size of level n is
+	// size of that level divided by MaxBytesForLevelMultiplier for each level
+	// between, but not less than MaxBytesForLevelBase. Most data will stay in
+	// bottommost level, which results in stable space amplification.
+	//
+	// The default value is false.
+	DynamicLevelBytes bool
+
+	// TargetFileSize specifies the target size in bytes of table files in
+	// level-1. Compaction stops building a table once its size reaches the
+	// target size of output level.
+	//
+	// The default value is 2MiB.
+	TargetFileSize int
+
+	// TargetFileSizeMultiplier specifies the ratio of target size of table
+	// files in level n+1 to that in level n, for n >= 1.
+	//
+	// The default value is 1, which means all levels have same target size.
+	TargetFileSizeMultiplier int
+
+	// ExpandedCompactionFactor limits total size of files in a compaction
+	// after expanding to this value times target file size of input level.
+	//
+	// The default value is 25.
+	ExpandedCompactionFactor int
+
+	// MaxGrandparentOverlapFactor limits total size of grandparent files a
+	// compaction output file could overlap with to this value times target
+	// file size of output level. A smaller value results in more expensive
+	// compactions in next level.
+	//
+	// The default value is 10.
+	MaxGrandparentOverlapFactor int
+
+	// MinBlobSize specifies the minimum size in bytes of values to store in
+	// blob files instead of tables when memtable is flushed. Tables keep
+	// pointers to these values, so compactions rewrite much less data for
+	// large values. Values written with time to live are always stored in
+	// tables.
+	//
+	// The default value is 0, which disables blob files.
+	MinBlobSize int
+
+	// BlobGarbageRatio specifies the ratio of garbage bytes, which are values
+	// overwritten or deleted in compactions, in a blob file before it is
+	// collected. Live values in a collected blob file are relocated to new
+	// blob file by compactions which encounter them. Blob file is deleted
+	// once all its values are garbage.
+	//
+	// The default value is 0.5.
+	BlobGarbageRatio float64
+
 	// Filter specifies a Filter to filter out unnecessary disk reads when looking for
 	// a specific key. The filter is also used to generate filter data when building
 	// table files.
@@ -140,6 +223,32 @@ type Options struct {
 	// The default value is nil.
 	Filter Filter
 
+	// MergeOperator specifies a MergeOperator to merge operands written by
+	// Merge into values. A db containing merge operands must be opened with
+	// a merge operator that understands these operands.
+	//
+	// The default value is nil.
+	MergeOperator MergeOperator
+
+	// CompactionFilter specifies a CompactionFilter to drop or rewrite values
+	// when they are compacted while no snapshots are live.
+	//
+	// The default value is nil.
+	CompactionFilter CompactionFilter
+
+	// CompactionFilterOnFlush specifies whether to apply CompactionFilter to
+	// values flushed from memtable to level 0, besides level compactions.
+	//
+	// The default value is false.
+	CompactionFilterOnFlush bool
+
+	// Clock specifies a Clock to tell current time, which decides whether
+	// values written with time to live have expired. It is mainly used by
+	// tests to control expiration.
+	//
+	// The default value is a clock backed by time.Now.
+	Clock Clock
+
 	// Logger specifies a place that all internal progress/error information generated
 	// by this db instance will be written to.
 	//
@@ -161,6 +270,24 @@ type Options struct {
 	//
 	// The default value is false.
 	ErrorIfExists bool
+
+	// RetainLogs specifies whether to keep log files, whose updates have been
+	// compacted to tables, for DB.GetUpdatesSince until all updates in them
+	// are acknowledged through DB.AcknowledgeUpdates. Acknowledgement is not
+	// persisted, all log files are retained after reopening until consumers
+	// acknowledge again.
+	//
+	// The default value is false.
+	RetainLogs bool
+
+	// ColumnFamilies specifies options of column families, keyed by name,
+	// when opening db. Column families not specified here use options of
+	// db. Clock, Logger, FileSystem, CreateIfMissing, ErrorIfExists and
+	// RetainLogs are shared by all column families, they are ignored in
+	// options of column families.
+	//
+	// The default value is nil.
+	ColumnFamilies map[string]*Options
 }
 
 func (opts *Options) getLogger() logger.LogCloser {
@@ -180,6 +307,20 @@ func (opts *Options) getFilter() filter.Filter {
 	return wrappedFilter{opts.Filter}
 }
 
+func (opts *Options) getCompactionFilter() compaction.Filter {
+	if opts.CompactionFilter == nil {
+		return nil
+	}
+	return wrappedCompactionFilter{opts.CompactionFilter}
+}
+
+func (opts *Options) getClock() options.Clock {
+	if opts.Clock == nil {
+		return options.DefaultClock
+	}
+	return opts.Clock
+}
+
 func (opts *Options) getFileSystem() file.FileSystem {
 	if opts.FileSystem == nil {
 		return file.DefaultFileSystem
@@ -187,6 +328,9 @@ func (opts *Options) getFileSystem() file.FileSystem {
 	if fs, ok := opts.FileSystem.(internalFileSystem); ok {
 		return fs.FileSystem
 	}
+	if linker, ok := opts.FileSystem.(Linker); ok {
+		return linkedFileSystem{wrappedFileSystem{opts.FileSystem}, linker}
+	}
 	return wrappedFileSystem{opts.FileSystem}
 }
 
@@ -197,14 +341,37 @@ func (opts *Options) getComparator() *keys.InternalComparator {
 	return &keys.InternalComparator{UserKeyComparator: opts.Comparator}
 }
 
-func (opts *Options) getCompression() compress.Type {
-	switch opts.Compression {
+func convertCompression(compression CompressionType, defaultCompression compress.Type) compress.Type {
+	switch compression {
 	case NoCompression:
 		return compress.NoCompression
 	case SnappyCompression:
 		return compress.SnappyCompression
+	case ZstdCompression:
+		return compress.ZstdCompression
+	case LZ4Compression:
+		return compress.LZ4Compression
+	}
+	if id := compression - customCompression; id > 0 && id <= CompressionType(compress.MaxType) {
+		return compress.Type(id)
 	}
-	return options.DefaultCompression
+	return defaultCompression
+}
+
+func (opts *Options) getCompression() compress.Type {
+	return convertCompression(opts.Compression, options.DefaultCompression)
+}
+
+func (opts *Options) getLevelCompression() []compress.Type {
+	if len(opts.LevelCompression) == 0 {
+		return nil
+	}
+	compression := opts.getCompression()
+	levelCompression := make([]compress.Type, len(opts.LevelCompression))
+	for i, c := range opts.LevelCompression {
+		levelCompression[i] = convertCompression(c, compression)
+	}
+	return levelCompression
 }
 
 func (opts *Options) getBlockSize() int {
@@ -302,6 +469,66 @@ func (opts *Options) getLevel0StopWriteFiles() int {
 	return opts.Level0StopWriteFiles
 }
 
+func (opts *Options) getMaxBytesForLevelBase() int {
+	if opts.MaxBytesForLevelBase <= 0 {
+		return options.DefaultMaxBytesForLevelBase
+	}
+	return opts.MaxBytesForLevelBase
+}
+
+func (opts *Options) getMaxBytesForLevelMultiplier() float64 {
+	if opts.MaxBytesForLevelMultiplier <= 1 {
+		return options.DefaultMaxBytesForLevelMultiplier
+	}
+	return opts.MaxBytesForLevelMultiplier
+}
+
+func (opts *Options) getTargetFileSize() int {
+	if opts.TargetFileSize <= 0 {
+		return options.DefaultTargetFileSize
+	}
+	return opts.TargetFileSize
+}
+
+func (opts *Options) getTargetFileSizeMultiplier() int {
+	if opts.TargetFileSizeMultiplier <= 0 {
+		return options.DefaultTargetFileSizeMultiplier
+	}
+	return opts.TargetFileSizeMultiplier
+}
+
+func (opts *Options) getExpandedCompactionFactor() int {
+	if opts.ExpandedCompactionFactor <= 0 {
+		return options.DefaultExpandedCompactionFactor
+	}
+	return opts.ExpandedCompactionFactor
+}
+
+func (opts *Options) getMaxGrandparentOverlapFactor() int {
+	if opts.MaxGrandparentOverlapFactor <= 0 {
+		return options.DefaultMaxGrandparentOverlapFactor
+	}
+	return opts.MaxGrandparentOverlapFactor
+}
+
+func (opts *Options) getBlobGarbageRatio() float64 {
+	if opts.BlobGarbageRatio <= 0 || opts.BlobGarbageRatio > 1 {
+		return options.DefaultBlobGarbageRatio
+	}
+	return opts.BlobGarbageRatio
+}
+
+func (opts *Options) getColumnFamilies() map[string]*options.Options {
+	if len(opts.ColumnFamilies) == 0 {
+		return nil
+	}
+	families := make(map[string]*options.Options, len(opts.ColumnFamilies))
+	for name, familyOpts := range opts.ColumnFamilies {
+		families[name] = convertOptions(familyOpts)
+	}
+	return families
+}
+
 func convertOptions(opts *Options) *options.Options {
 	if opts == nil {
 		return &options.DefaultOptions
@@ -309,6 +536,7 @@ func convertOptions(opts *Options) *options.Options {
 	var iopts options.Options
 	iopts.Comparator = opts.getComparator()
 	iopts.Compression = opts.getCompression()
+	iopts.LevelCompression = opts.getLevelCompression()
 	iopts.BlockSize = opts.getBlockSize()
 	iopts.BlockRestartInterval = opts.getBlockRestartInterval()
 	iopts.BlockCompressionRatio = opts.getBlockCompressionRatio()
@@ -322,11 +550,26 @@ func convertOptions(opts *Options) *options.Options {
 	iopts.Level0CompactionFiles = opts.getLevel0CompactionFiles()
 	iopts.Level0SlowdownWriteFiles = opts.getLevel0SlowdownWriteFiles()
 	iopts.Level0StopWriteFiles = opts.getLevel0StopWriteFiles()
+	iopts.MaxBytesForLevelBase = opts.getMaxBytesForLevelBase()
+	iopts.MaxBytesForLevelMultiplier = opts.getMaxBytesForLevelMultiplier()
+	iopts.TargetFileSize = opts.getTargetFileSize()
+	iopts.TargetFileSizeMultiplier = opts.getTargetFileSizeMultiplier()
+	iopts.ExpandedCompactionFactor = opts.getExpandedCompactionFactor()
+	iopts.MaxGrandparentOverlapFactor = opts.getMaxGrandparentOverlapFactor()
+	iopts.MinBlobSize = opts.MinBlobSize
+	iopts.BlobGarbageRatio = opts.getBlobGarbageRatio()
+	iopts.DynamicLevelBytes = opts.DynamicLevelBytes
 	iopts.Filter = opts.getFilter()
+	iopts.MergeOperator = opts.MergeOperator
+	iopts.CompactionFilter = opts.getCompactionFilter()
+	iopts.CompactionFilterOnFlush = opts.CompactionFilterOnFlush
+	iopts.Clock = opts.getClock()
 	iopts.Logger = opts.getLogger()
 	iopts.FileSystem = opts.getFileSystem()
 	iopts.CreateIfMissing = opts.CreateIfMissing
 	iopts.ErrorIfExists = opts.ErrorIfExists
+	iopts.RetainLogs = opts.RetainLogs
+	iopts.ColumnFamilies = opts.getColumnFamilies()
 	return &iopts
 }
 
@@ -367,6 +610,15 @@ type WriteOptions struct {
 	// as the "write()" system call. A write with true Sync has similar crash
 	// semantics to a "write()" system call followed by "fsync()".
 	Sync bool
+
+	// DisableWAL specifies whether to skip writing to the log file. Writes
+	// with DisableWAL set are only inserted into memtable, they will be lost
+	// if process crashs before they are compacted to tables. Close compacts
+	// memtables containing such writes to tables, so no writes will be lost
+	// after a successful Close.
+	//
+	// Sync is ignored if DisableWAL is true.
+	DisableWAL bool
 }
 
 func convertWriteOptions(opts *WriteOptions) *options.WriteOptions {
@@ -375,3 +627,26 @@ func convertWriteOptions(opts *WriteOptions) *options.WriteOptions {
 	}
 	return (*options.WriteOptions)(unsafe.Pointer(opts))
 }
+
+// TxnOptions contains options controlling pessimistic transactions.
+type TxnOptions struct {
+	// LockTimeout specifies how long a transaction waits for a key locked
+	// by other transactions before failing with ErrLockTimeout.
+	//
+	// The default value is 1 second.
+	LockTimeout time.Duration
+}
+
+func (opts *TxnOptions) getLockTimeout() time.Duration {
+	if opts.LockTimeout <= 0 {
+		return options.DefaultLockTimeout
+	}
+	return opts.LockTimeout
+}
+
+func convertTxnOptions(opts *TxnOptions) *options.TxnOptions {
+	if opts == nil {
+		return &options.DefaultTxnOptions
+	}
+	return &options.TxnOptions{LockTimeout: opts.getLockTimeout()}
+}
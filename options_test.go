@@ -6,6 +6,7 @@ import (
 	"io"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/kezhuw/leveldb/internal/compaction"
 	"github.com/kezhuw/leveldb/internal/compress"
@@ -13,6 +14,7 @@ import (
 	"github.com/kezhuw/leveldb/internal/filter"
 	"github.com/kezhuw/leveldb/internal/keys"
 	"github.com/kezhuw/leveldb/internal/logger"
+	"github.com/kezhuw/leveldb/internal/merge"
 	"github.com/kezhuw/leveldb/internal/options"
 )
 
@@ -154,6 +156,14 @@ type optionsTest struct {
 	level0CompactionFiles       int
 	level0SlowdownWriteFiles    int
 	level0StopWriteFiles        int
+	maxBytesForLevelBase        int
+	maxBytesForLevelMultiplier  float64
+	targetFileSize              int
+	targetFileSizeMultiplier    int
+	expandedCompactionFactor    int
+	maxGrandparentOverlapFactor int
+	minBlobSize                 int
+	blobGarbageRatio            float64
 	filterBuffer                *bytes.Buffer
 	loggerBuffer                *bytes.Buffer
 	fsBuffer                    *bytes.Buffer
@@ -176,13 +186,22 @@ var optionsTests = []optionsTest{
 		level0CompactionFiles:       options.DefaultLevel0CompactionFiles,
 		level0SlowdownWriteFiles:    options.DefaultLevel0SlowdownWriteFiles,
 		level0StopWriteFiles:        options.DefaultLevel0StopWriteFiles,
+		maxBytesForLevelBase:        options.DefaultMaxBytesForLevelBase,
+		maxBytesForLevelMultiplier:  options.DefaultMaxBytesForLevelMultiplier,
+		targetFileSize:              options.DefaultTargetFileSize,
+		targetFileSizeMultiplier:    options.DefaultTargetFileSizeMultiplier,
+		expandedCompactionFactor:    options.DefaultExpandedCompactionFactor,
+		maxGrandparentOverlapFactor: options.DefaultMaxGrandparentOverlapFactor,
+		blobGarbageRatio:            options.DefaultBlobGarbageRatio,
 	},
 	{
 		options: &Options{
-			Compression:           SnappyCompression,
-			BlockCompressionRatio: 7.0 / 10.0,
-			CompactionConcurrency: MaxCompactionConcurrency,
-			Level0CompactionFiles: 10,
+			Compression:                SnappyCompression,
+			BlockCompressionRatio:      7.0 / 10.0,
+			CompactionConcurrency:      MaxCompactionConcurrency,
+			Level0CompactionFiles:      10,
+			MaxBytesForLevelMultiplier: 0.5,
+			BlobGarbageRatio:           1.5,
 		},
 		comparator:                  keys.BytewiseComparator,
 		compression:                 compress.SnappyCompression,
@@ -199,6 +218,13 @@ var optionsTests = []optionsTest{
 		level0CompactionFiles:       10,
 		level0SlowdownWriteFiles:    10 + options.DefaultLevel0ThrottleStepFiles,
 		level0StopWriteFiles:        10 + options.DefaultLevel0ThrottleStepFiles + options.DefaultLevel0ThrottleStepFiles,
+		maxBytesForLevelBase:        options.DefaultMaxBytesForLevelBase,
+		maxBytesForLevelMultiplier:  options.DefaultMaxBytesForLevelMultiplier,
+		targetFileSize:              options.DefaultTargetFileSize,
+		targetFileSizeMultiplier:    options.DefaultTargetFileSizeMultiplier,
+		expandedCompactionFactor:    options.DefaultExpandedCompactionFactor,
+		maxGrandparentOverlapFactor: options.DefaultMaxGrandparentOverlapFactor,
+		blobGarbageRatio:            options.DefaultBlobGarbageRatio,
 	},
 	{
 		options: &Options{
@@ -220,6 +246,14 @@ var optionsTests = []optionsTest{
 			Level0CompactionFiles:       10,
 			Level0SlowdownWriteFiles:    12,
 			Level0StopWriteFiles:        14,
+			MaxBytesForLevelBase:        64 * 1024 * 1024,
+			MaxBytesForLevelMultiplier:  8,
+			TargetFileSize:              4 * 1024 * 1024,
+			TargetFileSizeMultiplier:    2,
+			ExpandedCompactionFactor:    20,
+			MaxGrandparentOverlapFactor: 8,
+			MinBlobSize:                 4096,
+			BlobGarbageRatio:            0.25,
 		},
 		comparator:                  keys.BytewiseComparator,
 		compression:                 compress.NoCompression,
@@ -236,6 +270,14 @@ var optionsTests = []optionsTest{
 		level0CompactionFiles:       10,
 		level0SlowdownWriteFiles:    12,
 		level0StopWriteFiles:        14,
+		maxBytesForLevelBase:        64 * 1024 * 1024,
+		maxBytesForLevelMultiplier:  8,
+		targetFileSize:              4 * 1024 * 1024,
+		targetFileSizeMultiplier:    2,
+		expandedCompactionFactor:    20,
+		maxGrandparentOverlapFactor: 8,
+		minBlobSize:                 4096,
+		blobGarbageRatio:            0.25,
 		filterBuffer:                filterBuffer,
 		loggerBuffer:                loggerBuffer,
 		fsBuffer:                    fsBuffer,
@@ -293,6 +335,27 @@ func TestOptions(t *testing.T) {
 		if level0StopWriteFiles := opts.getLevel0StopWriteFiles(); level0StopWriteFiles != test.level0StopWriteFiles {
 			t.Errorf("test=%d-Level0StopWriteFiles got=%d want=%d", i, level0StopWriteFiles, test.level0StopWriteFiles)
 		}
+		if maxBytesForLevelBase := opts.getMaxBytesForLevelBase(); maxBytesForLevelBase != test.maxBytesForLevelBase {
+			t.Errorf("test=%d-MaxBytesForLevelBase got=%d want=%d", i, maxBytesForLevelBase, test.maxBytesForLevelBase)
+		}
+		if maxBytesForLevelMultiplier := opts.getMaxBytesForLevelMultiplier(); maxBytesForLevelMultiplier != test.maxBytesForLevelMultiplier {
+			t.Errorf("test=%d-MaxBytesForLevelMultiplier got=%v want=%v", i, maxBytesForLevelMultiplier, test.maxBytesForLevelMultiplier)
+		}
+		if targetFileSize := opts.getTargetFileSize(); targetFileSize != test.targetFileSize {
+			t.Errorf("test=%d-TargetFileSize got=%d want=%d", i, targetFileSize, test.targetFileSize)
+		}
+		if targetFileSizeMultiplier := opts.getTargetFileSizeMultiplier(); targetFileSizeMultiplier != test.targetFileSizeMultiplier {
+			t.Errorf("test=%d-TargetFileSizeMultiplier got=%d want=%d", i, targetFileSizeMultiplier, test.targetFileSizeMultiplier)
+		}
+		if expandedCompactionFactor := opts.getExpandedCompactionFactor(); expandedCompactionFactor != test.expandedCompactionFactor {
+			t.Errorf("test=%d-ExpandedCompactionFactor got=%d want=%d", i, expandedCompactionFactor, test.expandedCompactionFactor)
+		}
+		if maxGrandparentOverlapFactor := opts.getMaxGrandparentOverlapFactor(); maxGrandparentOverlapFactor != test.maxGrandparentOverlapFactor {
+			t.Errorf("test=%d-MaxGrandparentOverlapFactor got=%d want=%d", i, maxGrandparentOverlapFactor, test.maxGrandparentOverlapFactor)
+		}
+		if blobGarbageRatio := opts.getBlobGarbageRatio(); blobGarbageRatio != test.blobGarbageRatio {
+			t.Errorf("test=%d-BlobGarbageRatio got=%v want=%v", i, blobGarbageRatio, test.blobGarbageRatio)
+		}
 		if filter := opts.getFilter(); !matchFilter(filter, test.filterBuffer) {
 			t.Errorf("test=%d-Filter got=%v", i, filter)
 		}
@@ -353,6 +416,30 @@ func TestConvertOptions(t *testing.T) {
 		if level0StopWriteFiles := opts.Level0StopWriteFiles; level0StopWriteFiles != test.level0StopWriteFiles {
 			t.Errorf("test=%d-Level0StopWriteFiles got=%d want=%d", i, level0StopWriteFiles, test.level0StopWriteFiles)
 		}
+		if maxBytesForLevelBase := opts.MaxBytesForLevelBase; maxBytesForLevelBase != test.maxBytesForLevelBase {
+			t.Errorf("test=%d-MaxBytesForLevelBase got=%d want=%d", i, maxBytesForLevelBase, test.maxBytesForLevelBase)
+		}
+		if maxBytesForLevelMultiplier := opts.MaxBytesForLevelMultiplier; maxBytesForLevelMultiplier != test.maxBytesForLevelMultiplier {
+			t.Errorf("test=%d-MaxBytesForLevelMultiplier got=%v want=%v", i, maxBytesForLevelMultiplier, test.maxBytesForLevelMultiplier)
+		}
+		if targetFileSize := opts.TargetFileSize; targetFileSize != test.targetFileSize {
+			t.Errorf("test=%d-TargetFileSize got=%d want=%d", i, targetFileSize, test.targetFileSize)
+		}
+		if targetFileSizeMultiplier := opts.TargetFileSizeMultiplier; targetFileSizeMultiplier != test.targetFileSizeMultiplier {
+			t.Errorf("test=%d-TargetFileSizeMultiplier got=%d want=%d", i, targetFileSizeMultiplier, test.targetFileSizeMultiplier)
+		}
+		if expandedCompactionFactor := opts.ExpandedCompactionFactor; expandedCompactionFactor != test.expandedCompactionFactor {
+			t.Errorf("test=%d-ExpandedCompactionFactor got=%d want=%d", i, expandedCompactionFactor, test.expandedCompactionFactor)
+		}
+		if maxGrandparentOverlapFactor := opts.MaxGrandparentOverlapFactor; maxGrandparentOverlapFactor != test.maxGrandparentOverlapFactor {
+			t.Errorf("test=%d-MaxGrandparentOverlapFactor got=%d want=%d", i, maxGrandparentOverlapFactor, test.maxGrandparentOverlapFactor)
+		}
+		if minBlobSize := opts.MinBlobSize; minBlobSize != test.minBlobSize {
+			t.Errorf("test=%d-MinBlobSize got=%d want=%d", i, minBlobSize, test.minBlobSize)
+		}
+		if blobGarbageRatio := opts.BlobGarbageRatio; blobGarbageRatio != test.blobGarbageRatio {
+			t.Errorf("test=%d-BlobGarbageRatio got=%v want=%v", i, blobGarbageRatio, test.blobGarbageRatio)
+		}
 		if filter := opts.Filter; !matchFilter(filter, test.filterBuffer) {
 			t.Errorf("test=%d-Filter got=%v", i, filter)
 		}
@@ -416,6 +503,14 @@ var writeOptionsTests = []writeOptionsTest{
 			Sync: true,
 		},
 	},
+	{
+		options: &WriteOptions{
+			DisableWAL: true,
+		},
+		want: options.WriteOptions{
+			DisableWAL: true,
+		},
+	},
 }
 
 func TestConvertWriteOptions(t *testing.T) {
@@ -427,6 +522,44 @@ func TestConvertWriteOptions(t *testing.T) {
 	}
 }
 
+type txnOptionsTest struct {
+	options *TxnOptions
+	want    options.TxnOptions
+}
+
+var txnOptionsTests = []txnOptionsTest{
+	{
+		want: options.DefaultTxnOptions,
+	},
+	{
+		options: &TxnOptions{},
+		want:    options.DefaultTxnOptions,
+	},
+	{
+		options: &TxnOptions{
+			LockTimeout: -time.Second,
+		},
+		want: options.DefaultTxnOptions,
+	},
+	{
+		options: &TxnOptions{
+			LockTimeout: 100 * time.Millisecond,
+		},
+		want: options.TxnOptions{
+			LockTimeout: 100 * time.Millisecond,
+		},
+	},
+}
+
+func TestConvertTxnOptions(t *testing.T) {
+	for i, test := range txnOptionsTests {
+		opts := convertTxnOptions(test.options)
+		if *opts != test.want {
+			t.Errorf("test=%d got=%v want=%v", i, *opts, test.want)
+		}
+	}
+}
+
 func buildFieldMap(typ reflect.Type) map[string]reflect.StructField {
 	fields := make(map[string]reflect.StructField)
 	for i, n := 0, typ.NumField(); i < n; i++ {
@@ -455,10 +588,26 @@ func TestOptionsType(t *testing.T) {
 			apiType:      reflect.TypeOf(DefaultCompression),
 			internalType: reflect.TypeOf(compress.NoCompression),
 		},
+		"LevelCompression": {
+			apiType:      reflect.TypeOf([]CompressionType(nil)),
+			internalType: reflect.TypeOf([]compress.Type(nil)),
+		},
 		"Filter": {
 			apiType:      reflect.TypeOf((*Filter)(nil)).Elem(),
 			internalType: reflect.TypeOf((*filter.Filter)(nil)).Elem(),
 		},
+		"MergeOperator": {
+			apiType:      reflect.TypeOf((*MergeOperator)(nil)).Elem(),
+			internalType: reflect.TypeOf((*merge.Operator)(nil)).Elem(),
+		},
+		"CompactionFilter": {
+			apiType:      reflect.TypeOf((*CompactionFilter)(nil)).Elem(),
+			internalType: reflect.TypeOf((*compaction.Filter)(nil)).Elem(),
+		},
+		"Clock": {
+			apiType:      reflect.TypeOf((*Clock)(nil)).Elem(),
+			internalType: reflect.TypeOf((*options.Clock)(nil)).Elem(),
+		},
 		"Logger": {
 			apiType:      reflect.TypeOf((*Logger)(nil)).Elem(),
 			internalType: reflect.TypeOf((*logger.LogCloser)(nil)).Elem(),
@@ -467,6 +616,10 @@ func TestOptionsType(t *testing.T) {
 			apiType:      reflect.TypeOf((*FileSystem)(nil)).Elem(),
 			internalType: reflect.TypeOf((*file.FileSystem)(nil)).Elem(),
 		},
+		"ColumnFamilies": {
+			apiType:      reflect.TypeOf(map[string]*Options(nil)),
+			internalType: reflect.TypeOf(map[string]*options.Options(nil)),
+		},
 	}
 	apiType := reflect.TypeOf(Options{})
 	internalType := reflect.TypeOf(options.Options{})
@@ -534,3 +687,81 @@ func TestWriteOptionsType(t *testing.T) {
 	internalType := reflect.TypeOf(options.WriteOptions{})
 	testOptionsLayout(t, apiType, internalType)
 }
+
+func TestTxnOptionsType(t *testing.T) {
+	apiType := reflect.TypeOf(TxnOptions{})
+	internalType := reflect.TypeOf(options.TxnOptions{})
+	testOptionsLayout(t, apiType, internalType)
+}
+
+type nopCompressor struct{}
+
+func (nopCompressor) Encode(dst, src []byte) ([]byte, error) {
+	return append(dst[:0], src...), nil
+}
+
+func (nopCompressor) Decode(dst, src []byte) ([]byte, error) {
+	return append(dst[:0], src...), nil
+}
+
+func TestCompressionType(t *testing.T) {
+	customCompression, err := RegisterCompressor(200, nopCompressor{})
+	if err != nil {
+		t.Fatalf("RegisterCompressor got error %q", err)
+	}
+	for _, id := range []uint8{NoCompressionID, SnappyCompressionID, LZ4CompressionID} {
+		if _, err := RegisterCompressor(id, nopCompressor{}); err == nil {
+			t.Errorf("RegisterCompressor builtin id %d got no error", id)
+		}
+	}
+	tests := []struct {
+		compression CompressionType
+		want        compress.Type
+	}{
+		{DefaultCompression, compress.SnappyCompression},
+		{NoCompression, compress.NoCompression},
+		{SnappyCompression, compress.SnappyCompression},
+		{ZstdCompression, compress.ZstdCompression},
+		{LZ4Compression, compress.LZ4Compression},
+		{customCompression, compress.Type(200)},
+	}
+	for i, test := range tests {
+		opts := Options{Compression: test.compression}
+		if got := opts.getCompression(); got != test.want {
+			t.Errorf("test=%d-Compression got=%v want=%v", i, got, test.want)
+		}
+	}
+}
+
+func TestLevelCompression(t *testing.T) {
+	tests := []struct {
+		compression      CompressionType
+		levelCompression []CompressionType
+		want             []compress.Type
+	}{
+		{
+			want: []compress.Type{compress.SnappyCompression, compress.SnappyCompression, compress.SnappyCompression},
+		},
+		{
+			compression: LZ4Compression,
+			want:        []compress.Type{compress.LZ4Compression, compress.LZ4Compression, compress.LZ4Compression},
+		},
+		{
+			levelCompression: []CompressionType{NoCompression, LZ4Compression},
+			want:             []compress.Type{compress.NoCompression, compress.LZ4Compression, compress.LZ4Compression},
+		},
+		{
+			compression:      NoCompression,
+			levelCompression: []CompressionType{DefaultCompression, SnappyCompression, DefaultCompression},
+			want:             []compress.Type{compress.NoCompression, compress.SnappyCompression, compress.NoCompression, compress.NoCompression},
+		},
+	}
+	for i, test := range tests {
+		opts := convertOptions(&Options{Compression: test.compression, LevelCompression: test.levelCompression})
+		for level, want := range test.want {
+			if got := opts.CompressionOfLevel(level); got != want {
+				t.Errorf("test=%d-LevelCompression level=%d got=%v want=%v", i, level, got, want)
+			}
+		}
+	}
+}
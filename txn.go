@@ -0,0 +1,86 @@
+package leveldb
+
+import (
+	"runtime"
+
+	"github.com/kezhuw/leveldb/internal/leveldb"
+)
+
+// Txn is a transaction which buffers writes and reads them back. Txn is not
+// safe for concurrent use.
+//
+// An optimistic transaction reads from a snapshot of db taken at its
+// beginning. Commit fails with ErrConflict if any key read or written by
+// this transaction has been modified by others since that snapshot.
+//
+// A pessimistic transaction reads latest data of db. It locks keys it writes
+// or reads through GetForUpdate until it is committed or rolled back, so its
+// commit never conflicts with others.
+type Txn struct {
+	txn *leveldb.Txn
+}
+
+// BeginTransaction starts an optimistic transaction. Callers should either
+// commit or roll back the returned transaction.
+func (db *DB) BeginTransaction() *Txn {
+	txn := &Txn{txn: db.db.BeginTransaction()}
+	runtime.SetFinalizer(txn, (*Txn).Rollback)
+	return txn
+}
+
+// BeginPessimisticTransaction starts a pessimistic transaction. Callers
+// should either commit or roll back the returned transaction to release
+// locks it holds.
+func (db *DB) BeginPessimisticTransaction(opts *TxnOptions) *Txn {
+	txn := &Txn{txn: db.db.BeginPessimisticTransaction(convertTxnOptions(opts))}
+	runtime.SetFinalizer(txn, (*Txn).Rollback)
+	return txn
+}
+
+// Get gets value for given key from writes buffered in this transaction
+// and db. It returns ErrNotFound if there is no such key.
+func (txn *Txn) Get(key []byte, opts *ReadOptions) ([]byte, error) {
+	return txn.txn.Get(key, convertReadOptions(opts))
+}
+
+// GetForUpdate is same as Get, except that a pessimistic transaction locks
+// key before reading, so key stays unchanged until this transaction ends.
+// It returns ErrLockTimeout if key is not unlocked by other transactions in
+// TxnOptions.LockTimeout, or ErrDeadlock if waiting for key forms a cycle.
+func (txn *Txn) GetForUpdate(key []byte, opts *ReadOptions) ([]byte, error) {
+	return txn.txn.GetForUpdate(key, convertReadOptions(opts))
+}
+
+// Put buffers a key/value update in this transaction. A pessimistic
+// transaction locks key as GetForUpdate does.
+func (txn *Txn) Put(key, value []byte) error {
+	return txn.txn.Put(key, value)
+}
+
+// Delete buffers a key deletion in this transaction.
+func (txn *Txn) Delete(key []byte) error {
+	return txn.txn.Delete(key)
+}
+
+// Merge buffers a merge of operand into value of key in this transaction.
+// It returns ErrNoMergeOperator if db is opened without a merge operator.
+func (txn *Txn) Merge(key, operand []byte) error {
+	return txn.txn.Merge(key, operand)
+}
+
+// Commit applies writes buffered in this transaction to db atomically. An
+// optimistic transaction returns ErrConflict, and writes nothing, if any key
+// read or written by it has been modified since its beginning. Conflicts are
+// checked against memtables only, so it returns ErrTryAgain, and writes
+// nothing, if memtables have been flushed since its beginning.
+func (txn *Txn) Commit(opts *WriteOptions) error {
+	runtime.SetFinalizer(txn, nil)
+	return txn.txn.Commit(convertWriteOptions(opts))
+}
+
+// Rollback discards writes buffered in this transaction, and releases locks
+// held by it.
+func (txn *Txn) Rollback() error {
+	runtime.SetFinalizer(txn, nil)
+	return txn.txn.Rollback()
+}
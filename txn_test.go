@@ -0,0 +1,184 @@
+package leveldb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOptimisticTxnConflict(t *testing.T) {
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+
+	db := openTestDB(t, filepath.Join(dir, "db"), nil)
+	defer db.Close()
+	putTestKeys(t, db, 0, 10, nil)
+
+	txn := db.BeginTransaction()
+	if _, err := txn.Get(testKey(0), nil); err != nil {
+		t.Fatalf("fail to get in transaction: %s", err)
+	}
+	if err := txn.Put(testKey(1), []byte("txn")); err != nil {
+		t.Fatalf("fail to put in transaction: %s", err)
+	}
+	expectValue(t, db, testKey(1), testValue(1))
+	if err := db.Put(testKey(2), []byte("other"), nil); err != nil {
+		t.Fatalf("fail to put: %s", err)
+	}
+	if err := txn.Commit(nil); err != nil {
+		t.Fatalf("fail to commit transaction: %s", err)
+	}
+	expectValue(t, db, testKey(1), []byte("txn"))
+
+	for _, key := range [][]byte{testKey(0), testKey(1)} {
+		txn := db.BeginTransaction()
+		txn.Get(testKey(0), nil)
+		if err := txn.Put(testKey(1), []byte("conflict")); err != nil {
+			t.Fatalf("fail to put in transaction: %s", err)
+		}
+		if err := db.Put(key, []byte("other"), nil); err != nil {
+			t.Fatalf("fail to put: %s", err)
+		}
+		if err := txn.Commit(nil); err != ErrConflict {
+			t.Fatalf("key %s modified: expect error %v, got %v", key, ErrConflict, err)
+		}
+		expectValue(t, db, key, []byte("other"))
+	}
+
+	txn = db.BeginTransaction()
+	if err := txn.Put(testKey(3), []byte("txn")); err != nil {
+		t.Fatalf("fail to put in transaction: %s", err)
+	}
+	if err := db.DeleteRange(testKey(3), testKey(5), nil); err != nil {
+		t.Fatalf("fail to delete range: %s", err)
+	}
+	if err := txn.Commit(nil); err != ErrConflict {
+		t.Fatalf("key range deleted: expect error %v, got %v", ErrConflict, err)
+	}
+}
+
+func TestOptimisticTxnTryAgain(t *testing.T) {
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+
+	db := openTestDB(t, filepath.Join(dir, "db"), nil)
+	defer db.Close()
+	putTestKeys(t, db, 0, 10, nil)
+
+	txn := db.BeginTransaction()
+	if err := txn.Put(testKey(0), []byte("txn")); err != nil {
+		t.Fatalf("fail to put in transaction: %s", err)
+	}
+	if err := db.Put(testKey(1), []byte("other"), nil); err != nil {
+		t.Fatalf("fail to put: %s", err)
+	}
+	if err := db.Flush(true); err != nil {
+		t.Fatalf("fail to flush: %s", err)
+	}
+	if err := txn.Commit(nil); err != ErrTryAgain {
+		t.Fatalf("memtable flushed: expect error %v, got %v", ErrTryAgain, err)
+	}
+	expectValue(t, db, testKey(0), testValue(0))
+
+	// Transaction begun after flush is checked against new memtable.
+	txn = db.BeginTransaction()
+	if err := txn.Put(testKey(0), []byte("txn")); err != nil {
+		t.Fatalf("fail to put in transaction: %s", err)
+	}
+	if err := txn.Commit(nil); err != nil {
+		t.Fatalf("fail to commit transaction: %s", err)
+	}
+	expectValue(t, db, testKey(0), []byte("txn"))
+}
+
+func TestPessimisticTxnLockTimeout(t *testing.T) {
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+
+	db := openTestDB(t, filepath.Join(dir, "db"), nil)
+	defer db.Close()
+	putTestKeys(t, db, 0, 10, nil)
+
+	txn1 := db.BeginPessimisticTransaction(nil)
+	if err := txn1.Put(testKey(0), []byte("txn1")); err != nil {
+		t.Fatalf("fail to put in transaction: %s", err)
+	}
+	txn2 := db.BeginPessimisticTransaction(&TxnOptions{LockTimeout: 10 * time.Millisecond})
+	if _, err := txn2.GetForUpdate(testKey(0), nil); err != ErrLockTimeout {
+		t.Fatalf("get locked key for update: expect error %v, got %v", ErrLockTimeout, err)
+	}
+	if err := txn2.Put(testKey(0), []byte("txn2")); err != ErrLockTimeout {
+		t.Fatalf("put locked key: expect error %v, got %v", ErrLockTimeout, err)
+	}
+	if _, err := txn2.GetForUpdate(testKey(1), nil); err != nil {
+		t.Fatalf("fail to get unlocked key for update: %s", err)
+	}
+	if err := txn2.Rollback(); err != nil {
+		t.Fatalf("fail to rollback transaction: %s", err)
+	}
+
+	txn3 := db.BeginPessimisticTransaction(&TxnOptions{LockTimeout: 10 * time.Second})
+	done := make(chan error, 1)
+	go func() {
+		if _, err := txn3.GetForUpdate(testKey(0), nil); err != nil {
+			done <- err
+			return
+		}
+		done <- txn3.Commit(nil)
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("get locked key for update: expect blocking, got error %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	if err := txn1.Commit(nil); err != nil {
+		t.Fatalf("fail to commit transaction: %s", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("fail to lock key released by commit: %s", err)
+	}
+	expectValue(t, db, testKey(0), []byte("txn1"))
+}
+
+func TestPessimisticTxnDeadlock(t *testing.T) {
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+
+	db := openTestDB(t, filepath.Join(dir, "db"), nil)
+	defer db.Close()
+
+	opts := &TxnOptions{LockTimeout: 10 * time.Second}
+	txns := []*Txn{db.BeginPessimisticTransaction(opts), db.BeginPessimisticTransaction(opts)}
+	for i, txn := range txns {
+		if err := txn.Put(testKey(i), testValue(i)); err != nil {
+			t.Fatalf("fail to put in transaction: %s", err)
+		}
+	}
+
+	errs := make(chan error, len(txns))
+	for i, txn := range txns {
+		go func(i int, txn *Txn) {
+			err := txn.Put(testKey(1-i), testValue(i))
+			if err != nil {
+				txn.Rollback()
+				errs <- err
+				return
+			}
+			errs <- txn.Commit(nil)
+		}(i, txn)
+	}
+	var deadlocks int
+	for range txns {
+		switch err := <-errs; err {
+		case nil:
+		case ErrDeadlock:
+			deadlocks++
+		default:
+			t.Fatalf("transactions waiting for each other: expect error %v, got %v", ErrDeadlock, err)
+		}
+	}
+	if deadlocks != 1 {
+		t.Fatalf("transactions waiting for each other: got %d deadlocks, want 1", deadlocks)
+	}
+}
@@ -0,0 +1,61 @@
+package leveldb
+
+import (
+	"github.com/kezhuw/leveldb/internal/keys"
+	"github.com/kezhuw/leveldb/internal/leveldb"
+)
+
+// UpdateIterator iterates batches written to db in order.
+type UpdateIterator interface {
+	// Next moves to next batch. It returns false if there are no more
+	// batches or an error occurs.
+	Next() bool
+
+	// Sequence returns sequence number of first update in current batch.
+	// Updates in batch are numbered consecutively.
+	Sequence() uint64
+
+	// Batch returns current batch.
+	Batch() *Batch
+
+	// Err returns error encountered in iteration.
+	Err() error
+
+	// Close releases resources hold by this iterator.
+	Close() error
+}
+
+type updateIterator struct {
+	*leveldb.UpdateIterator
+}
+
+func (it updateIterator) Sequence() uint64 {
+	return uint64(it.UpdateIterator.Sequence())
+}
+
+func (it updateIterator) Batch() *Batch {
+	b := &Batch{}
+	b.batch.Reset(append([]byte(nil), it.UpdateIterator.Batch().Bytes()...))
+	return b
+}
+
+// GetUpdatesSince returns an iterator which iterates batches written to db
+// starting from the one containing update numbered seq, and ending with the
+// last batch written before this call. Batches are read from log files, so
+// writes with WriteOptions.DisableWAL are not included. It returns
+// ErrUpdatesUnavailable if log files containing update seq have been deleted.
+// Options.RetainLogs keeps log files from being deleted.
+func (db *DB) GetUpdatesSince(seq uint64) (UpdateIterator, error) {
+	it, err := db.db.GetUpdatesSince(keys.Sequence(seq))
+	if err != nil {
+		return nil, err
+	}
+	return updateIterator{it}, nil
+}
+
+// AcknowledgeUpdates acknowledges that all updates numbered up to seq have
+// been consumed, so log files retained for them could be deleted. It is a
+// no-op if db is not opened with Options.RetainLogs.
+func (db *DB) AcknowledgeUpdates(seq uint64) {
+	db.db.AcknowledgeUpdates(keys.Sequence(seq))
+}
@@ -0,0 +1,118 @@
+package leveldb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type keyCollector struct {
+	keys []string
+}
+
+func (c *keyCollector) Put(key, value []byte) {
+	c.keys = append(c.keys, string(key))
+}
+
+func (c *keyCollector) Delete(key []byte) {
+	c.keys = append(c.keys, string(key))
+}
+
+// expectUpdates expects that updates since seq are puts of keys from start to
+// end, one per batch, numbered from first.
+func expectUpdates(t *testing.T, db *DB, seq, first uint64, start, end int) {
+	it, err := db.GetUpdatesSince(seq)
+	if err != nil {
+		t.Fatalf("fail to get updates since %d: %s", seq, err)
+	}
+	defer it.Close()
+	for i := start; i < end; i++ {
+		if !it.Next() {
+			t.Fatalf("updates since %d: expect key %s, got end with error %v", seq, testKey(i), it.Err())
+		}
+		want := first + uint64(i-start)
+		if got := it.Sequence(); got != want {
+			t.Fatalf("updates since %d: expect sequence %d, got %d", seq, want, got)
+		}
+		var c keyCollector
+		if err := it.Batch().Replay(&c); err != nil {
+			t.Fatalf("updates since %d: fail to replay batch: %s", seq, err)
+		}
+		if len(c.keys) != 1 || c.keys[0] != string(testKey(i)) {
+			t.Fatalf("updates since %d: expect key %s, got %q", seq, testKey(i), c.keys)
+		}
+	}
+	if it.Next() {
+		t.Fatalf("updates since %d: expect end, got batch numbered %d", seq, it.Sequence())
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("updates since %d: got error %s", seq, err)
+	}
+}
+
+// waitUpdatesUnavailable waits until updates since seq are deleted.
+func waitUpdatesUnavailable(t *testing.T, db *DB, seq uint64) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		it, err := db.GetUpdatesSince(seq)
+		if err == ErrUpdatesUnavailable {
+			return
+		}
+		if err != nil {
+			t.Fatalf("fail to get updates since %d: %s", seq, err)
+		}
+		it.Close()
+		if time.Now().After(deadline) {
+			t.Fatalf("updates since %d are still available", seq)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func flushTestDB(t *testing.T, db *DB) {
+	if err := db.Flush(true); err != nil {
+		t.Fatalf("fail to flush: %s", err)
+	}
+}
+
+func TestGetUpdatesSince(t *testing.T) {
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+
+	db := openTestDB(t, filepath.Join(dir, "db"), &Options{RetainLogs: true})
+	defer db.Close()
+
+	// Updates are numbered from 2. Logs contain updates 2-11, 12-21 and
+	// 22-31 in order.
+	putTestKeys(t, db, 0, 10, nil)
+	flushTestDB(t, db)
+	putTestKeys(t, db, 10, 20, nil)
+	flushTestDB(t, db)
+	putTestKeys(t, db, 20, 30, nil)
+
+	expectUpdates(t, db, 0, 2, 0, 30)
+	expectUpdates(t, db, 1, 2, 0, 30)
+	expectUpdates(t, db, 2, 2, 0, 30)
+	expectUpdates(t, db, 15, 15, 13, 30)
+	expectUpdates(t, db, 31, 31, 29, 30)
+	expectUpdates(t, db, 32, 32, 30, 30)
+
+	// First log is deleted once all its updates and some updates in second
+	// log are acknowledged. Second log is retained as its last update is not
+	// acknowledged.
+	db.AcknowledgeUpdates(20)
+	waitUpdatesUnavailable(t, db, 11)
+	expectUpdates(t, db, 12, 12, 10, 30)
+
+	// Second log is deleted once its last update is acknowledged.
+	db.AcknowledgeUpdates(21)
+	waitUpdatesUnavailable(t, db, 21)
+	expectUpdates(t, db, 22, 22, 20, 30)
+
+	// Log followed by a log without updates is retained, as its last
+	// sequence is unknown.
+	db.AcknowledgeUpdates(31)
+	flushTestDB(t, db)
+	expectUpdates(t, db, 22, 22, 20, 30)
+}